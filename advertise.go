@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// hostnamePattern matches DNS host names accepted as an advertised address.
+var hostnamePattern = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.)*[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.?$`)
+
+// advertisedAddress returns the address and port the server should use to reach the agent
+// API, from AGENT_ADVERTISE_ADDRESS and AGENT_ADVERTISE_PORT. Either is empty or zero when
+// not set, meaning the local one applies. Both are validated at startup.
+func advertisedAddress() (string, int) {
+	host := strings.Trim(strings.TrimSpace(os.Getenv("AGENT_ADVERTISE_ADDRESS")), "[]")
+	port, _ := strconv.Atoi(strings.TrimSpace(os.Getenv("AGENT_ADVERTISE_PORT")))
+	return host, port
+}
+
+// validateAdvertisedAddress checks AGENT_ADVERTISE_ADDRESS and AGENT_ADVERTISE_PORT at
+// startup, so a NAT'd agent does not register an address the server cannot dial.
+func validateAdvertisedAddress() error {
+	if raw := strings.TrimSpace(os.Getenv("AGENT_ADVERTISE_ADDRESS")); raw != "" {
+		host := strings.Trim(raw, "[]")
+		if net.ParseIP(host) == nil && !hostnamePattern.MatchString(host) {
+			return fmt.Errorf("invalid AGENT_ADVERTISE_ADDRESS %q: use an IP address or host name without a port", raw)
+		}
+	}
+	if raw := strings.TrimSpace(os.Getenv("AGENT_ADVERTISE_PORT")); raw != "" {
+		if port, err := strconv.Atoi(raw); err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid AGENT_ADVERTISE_PORT %q: use a port between 1 and 65535", raw)
+		}
+	}
+	return nil
+}
+
+// reachableAddress returns the address and port of the agent API as seen from the server:
+// the advertised ones where set, else the local IP and listener port.
+func reachableAddress(ip string, agentPort int) (string, int) {
+	host, port := advertisedAddress()
+	if host == "" {
+		host = ip
+	}
+	if port == 0 {
+		port = agentPort
+	}
+	return host, port
+}
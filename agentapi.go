@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// agentAPI is the HTTP API served on the agent's own listener.
+// Every endpoint except /healthz requires a bearer token granting the endpoint's scope.
+type agentAPI struct {
+	mux         *http.ServeMux
+	credentials []APICredential
+	// routes are the registered endpoints, listed in /openapi.json. Endpoints can be added
+	// while the API is serving, hence the lock.
+	routesMu sync.Mutex
+	routes   []apiRoute
+}
+
+// newAgentAPI creates the agent API with its built-in endpoints registered.
+func newAgentAPI(credentials []APICredential) *agentAPI {
+	api := &agentAPI{mux: http.NewServeMux(), credentials: credentials}
+	api.mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	api.addRoute("GET /healthz", "")
+	api.mux.HandleFunc("GET /openapi.json", api.handleOpenAPI)
+	api.addRoute("GET /openapi.json", "")
+	api.handle("GET /status", scopeReadStatus, handleStatus)
+	api.handle("GET /processes", scopeReadStatus, handleProcesses)
+	api.handleCommand("POST /processes/upload", "processes.upload", scopeTriggerCollect, handleUploadProcesses)
+	api.handle("GET /logs", scopeReadStatus, handleLogs)
+	api.handle("GET /history", scopeReadStatus, handleHistory)
+	api.handleCommand("POST /diagnostics", "diagnostics", scopeRunCommands, handleDiagnostics)
+	api.handleCommand("POST /collectors/{name}/enable", "collector.enable", scopeRunCommands, handleToggleCollector(true))
+	api.handleCommand("POST /collectors/{name}/disable", "collector.disable", scopeRunCommands, handleToggleCollector(false))
+	api.handleCommand("DELETE /collectors/{name}", "collector.reset", scopeRunCommands, handleResetCollector)
+	api.handle("GET /maintenance", scopeReadStatus, handleMaintenanceStatus)
+	api.handleCommand("POST /maintenance", "maintenance.start", scopeRunCommands, api.handleStartMaintenance)
+	api.handleCommand("DELETE /maintenance", "maintenance.end", scopeRunCommands, handleEndMaintenance)
+	return api
+}
+
+// handle registers an endpoint that requires a valid bearer token granting scope.
+func (a *agentAPI) handle(pattern, scope string, h http.HandlerFunc) {
+	a.mux.HandleFunc(pattern, a.requireScope(scope, h))
+	a.addRoute(pattern, scope)
+}
+
+// handleCommand registers an endpoint that requires a valid bearer token granting scope and
+// triggers an action on the agent. Every call, authorized or not, is recorded in the audit log.
+func (a *agentAPI) handleCommand(pattern, action, scope string, h http.HandlerFunc) {
+	a.mux.HandleFunc(pattern, a.audited(action, a.requireScope(scope, h)))
+	a.addRoute(pattern, scope)
+}
+
+// requireScope wraps a handler so that it only runs for requests carrying a token that
+// grants scope: unknown tokens get 401, tokens without the scope 403. If no credential is
+// configured, authenticated endpoints are disabled entirely.
+func (a *agentAPI) requireScope(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(a.credentials) == 0 {
+			http.Error(w, "agent API disabled: AGENT_TOKEN not set", http.StatusForbidden)
+			return
+		}
+		cred, ok := a.authenticate(r)
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !cred.allows(scope) {
+			http.Error(w, fmt.Sprintf("token %s lacks the %s scope", cred.Name, scope), http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// loadAgentTLS loads the certificate and key of AGENT_TLS_CERT and AGENT_TLS_KEY. It
+// returns nil when neither is set, and fails when only one is set or the pair cannot be
+// loaded, so the API is never served in plaintext by mistake.
+func loadAgentTLS() (*tls.Config, error) {
+	certFile, keyFile := os.Getenv("AGENT_TLS_CERT"), os.Getenv("AGENT_TLS_KEY")
+	switch {
+	case certFile == "" && keyFile == "":
+		return nil, nil
+	case certFile == "" || keyFile == "":
+		return nil, fmt.Errorf("AGENT_TLS_CERT and AGENT_TLS_KEY must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load agent API certificate: %v", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// serve runs the API on the given listener, over TLS when tlsConfig is set.
+func (a *agentAPI) serve(ln net.Listener, tlsConfig *tls.Config) {
+	server := &http.Server{
+		Handler:           a.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	fmt.Println("Agent API stopped:", server.Serve(ln))
+}
+
+// writeJSON encodes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Printf("Error encoding API response: %v\n", err)
+	}
+}
+
+// StatusInfo is the response of the agent's /status endpoint.
+type StatusInfo struct {
+	Hostname  string `json:"hostname"`
+	StartedAt int64  `json:"startedAt"`
+	Uptime    int64  `json:"uptimeSeconds"`
+	// LastSeq is the sequence number of the most recent metrics sample.
+	LastSeq uint64         `json:"lastSeq"`
+	Servers []ServerStatus `json:"servers"`
+	// Maintenance is the active maintenance window, if any.
+	Maintenance *MaintenanceWindow `json:"maintenance,omitempty"`
+	// Blackout lists the active blackout windows.
+	Blackout []string `json:"blackout,omitempty"`
+	// Delivery reports the current queue and spool depths and the counts accumulated since
+	// the agent started.
+	Delivery DeliveryStats `json:"delivery"`
+	// Collectors is the health and timing of every collector run so far.
+	Collectors []CollectorStatus `json:"collectors"`
+}
+
+// ServerStatus reports the delivery progress to one monitoring server.
+type ServerStatus struct {
+	Name       string `json:"name"`
+	Registered bool   `json:"registered"`
+	// LastAckSeq is the sequence number of the last batch the server acknowledged.
+	LastAckSeq uint64 `json:"lastAckSeq"`
+	// Pending is the number of batches spooled for the server.
+	Pending int `json:"pending"`
+	// SpoolBytes is the size of the spooled batches.
+	SpoolBytes int64 `json:"spoolBytes"`
+	// Retried and Dropped count the failed send attempts and the batches evicted from the
+	// spool since the agent started; DroppedAge and DroppedSize are the part evicted by the
+	// SPOOL_MAX_AGE_HOURS and SPOOL_MAX_MB limits.
+	Retried     uint64 `json:"retried"`
+	Dropped     uint64 `json:"dropped"`
+	DroppedAge  uint64 `json:"droppedAge,omitempty"`
+	DroppedSize uint64 `json:"droppedSize,omitempty"`
+	// DeadLettered counts the batches moved to the dead-letter directory since the agent
+	// started, rejected by the server or unreadable.
+	DeadLettered uint64 `json:"deadLettered,omitempty"`
+	// Protocol is the HTTP version of the last response from the server, e.g. HTTP/2.0.
+	Protocol string `json:"protocol,omitempty"`
+	// Proxy is the SOCKS5 proxy used to reach the server, without its password.
+	Proxy string `json:"proxy,omitempty"`
+}
+
+// agentStartTime records when the agent process started.
+var agentStartTime = time.Now()
+
+// handleStatus reports basic information about the running agent.
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	hostname, _ := getHostname()
+	state := readState()
+	status := StatusInfo{
+		Hostname:    hostname,
+		StartedAt:   agentStartTime.UnixMilli(),
+		Uptime:      int64(time.Since(agentStartTime).Seconds()),
+		LastSeq:     state.LastSeq,
+		Servers:     []ServerStatus{},
+		Maintenance: currentMaintenance(),
+		Blackout:    activeBlackoutNames(),
+		Delivery:    deliveryTotals(),
+		Collectors:  allCollectorHealth(),
+	}
+	for _, d := range destinations {
+		status.Servers = append(status.Servers, ServerStatus{
+			Name:         d.name,
+			Registered:   d.registered.Load(),
+			LastAckSeq:   state.LastAckSeq[d.name],
+			Pending:      len(d.spoolFiles()),
+			SpoolBytes:   d.spoolBytes(),
+			Retried:      d.retried.Load(),
+			Dropped:      d.evictions(),
+			DroppedAge:   d.evictedAge.Load(),
+			DroppedSize:  d.evictedSize.Load(),
+			DeadLettered: d.deadLettered.Load(),
+			Protocol:     serverProtocol(d.baseURL),
+			Proxy:        d.proxyName(),
+		})
+	}
+	writeJSON(w, status)
+}
+
+// handleCollect triggers an immediate out-of-band collection, queues the result for
+// sending to the monitoring servers and returns the collected metrics to the caller.
+func handleCollect(w http.ResponseWriter, r *http.Request) {
+	metrics, err := collectMetrics()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to collect metrics: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !submitMetrics(&metrics, false) {
+		http.Error(w, "metrics pipeline is full", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, buildPayload(metrics))
+}
+
+// RescanResult is the response of the /rescan endpoint.
+type RescanResult struct {
+	OpenPorts []int         `json:"openPorts"`
+	Services  []PortService `json:"services,omitempty"`
+	Added     []int         `json:"added"`
+	Removed   []int         `json:"removed"`
+	Timestamp int64         `json:"timestamp"`
+}
+
+// handleRescan reruns the port scan and returns the differences from the previous scan.
+func handleRescan(w http.ResponseWriter, r *http.Request) {
+	current := getOpenPorts()
+	sort.Ints(current)
+	previous := recordOpenPorts(current)
+	added, removed := diffPorts(previous, current)
+	writeJSON(w, RescanResult{
+		OpenPorts: current,
+		Services:  grabBanners(current),
+		Added:     added,
+		Removed:   removed,
+		Timestamp: time.Now().UnixMilli(),
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate and its key to dir.
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "agent"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestLoadAgentTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir)
+	missing := filepath.Join(dir, "missing.pem")
+	tests := []struct {
+		name, cert, key  string
+		wantTLS, wantErr bool
+	}{
+		{name: "plaintext"},
+		{name: "valid pair", cert: certFile, key: keyFile, wantTLS: true},
+		{name: "only cert", cert: certFile, wantErr: true},
+		{name: "only key", key: keyFile, wantErr: true},
+		{name: "missing cert", cert: missing, key: keyFile, wantErr: true},
+		{name: "key as cert", cert: keyFile, key: keyFile, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("AGENT_TLS_CERT", tt.cert)
+		t.Setenv("AGENT_TLS_KEY", tt.key)
+		config, err := loadAgentTLS()
+		if (err != nil) != tt.wantErr || (config != nil) != tt.wantTLS {
+			t.Errorf("%s: got TLS %v, error %v; want TLS %v, error %v", tt.name, config != nil, err, tt.wantTLS, tt.wantErr)
+		}
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	t.Setenv("STATE_DIR", t.TempDir())
+	api := newAgentAPI([]APICredential{
+		{Name: "reader", Token: "read-token", Scopes: []string{scopeReadStatus}},
+		{Name: "operator", Token: "operator-token", Scopes: []string{scopeReadStatus, scopeTriggerCollect}},
+	})
+	ok := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("done\n")) }
+	api.handle("GET /test", scopeTriggerCollect, ok)
+	api.handleCommand("POST /test", "test.run", scopeTriggerCollect, ok)
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"missing token", "", http.StatusUnauthorized},
+		{"not a bearer token", "Basic b3BlcmF0b3ItdG9rZW4=", http.StatusUnauthorized},
+		{"empty token", "Bearer ", http.StatusUnauthorized},
+		{"wrong token", "Bearer other-token", http.StatusUnauthorized},
+		{"token prefix", "Bearer operator", http.StatusUnauthorized},
+		{"wrong scope", "Bearer read-token", http.StatusForbidden},
+		{"correct scope", "Bearer operator-token", http.StatusOK},
+	}
+	for _, method := range []string{"GET", "POST"} {
+		for _, tt := range tests {
+			t.Run(method+" "+tt.name, func(t *testing.T) {
+				req := httptest.NewRequest(method, "/test", nil)
+				if tt.header != "" {
+					req.Header.Set("Authorization", tt.header)
+				}
+				rec := httptest.NewRecorder()
+				api.mux.ServeHTTP(rec, req)
+				if rec.Code != tt.want {
+					t.Errorf("got %d %q, want %d", rec.Code, rec.Body.String(), tt.want)
+				}
+				if got := rec.Body.String() == "done\n"; got != (tt.want == http.StatusOK) {
+					t.Errorf("handler ran: %v, want %v", got, tt.want == http.StatusOK)
+				}
+			})
+		}
+	}
+}
+
+func TestRequireScopeWithoutCredentials(t *testing.T) {
+	api := newAgentAPI(nil)
+	api.handle("GET /test", scopeReadStatus, func(w http.ResponseWriter, r *http.Request) {})
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rec := httptest.NewRecorder()
+	api.mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("got %d, want %d with no credential configured", rec.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Scopes granted to agent API credentials.
+const (
+	// scopeReadStatus allows the read-only endpoints: /status, /processes, /logs and
+	// GET /maintenance.
+	scopeReadStatus = "read-status"
+	// scopeTriggerCollect allows /collect, /rescan and /ingest.
+	scopeTriggerCollect = "trigger-collect"
+	// scopeRunCommands allows /diagnostics, which runs commands on the host, and starting or
+	// ending maintenance windows.
+	scopeRunCommands = "run-commands"
+)
+
+// allScopes are the scopes of the AGENT_TOKEN credential.
+var allScopes = []string{scopeReadStatus, scopeTriggerCollect, scopeRunCommands}
+
+// APICredential is a named agent API token restricted to a set of scopes.
+type APICredential struct {
+	Name string `json:"name"`
+	// Token is the bearer token, or TokenEnv names an environment variable holding it
+	// (which may be an encrypted value).
+	Token    string   `json:"token,omitempty"`
+	TokenEnv string   `json:"tokenEnv,omitempty"`
+	Scopes   []string `json:"scopes"`
+}
+
+// allows reports whether the credential grants scope.
+func (c APICredential) allows(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope || s == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// loadAPICredentials returns the agent API credentials: AGENT_TOKEN, which grants every
+// scope, and the credentials listed in AGENT_TOKENS_FILE.
+func loadAPICredentials() ([]APICredential, error) {
+	var creds []APICredential
+	if token := os.Getenv("AGENT_TOKEN"); token != "" {
+		creds = append(creds, APICredential{Name: "default", Token: token, Scopes: allScopes})
+	}
+	file := os.Getenv("AGENT_TOKENS_FILE")
+	if file == "" {
+		return creds, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agent tokens: %v", err)
+	}
+	var listed []APICredential
+	if err := json.Unmarshal(data, &listed); err != nil {
+		return nil, fmt.Errorf("invalid agent tokens file: %v", err)
+	}
+	for _, c := range listed {
+		if c.TokenEnv != "" {
+			c.Token = os.Getenv(c.TokenEnv)
+		}
+		if c.Name == "" || c.Token == "" {
+			return nil, fmt.Errorf("agent token %q has no name or token", c.Name)
+		}
+		for _, s := range c.Scopes {
+			if s != "*" && s != scopeReadStatus && s != scopeTriggerCollect && s != scopeRunCommands {
+				return nil, fmt.Errorf("agent token %s has unknown scope %q", c.Name, s)
+			}
+		}
+		creds = append(creds, c)
+	}
+	return creds, nil
+}
+
+// authenticate returns the credential matching the request's bearer token. Every
+// credential is compared, so the response time does not reveal which one matched.
+func (a *agentAPI) authenticate(r *http.Request) (APICredential, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return APICredential{}, false
+	}
+	var match APICredential
+	found := false
+	for _, c := range a.credentials {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(c.Token)) == 1 && !found {
+			match, found = c, true
+		}
+	}
+	return match, found
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultAssetDiscoveryWindow is how long the agent listens for mDNS and SSDP answers.
+const defaultAssetDiscoveryWindow = 3 * time.Second
+
+// DiscoveredAsset is a device that announced itself over mDNS or SSDP.
+type DiscoveredAsset struct {
+	Address  string `json:"address"`
+	Name     string `json:"name,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Protocol string `json:"protocol"`
+	// Services lists the mDNS service types or SSDP search targets the device announced.
+	Services     []string `json:"services,omitempty"`
+	Manufacturer string   `json:"manufacturer,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	Server       string   `json:"server,omitempty"`
+}
+
+// assetSet merges the announcements received from each device.
+type assetSet struct {
+	sync.Mutex
+	assets   map[string]*DiscoveredAsset
+	services map[string]map[string]bool
+}
+
+// get returns the asset announced over protocol from address, creating it if needed.
+// The caller must hold the lock.
+func (s *assetSet) get(protocol, address string) *DiscoveredAsset {
+	key := protocol + "\x00" + address
+	if s.assets == nil {
+		s.assets = make(map[string]*DiscoveredAsset)
+		s.services = make(map[string]map[string]bool)
+	}
+	a, ok := s.assets[key]
+	if !ok {
+		a = &DiscoveredAsset{Address: address, Protocol: protocol}
+		s.assets[key] = a
+		s.services[key] = make(map[string]bool)
+	}
+	return a
+}
+
+// addService records a service announced by an asset. The caller must hold the lock.
+func (s *assetSet) addService(a *DiscoveredAsset, service string) {
+	if service == "" {
+		return
+	}
+	seen := s.services[a.Protocol+"\x00"+a.Address]
+	if !seen[service] {
+		seen[service] = true
+		a.Services = append(a.Services, service)
+	}
+}
+
+// list returns the assets sorted by protocol and address.
+func (s *assetSet) list() []DiscoveredAsset {
+	s.Lock()
+	defer s.Unlock()
+	list := make([]DiscoveredAsset, 0, len(s.assets))
+	for _, a := range s.assets {
+		sort.Strings(a.Services)
+		list = append(list, *a)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Protocol != list[j].Protocol {
+			return list[i].Protocol < list[j].Protocol
+		}
+		return list[i].Address < list[j].Address
+	})
+	return list
+}
+
+// readPackets reads datagrams from conn until the deadline and passes each to handle.
+func readPackets(conn net.PacketConn, deadline time.Time, handle func(data []byte, from net.IP)) {
+	conn.SetReadDeadline(deadline)
+	buf := make([]byte, 9000)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if udp, ok := addr.(*net.UDPAddr); ok {
+			handle(buf[:n], udp.IP)
+		}
+	}
+}
+
+// latestAssets holds the result of the last mDNS/SSDP discovery.
+var latestAssets struct {
+	sync.Mutex
+	assets []DiscoveredAsset
+}
+
+// discoverAssets queries the LAN with mDNS and SSDP and collects the answers, along with
+// unsolicited announcements received in the meantime.
+func discoverAssets() []DiscoveredAsset {
+	window := time.Duration(envInt("ASSET_DISCOVERY_WINDOW", int(defaultAssetDiscoveryWindow/time.Second))) * time.Second
+	if window <= 0 {
+		window = defaultAssetDiscoveryWindow
+	}
+	set := &assetSet{}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := discoverMDNS(set, window); err != nil {
+			fmt.Printf("mDNS discovery failed: %v\n", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if err := discoverSSDP(set, window); err != nil {
+			fmt.Printf("SSDP discovery failed: %v\n", err)
+		}
+	}()
+	wg.Wait()
+	return set.list()
+}
+
+// startAssetDiscovery runs mDNS/SSDP discovery every ASSET_DISCOVERY_INTERVAL seconds.
+func startAssetDiscovery() {
+	interval := time.Duration(envInt("ASSET_DISCOVERY_INTERVAL", 900)) * time.Second
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+	supervise("asset discovery", func() {
+		for {
+			if collectorEnabled("ASSET_DISCOVERY", false) {
+				assets := discoverAssets()
+				latestAssets.Lock()
+				latestAssets.assets = assets
+				latestAssets.Unlock()
+			}
+			time.Sleep(interval)
+		}
+	})
+}
+
+// collectAssets returns the devices found by the last mDNS/SSDP discovery.
+func collectAssets() []DiscoveredAsset {
+	if !collectorEnabled("ASSET_DISCOVERY", false) {
+		return nil
+	}
+	latestAssets.Lock()
+	defer latestAssets.Unlock()
+	return latestAssets.assets
+}
+
+// trimLocal strips the ".local." suffix of an mDNS name.
+func trimLocal(name string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(name, "."), ".local")
+}
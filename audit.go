@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEntry records a remotely triggered command or configuration change.
+type AuditEntry struct {
+	Timestamp int64 `json:"timestamp"`
+	// Actor is who triggered the action: the address of an agent API client, or the
+	// monitoring server for remote configuration changes.
+	Actor   string `json:"actor"`
+	Action  string `json:"action"`
+	Details string `json:"details,omitempty"`
+	Result  string `json:"result"`
+}
+
+// auditLog serializes appends to the audit log.
+var auditLog sync.Mutex
+
+// auditLogPath returns AUDIT_LOG, or audit.log in the state directory.
+func auditLogPath() string {
+	if path := os.Getenv("AUDIT_LOG"); path != "" {
+		return path
+	}
+	return filepath.Join(stateDir(), "audit.log")
+}
+
+// writeAudit appends an entry to the local audit log, one JSON object per line, and
+// mirrors it to the server as an audit event. The log is only ever opened for appending;
+// rotating or pruning it is left to the administrator.
+func writeAudit(e AuditEntry) {
+	if e.Timestamp == 0 {
+		e.Timestamp = time.Now().UnixMilli()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		fmt.Printf("Error encoding audit entry: %v\n", err)
+		return
+	}
+	auditLog.Lock()
+	f, err := os.OpenFile(auditLogPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err == nil {
+		_, err = f.Write(append(line, '\n'))
+		if serr := f.Sync(); err == nil {
+			err = serr
+		}
+		f.Close()
+	}
+	auditLog.Unlock()
+	if err != nil {
+		fmt.Printf("Error writing audit log: %v\n", err)
+	}
+
+	attributes := map[string]string{"actor": e.Actor, "action": e.Action, "result": e.Result}
+	if e.Details != "" {
+		attributes["details"] = e.Details
+	}
+	queueEvent(Event{
+		Type:       "audit",
+		Message:    fmt.Sprintf("%s by %s: %s", e.Action, e.Actor, e.Result),
+		Timestamp:  e.Timestamp,
+		Attributes: attributes,
+	})
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code and forwards it.
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records an implicit 200 status and forwards the body.
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush forwards to the underlying writer, so streaming handlers keep working.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// audited wraps a handler so that every request, including rejected ones, is recorded in
+// the audit log with the caller's credential name and the response status as the result.
+func (a *agentAPI) audited(action string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		h(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		actor := "api:" + r.RemoteAddr
+		if cred, ok := a.authenticate(r); ok {
+			actor = "api:" + cred.Name + "@" + r.RemoteAddr
+		}
+		writeAudit(AuditEntry{
+			Actor:   actor,
+			Action:  action,
+			Details: r.URL.RawQuery,
+			Result:  fmt.Sprintf("%d %s", rec.status, http.StatusText(rec.status)),
+		})
+	}
+}
+
+// diffFlags describes the changes between two remote flag sets, e.g. "TCP_STATS=true,
+// DIAGNOSTICS unset", or returns "" when they are equal.
+func diffFlags(previous, current map[string]bool) string {
+	var changes []string
+	for name, v := range current {
+		if old, ok := previous[name]; !ok || old != v {
+			changes = append(changes, fmt.Sprintf("%s=%t", name, v))
+		}
+	}
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			changes = append(changes, name+" unset")
+		}
+	}
+	sort.Strings(changes)
+	return strings.Join(changes, ", ")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BandwidthResult holds the outcome of a bandwidth self-test.
+type BandwidthResult struct {
+	Timestamp    int64   `json:"timestamp"`
+	DownloadMbps float64 `json:"downloadMbps"`
+	UploadMbps   float64 `json:"uploadMbps"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// latestBandwidth holds the last bandwidth result not yet reported to the server.
+var latestBandwidth struct {
+	sync.Mutex
+	result *BandwidthResult
+}
+
+// takeBandwidthResult returns the pending bandwidth result, if any, and clears it
+// so every measurement is reported exactly once.
+func takeBandwidthResult() *BandwidthResult {
+	latestBandwidth.Lock()
+	defer latestBandwidth.Unlock()
+	r := latestBandwidth.result
+	latestBandwidth.result = nil
+	return r
+}
+
+// mbps converts a byte count transferred over a duration into megabits per second.
+func mbps(n int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) * 8 / d.Seconds() / 1e6
+}
+
+// runBandwidthTest downloads url and uploads uploadBytes of random data to it,
+// measuring throughput in both directions.
+func runBandwidthTest(url string, uploadBytes int) BandwidthResult {
+	result := BandwidthResult{Timestamp: time.Now().UnixMilli()}
+	client := &http.Client{Timeout: 2 * time.Minute}
+
+	start := time.Now()
+	resp, err := client.Get(url)
+	if err != nil {
+		result.Error = fmt.Sprintf("download failed: %v", err)
+		return result
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		result.Error = fmt.Sprintf("download failed: %v", err)
+		return result
+	}
+	result.DownloadMbps = mbps(n, time.Since(start))
+
+	payload := make([]byte, uploadBytes)
+	rand.Read(payload)
+	start = time.Now()
+	resp, err = client.Post(url, "application/octet-stream", bytes.NewReader(payload))
+	if err != nil {
+		result.Error = fmt.Sprintf("upload failed: %v", err)
+		return result
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	result.UploadMbps = mbps(int64(uploadBytes), time.Since(start))
+	return result
+}
+
+// startBandwidthTests runs the bandwidth self-test on the schedule configured by
+// BANDWIDTH_TEST_URL, BANDWIDTH_TEST_INTERVAL (seconds, default 3600) and
+// BANDWIDTH_TEST_UPLOAD_BYTES (default 10 MB). It does nothing if no URL is set.
+func startBandwidthTests() {
+	url := os.Getenv("BANDWIDTH_TEST_URL")
+	if url == "" {
+		return
+	}
+	interval := time.Hour
+	if s := os.Getenv("BANDWIDTH_TEST_INTERVAL"); s != "" {
+		if seconds, err := strconv.Atoi(s); err == nil && seconds > 0 {
+			interval = time.Duration(seconds) * time.Second
+		} else {
+			fmt.Printf("Invalid BANDWIDTH_TEST_INTERVAL value, using default 3600 seconds: %s\n", s)
+		}
+	}
+	uploadBytes := 10 * 1024 * 1024
+	if s := os.Getenv("BANDWIDTH_TEST_UPLOAD_BYTES"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			uploadBytes = n
+		} else {
+			fmt.Printf("Invalid BANDWIDTH_TEST_UPLOAD_BYTES value, using default 10 MB: %s\n", s)
+		}
+	}
+
+	supervise("bandwidth test", func() {
+		for {
+			if !featureEnabled("BANDWIDTH_TEST", true) || checksSuppressed() {
+				time.Sleep(interval)
+				continue
+			}
+			result := runBandwidthTest(url, uploadBytes)
+			if result.Error != "" {
+				fmt.Printf("Bandwidth test failed: %s\n", result.Error)
+			}
+			latestBandwidth.Lock()
+			latestBandwidth.result = &result
+			latestBandwidth.Unlock()
+			time.Sleep(interval)
+		}
+	})
+}
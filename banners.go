@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Banner grabbing limits.
+const (
+	defaultBannerTimeoutMs = 500
+	bannerWorkers          = 16
+	maxBannerRead          = 512
+	maxBannerLength        = 128
+)
+
+// httpProbe is sent to ports that stay silent after connecting, since HTTP servers wait for
+// the client to speak first.
+const httpProbe = "HEAD / HTTP/1.0\r\nHost: 127.0.0.1\r\nUser-Agent: cheetah-agent\r\n\r\n"
+
+// PortService is the service identified on an open port from its banner.
+type PortService struct {
+	Port int `json:"port"`
+	// Service is the protocol recognized from the banner (ssh, http, mysql, ...), or
+	// "unknown" when the port answered with something unrecognized.
+	Service string `json:"service"`
+	// Banner is the server's identification, such as its version string or HTTP Server
+	// header, with non-printable characters removed.
+	Banner string `json:"banner,omitempty"`
+}
+
+// grabBanners identifies the services listening on the given local ports when
+// SCAN_BANNERS is enabled. Each port gets a connection and at most two reads bounded by
+// SCAN_BANNER_TIMEOUT_MS; ports that answer nothing are left out.
+func grabBanners(ports []int) []PortService {
+	if !envBool("SCAN_BANNERS", false) || liteMode() || len(ports) == 0 {
+		return nil
+	}
+	timeout := time.Duration(envInt("SCAN_BANNER_TIMEOUT_MS", defaultBannerTimeoutMs)) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultBannerTimeoutMs * time.Millisecond
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var services []PortService
+	work := make(chan int)
+	for i := 0; i < min(bannerWorkers, len(ports)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range work {
+				if s, ok := grabBanner("127.0.0.1", p, timeout); ok {
+					mu.Lock()
+					services = append(services, s)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, p := range ports {
+		work <- p
+	}
+	close(work)
+	wg.Wait()
+	sort.Slice(services, func(i, j int) bool { return services[i].Port < services[j].Port })
+	return services
+}
+
+// grabBanner reads what the service on port says after connecting, or in answer to an
+// HTTP request if it says nothing, and identifies it.
+func grabBanner(host string, port int, timeout time.Duration) (PortService, bool) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		return PortService{}, false
+	}
+	defer conn.Close()
+	buf := make([]byte, maxBannerRead)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	n, _ := conn.Read(buf)
+	if n == 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+		if _, err := conn.Write([]byte(httpProbe)); err != nil {
+			return PortService{}, false
+		}
+		n, _ = conn.Read(buf)
+	}
+	if n == 0 {
+		return PortService{}, false
+	}
+	service, banner := identifyBanner(buf[:n])
+	return PortService{Port: port, Service: service, Banner: banner}, true
+}
+
+// identifyBanner recognizes the protocol of the first bytes sent by a service and extracts
+// its identification.
+func identifyBanner(data []byte) (service, banner string) {
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	first := cleanBanner(string(line))
+	upper := strings.ToUpper(first)
+	switch {
+	case bytes.HasPrefix(data, []byte("SSH-")):
+		return "ssh", first
+	case bytes.HasPrefix(data, []byte("HTTP/")):
+		for _, header := range strings.Split(string(data), "\n") {
+			if name, value, ok := strings.Cut(header, ":"); ok && strings.EqualFold(strings.TrimSpace(name), "server") {
+				return "http", cleanBanner(value)
+			}
+		}
+		return "http", first
+	case strings.HasPrefix(first, "220"):
+		if strings.Contains(upper, "FTP") {
+			return "ftp", first
+		}
+		return "smtp", first
+	case strings.HasPrefix(first, "+OK"):
+		return "pop3", first
+	case strings.HasPrefix(first, "* OK"):
+		return "imap", first
+	case bytes.HasPrefix(data, []byte("RFB ")):
+		return "vnc", first
+	case len(data) > 5 && data[3] == 0 && data[4] == 10:
+		// MySQL and MariaDB greet with a protocol 10 handshake packet whose payload starts
+		// with the NUL-terminated server version.
+		version, _, _ := bytes.Cut(data[5:], []byte{0})
+		return "mysql", cleanBanner(string(version))
+	case len(data) > 5 && data[3] == 0 && data[4] == 0xff:
+		// A MySQL error packet, such as "host is not allowed to connect".
+		return "mysql", ""
+	case len(data) > 2 && data[0] == 0x15 && data[1] == 3:
+		// A TLS alert in answer to the plaintext probe.
+		return "tls", ""
+	}
+	return "unknown", first
+}
+
+// cleanBanner keeps the printable ASCII characters of a banner, trimmed and truncated.
+func cleanBanner(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.TrimSpace(s)
+	if len(s) > maxBannerLength {
+		s = s[:maxBannerLength]
+	}
+	return s
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdentifyBanner(t *testing.T) {
+	tests := []struct {
+		name, data      string
+		service, banner string
+	}{
+		{"ssh", "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13\r\n", "ssh", "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13"},
+		{"http with server", "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nserver: nginx/1.24.0\r\n\r\n", "http", "nginx/1.24.0"},
+		{"http without server", "HTTP/1.0 400 Bad Request\r\n\r\n", "http", "HTTP/1.0 400 Bad Request"},
+		{"ftp", "220 (vsFTPd 3.0.5)\r\n", "ftp", "220 (vsFTPd 3.0.5)"},
+		{"smtp", "220 mail.example.com ESMTP Postfix\r\n", "smtp", "220 mail.example.com ESMTP Postfix"},
+		{"pop3", "+OK Dovecot ready.\r\n", "pop3", "+OK Dovecot ready."},
+		{"imap", "* OK [CAPABILITY IMAP4rev1] Dovecot ready.\r\n", "imap", "* OK [CAPABILITY IMAP4rev1] Dovecot ready."},
+		{"vnc", "RFB 003.008\n", "vnc", "RFB 003.008"},
+		{"mysql", "\x4a\x00\x00\x00\x0a8.0.36\x00\x08\x00\x00\x00", "mysql", "8.0.36"},
+		{"mysql error", "\x45\x00\x00\x00\xffj\x04Host is not allowed", "mysql", ""},
+		{"tls alert", "\x15\x03\x01\x00\x02\x02\x46", "tls", ""},
+		{"unknown", "hello\x01\x02 there\n", "unknown", "hello there"},
+		{"long", strings.Repeat("x", 300), "unknown", strings.Repeat("x", maxBannerLength)},
+	}
+	for _, tt := range tests {
+		service, banner := identifyBanner([]byte(tt.data))
+		if service != tt.service || banner != tt.banner {
+			t.Errorf("%s: got %q, %q; want %q, %q", tt.name, service, banner, tt.service, tt.banner)
+		}
+	}
+}
+
+func TestGrabBannerProbesSilentServices(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("cannot listen:", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// An HTTP server says nothing until it receives a request.
+			buf := make([]byte, 512)
+			conn.Read(buf)
+			conn.Write([]byte("HTTP/1.1 200 OK\r\nServer: test/1.0\r\n\r\n"))
+			conn.Close()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+	got, ok := grabBanner("127.0.0.1", port, 200*time.Millisecond)
+	if want := (PortService{Port: port, Service: "http", Banner: "test/1.0"}); !ok || got != want {
+		t.Errorf("got %+v, %v; want %+v", got, ok, want)
+	}
+}
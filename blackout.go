@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// BlackoutWindow is a recurring period, such as a nightly backup, during which the
+// agent mutes its events and can skip its active checks, because the load or failures
+// they would report are expected.
+type BlackoutWindow struct {
+	Name string `json:"name"`
+	// Schedule is a standard 5-field cron expression for the start of each window, in
+	// local time unless prefixed with CRON_TZ=<zone>, e.g. "CRON_TZ=UTC 0 2 * * *".
+	Schedule string `json:"schedule"`
+	// Duration is how long each window lasts, e.g. "2h".
+	Duration string `json:"duration"`
+	// SuppressChecks skips the latency, file freshness, port and bandwidth checks during
+	// the window.
+	SuppressChecks bool `json:"suppressChecks,omitempty"`
+
+	schedule cron.Schedule
+	duration time.Duration
+}
+
+// blackouts holds the windows loaded from BLACKOUT_FILE and the names active at the last
+// call to trackBlackouts.
+var blackouts struct {
+	sync.Mutex
+	windows []BlackoutWindow
+	active  map[string]bool
+}
+
+// loadBlackoutWindows reads and validates BLACKOUT_FILE at startup, so a bad schedule is
+// reported immediately rather than silently never matching.
+func loadBlackoutWindows() error {
+	file := os.Getenv("BLACKOUT_FILE")
+	if file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read blackout windows: %v", err)
+	}
+	var windows []BlackoutWindow
+	if err := json.Unmarshal(data, &windows); err != nil {
+		return fmt.Errorf("invalid blackout windows file: %v", err)
+	}
+	for i := range windows {
+		w := &windows[i]
+		if w.Name == "" {
+			return fmt.Errorf("blackout window %d has no name", i+1)
+		}
+		if w.schedule, err = cron.ParseStandard(w.Schedule); err != nil {
+			return fmt.Errorf("blackout window %s: invalid schedule %q: %v", w.Name, w.Schedule, err)
+		}
+		if w.duration, err = time.ParseDuration(w.Duration); err != nil || w.duration <= 0 {
+			return fmt.Errorf("blackout window %s: invalid duration %q", w.Name, w.Duration)
+		}
+	}
+	blackouts.Lock()
+	blackouts.windows = windows
+	blackouts.Unlock()
+	fmt.Printf("Loaded %d blackout windows\n", len(windows))
+	return nil
+}
+
+// active reports whether a window started by w's schedule covers now: the first start
+// after now-duration must not be later than now.
+func (w BlackoutWindow) active(now time.Time) bool {
+	return !w.schedule.Next(now.Add(-w.duration)).After(now)
+}
+
+// activeBlackouts returns the windows covering the current time.
+func activeBlackouts() []BlackoutWindow {
+	blackouts.Lock()
+	defer blackouts.Unlock()
+	now := time.Now()
+	var active []BlackoutWindow
+	for _, w := range blackouts.windows {
+		if w.active(now) {
+			active = append(active, w)
+		}
+	}
+	return active
+}
+
+// activeBlackoutNames returns the sorted names of the windows covering the current time.
+func activeBlackoutNames() []string {
+	var names []string
+	for _, w := range activeBlackouts() {
+		names = append(names, w.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checksSuppressed reports whether an active blackout window suppresses the active checks.
+func checksSuppressed() bool {
+	for _, w := range activeBlackouts() {
+		if w.SuppressChecks {
+			return true
+		}
+	}
+	return false
+}
+
+// trackBlackouts is called once per collection: it emits blackout.started and
+// blackout.ended events as windows begin and end, and returns the names of the active
+// windows, reported in the blackout field of the payload.
+func trackBlackouts() []string {
+	names := activeBlackoutNames()
+	current := make(map[string]bool, len(names))
+	for _, name := range names {
+		current[name] = true
+	}
+	blackouts.Lock()
+	previous := blackouts.active
+	blackouts.active = current
+	blackouts.Unlock()
+	for _, name := range names {
+		if !previous[name] {
+			queueEvent(Event{
+				Type:       "blackout.started",
+				Message:    fmt.Sprintf("Blackout window %s started, alerts are muted", name),
+				Timestamp:  time.Now().UnixMilli(),
+				Attributes: map[string]string{"window": name},
+			})
+		}
+	}
+	for name := range previous {
+		if !current[name] {
+			queueEvent(Event{
+				Type:       "blackout.ended",
+				Message:    fmt.Sprintf("Blackout window %s ended", name),
+				Timestamp:  time.Now().UnixMilli(),
+				Attributes: map[string]string{"window": name},
+			})
+		}
+	}
+	return names
+}
+
+// muteAttributes returns the attributes added to events raised while alerting is muted by
+// a maintenance window or blackout windows, or nil when it is not.
+func muteAttributes() map[string]string {
+	attributes := make(map[string]string)
+	if inMaintenance() {
+		attributes["maintenance"] = "true"
+	}
+	if names := activeBlackoutNames(); len(names) > 0 {
+		attributes["blackout"] = strings.Join(names, ",")
+	}
+	if len(attributes) == 0 {
+		return nil
+	}
+	return attributes
+}
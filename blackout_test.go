@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+func TestBlackoutWindowActive(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 5, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		schedule string
+		duration time.Duration
+		now      time.Time
+		want     bool
+	}{
+		{"CRON_TZ=UTC 0 2 * * *", 2 * time.Hour, at(1, 1, 59), false},
+		{"CRON_TZ=UTC 0 2 * * *", 2 * time.Hour, at(1, 2, 0), true},
+		{"CRON_TZ=UTC 0 2 * * *", 2 * time.Hour, at(1, 3, 59), true},
+		// The window ends when its duration has elapsed.
+		{"CRON_TZ=UTC 0 2 * * *", 2 * time.Hour, at(1, 4, 0), false},
+		// A window crossing midnight.
+		{"CRON_TZ=UTC 30 23 * * *", time.Hour, at(1, 23, 45), true},
+		{"CRON_TZ=UTC 30 23 * * *", time.Hour, at(2, 0, 15), true},
+		{"CRON_TZ=UTC 30 23 * * *", time.Hour, at(2, 0, 45), false},
+		// Saturdays only; 2024-05-04 is a Saturday.
+		{"CRON_TZ=UTC 0 0 * * 6", 24 * time.Hour, at(4, 12, 0), true},
+		{"CRON_TZ=UTC 0 0 * * 6", 24 * time.Hour, at(5, 12, 0), false},
+	}
+	for _, tt := range tests {
+		schedule, err := cron.ParseStandard(tt.schedule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := BlackoutWindow{schedule: schedule, duration: tt.duration}
+		if got := w.active(tt.now); got != tt.want {
+			t.Errorf("%q for %s at %s: got %v, want %v", tt.schedule, tt.duration, tt.now.Format(time.RFC3339), got, tt.want)
+		}
+	}
+}
+
+func TestLoadBlackoutWindows(t *testing.T) {
+	t.Cleanup(func() { blackouts.windows = nil })
+	tests := []struct {
+		file    string
+		wantErr bool
+	}{
+		{`[{"name": "backup", "schedule": "0 2 * * *", "duration": "2h", "suppressChecks": true}]`, false},
+		{`[]`, false},
+		{`[{"schedule": "0 2 * * *", "duration": "2h"}]`, true},
+		{`[{"name": "backup", "schedule": "every night", "duration": "2h"}]`, true},
+		{`[{"name": "backup", "schedule": "0 2 * * *", "duration": "forever"}]`, true},
+		{`[{"name": "backup", "schedule": "0 2 * * *", "duration": "-1h"}]`, true},
+		{`{"name": "backup"}`, true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "blackouts.json")
+		if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("BLACKOUT_FILE", path)
+		if err := loadBlackoutWindows(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.file, err, tt.wantErr)
+		}
+	}
+}
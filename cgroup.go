@@ -0,0 +1,164 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// unlimitedCgroupMemory is the threshold above which a cgroup v1 memory limit means "no limit".
+const unlimitedCgroupMemory = 1 << 62
+
+// CgroupStats reports the resource limits and usage of the agent's cgroup.
+type CgroupStats struct {
+	Version          int     `json:"version"`
+	MemoryLimitBytes uint64  `json:"memoryLimitBytes,omitempty"`
+	MemoryUsageBytes uint64  `json:"memoryUsageBytes"`
+	CPUQuotaCores    float64 `json:"cpuQuotaCores,omitempty"`
+	CPUUsagePercent  float64 `json:"cpuUsagePercent,omitempty"`
+	NrPeriods        uint64  `json:"nrPeriods"`
+	NrThrottled      uint64  `json:"nrThrottled"`
+	ThrottledUsec    uint64  `json:"throttledUsec"`
+}
+
+// lastCgroupCPU holds the previous cgroup CPU usage sample, used to compute CPU percentage.
+var lastCgroupCPU struct {
+	sync.Mutex
+	usageUsec uint64
+	at        time.Time
+}
+
+// isContainerized reports whether the agent appears to be running inside a container.
+func isContainerized() bool {
+	for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return true
+	}
+	data, err := os.ReadFile("/proc/1/cgroup")
+	if err != nil {
+		return false
+	}
+	for _, marker := range []string{"docker", "kubepods", "containerd", "libpod", "lxc"} {
+		if strings.Contains(string(data), marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// readKeyValues parses a file of "key value" lines such as cpu.stat.
+func readKeyValues(path string) map[string]uint64 {
+	values := make(map[string]uint64)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return values
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = v
+		}
+	}
+	return values
+}
+
+// readCgroupV2 reads limits and usage from the unified hierarchy.
+func readCgroupV2() (*CgroupStats, uint64, bool) {
+	const root = "/sys/fs/cgroup/"
+	usage, err := readUintFile(root + "memory.current")
+	if err != nil {
+		return nil, 0, false
+	}
+	stats := &CgroupStats{Version: 2, MemoryUsageBytes: usage}
+	if limit, err := readUintFile(root + "memory.max"); err == nil {
+		stats.MemoryLimitBytes = limit
+	}
+	if data, err := os.ReadFile(root + "cpu.max"); err == nil {
+		// Format: "<quota|max> <period>"
+		fields := strings.Fields(string(data))
+		if len(fields) == 2 && fields[0] != "max" {
+			quota, _ := strconv.ParseFloat(fields[0], 64)
+			period, _ := strconv.ParseFloat(fields[1], 64)
+			if period > 0 {
+				stats.CPUQuotaCores = quota / period
+			}
+		}
+	}
+	cpuStat := readKeyValues(root + "cpu.stat")
+	stats.NrPeriods = cpuStat["nr_periods"]
+	stats.NrThrottled = cpuStat["nr_throttled"]
+	stats.ThrottledUsec = cpuStat["throttled_usec"]
+	return stats, cpuStat["usage_usec"], true
+}
+
+// readCgroupV1 reads limits and usage from the legacy per-controller hierarchies.
+func readCgroupV1() (*CgroupStats, uint64, bool) {
+	const root = "/sys/fs/cgroup/"
+	usage, err := readUintFile(root + "memory/memory.usage_in_bytes")
+	if err != nil {
+		return nil, 0, false
+	}
+	stats := &CgroupStats{Version: 1, MemoryUsageBytes: usage}
+	if limit, err := readUintFile(root + "memory/memory.limit_in_bytes"); err == nil && limit < unlimitedCgroupMemory {
+		stats.MemoryLimitBytes = limit
+	}
+	quota, errQ := strconv.ParseFloat(readTrimmed(root+"cpu/cpu.cfs_quota_us"), 64)
+	period, errP := strconv.ParseFloat(readTrimmed(root+"cpu/cpu.cfs_period_us"), 64)
+	if errQ == nil && errP == nil && quota > 0 && period > 0 {
+		stats.CPUQuotaCores = quota / period
+	}
+	cpuStat := readKeyValues(root + "cpu/cpu.stat")
+	stats.NrPeriods = cpuStat["nr_periods"]
+	stats.NrThrottled = cpuStat["nr_throttled"]
+	stats.ThrottledUsec = cpuStat["throttled_time"] / 1000
+	usageNs, _ := readUintFile(root + "cpuacct/cpuacct.usage")
+	return stats, usageNs / 1000, true
+}
+
+// readTrimmed returns the trimmed content of a file, or "" on error.
+func readTrimmed(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// collectCgroup returns cgroup limits and usage when the agent runs inside a container,
+// or nil otherwise. CPU usage is computed relative to the CPU quota from the delta since
+// the previous collection, so it is only available from the second collection onwards.
+func collectCgroup() *CgroupStats {
+	if !isContainerized() {
+		return nil
+	}
+	stats, usageUsec, ok := readCgroupV2()
+	if !ok {
+		stats, usageUsec, ok = readCgroupV1()
+	}
+	if !ok {
+		return nil
+	}
+
+	lastCgroupCPU.Lock()
+	defer lastCgroupCPU.Unlock()
+	now := time.Now()
+	if !lastCgroupCPU.at.IsZero() && stats.CPUQuotaCores > 0 && usageUsec >= lastCgroupCPU.usageUsec {
+		elapsed := now.Sub(lastCgroupCPU.at).Microseconds()
+		if elapsed > 0 {
+			used := float64(usageUsec-lastCgroupCPU.usageUsec) / float64(elapsed)
+			stats.CPUUsagePercent = used / stats.CPUQuotaCores * 100
+		}
+	}
+	lastCgroupCPU.usageUsec = usageUsec
+	lastCgroupCPU.at = now
+	return stats
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Collector health states.
+const (
+	collectorOK = "ok"
+	// collectorDegraded means the collector's last runs failed, fewer times in a row than
+	// COLLECTOR_MAX_FAILURES.
+	collectorDegraded = "degraded"
+	// collectorFailed means the collector failed COLLECTOR_MAX_FAILURES times in a row and
+	// is disabled until its next retry.
+	collectorFailed = "failed"
+	// collectorDisabled means the collector is switched off at runtime and has not run since
+	// the agent started.
+	collectorDisabled = "disabled"
+)
+
+// Defaults of the automatic disabling of failing collectors.
+const (
+	defaultCollectorMaxFailures  = 5
+	defaultCollectorRetrySeconds = 600
+)
+
+// CollectorHealth is the health of one collector. A run fails when the collector panics,
+// returns an error or returns a result whose Error field is set.
+type CollectorHealth struct {
+	State             string `json:"state"`
+	ConsecutiveErrors int    `json:"consecutiveErrors,omitempty"`
+	TotalErrors       int    `json:"totalErrors,omitempty"`
+	LastError         string `json:"lastError,omitempty"`
+	LastErrorAt       int64  `json:"lastErrorAt,omitempty"`
+	// RetryAt is when a failed collector is next run, in Unix milliseconds.
+	RetryAt int64 `json:"retryAt,omitempty"`
+}
+
+// collectorHealth holds the health of every collector run or skipped so far, by collector
+// name.
+var collectorHealth struct {
+	sync.Mutex
+	collectors map[string]*CollectorHealth
+}
+
+// collectorSkipped reports whether name is disabled at runtime, or after repeated failures
+// and not yet due for a retry.
+// A collector disabled at runtime that has not run yet is recorded as collectorDisabled, so
+// it is known to the agent API even when it was disabled before a restart.
+func collectorSkipped(name string) bool {
+	disabled := collectorDisabledBy(name) != ""
+	collectorHealth.Lock()
+	defer collectorHealth.Unlock()
+	h := collectorHealth.collectors[name]
+	if disabled {
+		if h == nil {
+			if collectorHealth.collectors == nil {
+				collectorHealth.collectors = make(map[string]*CollectorHealth)
+			}
+			collectorHealth.collectors[name] = &CollectorHealth{State: collectorDisabled}
+		}
+		return true
+	}
+	return h != nil && h.State == collectorFailed && time.Now().UnixMilli() < h.RetryAt
+}
+
+// recordCollectorRun updates the health of name after a run that failed with errMsg, or
+// succeeded when errMsg is empty, and emits collector.disabled and collector.recovered
+// events on the transitions to and from the failed state.
+func recordCollectorRun(name, errMsg string) {
+	collectorHealth.Lock()
+	if collectorHealth.collectors == nil {
+		collectorHealth.collectors = make(map[string]*CollectorHealth)
+	}
+	h := collectorHealth.collectors[name]
+	if h == nil {
+		h = &CollectorHealth{State: collectorOK}
+		collectorHealth.collectors[name] = h
+	}
+	previous := h.State
+	if errMsg == "" {
+		h.State, h.ConsecutiveErrors, h.RetryAt = collectorOK, 0, 0
+	} else {
+		h.ConsecutiveErrors++
+		h.TotalErrors++
+		h.LastError, h.LastErrorAt = errMsg, time.Now().UnixMilli()
+		h.State = collectorDegraded
+		if max := envInt("COLLECTOR_MAX_FAILURES", defaultCollectorMaxFailures); max > 0 && h.ConsecutiveErrors >= max {
+			retry := time.Duration(envInt("COLLECTOR_RETRY_INTERVAL", defaultCollectorRetrySeconds)) * time.Second
+			h.State, h.RetryAt = collectorFailed, time.Now().Add(retry).UnixMilli()
+		}
+	}
+	state, failures, retryAt := h.State, h.ConsecutiveErrors, h.RetryAt
+	collectorHealth.Unlock()
+
+	switch {
+	case state == collectorFailed && previous != collectorFailed:
+		queueEvent(Event{
+			Type:      "collector.disabled",
+			Message:   fmt.Sprintf("collector %s disabled after %d consecutive failures: %s", name, failures, errMsg),
+			Timestamp: time.Now().UnixMilli(),
+			Attributes: map[string]string{
+				"collector": name,
+				"error":     errMsg,
+				"retryAt":   time.UnixMilli(retryAt).UTC().Format(time.RFC3339),
+			},
+		})
+	case state == collectorOK && previous == collectorFailed:
+		queueEvent(Event{
+			Type:       "collector.recovered",
+			Message:    fmt.Sprintf("collector %s recovered", name),
+			Timestamp:  time.Now().UnixMilli(),
+			Attributes: map[string]string{"collector": name},
+		})
+	}
+}
+
+// resetCollectorHealth clears the failures of name, so a collector disabled after repeated
+// failures runs again in the next cycle.
+func resetCollectorHealth(name string) {
+	collectorHealth.Lock()
+	defer collectorHealth.Unlock()
+	if h := collectorHealth.collectors[name]; h != nil {
+		h.State, h.ConsecutiveErrors, h.RetryAt = collectorOK, 0, 0
+	}
+}
+
+// resultError returns the Error field of a collector result, a struct or a pointer to
+// one, or "" when it has none.
+func resultError(result interface{}) string {
+	v := reflect.ValueOf(result)
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	if f := v.FieldByName("Error"); f.IsValid() && f.Kind() == reflect.String {
+		return f.String()
+	}
+	return ""
+}
+
+// notImplemented reports whether err is gopsutil's error for a function it does not
+// implement on this platform, which collectors treat as having nothing to report.
+func notImplemented(err error) bool {
+	return err != nil && err.Error() == "not implemented yet"
+}
+
+// unhealthyCollectors returns the health of the collectors that are not ok, reported in
+// each payload. Collectors disabled at runtime are reported in disabledCollectors instead.
+func unhealthyCollectors() map[string]CollectorHealth {
+	collectorHealth.Lock()
+	defer collectorHealth.Unlock()
+	var unhealthy map[string]CollectorHealth
+	for name, h := range collectorHealth.collectors {
+		if h.State != collectorOK && h.State != collectorDisabled {
+			if unhealthy == nil {
+				unhealthy = make(map[string]CollectorHealth)
+			}
+			unhealthy[name] = *h
+		}
+	}
+	return unhealthy
+}
+
+// CollectorStatus is the health and timing of one collector in GET /status.
+type CollectorStatus struct {
+	Name string `json:"name"`
+	CollectorHealth
+	// DisabledBy is set when the collector was switched off at runtime, through the agent
+	// API ("api") or the remote configuration ("server").
+	DisabledBy string `json:"disabledBy,omitempty"`
+	// EnabledBy is "api" when the collector was switched on through the agent API although
+	// its configuration leaves it off.
+	EnabledBy string           `json:"enabledBy,omitempty"`
+	Timing    *CollectorTiming `json:"timing,omitempty"`
+	// Parts is the timing of each plugin, WASM module or script run by the collector.
+	Parts map[string]*CollectorTiming `json:"parts,omitempty"`
+}
+
+// collectorStatus returns the health, timing and runtime state of name.
+func collectorStatus(name string) CollectorStatus {
+	collectorHealth.Lock()
+	status := CollectorStatus{Name: name}
+	if h := collectorHealth.collectors[name]; h != nil {
+		status.CollectorHealth = *h
+	}
+	collectorHealth.Unlock()
+	status.DisabledBy = collectorDisabledBy(name)
+	status.EnabledBy = collectorEnabledBy(name)
+	status.Timing = collectorTiming(name)
+	status.Parts = partTimings(name)
+	return status
+}
+
+// allCollectorHealth returns the health, timing and runtime state of every collector run or
+// skipped so far, sorted by name.
+func allCollectorHealth() []CollectorStatus {
+	collectorHealth.Lock()
+	names := make([]string, 0, len(collectorHealth.collectors))
+	for name := range collectorHealth.collectors {
+		names = append(names, name)
+	}
+	collectorHealth.Unlock()
+	statuses := make([]CollectorStatus, 0, len(names))
+	for _, name := range names {
+		statuses = append(statuses, collectorStatus(name))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
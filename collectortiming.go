@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCollectorBudgetMs is how long a collector may take per cycle before it is
+// reported as slow.
+const defaultCollectorBudgetMs = 5000
+
+// CollectorTiming is how long one collector takes per cycle, in milliseconds.
+type CollectorTiming struct {
+	LastMs float64 `json:"lastMs"`
+	AvgMs  float64 `json:"avgMs"`
+	MaxMs  float64 `json:"maxMs"`
+	Runs   int     `json:"runs"`
+	// BudgetMs is the time allowed per run; OverBudget counts the runs that exceeded it.
+	BudgetMs   int64 `json:"budgetMs,omitempty"`
+	OverBudget int   `json:"overBudget,omitempty"`
+	slow       bool
+}
+
+// collectorTimings holds the timing of every collector run so far, by collector name.
+var collectorTimings struct {
+	sync.Mutex
+	collectors map[string]*CollectorTiming
+}
+
+// collectorBudget returns the time name may take per run: its entry in COLLECTOR_BUDGETS,
+// a comma-separated list of name=milliseconds such as "sql=10000,plugins=2000", else
+// COLLECTOR_BUDGET_MS. It returns 0 when the budget is disabled.
+func collectorBudget(name string) time.Duration {
+	for _, item := range envList("COLLECTOR_BUDGETS", nil) {
+		if n, ms, ok := strings.Cut(item, "="); ok && strings.TrimSpace(n) == name {
+			if v, err := strconv.Atoi(strings.TrimSpace(ms)); err == nil && v >= 0 {
+				return time.Duration(v) * time.Millisecond
+			}
+		}
+	}
+	return time.Duration(envInt("COLLECTOR_BUDGET_MS", defaultCollectorBudgetMs)) * time.Millisecond
+}
+
+// recordCollectorDuration records how long a run of name took, and emits collector.slow
+// when it starts exceeding its budget.
+func recordCollectorDuration(name string, d time.Duration) {
+	budget := collectorBudget(name)
+	ms := float64(d.Microseconds()) / 1000
+
+	collectorTimings.Lock()
+	if collectorTimings.collectors == nil {
+		collectorTimings.collectors = make(map[string]*CollectorTiming)
+	}
+	t := collectorTimings.collectors[name]
+	if t == nil {
+		t = &CollectorTiming{}
+		collectorTimings.collectors[name] = t
+	}
+	t.Runs++
+	t.LastMs = ms
+	t.AvgMs += (ms - t.AvgMs) / float64(t.Runs)
+	t.MaxMs = max(t.MaxMs, ms)
+	t.BudgetMs = budget.Milliseconds()
+	over := budget > 0 && d > budget
+	wasSlow := t.slow
+	t.slow = over
+	if over {
+		t.OverBudget++
+	}
+	collectorTimings.Unlock()
+
+	if over && !wasSlow {
+		queueEvent(Event{
+			Type:      "collector.slow",
+			Message:   fmt.Sprintf("collector %s took %s, over its budget of %s", name, d.Round(time.Millisecond), budget),
+			Timestamp: time.Now().UnixMilli(),
+			Attributes: map[string]string{
+				"collector":  name,
+				"durationMs": strconv.FormatInt(d.Milliseconds(), 10),
+				"budgetMs":   strconv.FormatInt(budget.Milliseconds(), 10),
+			},
+		})
+	}
+}
+
+// collectorTiming returns the timing of name, or nil if it has not run.
+func collectorTiming(name string) *CollectorTiming {
+	collectorTimings.Lock()
+	defer collectorTimings.Unlock()
+	if t := collectorTimings.collectors[name]; t != nil {
+		timing := *t
+		return &timing
+	}
+	return nil
+}
+
+// lastCollectorDurations returns the duration of the last run of every collector, in
+// milliseconds, reported in the agent's self-telemetry.
+func lastCollectorDurations() map[string]float64 {
+	collectorTimings.Lock()
+	defer collectorTimings.Unlock()
+	if len(collectorTimings.collectors) == 0 {
+		return nil
+	}
+	durations := make(map[string]float64, len(collectorTimings.collectors))
+	for name, t := range collectorTimings.collectors {
+		durations[name] = t.LastMs
+	}
+	return durations
+}
+
+// recordPartDurations records how long each part of the collector name took in this run,
+// such as each plugin of plugins, under "<name>.<part>". Parts that no longer exist are
+// forgotten.
+func recordPartDurations(name string, durations map[string]time.Duration) {
+	for part, d := range durations {
+		recordCollectorDuration(name+"."+part, d)
+	}
+	collectorTimings.Lock()
+	defer collectorTimings.Unlock()
+	for key := range collectorTimings.collectors {
+		if part, ok := strings.CutPrefix(key, name+"."); ok {
+			if _, ran := durations[part]; !ran {
+				delete(collectorTimings.collectors, key)
+			}
+		}
+	}
+}
+
+// partTimings returns the timing of every part of the collector name, by part name, or nil
+// if it has none.
+func partTimings(name string) map[string]*CollectorTiming {
+	collectorTimings.Lock()
+	defer collectorTimings.Unlock()
+	var parts map[string]*CollectorTiming
+	for key, t := range collectorTimings.collectors {
+		if part, ok := strings.CutPrefix(key, name+"."); ok {
+			if parts == nil {
+				parts = make(map[string]*CollectorTiming)
+			}
+			timing := *t
+			parts[part] = &timing
+		}
+	}
+	return parts
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordPartDurations(t *testing.T) {
+	t.Setenv("STATE_DIR", t.TempDir())
+	t.Cleanup(func() {
+		collectorTimings.Lock()
+		for _, name := range []string{"plugins", "plugins.fast", "plugins.slow", "pluginsx.other"} {
+			delete(collectorTimings.collectors, name)
+		}
+		collectorTimings.Unlock()
+	})
+	recordCollectorDuration("plugins", 50*time.Millisecond)
+	recordCollectorDuration("pluginsx.other", time.Millisecond)
+	recordPartDurations("plugins", map[string]time.Duration{"fast": time.Millisecond, "slow": 40 * time.Millisecond})
+	parts := partTimings("plugins")
+	if len(parts) != 2 || parts["fast"].LastMs != 1 || parts["slow"].LastMs != 40 {
+		t.Fatalf("got parts %v, want fast at 1ms and slow at 40ms", parts)
+	}
+
+	recordPartDurations("plugins", map[string]time.Duration{"slow": 20 * time.Millisecond})
+	parts = partTimings("plugins")
+	if len(parts) != 1 || parts["slow"].Runs != 2 || parts["slow"].MaxMs != 40 {
+		t.Errorf("got parts %v, want only slow with 2 runs", parts)
+	}
+	if collectorTiming("plugins") == nil || collectorTiming("pluginsx.other") == nil {
+		t.Error("timings other than the removed part were forgotten")
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// Sources of a collector being switched on or off at runtime, reported in GET /status.
+const (
+	disabledByAPI    = "api"
+	disabledByServer = "server"
+)
+
+// collectorOverrides holds the collectors switched on or off at runtime, by collector name:
+// through the agent API, which wins, and through the remote configuration.
+var collectorOverrides struct {
+	sync.Mutex
+	// local maps a collector to false when disabled through the agent API, or to true when
+	// enabled there despite the remote configuration.
+	local map[string]bool
+	// remote are the collectors disabled by the remote configuration.
+	remote []string
+	// configured maps a collector with an enable variable to whether the configuration
+	// enables it, as seen on its last run.
+	configured map[string]bool
+}
+
+// collectorFlags maps the environment variables checked by collectorEnabled to the name of
+// the collector they switch on or off, so enabling a collector through the agent API also
+// overrides them.
+var collectorFlags = map[string]string{
+	"ASSET_DISCOVERY":    "discoveredAssets",
+	"CONNTRACK_STATS":    "conntrack",
+	"CONTAINER_STATS":    "containers",
+	"DISK_BUSY_STATS":    "diskBusy",
+	"DISK_LIST":          "disks",
+	"EBPF_PROCESS_NET":   "processNetwork",
+	"FD_STATS":           "fileHandles",
+	"FIREWALL_INVENTORY": "firewall",
+	"IIS_STATS":          "iis",
+	"INTERFACE_STATS":    "interfaces",
+	"JOB_CHECKS":         "jobs",
+	"JSON_SCRAPE":        "jsonScrapes",
+	"LAN_DISCOVERY":      "lanDiscovery",
+	"LSM_STATUS":         "securityModules",
+	"LVM_STATS":          "lvm",
+	"MAC_POWER_STATS":    "macPower",
+	"MSSQL_STATS":        "mssql",
+	"NEIGHBOR_TABLE":     "neighbors",
+	"NUMA_STATS":         "memoryTopology",
+	"PACKAGE_UPDATES":    "packageUpdates",
+	"PLUGINS":            "plugins",
+	"PORTS_VERIFY":       "portStatus",
+	"PROCESS_COUNTS":     "processCounts",
+	"PROCESS_STATS":      "topProcesses",
+	"PSI_STATS":          "pressure",
+	"RAID_STATS":         "raid",
+	"REBOOT_CHECK":       "reboot",
+	"ROUTE_MONITORING":   "route",
+	"SCRIPTS":            "scripts",
+	"SQL_QUERIES":        "sql",
+	"STORAGE_POOLS":      "storagePools",
+	"TCP_STATS":          "tcp",
+	"WASM_COLLECTORS":    "wasm",
+	"WINDOWS_INVENTORY":  "windowsInventory",
+}
+
+// applyCollectorOverride returns whether the collector switched by the variable flag runs,
+// given whether the configuration enables it: a collector enabled through the agent API
+// runs even when the configuration or its default leaves it off.
+func applyCollectorOverride(flag string, configured bool) bool {
+	name, ok := collectorFlags[flag]
+	if !ok {
+		return configured
+	}
+	collectorOverrides.Lock()
+	defer collectorOverrides.Unlock()
+	if collectorOverrides.configured == nil {
+		collectorOverrides.configured = make(map[string]bool)
+	}
+	collectorOverrides.configured[name] = configured
+	return configured || collectorOverrides.local[name]
+}
+
+// collectorEnabledBy returns disabledByAPI when the collector name runs only because it was
+// enabled through the agent API, its configuration leaving it off, or "" otherwise.
+func collectorEnabledBy(name string) string {
+	collectorOverrides.Lock()
+	defer collectorOverrides.Unlock()
+	configured, ok := collectorOverrides.configured[name]
+	if ok && !configured && collectorOverrides.local[name] {
+		return disabledByAPI
+	}
+	return ""
+}
+
+// loadCollectorOverrides restores the collectors switched on or off before the last restart.
+func loadCollectorOverrides() {
+	state := readState()
+	collectorOverrides.Lock()
+	collectorOverrides.local = state.CollectorOverrides
+	collectorOverrides.remote = state.RemoteDisabledCollectors
+	collectorOverrides.Unlock()
+}
+
+// collectorDisabledBy returns who disabled the collector name at runtime, disabledByAPI or
+// disabledByServer, or "" when it runs as configured.
+func collectorDisabledBy(name string) string {
+	collectorOverrides.Lock()
+	defer collectorOverrides.Unlock()
+	if enabled, ok := collectorOverrides.local[name]; ok {
+		if enabled {
+			return ""
+		}
+		return disabledByAPI
+	}
+	if slices.Contains(collectorOverrides.remote, name) {
+		return disabledByServer
+	}
+	return ""
+}
+
+// disabledCollectors returns the collectors disabled at runtime, reported in each payload.
+func disabledCollectors() []string {
+	collectorOverrides.Lock()
+	var names []string
+	for name, enabled := range collectorOverrides.local {
+		if !enabled {
+			names = append(names, name)
+		}
+	}
+	for _, name := range collectorOverrides.remote {
+		if _, ok := collectorOverrides.local[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	collectorOverrides.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+// setCollectorOverride switches the collector name on or off through the agent API, or
+// back to the remote and local configuration when enabled is nil, and persists the choice.
+func setCollectorOverride(name string, enabled *bool) {
+	collectorOverrides.Lock()
+	if enabled == nil {
+		delete(collectorOverrides.local, name)
+	} else {
+		if collectorOverrides.local == nil {
+			collectorOverrides.local = make(map[string]bool)
+		}
+		collectorOverrides.local[name] = *enabled
+	}
+	local := make(map[string]bool, len(collectorOverrides.local))
+	for k, v := range collectorOverrides.local {
+		local[k] = v
+	}
+	collectorOverrides.Unlock()
+	updateState(func(s *AgentState) { s.CollectorOverrides = local })
+}
+
+// applyRemoteCollectors installs the collectors disabled by the remote configuration
+// received from the server at baseURL and persists them. Changes are recorded in the audit
+// log.
+func applyRemoteCollectors(baseURL string, disabled []string) {
+	disabled = slices.Clone(disabled)
+	sort.Strings(disabled)
+	collectorOverrides.Lock()
+	previous := collectorOverrides.remote
+	collectorOverrides.remote = disabled
+	collectorOverrides.Unlock()
+	updateState(func(s *AgentState) { s.RemoteDisabledCollectors = disabled })
+	if !slices.Equal(previous, disabled) {
+		writeAudit(AuditEntry{Actor: "server:" + baseURL, Action: "config.collectors", Details: fmt.Sprintf("disabled %v", disabled), Result: "applied"})
+	}
+}
+
+// knownCollector reports whether name is a collector that has run or was skipped as
+// disabled, so typos are rejected.
+func knownCollector(name string) bool {
+	collectorHealth.Lock()
+	defer collectorHealth.Unlock()
+	_, ok := collectorHealth.collectors[name]
+	return ok
+}
+
+// handleToggleCollector returns the handler of POST /collectors/{name}/enable and
+// /disable, which switch a collector on or off without restarting the agent. Enabling a
+// collector also ends a disabling after repeated failures and overrides a configuration
+// that leaves it off, so it runs in the next cycle; the response's enabledBy tells the
+// latter apart.
+func handleToggleCollector(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if !knownCollector(name) {
+			http.Error(w, fmt.Sprintf("unknown collector %q", name), http.StatusNotFound)
+			return
+		}
+		setCollectorOverride(name, &enabled)
+		if enabled {
+			resetCollectorHealth(name)
+			if collectorEnabledBy(name) != "" {
+				fmt.Printf("Collector %s enabled through the agent API, overriding its configuration\n", name)
+			} else {
+				fmt.Printf("Collector %s enabled through the agent API\n", name)
+			}
+		} else {
+			fmt.Printf("Collector %s disabled through the agent API\n", name)
+		}
+		writeJSON(w, collectorStatus(name))
+	}
+}
+
+// handleResetCollector handles DELETE /collectors/{name}, which drops the override set
+// through the agent API, so the collector runs as the configuration says again.
+func handleResetCollector(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !knownCollector(name) {
+		http.Error(w, fmt.Sprintf("unknown collector %q", name), http.StatusNotFound)
+		return
+	}
+	setCollectorOverride(name, nil)
+	writeJSON(w, collectorStatus(name))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestKnownCollectorDisabledBeforeRestart(t *testing.T) {
+	t.Setenv("STATE_DIR", t.TempDir())
+	disabled := false
+	setCollectorOverride("lvm", &disabled)
+	t.Cleanup(func() {
+		setCollectorOverride("lvm", nil)
+		collectorHealth.Lock()
+		delete(collectorHealth.collectors, "lvm")
+		collectorHealth.Unlock()
+	})
+
+	if knownCollector("lvm") {
+		t.Fatal("lvm known before any collection")
+	}
+	ran := false
+	timedCollect(collectionTimes{}, "lvm", func() int { ran = true; return 1 })
+	if ran {
+		t.Error("disabled collector ran")
+	}
+	if !knownCollector("lvm") {
+		t.Fatal("skipped collector not known to the agent API")
+	}
+	if state := collectorStatus("lvm").State; state != collectorDisabled {
+		t.Errorf("got state %q, want %q", state, collectorDisabled)
+	}
+	if _, ok := unhealthyCollectors()["lvm"]; ok {
+		t.Error("disabled collector reported as unhealthy")
+	}
+}
+
+func TestEnableCollectorOverridesConfiguration(t *testing.T) {
+	t.Setenv("STATE_DIR", t.TempDir())
+	t.Setenv("FIREWALL_INVENTORY", "")
+	t.Setenv("LVM_STATS", "true")
+	t.Cleanup(func() {
+		for _, name := range []string{"firewall", "lvm"} {
+			setCollectorOverride(name, nil)
+			collectorHealth.Lock()
+			delete(collectorHealth.collectors, name)
+			collectorHealth.Unlock()
+			collectorOverrides.Lock()
+			delete(collectorOverrides.configured, name)
+			collectorOverrides.Unlock()
+		}
+	})
+	collect := func(name, flag string) bool {
+		ran := false
+		timedCollect(collectionTimes{}, name, func() int {
+			ran = collectorEnabled(flag, false)
+			return 1
+		})
+		return ran
+	}
+	toggle := func(h http.HandlerFunc, method, name string) CollectorStatus {
+		req := httptest.NewRequest(method, "/collectors/"+name, nil)
+		req.SetPathValue("name", name)
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s %s: got %d %s", method, name, rec.Code, rec.Body)
+		}
+		var status CollectorStatus
+		if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+
+	if collect("firewall", "FIREWALL_INVENTORY") {
+		t.Fatal("collector off by default ran")
+	}
+	if status := toggle(handleToggleCollector(true), "POST", "firewall"); status.EnabledBy != disabledByAPI {
+		t.Errorf("enabling a collector off by default: got enabledBy %q, want %q", status.EnabledBy, disabledByAPI)
+	}
+	if !collect("firewall", "FIREWALL_INVENTORY") {
+		t.Error("collector enabled through the API did not run")
+	}
+	if status := toggle(handleResetCollector, "DELETE", "firewall"); status.EnabledBy != "" {
+		t.Errorf("reset collector: got enabledBy %q", status.EnabledBy)
+	}
+	if collect("firewall", "FIREWALL_INVENTORY") {
+		t.Error("reset collector ran against its configuration")
+	}
+
+	// A collector the configuration enables is only switched back on.
+	collect("lvm", "LVM_STATS")
+	if status := toggle(handleToggleCollector(true), "POST", "lvm"); status.EnabledBy != "" {
+		t.Errorf("enabling a configured collector: got enabledBy %q", status.EnabledBy)
+	}
+}
+
+func TestCollectorFlagsCoverEveryCollector(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := regexp.MustCompile(`collectorEnabled\("([A-Z0-9_]+)"`)
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range call.FindAllStringSubmatch(string(data), -1) {
+			if _, ok := collectorFlags[m[1]]; !ok {
+				t.Errorf("%s: %s is not in collectorFlags", file, m[1])
+			}
+		}
+	}
+}
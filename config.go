@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	encryptedPrefix = "ENC[AES256_GCM,"
+	encryptedSuffix = "]"
+)
+
+// loadConfigKey reads the 32-byte AES key used to decrypt configuration values.
+// The key is taken from CONFIG_KEY (base64) or, if unset, from the file named by CONFIG_KEY_FILE.
+func loadConfigKey() ([]byte, error) {
+	encoded := os.Getenv("CONFIG_KEY")
+	if encoded == "" {
+		keyFile := os.Getenv("CONFIG_KEY_FILE")
+		if keyFile == "" {
+			return nil, fmt.Errorf("neither CONFIG_KEY nor CONFIG_KEY_FILE is set")
+		}
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %v", err)
+		}
+		encoded = strings.TrimSpace(string(data))
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config key encoding: %v", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid config key length: got %d bytes, want 32", len(key))
+	}
+	return key, nil
+}
+
+// isEncrypted reports whether a configuration value is meant to be in the ENC[AES256_GCM,...]
+// format. Only the prefix is checked, so a truncated value fails to decrypt instead of being
+// used as plaintext.
+func isEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// encryptValue encrypts a plaintext value into the ENC[AES256_GCM,<base64>] format.
+func encryptValue(plaintext string, key []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed) + encryptedSuffix, nil
+}
+
+// decryptValue decrypts a value produced by encryptValue.
+func decryptValue(value string, key []byte) (string, error) {
+	encoded, ok := strings.CutPrefix(value, encryptedPrefix)
+	if ok {
+		encoded, ok = strings.CutSuffix(encoded, encryptedSuffix)
+	}
+	if !ok {
+		return "", fmt.Errorf("malformed encrypted value: want %s<base64>%s", encryptedPrefix, encryptedSuffix)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted value encoding: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("encrypted value too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// decryptEnv replaces every encrypted environment variable with its decrypted value,
+// so the rest of the agent can keep reading its configuration with os.Getenv.
+// The key is only required if at least one encrypted value is present.
+func decryptEnv() error {
+	var key []byte
+	for _, kv := range os.Environ() {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !isEncrypted(value) {
+			continue
+		}
+		if key == nil {
+			k, err := loadConfigKey()
+			if err != nil {
+				return err
+			}
+			key = k
+		}
+		plaintext, err := decryptValue(value, key)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		os.Setenv(name, plaintext)
+	}
+	return nil
+}
+
+// runEncrypt implements the "encrypt" subcommand: it prints the encrypted form of each argument.
+func runEncrypt(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: encrypt <value> [value...]")
+	}
+	key, err := loadConfigKey()
+	if err != nil {
+		return err
+	}
+	for _, arg := range args {
+		enc, err := encryptValue(arg, key)
+		if err != nil {
+			return err
+		}
+		fmt.Println(enc)
+	}
+	return nil
+}
+
+// envBool reads a boolean environment variable, returning def if it is unset or invalid.
+func envBool(name string, def bool) bool {
+	s := os.Getenv(name)
+	if s == "" {
+		return def
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		fmt.Printf("Invalid %s value, using default %v: %v\n", name, def, err)
+		return def
+	}
+	return v
+}
+
+// envList reads a comma-separated environment variable into a list of trimmed, non-empty
+// items, returning def if the variable is unset.
+func envList(name string, def []string) []string {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return def
+	}
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// envInt reads a non-negative integer environment variable, returning def if it is unset
+// or invalid.
+func envInt(name string, def int) int {
+	s := os.Getenv(name)
+	if s == "" {
+		return def
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 {
+		fmt.Printf("Invalid %s value, using default %d: %s\n", name, def, s)
+		return def
+	}
+	return v
+}
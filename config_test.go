@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testConfigKey returns a random config key and sets CONFIG_KEY to it.
+func testConfigKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	rand.Read(key)
+	t.Setenv("CONFIG_KEY", base64.StdEncoding.EncodeToString(key))
+	t.Setenv("CONFIG_KEY_FILE", "")
+	return key
+}
+
+// tamper flips one bit of the ciphertext inside an encrypted value.
+func tamper(t *testing.T, value string) string {
+	t.Helper()
+	encoded := strings.TrimSuffix(strings.TrimPrefix(value, encryptedPrefix), encryptedSuffix)
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed) + encryptedSuffix
+}
+
+func TestDecryptValue(t *testing.T) {
+	key := testConfigKey(t)
+	otherKey := bytes.Repeat([]byte{1}, 32)
+	enc, err := encryptValue("s3cret", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEncrypted(enc) {
+		t.Fatalf("%s not recognized as encrypted", enc)
+	}
+	if got, err := decryptValue(enc, key); err != nil || got != "s3cret" {
+		t.Fatalf("round trip: got %q, %v", got, err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		key   []byte
+	}{
+		{"tampered ciphertext", tamper(t, enc), key},
+		{"wrong key", enc, otherKey},
+		{"missing closing bracket", strings.TrimSuffix(enc, encryptedSuffix), key},
+		{"invalid base64", encryptedPrefix + "not base64!" + encryptedSuffix, key},
+		{"too short", encryptedPrefix + base64.StdEncoding.EncodeToString([]byte("short")) + encryptedSuffix, key},
+		{"empty", encryptedPrefix + encryptedSuffix, key},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := decryptValue(tt.value, tt.key); err == nil {
+				t.Errorf("decrypted %q, want an error", got)
+			}
+		})
+	}
+}
+
+func TestDecryptEnv(t *testing.T) {
+	key := testConfigKey(t)
+	enc, err := encryptValue("s3cret", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_SECRET", enc)
+	t.Setenv("TEST_PLAIN", "ENC plain value")
+	if err := decryptEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if got := os.Getenv("TEST_SECRET"); got != "s3cret" {
+		t.Errorf("TEST_SECRET = %q, want the decrypted value", got)
+	}
+	if got := os.Getenv("TEST_PLAIN"); got != "ENC plain value" {
+		t.Errorf("TEST_PLAIN = %q, want it unchanged", got)
+	}
+
+	// A value that cannot be decrypted is a configuration error naming the variable; it
+	// is never used as plaintext.
+	for name, value := range map[string]string{
+		"tampered":  tamper(t, enc),
+		"truncated": strings.TrimSuffix(enc, encryptedSuffix),
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("TEST_SECRET", value)
+			err := decryptEnv()
+			if err == nil || !strings.Contains(err.Error(), "TEST_SECRET") {
+				t.Errorf("got error %v, want one naming TEST_SECRET", err)
+			}
+		})
+	}
+
+	t.Run("no key", func(t *testing.T) {
+		t.Setenv("TEST_SECRET", enc)
+		t.Setenv("CONFIG_KEY", "")
+		if err := decryptEnv(); err == nil {
+			t.Error("decrypted without a key")
+		}
+	})
+}
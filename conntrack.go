@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ConntrackStats reports the netfilter connection tracking table utilization.
+type ConntrackStats struct {
+	Count        uint64  `json:"count"`
+	Max          uint64  `json:"max"`
+	UsagePercent float64 `json:"usagePercent"`
+}
+
+// collectConntrack reads nf_conntrack count and max on Linux. It returns nil when
+// connection tracking is not loaded or CONNTRACK_STATS is "false".
+func collectConntrack() (*ConntrackStats, error) {
+	if !collectorEnabled("CONNTRACK_STATS", true) {
+		return nil, nil
+	}
+	count, err := readUintFile("/proc/sys/net/netfilter/nf_conntrack_count")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the conntrack count: %v", err)
+	}
+	max, err := readUintFile("/proc/sys/net/netfilter/nf_conntrack_max")
+	if err != nil || max == 0 {
+		return nil, nil
+	}
+	return &ConntrackStats{
+		Count:        count,
+		Max:          max,
+		UsagePercent: float64(count) / float64(max) * 100,
+	}, nil
+}
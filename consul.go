@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// consulTimeout bounds every request to the Consul agent.
+const consulTimeout = 5 * time.Second
+
+// consulAddr returns the address of the local Consul agent from CONSUL_ADDR, or "" if
+// Consul integration is disabled.
+func consulAddr() string {
+	addr := strings.TrimSuffix(os.Getenv("CONSUL_ADDR"), "/")
+	if addr != "" && !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return addr
+}
+
+// consulRequest sends a request to the Consul HTTP API, authenticating with CONSUL_TOKEN if set.
+func consulRequest(method, path string, body interface{}) (*http.Response, error) {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, consulAddr()+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("CONSUL_TOKEN"); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	client := &http.Client{Timeout: consulTimeout}
+	return client.Do(req)
+}
+
+// discoverConsulServer returns the address of a healthy instance of the monitoring server
+// service (CONSUL_SERVER_SERVICE) registered in Consul.
+func discoverConsulServer() (string, string, error) {
+	service := os.Getenv("CONSUL_SERVER_SERVICE")
+	if service == "" {
+		service = "cheetah-server"
+	}
+	resp, err := consulRequest("GET", "/v1/health/service/"+url.PathEscape(service)+"?passing=true", nil)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to query Consul: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("consul query failed with status: %s", resp.Status)
+	}
+	var entries []struct {
+		Node struct {
+			Address string
+		}
+		Service struct {
+			Address string
+			Port    int
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return "", "", fmt.Errorf("invalid Consul response: %v", err)
+	}
+	if len(entries) == 0 {
+		return "", "", fmt.Errorf("no healthy instances of %s in Consul", service)
+	}
+	// The service address may be empty, in which case Consul means the node address.
+	host := entries[0].Service.Address
+	if host == "" {
+		host = entries[0].Node.Address
+	}
+	return host, strconv.Itoa(entries[0].Service.Port), nil
+}
+
+// registerConsulService registers the agent as a Consul service whose health check
+// polls the agent's /healthz endpoint. It is a no-op unless CONSUL_ADDR is set and
+// CONSUL_REGISTER is not disabled.
+func registerConsulService(agentID, ip string, agentPort int, agentTLS bool) {
+	if consulAddr() == "" || !envBool("CONSUL_REGISTER", true) {
+		return
+	}
+	scheme := "http"
+	if agentTLS {
+		scheme = "https"
+	}
+	registration := map[string]interface{}{
+		"ID":      "cheetah-agent-" + agentID,
+		"Name":    "cheetah-agent",
+		"Address": ip,
+		"Port":    agentPort,
+		"Meta":    map[string]string{"agentId": agentID},
+		"Check": map[string]interface{}{
+			"HTTP":                           fmt.Sprintf("%s://%s/healthz", scheme, net.JoinHostPort(ip, strconv.Itoa(agentPort))),
+			"Interval":                       "30s",
+			"Timeout":                        "5s",
+			"TLSSkipVerify":                  agentTLS,
+			"DeregisterCriticalServiceAfter": "1h",
+		},
+	}
+	resp, err := consulRequest("PUT", "/v1/agent/service/register", registration)
+	if err != nil {
+		fmt.Printf("Error registering Consul service: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("Consul service registration failed with status: %s\n", resp.Status)
+		return
+	}
+	fmt.Println("Registered agent as Consul service cheetah-agent")
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Container runtime APIs.
+const (
+	// runtimeDockerAPI is the Docker Engine API, also served by Podman's Docker-compatible socket.
+	runtimeDockerAPI = "docker"
+	// runtimeCRI is the Kubernetes Container Runtime Interface, served by containerd and CRI-O.
+	runtimeCRI = "cri"
+)
+
+// containerRuntimeTimeout bounds a whole collection from the container runtime.
+const containerRuntimeTimeout = 10 * time.Second
+
+// maxContainerStatsRequests is the number of per-container stats requests sent to a Docker
+// API at once.
+const maxContainerStatsRequests = 8
+
+// CRI methods used by the collector.
+const (
+	criListContainers     = "/runtime.v1.RuntimeService/ListContainers"
+	criListContainerStats = "/runtime.v1.RuntimeService/ListContainerStats"
+)
+
+// ContainerRuntimeStats holds the running containers of the host's container runtime.
+type ContainerRuntimeStats struct {
+	// API is the runtime API spoken on Socket: docker or cri.
+	API        string           `json:"api"`
+	Socket     string           `json:"socket"`
+	Containers []ContainerStats `json:"containers"`
+}
+
+// ContainerStats is the resource usage of one running container.
+type ContainerStats struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Image string `json:"image,omitempty"`
+	// CPUUsageNanos is the CPU time used since the container started.
+	CPUUsageNanos uint64 `json:"cpuUsageNanos"`
+	// CPUPercent is the CPU used since the previous collection, in percent of one core.
+	CPUPercent float64 `json:"cpuPercent,omitempty"`
+	// MemoryWorkingSetBytes is the memory in use minus the inactive page cache, as reported
+	// by docker stats and the kubelet.
+	MemoryWorkingSetBytes uint64 `json:"memoryWorkingSetBytes"`
+	MemoryLimitBytes      uint64 `json:"memoryLimitBytes,omitempty"`
+	// Network counters are only reported by the Docker API.
+	NetworkRxBytes uint64 `json:"networkRxBytes,omitempty"`
+	NetworkTxBytes uint64 `json:"networkTxBytes,omitempty"`
+}
+
+// lastContainerCPU holds the previous CPU usage sample of each container, by container ID,
+// used to compute CPU percentages.
+var lastContainerCPU struct {
+	sync.Mutex
+	usage map[string]uint64
+	at    time.Time
+}
+
+// defaultRuntimeSockets are the sockets probed, in order, when CONTAINER_RUNTIME_SOCKET is
+// not set.
+func defaultRuntimeSockets() []string {
+	sockets := []string{"/var/run/docker.sock", "/run/podman/podman.sock"}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		sockets = append(sockets, filepath.Join(dir, "podman", "podman.sock"))
+	}
+	return append(sockets, "/run/containerd/containerd.sock", "/run/crio/crio.sock")
+}
+
+// runtimeAPI returns the API spoken on socket: CONTAINER_RUNTIME_API when set, otherwise
+// the CRI for the containerd and CRI-O sockets and the Docker API for the others.
+func runtimeAPI(socket string) string {
+	switch api := os.Getenv("CONTAINER_RUNTIME_API"); api {
+	case runtimeDockerAPI, runtimeCRI:
+		return api
+	case "":
+	default:
+		fmt.Printf("Invalid CONTAINER_RUNTIME_API value, guessing from the socket: %s\n", api)
+	}
+	switch filepath.Base(socket) {
+	case "containerd.sock", "crio.sock", "cri-dockerd.sock":
+		return runtimeCRI
+	}
+	return runtimeDockerAPI
+}
+
+// collectContainers reports the resource usage of the containers running on the host, read
+// from the container runtime socket named by CONTAINER_RUNTIME_SOCKET or, if unset, the
+// first of the usual Docker, Podman, containerd and CRI-O sockets that exists.
+func collectContainers() (*ContainerRuntimeStats, error) {
+	if !collectorEnabled("CONTAINER_STATS", false) {
+		return nil, nil
+	}
+	socket := os.Getenv("CONTAINER_RUNTIME_SOCKET")
+	if socket == "" {
+		for _, s := range defaultRuntimeSockets() {
+			if _, err := os.Stat(s); err == nil {
+				socket = s
+				break
+			}
+		}
+		if socket == "" {
+			return nil, nil
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), containerRuntimeTimeout)
+	defer cancel()
+	stats := &ContainerRuntimeStats{API: runtimeAPI(socket), Socket: socket}
+	var err error
+	if stats.API == runtimeCRI {
+		stats.Containers, err = listCRIContainers(ctx, socket)
+	} else {
+		stats.Containers, err = listDockerContainers(ctx, socket)
+	}
+	if stats.Containers == nil {
+		if err != nil {
+			return nil, fmt.Errorf("failed to list containers on %s: %v", socket, err)
+		}
+		stats.Containers = []ContainerStats{}
+	}
+	setContainerCPUPercent(stats.Containers)
+	if err != nil {
+		return stats, fmt.Errorf("failed to read container stats on %s: %v", socket, err)
+	}
+	return stats, nil
+}
+
+// setContainerCPUPercent fills the CPU percentages of containers from their usage at the
+// previous collection.
+func setContainerCPUPercent(containers []ContainerStats) {
+	lastContainerCPU.Lock()
+	defer lastContainerCPU.Unlock()
+	now := time.Now()
+	elapsed := now.Sub(lastContainerCPU.at)
+	usage := make(map[string]uint64, len(containers))
+	for i := range containers {
+		c := &containers[i]
+		usage[c.ID] = c.CPUUsageNanos
+		if prev, ok := lastContainerCPU.usage[c.ID]; ok && elapsed > 0 && c.CPUUsageNanos >= prev {
+			c.CPUPercent = float64(c.CPUUsageNanos-prev) / float64(elapsed.Nanoseconds()) * 100
+		}
+	}
+	lastContainerCPU.usage, lastContainerCPU.at = usage, now
+}
+
+// dockerContainer is an entry of the Docker API's GET /containers/json.
+type dockerContainer struct {
+	ID    string   `json:"Id"`
+	Names []string `json:"Names"`
+	Image string   `json:"Image"`
+}
+
+// dockerStats is the subset of the Docker API's GET /containers/{id}/stats used by the agent.
+type dockerStats struct {
+	CPUStats struct {
+		CPUUsage struct {
+			TotalUsage uint64 `json:"total_usage"`
+		} `json:"cpu_usage"`
+	} `json:"cpu_stats"`
+	MemoryStats struct {
+		Usage uint64            `json:"usage"`
+		Limit uint64            `json:"limit"`
+		Stats map[string]uint64 `json:"stats"`
+	} `json:"memory_stats"`
+	Networks map[string]struct {
+		RxBytes uint64 `json:"rx_bytes"`
+		TxBytes uint64 `json:"tx_bytes"`
+	} `json:"networks"`
+}
+
+// listDockerContainers returns the running containers of the Docker API on socket, with
+// their stats. Containers whose stats cannot be read are listed without them, and the
+// first such error is returned.
+func listDockerContainers(ctx context.Context, socket string) ([]ContainerStats, error) {
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+	defer client.CloseIdleConnections()
+
+	var list []dockerContainer
+	if err := getDockerJSON(ctx, client, "/containers/json", &list); err != nil {
+		return nil, err
+	}
+	containers := make([]ContainerStats, len(list))
+	errs := make([]error, len(list))
+	sem := make(chan struct{}, maxContainerStatsRequests)
+	var wg sync.WaitGroup
+	for i, c := range list {
+		name := c.ID
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		containers[i] = ContainerStats{ID: c.ID, Name: name, Image: c.Image}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			var s dockerStats
+			// one-shot skips the second sample Docker otherwise waits for to fill precpu_stats.
+			if err := getDockerJSON(ctx, client, "/containers/"+c.ID+"/stats?stream=false&one-shot=true", &s); err != nil {
+				errs[i] = fmt.Errorf("%s: %v", name, err)
+				return
+			}
+			cs := &containers[i]
+			cs.CPUUsageNanos = s.CPUStats.CPUUsage.TotalUsage
+			cs.MemoryWorkingSetBytes = dockerWorkingSet(s.MemoryStats.Usage, s.MemoryStats.Stats)
+			cs.MemoryLimitBytes = s.MemoryStats.Limit
+			for _, n := range s.Networks {
+				cs.NetworkRxBytes += n.RxBytes
+				cs.NetworkTxBytes += n.TxBytes
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return containers, err
+		}
+	}
+	return containers, nil
+}
+
+// dockerWorkingSet subtracts the inactive page cache from a Docker memory usage, as docker stats
+// does: inactive_file on cgroup v2, total_inactive_file on cgroup v1.
+func dockerWorkingSet(usage uint64, stats map[string]uint64) uint64 {
+	inactive, ok := stats["inactive_file"]
+	if !ok {
+		inactive = stats["total_inactive_file"]
+	}
+	if inactive > usage {
+		return 0
+	}
+	return usage - inactive
+}
+
+// getDockerJSON decodes the JSON response of a GET request to the Docker API.
+func getDockerJSON(ctx context.Context, client *http.Client, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://runtime"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// rawCodec passes gRPC messages through as encoded protobuf bytes, so the CRI can be called
+// without its generated types. Messages are *[]byte.
+type rawCodec struct{}
+
+// Marshal implements encoding.Codec.
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	return *v.(*[]byte), nil
+}
+
+// Unmarshal implements encoding.Codec.
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*[]byte) = append([]byte(nil), data...)
+	return nil
+}
+
+// Name implements encoding.Codec.
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// listCRIContainers returns the running containers of the CRI runtime on socket, with their
+// stats.
+func listCRIContainers(ctx context.Context, socket string) ([]ContainerStats, error) {
+	conn, err := grpc.DialContext(ctx, "unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// ListContainersRequest{filter: {state: {state: CONTAINER_RUNNING}}}
+	running := protowire.AppendVarint(protowire.AppendTag(nil, 1, protowire.VarintType), 1)
+	filter := protowire.AppendBytes(protowire.AppendTag(nil, 2, protowire.BytesType), running)
+	req := protowire.AppendBytes(protowire.AppendTag(nil, 1, protowire.BytesType), filter)
+	var resp []byte
+	if err := conn.Invoke(ctx, criListContainers, &req, &resp, grpc.ForceCodec(rawCodec{})); err != nil {
+		return nil, err
+	}
+	containers, err := parseCRIContainers(resp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ListContainers response: %v", err)
+	}
+
+	req, resp = nil, nil
+	if err := conn.Invoke(ctx, criListContainerStats, &req, &resp, grpc.ForceCodec(rawCodec{})); err != nil {
+		return containers, err
+	}
+	if err := parseCRIContainerStats(resp, containers); err != nil {
+		return containers, fmt.Errorf("invalid ListContainerStats response: %v", err)
+	}
+	return containers, nil
+}
+
+// parseCRIContainers decodes the containers of a ListContainersResponse.
+func parseCRIContainers(resp []byte) ([]ContainerStats, error) {
+	containers := []ContainerStats{}
+	err := forEachProtoField(resp, func(num protowire.Number, container []byte, _ uint64) error {
+		if num != 1 {
+			return nil
+		}
+		var c ContainerStats
+		err := forEachProtoField(container, func(num protowire.Number, data []byte, _ uint64) error {
+			switch num {
+			case 1:
+				c.ID = string(data)
+			case 3: // metadata
+				c.Name = protoString(data, 1)
+			case 4: // image spec
+				c.Image = protoString(data, 1)
+			}
+			return nil
+		})
+		containers = append(containers, c)
+		return err
+	})
+	return containers, err
+}
+
+// parseCRIContainerStats decodes a ListContainerStatsResponse into the matching containers.
+func parseCRIContainerStats(resp []byte, containers []ContainerStats) error {
+	byID := make(map[string]*ContainerStats, len(containers))
+	for i := range containers {
+		byID[containers[i].ID] = &containers[i]
+	}
+	return forEachProtoField(resp, func(num protowire.Number, stats []byte, _ uint64) error {
+		if num != 1 {
+			return nil
+		}
+		var id string
+		var cpu, workingSet, available uint64
+		err := forEachProtoField(stats, func(num protowire.Number, data []byte, _ uint64) error {
+			switch num {
+			case 1: // attributes
+				id = protoString(data, 1)
+			case 2: // cpu: usage_core_nano_seconds
+				cpu = protoUInt64Value(data, 2)
+			case 3: // memory: working_set_bytes and available_bytes
+				workingSet, available = protoUInt64Value(data, 2), protoUInt64Value(data, 3)
+			}
+			return nil
+		})
+		if c := byID[id]; c != nil {
+			c.CPUUsageNanos, c.MemoryWorkingSetBytes = cpu, workingSet
+			if available > 0 {
+				c.MemoryLimitBytes = workingSet + available
+			}
+		}
+		return err
+	})
+}
+
+// forEachProtoField calls fn with each field of the protobuf message b: its number, and its
+// value as bytes for length-delimited fields or as an integer for varints.
+func forEachProtoField(b []byte, fn func(num protowire.Number, data []byte, value uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var data []byte
+		var value uint64
+		switch typ {
+		case protowire.VarintType:
+			value, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			data, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := fn(num, data, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// protoString returns the string field num of the protobuf message b, or "" if it is
+// missing or b is malformed.
+func protoString(b []byte, num protowire.Number) string {
+	var s string
+	forEachProtoField(b, func(n protowire.Number, data []byte, _ uint64) error {
+		if n == num {
+			s = string(data)
+		}
+		return nil
+	})
+	return s
+}
+
+// protoUInt64Value returns the value of the google.protobuf.UInt64Value field num of the
+// protobuf message b, or 0 if it is missing or b is malformed.
+func protoUInt64Value(b []byte, num protowire.Number) uint64 {
+	var v uint64
+	forEachProtoField(b, func(n protowire.Number, data []byte, _ uint64) error {
+		if n == num {
+			forEachProtoField(data, func(n protowire.Number, _ []byte, value uint64) error {
+				if n == 1 {
+					v = value
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return v
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// listenUnix listens on a socket named name in a temporary directory.
+func listenUnix(t *testing.T, name string) (net.Listener, string) {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), name)
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	return ln, socket
+}
+
+// useContainerSocket enables the container collector on socket and resets its CPU samples.
+func useContainerSocket(t *testing.T, socket string) {
+	t.Helper()
+	t.Setenv("CONTAINER_STATS", "true")
+	t.Setenv("CONTAINER_RUNTIME_SOCKET", socket)
+	t.Setenv("CONTAINER_RUNTIME_API", "")
+	t.Cleanup(func() {
+		lastContainerCPU.Lock()
+		lastContainerCPU.usage = nil
+		lastContainerCPU.Unlock()
+	})
+}
+
+func TestCollectContainersDockerAPI(t *testing.T) {
+	ln, socket := listenUnix(t, "podman.sock")
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /containers/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"Id":"abc","Names":["/web"],"Image":"nginx:1.27"},{"Id":"def","Names":["/db"],"Image":"postgres:17"}]`))
+	})
+	mux.HandleFunc("GET /containers/abc/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("stream") != "false" {
+			t.Error("stats requested as a stream")
+		}
+		w.Write([]byte(`{"cpu_stats":{"cpu_usage":{"total_usage":5000000000}},
+			"memory_stats":{"usage":300000000,"limit":1000000000,"stats":{"inactive_file":100000000}},
+			"networks":{"eth0":{"rx_bytes":1000,"tx_bytes":200},"eth1":{"rx_bytes":10,"tx_bytes":2}}}`))
+	})
+	mux.HandleFunc("GET /containers/def/stats", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such container", http.StatusNotFound)
+	})
+	srv := httptest.NewUnstartedServer(mux)
+	srv.Listener = ln
+	srv.Start()
+	defer srv.Close()
+	useContainerSocket(t, socket)
+
+	stats, err := collectContainers()
+	if err == nil {
+		t.Error("stats error not reported")
+	}
+	if stats == nil {
+		t.Fatal("no containers reported")
+	}
+	want := &ContainerRuntimeStats{API: runtimeDockerAPI, Socket: socket, Containers: []ContainerStats{
+		{ID: "abc", Name: "web", Image: "nginx:1.27", CPUUsageNanos: 5000000000,
+			MemoryWorkingSetBytes: 200000000, MemoryLimitBytes: 1000000000, NetworkRxBytes: 1010, NetworkTxBytes: 202},
+		{ID: "def", Name: "db", Image: "postgres:17"},
+	}}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("got %+v\nwant %+v", stats, want)
+	}
+}
+
+// protoMessage encodes fields, given as number and value pairs: a string, a []byte for an
+// embedded message or a uint64 for a varint.
+func protoMessage(fields ...interface{}) []byte {
+	var b []byte
+	for i := 0; i < len(fields); i += 2 {
+		num := protowire.Number(fields[i].(int))
+		switch v := fields[i+1].(type) {
+		case string:
+			b = protowire.AppendBytes(protowire.AppendTag(b, num, protowire.BytesType), []byte(v))
+		case []byte:
+			b = protowire.AppendBytes(protowire.AppendTag(b, num, protowire.BytesType), v)
+		case uint64:
+			b = protowire.AppendVarint(protowire.AppendTag(b, num, protowire.VarintType), v)
+		}
+	}
+	return b
+}
+
+func TestCollectContainersCRI(t *testing.T) {
+	ln, socket := listenUnix(t, "containerd.sock")
+	container := func(id, name, image string) []byte {
+		return protoMessage(1, id, 2, "pod", 3, protoMessage(1, name, 2, uint64(1)), 4, protoMessage(1, image), 6, uint64(1))
+	}
+	containerStats := func(id string, cpu, workingSet, available uint64) []byte {
+		value := func(v uint64) []byte { return protoMessage(1, v) }
+		return protoMessage(
+			1, protoMessage(1, id, 2, protoMessage(1, "ignored")),
+			2, protoMessage(1, uint64(1), 2, value(cpu)),
+			3, protoMessage(1, uint64(1), 2, value(workingSet), 3, value(available)),
+		)
+	}
+	srv := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}), grpc.UnknownServiceHandler(func(_ interface{}, stream grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(stream)
+		var req []byte
+		if err := stream.RecvMsg(&req); err != nil {
+			return err
+		}
+		var resp []byte
+		switch method {
+		case criListContainers:
+			// Only running containers are asked for.
+			if want := protoMessage(1, protoMessage(2, protoMessage(1, uint64(1)))); string(req) != string(want) {
+				t.Errorf("ListContainers request %x, want %x", req, want)
+			}
+			resp = protoMessage(1, container("abc", "web", "docker.io/library/nginx:1.27"), 1, container("def", "db", "postgres:17"))
+		case criListContainerStats:
+			resp = protoMessage(1, containerStats("abc", 7000000000, 50000000, 150000000), 1, containerStats("exited", 1, 1, 1),
+				1, containerStats("def", 3000, 4000, 0))
+		default:
+			t.Errorf("unexpected call to %s", method)
+		}
+		return stream.SendMsg(&resp)
+	}))
+	go srv.Serve(ln)
+	defer srv.Stop()
+	useContainerSocket(t, socket)
+
+	stats, err := collectContainers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ContainerRuntimeStats{API: runtimeCRI, Socket: socket, Containers: []ContainerStats{
+		{ID: "abc", Name: "web", Image: "docker.io/library/nginx:1.27", CPUUsageNanos: 7000000000,
+			MemoryWorkingSetBytes: 50000000, MemoryLimitBytes: 200000000},
+		{ID: "def", Name: "db", Image: "postgres:17", CPUUsageNanos: 3000, MemoryWorkingSetBytes: 4000},
+	}}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("got %+v\nwant %+v", stats, want)
+	}
+}
+
+func TestCollectContainersUnreachableSocket(t *testing.T) {
+	useContainerSocket(t, filepath.Join(t.TempDir(), "docker.sock"))
+	if stats, err := collectContainers(); err == nil || stats != nil {
+		t.Errorf("got %+v, %v; want an error for a missing configured socket", stats, err)
+	}
+}
+
+func TestRuntimeAPI(t *testing.T) {
+	tests := []struct {
+		socket, api, want string
+	}{
+		{"/var/run/docker.sock", "", runtimeDockerAPI},
+		{"/run/user/1000/podman/podman.sock", "", runtimeDockerAPI},
+		{"/run/containerd/containerd.sock", "", runtimeCRI},
+		{"/run/crio/crio.sock", "", runtimeCRI},
+		{"/run/k3s/containerd/containerd.sock", "", runtimeCRI},
+		{"/run/custom.sock", "cri", runtimeCRI},
+		{"/run/containerd/containerd.sock", "docker", runtimeDockerAPI},
+	}
+	for _, tt := range tests {
+		t.Setenv("CONTAINER_RUNTIME_API", tt.api)
+		if got := runtimeAPI(tt.socket); got != tt.want {
+			t.Errorf("runtimeAPI(%s) with %q: got %s, want %s", tt.socket, tt.api, got, tt.want)
+		}
+	}
+}
+
+func TestSetContainerCPUPercent(t *testing.T) {
+	t.Cleanup(func() { lastContainerCPU.usage = nil })
+	setContainerCPUPercent([]ContainerStats{{ID: "a", CPUUsageNanos: 1000}, {ID: "b", CPUUsageNanos: 1000}})
+	lastContainerCPU.at = lastContainerCPU.at.Add(-1e9)
+	containers := []ContainerStats{{ID: "a", CPUUsageNanos: 500001000}, {ID: "c", CPUUsageNanos: 1}}
+	setContainerCPUPercent(containers)
+	if p := containers[0].CPUPercent; p < 49 || p > 50 {
+		t.Errorf("got %.2f%% CPU, want about 50%%", p)
+	}
+	if containers[1].CPUPercent != 0 {
+		t.Error("CPU percent reported without a previous sample")
+	}
+	if _, ok := lastContainerCPU.usage["b"]; ok {
+		t.Error("sample of a removed container kept")
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runtimeCrashFile receives the runtime's own output for fatal errors that cannot be recovered.
+const runtimeCrashFile = "crash-runtime.txt"
+
+// CrashReport is written to the state directory on fatal panics and uploaded on the next start.
+type CrashReport struct {
+	Hostname  string            `json:"hostname"`
+	Timestamp int64             `json:"timestamp"`
+	Panic     string            `json:"panic"`
+	Stack     string            `json:"stack"`
+	Config    map[string]string `json:"config,omitempty"`
+	LogLines  []string          `json:"logLines,omitempty"`
+}
+
+// secretMarkers identify configuration variables whose values are redacted in crash reports.
+var secretMarkers = []string{"TOKEN", "KEY", "SECRET", "PASSWORD", "PASS", "CREDENTIAL"}
+
+// configSummary returns the agent's environment configuration with secret values redacted.
+func configSummary() map[string]string {
+	config := make(map[string]string)
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		for _, marker := range secretMarkers {
+			if strings.Contains(strings.ToUpper(name), marker) {
+				value = "<redacted>"
+				break
+			}
+		}
+		config[name] = value
+	}
+	return config
+}
+
+// writeCrashReport saves a crash report to the state directory.
+func writeCrashReport(report CrashReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(stateDir(), fmt.Sprintf("crash-%d.json", report.Timestamp))
+	return os.WriteFile(path, data, 0o600)
+}
+
+// installCrashHandling directs fatal runtime errors to a file in the state directory.
+// A non-empty file left by a previous run is first converted into a crash report.
+func installCrashHandling() {
+	path := filepath.Join(stateDir(), runtimeCrashFile)
+	if data, err := os.ReadFile(path); err == nil && len(bytes.TrimSpace(data)) > 0 {
+		hostname, _ := getHostname()
+		info, _ := os.Stat(path)
+		report := CrashReport{Hostname: hostname, Timestamp: info.ModTime().UnixMilli(), Panic: "fatal runtime error", Stack: string(data)}
+		if err := writeCrashReport(report); err != nil {
+			fmt.Printf("Error saving runtime crash report: %v\n", err)
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Printf("Error creating crash output file: %v\n", err)
+		return
+	}
+	if err := debug.SetCrashOutput(f, debug.CrashOptions{}); err != nil {
+		fmt.Printf("Error setting crash output: %v\n", err)
+	}
+	f.Close()
+}
+
+// handleFatalPanic is deferred in main: it records a crash report for a panic that
+// reached the top of the main goroutine and exits with a non-zero status.
+func handleFatalPanic() {
+	r := recover()
+	if r == nil {
+		return
+	}
+	hostname, _ := getHostname()
+	report := CrashReport{
+		Hostname:  hostname,
+		Timestamp: time.Now().UnixMilli(),
+		Panic:     fmt.Sprint(r),
+		Stack:     string(debug.Stack()),
+		Config:    configSummary(),
+		LogLines:  recentLogLines(),
+	}
+	flushLogs()
+	fmt.Printf("Fatal panic: %v\n%s\n", r, report.Stack)
+	if err := writeCrashReport(report); err != nil {
+		fmt.Printf("Error saving crash report: %v\n", err)
+	}
+	os.Exit(2)
+}
+
+// uploadCrashReports sends pending crash reports to the server and removes those accepted.
+func uploadCrashReports(crashURL string) {
+	paths, _ := filepath.Glob(filepath.Join(stateDir(), "crash-*.json"))
+	sort.Strings(paths)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		resp, err := httpClient().Post(crashURL, "application/json", bytes.NewReader(data))
+		if err != nil {
+			fmt.Printf("Error uploading crash report %s: %v\n", filepath.Base(path), err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Crash report upload failed with status: %s\n", resp.Status)
+			return
+		}
+		os.Remove(path)
+		fmt.Printf("Uploaded crash report %s\n", filepath.Base(path))
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// DeliveryStats accounts for the samples on their way to the servers, so data lost in the
+// pipeline or the spool shows up in the agent's own telemetry instead of going unnoticed.
+type DeliveryStats struct {
+	// Queued is the number of samples waiting in the pipeline queue.
+	Queued int `json:"queued"`
+	// Spooled is the number of batches stored on disk awaiting acknowledgement, summed over
+	// the servers.
+	Spooled int `json:"spooled"`
+	// Retried is the number of failed attempts to send a spooled batch.
+	Retried uint64 `json:"retried"`
+	// Dropped is the number of samples discarded from a full pipeline queue and of batches
+	// evicted from the spool by its retention limits.
+	Dropped uint64 `json:"dropped"`
+	// DeadLettered is the number of spooled batches moved to a dead-letter directory because
+	// the server rejected them or they could not be decrypted.
+	DeadLettered uint64 `json:"deadLettered"`
+	// Truncated is the number of events discarded from the full event buffer.
+	Truncated uint64 `json:"truncated"`
+}
+
+// lastDelivery holds the totals reported in the previous payload, so each payload reports
+// the counts of its own interval.
+var lastDelivery struct {
+	sync.Mutex
+	totals DeliveryStats
+}
+
+// deliveryTotals returns the current queue and spool depths and the counts accumulated
+// since the agent started.
+func deliveryTotals() DeliveryStats {
+	stats := DeliveryStats{Truncated: eventsTruncated.Load()}
+	if q := pipeline.collected; q != nil {
+		stats.Queued += len(q.ch)
+		stats.Dropped += q.dropped.Load()
+	}
+	for _, d := range destinations {
+		stats.Spooled += len(d.spoolFiles())
+		stats.Retried += d.retried.Load()
+		stats.Dropped += d.evictions()
+		stats.DeadLettered += d.deadLettered.Load()
+	}
+	return stats
+}
+
+// deliveryInterval returns the current depths and the counts accumulated since the
+// previous call, reported in the agent section of each payload.
+func deliveryInterval() *DeliveryStats {
+	totals := deliveryTotals()
+	lastDelivery.Lock()
+	defer lastDelivery.Unlock()
+	interval := totals
+	interval.Retried -= lastDelivery.totals.Retried
+	interval.Dropped -= lastDelivery.totals.Dropped
+	interval.DeadLettered -= lastDelivery.totals.DeadLettered
+	interval.Truncated -= lastDelivery.totals.Truncated
+	lastDelivery.totals = totals
+	return &interval
+}
+
+// ServerRTT summarizes the round-trip times of the metrics posts to one server since the
+// previous payload, a per-host view of the network path to the monitoring infrastructure.
+type ServerRTT struct {
+	Server string `json:"server"`
+	// LastMs is the most recent round trip, kept across intervals without posts.
+	LastMs  float64 `json:"lastMs"`
+	AvgMs   float64 `json:"avgMs,omitempty"`
+	MaxMs   float64 `json:"maxMs,omitempty"`
+	Samples int     `json:"samples"`
+}
+
+// rttStats accumulates the round-trip times of a destination's metrics posts.
+type rttStats struct {
+	sync.Mutex
+	last, sum, max time.Duration
+	count          int
+}
+
+// record adds one round-trip time.
+func (s *rttStats) record(d time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.last, s.sum, s.count = d, s.sum+d, s.count+1
+	s.max = max(s.max, d)
+}
+
+// take returns the summary of the round trips since the previous call and resets it. It
+// returns false when no post ever completed.
+func (s *rttStats) take() (ServerRTT, bool) {
+	s.Lock()
+	defer s.Unlock()
+	if s.last == 0 {
+		return ServerRTT{}, false
+	}
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	r := ServerRTT{LastMs: ms(s.last), MaxMs: ms(s.max), Samples: s.count}
+	if s.count > 0 {
+		r.AvgMs = ms(s.sum / time.Duration(s.count))
+	}
+	s.sum, s.max, s.count = 0, 0, 0
+	return r, true
+}
+
+// takeServerRTT returns the round-trip summary of every server a post has completed to.
+func takeServerRTT() []ServerRTT {
+	var results []ServerRTT
+	for _, d := range destinations {
+		if r, ok := d.rtt.take(); ok {
+			r.Server = d.name
+			results = append(results, r)
+		}
+	}
+	return results
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+)
+
+// destination is a monitoring server that receives registrations and metrics. Each
+// destination has its own registration state and spool, so an outage of one does not
+// hold back delivery to the others.
+type destination struct {
+	name    string
+	baseURL string
+	// primary marks the main server: only it can reassign the agent identity and receive crash reports.
+	primary  bool
+	spoolDir string
+	// proxy is the SOCKS5 proxy through which the server is reached, if any.
+	proxy *url.URL
+	// spoolMu serializes the writes to the spool directory and the retention policy.
+	spoolMu sync.Mutex
+	// flushMu serializes the flushes of the spool, which do not hold spoolMu while sending so
+	// new batches can be spooled meanwhile.
+	flushMu sync.Mutex
+	// flushes signals the output stage that batches are waiting in the spool. It holds at
+	// most one signal, so requests made while a flush is pending are coalesced into it.
+	flushes chan struct{}
+	// registered reports whether the agent has registered with this server. Until it has,
+	// metrics batches are only spooled.
+	registered atomic.Bool
+	// encoding is the content coding of metrics batches chosen in the server's handshake.
+	encoding atomic.Value
+	// endpoints are the endpoint paths chosen in the server's handshake, by name.
+	endpoints atomic.Value
+	// pausedUntil is when sends may resume after a 429 or 503 response, in Unix nanoseconds.
+	pausedUntil atomic.Int64
+	// retried counts the failed attempts to send spooled batches, which are retried later.
+	retried atomic.Uint64
+	// evicted counts the batches dropped from a full spool before the server received them,
+	// and evictedAge and evictedSize those dropped by the age and size limits.
+	evicted     atomic.Uint64
+	evictedAge  atomic.Uint64
+	evictedSize atomic.Uint64
+	// deadLettered counts the batches moved to the dead-letter directory.
+	deadLettered atomic.Uint64
+	// rtt accumulates the round-trip times of the metrics posts.
+	rtt rttStats
+}
+
+// destinations lists the primary server followed by the optional disaster recovery server.
+var destinations []*destination
+
+// newDestination creates a destination for the server at baseURL, spooling under spoolName.
+// Its SOCKS5 proxy, if any, is validated at startup.
+func newDestination(name, baseURL, spoolName string, primary bool) *destination {
+	proxy, _ := parseSOCKSProxy(socksProxyVars[name])
+	return &destination{
+		name:     name,
+		baseURL:  baseURL,
+		primary:  primary,
+		spoolDir: spoolName,
+		proxy:    proxy,
+		flushes:  make(chan struct{}, 1),
+	}
+}
+
+// requestFlush asks the output stage of d to flush its spool, without waiting for it.
+func (d *destination) requestFlush() {
+	select {
+	case d.flushes <- struct{}{}:
+	default:
+	}
+}
+
+// setupDestinations configures the primary server and, if DR_SERVER_URL or DR_SERVER_HOST
+// is set, a disaster recovery server that receives a mirrored copy of registrations and
+// metrics.
+func setupDestinations(primaryURL string) {
+	destinations = []*destination{newDestination("primary", primaryURL, "spool", true)}
+	if drURL, _ := configuredDRServerURL(primaryURL); drURL != "" {
+		destinations = append(destinations, newDestination("dr", drURL, "spool-dr", false))
+	}
+}
+
+// contentEncoding returns the content coding of metrics batches sent to d.
+func (d *destination) contentEncoding() string {
+	encoding, _ := d.encoding.Load().(string)
+	return encoding
+}
+
+// url returns the full URL of an API path on this destination.
+func (d *destination) url(path string) string {
+	return d.baseURL + path
+}
+
+// register registers the agent with this destination, retrying in the background on
+// failure. onRegistered runs once registration succeeds.
+func (d *destination) register(agentInfo AgentInfo, onRegistered func()) {
+	fmt.Printf("Registering agent to %s server: %s\n", d.name, d.endpoint(endpointRegister))
+	handshake, err := registerAgent(agentInfo, d.endpoint(endpointRegister), d.primary)
+	if err != nil {
+		fmt.Printf("Error registering agent with %s server: %v\n", d.name, err)
+		go retryRegistration(d, agentInfo, err, onRegistered)
+		return
+	}
+	d.applyHandshake(handshake)
+	d.registered.Store(true)
+	onRegistered()
+}
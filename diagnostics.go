@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"time"
+)
+
+// diagnosticTimeout bounds how long a single diagnostic command may run.
+const diagnosticTimeout = 60 * time.Second
+
+// validTarget matches hostnames and IPv4/IPv6 literals; anything else is rejected
+// before being passed to an external command.
+var validTarget = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.:\-]*$`)
+
+// diagnosticCommand returns the platform-specific command for a ping or traceroute diagnostic.
+func diagnosticCommand(ctx context.Context, kind, target string) (*exec.Cmd, error) {
+	windows := runtime.GOOS == "windows"
+	switch kind {
+	case "ping":
+		if windows {
+			return exec.CommandContext(ctx, "ping", "-n", "4", target), nil
+		}
+		return exec.CommandContext(ctx, "ping", "-c", "4", target), nil
+	case "traceroute":
+		if windows {
+			return exec.CommandContext(ctx, "tracert", "-d", target), nil
+		}
+		return exec.CommandContext(ctx, "traceroute", "-n", target), nil
+	}
+	return nil, fmt.Errorf("unknown diagnostic type: %q", kind)
+}
+
+// streamLine writes a line to the response and flushes it so the caller sees results as they arrive.
+func streamLine(w http.ResponseWriter, format string, args ...interface{}) {
+	fmt.Fprintf(w, format+"\n", args...)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// handleDiagnostics runs a network diagnostic toward a target and streams its output as plain text.
+// Query parameters: type (ping, traceroute or dns) and target (hostname or IP address).
+func handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if !featureEnabled("DIAGNOSTICS", true) {
+		http.Error(w, "diagnostics disabled by server", http.StatusForbidden)
+		return
+	}
+	kind := r.URL.Query().Get("type")
+	target := r.URL.Query().Get("target")
+	if !validTarget.MatchString(target) {
+		http.Error(w, fmt.Sprintf("invalid target: %q", target), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), diagnosticTimeout)
+	defer cancel()
+
+	if kind == "dns" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		start := time.Now()
+		addrs, err := net.DefaultResolver.LookupHost(ctx, target)
+		if err != nil {
+			streamLine(w, "lookup failed: %v", err)
+			return
+		}
+		for _, addr := range addrs {
+			streamLine(w, "%s", addr)
+		}
+		if names, err := net.DefaultResolver.LookupAddr(ctx, target); err == nil {
+			for _, name := range names {
+				streamLine(w, "PTR %s", name)
+			}
+		}
+		streamLine(w, "resolved in %s", time.Since(start))
+		return
+	}
+
+	cmd, err := diagnosticCommand(ctx, kind, target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to start %s: %v", kind, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		streamLine(w, "%s", scanner.Text())
+	}
+	if err := cmd.Wait(); err != nil {
+		streamLine(w, "%s exited: %v", kind, err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// srvDialTimeout bounds the reachability check of each SRV target.
+const srvDialTimeout = 3 * time.Second
+
+// serverURL returns the monitoring server base URL. When MONITORING_SERVER_SRV names a
+// DNS SRV record, the server is discovered through it, then through Consul if CONSUL_ADDR
+// is set; a discovered host and port replace those of the configured URL, keeping its
+// scheme and path prefix. Otherwise, or if discovery fails, the configured URL is used
+// (see configuredServerURL).
+func serverURL() string {
+	base, err := configuredServerURL()
+	if err != nil {
+		// Validated at startup.
+		base = "http://localhost:8080"
+	}
+	if name := os.Getenv("MONITORING_SERVER_SRV"); name != "" {
+		host, port, err := lookupServerSRV(name)
+		if err == nil {
+			fmt.Printf("Discovered monitoring server %s via SRV record %s\n", net.JoinHostPort(host, port), name)
+			return withHostPort(base, host, port)
+		}
+		fmt.Printf("Error discovering monitoring server via SRV: %v\n", err)
+	}
+	if consulAddr() != "" {
+		host, port, err := discoverConsulServer()
+		if err == nil {
+			fmt.Printf("Discovered monitoring server %s via Consul\n", net.JoinHostPort(host, port))
+			return withHostPort(base, host, port)
+		}
+		fmt.Printf("Error discovering monitoring server via Consul: %v\n", err)
+	}
+	return base
+}
+
+// lookupServerSRV resolves an SRV record and returns the first reachable target.
+// Targets are tried in priority order, with weighted random ordering among targets of
+// equal priority (RFC 2782), as returned by net.LookupSRV.
+func lookupServerSRV(name string) (string, string, error) {
+	_, addrs, err := net.LookupSRV("", "", name)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve %s: %v", name, err)
+	}
+	if len(addrs) == 0 {
+		return "", "", fmt.Errorf("no SRV targets for %s", name)
+	}
+	for _, srv := range addrs {
+		host := strings.TrimSuffix(srv.Target, ".")
+		port := strconv.Itoa(int(srv.Port))
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), srvDialTimeout)
+		if err != nil {
+			fmt.Printf("SRV target %s:%s unreachable: %v\n", host, port, err)
+			continue
+		}
+		conn.Close()
+		return host, port, nil
+	}
+	// None answered: use the preferred target so registration keeps retrying against it.
+	return strings.TrimSuffix(addrs[0].Target, "."), strconv.Itoa(int(addrs[0].Port)), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// DeviceBusy reports how busy a block device was since the previous collection.
+type DeviceBusy struct {
+	Name        string  `json:"name"`
+	BusyPercent float64 `json:"busyPercent"`
+}
+
+// lastIOTime holds the previous io time counters (milliseconds) per device.
+var lastIOTime struct {
+	sync.Mutex
+	ioTime map[string]uint64
+	at     time.Time
+}
+
+// collectDeviceBusy computes per-block-device busy percentage from the io time delta
+// since the previous collection. Loop and RAM devices are ignored. It is disabled with
+// DISK_BUSY_STATS=false and only reports from the second collection onwards.
+func collectDeviceBusy() ([]DeviceBusy, error) {
+	if !collectorEnabled("DISK_BUSY_STATS", true) {
+		return nil, nil
+	}
+	counters, err := disk.IOCounters()
+	if notImplemented(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read disk I/O counters: %v", err)
+	}
+	now := time.Now()
+
+	lastIOTime.Lock()
+	defer lastIOTime.Unlock()
+	previous, elapsed := lastIOTime.ioTime, now.Sub(lastIOTime.at)
+	lastIOTime.ioTime = make(map[string]uint64, len(counters))
+	lastIOTime.at = now
+
+	var devices []DeviceBusy
+	for name, c := range counters {
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+			continue
+		}
+		lastIOTime.ioTime[name] = c.IoTime
+		prev, ok := previous[name]
+		if !ok || c.IoTime < prev || elapsed <= 0 {
+			continue
+		}
+		busy := float64(c.IoTime-prev) / float64(elapsed.Milliseconds()) * 100
+		if busy > 100 {
+			busy = 100
+		}
+		devices = append(devices, DeviceBusy{Name: name, BusyPercent: busy})
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Name < devices[j].Name })
+	return devices, nil
+}
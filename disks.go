@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// networkFilesystems lists the filesystem types treated as network mounts.
+var networkFilesystems = map[string]bool{
+	"nfs": true, "nfs4": true, "cifs": true, "smbfs": true, "smb3": true,
+	"fuse.sshfs": true, "glusterfs": true, "ceph": true, "9p": true, "afs": true, "davfs": true,
+}
+
+// Default filters applied to the per-mount disk list unless overridden.
+var (
+	defaultExcludedFstypes = []string{"tmpfs", "devtmpfs", "squashfs"}
+	defaultExcludedMounts  = []string{"/snap/*"}
+)
+
+// DiskUsage reports the usage of a single mounted filesystem.
+type DiskUsage struct {
+	Mountpoint   string  `json:"mountpoint"`
+	Device       string  `json:"device"`
+	Fstype       string  `json:"fstype"`
+	Network      bool    `json:"network,omitempty"`
+	TotalBytes   uint64  `json:"totalBytes"`
+	UsedBytes    uint64  `json:"usedBytes"`
+	UsagePercent float64 `json:"usagePercent"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// diskTimeout returns the per-mount stat timeout from DISK_TIMEOUT (seconds, default 5).
+func diskTimeout() time.Duration {
+	if s := os.Getenv("DISK_TIMEOUT"); s != "" {
+		if seconds, err := strconv.Atoi(s); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		fmt.Printf("Invalid DISK_TIMEOUT value, using default 5 seconds: %s\n", s)
+	}
+	return 5 * time.Second
+}
+
+// statMount returns the usage of a mount point; replaced in tests.
+var statMount = disk.Usage
+
+// pendingStats holds the mount points whose stat has not returned yet.
+var pendingStats struct {
+	sync.Mutex
+	paths map[string]bool
+}
+
+// usageWithTimeout stats a mount point, giving up after timeout so that a hung
+// mount (typically NFS) cannot block the collection cycle. A mount whose previous stat
+// is still blocked is not stat'ed again, so hung mounts do not pile up goroutines.
+func usageWithTimeout(path string, timeout time.Duration) (*disk.UsageStat, error) {
+	type result struct {
+		usage *disk.UsageStat
+		err   error
+	}
+	pendingStats.Lock()
+	if pendingStats.paths[path] {
+		pendingStats.Unlock()
+		return nil, fmt.Errorf("previous stat still blocked")
+	}
+	if pendingStats.paths == nil {
+		pendingStats.paths = make(map[string]bool)
+	}
+	pendingStats.paths[path] = true
+	pendingStats.Unlock()
+
+	ch := make(chan result, 1)
+	go func() {
+		u, err := statMount(path)
+		pendingStats.Lock()
+		delete(pendingStats.paths, path)
+		pendingStats.Unlock()
+		ch <- result{u, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.usage, r.err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("timed out after %s", timeout)
+	}
+}
+
+// matchesMount reports whether mountpoint matches a filter entry: entries containing
+// wildcards are matched with path.Match, others as a path prefix.
+func matchesMount(mountpoint, pattern string) bool {
+	if strings.ContainsAny(pattern, "*?[") {
+		ok, _ := path.Match(pattern, mountpoint)
+		return ok || (strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mountpoint, strings.TrimSuffix(pattern, "*")))
+	}
+	return mountpoint == pattern || strings.HasPrefix(mountpoint, strings.TrimSuffix(pattern, "/")+"/")
+}
+
+// diskFilter decides which mounts are included in the per-mount disk list.
+type diskFilter struct {
+	includeFstypes []string
+	excludeFstypes []string
+	includeMounts  []string
+	excludeMounts  []string
+}
+
+// newDiskFilter reads the DISK_FSTYPES_INCLUDE, DISK_FSTYPES_EXCLUDE, DISK_MOUNTS_INCLUDE
+// and DISK_MOUNTS_EXCLUDE environment variables.
+func newDiskFilter() diskFilter {
+	return diskFilter{
+		includeFstypes: envList("DISK_FSTYPES_INCLUDE", nil),
+		excludeFstypes: envList("DISK_FSTYPES_EXCLUDE", defaultExcludedFstypes),
+		includeMounts:  envList("DISK_MOUNTS_INCLUDE", nil),
+		excludeMounts:  envList("DISK_MOUNTS_EXCLUDE", defaultExcludedMounts),
+	}
+}
+
+// allows reports whether a mount passes the filter. Include lists, when set, restrict
+// the mounts to those matching; exclude lists are applied afterwards.
+func (f diskFilter) allows(p disk.PartitionStat) bool {
+	if len(f.includeFstypes) > 0 && !slices.Contains(f.includeFstypes, p.Fstype) {
+		return false
+	}
+	if slices.Contains(f.excludeFstypes, p.Fstype) {
+		return false
+	}
+	if len(f.includeMounts) > 0 && !slices.ContainsFunc(f.includeMounts, func(m string) bool { return matchesMount(p.Mountpoint, m) }) {
+		return false
+	}
+	return !slices.ContainsFunc(f.excludeMounts, func(m string) bool { return matchesMount(p.Mountpoint, m) })
+}
+
+// collectDisks reports the usage of every mounted filesystem that passes the disk filter,
+// unless DISK_LIST is "false". Each mount is stat'ed with DISK_TIMEOUT; network mounts are
+// labelled, or skipped when NETWORK_FS=skip.
+func collectDisks() ([]DiskUsage, error) {
+	if !collectorEnabled("DISK_LIST", true) {
+		return nil, nil
+	}
+	mounts, err := listMounts()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mounts: %v", err)
+	}
+	timeout := diskTimeout()
+	filter := newDiskFilter()
+	seen := make(map[string]bool)
+	var disks []DiskUsage
+	for _, m := range mounts {
+		if seen[m.Mountpoint] || !filter.allows(m) {
+			continue
+		}
+		seen[m.Mountpoint] = true
+		d := DiskUsage{
+			Mountpoint: m.Mountpoint,
+			Device:     m.Device,
+			Fstype:     m.Fstype,
+			Network:    networkFilesystems[m.Fstype],
+		}
+		usage, err := usageWithTimeout(m.Mountpoint, timeout)
+		if err != nil {
+			d.Error = err.Error()
+		} else {
+			d.TotalBytes = usage.Total
+			d.UsedBytes = usage.Used
+			d.UsagePercent = usage.UsedPercent
+		}
+		disks = append(disks, d)
+	}
+	return disks, nil
+}
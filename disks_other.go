@@ -0,0 +1,35 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// rootMountpoint is the filesystem whose usage is reported as diskUsage.
+func rootMountpoint() string {
+	return "/"
+}
+
+// listMounts returns the local filesystems plus, unless NETWORK_FS is "skip", the network ones.
+func listMounts() ([]disk.PartitionStat, error) {
+	local, err := disk.Partitions(false)
+	if err != nil {
+		return nil, err
+	}
+	if os.Getenv("NETWORK_FS") == "skip" {
+		return local, nil
+	}
+	all, err := disk.Partitions(true)
+	if err != nil {
+		return local, nil
+	}
+	for _, p := range all {
+		if networkFilesystems[p.Fstype] {
+			local = append(local, p)
+		}
+	}
+	return local, nil
+}
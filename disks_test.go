@@ -0,0 +1,73 @@
+package main
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+func TestUsageWithTimeoutSkipsHungMount(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	statMount = func(path string) (*disk.UsageStat, error) {
+		calls.Add(1)
+		if path == "/hung" {
+			<-release
+		}
+		return &disk.UsageStat{Path: path}, nil
+	}
+	defer func() { statMount = disk.Usage }()
+
+	if _, err := usageWithTimeout("/hung", 10*time.Millisecond); err == nil {
+		t.Fatal("expected a timeout on the hung mount")
+	}
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < 5; i++ {
+		if _, err := usageWithTimeout("/hung", 10*time.Millisecond); err == nil {
+			t.Fatal("expected an error while the previous stat is blocked")
+		}
+	}
+	if calls.Load() != 1 || runtime.NumGoroutine() > goroutines {
+		t.Errorf("hung mount stat'ed %d times, goroutines %d -> %d", calls.Load(), goroutines, runtime.NumGoroutine())
+	}
+	if u, err := usageWithTimeout("/ok", time.Second); err != nil || u.Path != "/ok" {
+		t.Errorf("other mount: got %v, %v", u, err)
+	}
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if u, err := usageWithTimeout("/hung", time.Second); err == nil {
+			if u.Path != "/hung" {
+				t.Errorf("got usage of %s", u.Path)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("mount still skipped after its stat returned")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMatchesMount(t *testing.T) {
+	tests := []struct {
+		mountpoint, pattern string
+		want                bool
+	}{
+		{"/snap/core/123", "/snap/*", true},
+		{"/snap", "/snap/*", false},
+		{"/var/lib/docker/overlay", "/var/lib/docker", true},
+		{"/var/lib/dockerd", "/var/lib/docker", false},
+		{"/mnt/backup/daily", "/mnt/backup/", true},
+		{"/data1", "/data?", true},
+	}
+	for _, tt := range tests {
+		if got := matchesMount(tt.mountpoint, tt.pattern); got != tt.want {
+			t.Errorf("matchesMount(%q, %q) = %v, want %v", tt.mountpoint, tt.pattern, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+
+	"github.com/shirou/gopsutil/v3/disk"
+	"golang.org/x/sys/windows"
+)
+
+// rootMountpoint is the filesystem whose usage is reported as diskUsage: the system drive.
+func rootMountpoint() string {
+	if drive := os.Getenv("SystemDrive"); drive != "" {
+		return drive + `\`
+	}
+	return `C:\`
+}
+
+// listMounts enumerates the fixed drive letters. Removable, optical and network drives are skipped.
+func listMounts() ([]disk.PartitionStat, error) {
+	mask, err := windows.GetLogicalDrives()
+	if err != nil {
+		return nil, err
+	}
+	var drives []disk.PartitionStat
+	for i := 0; i < 26; i++ {
+		if mask&(1<<uint(i)) == 0 {
+			continue
+		}
+		root := string(rune('A'+i)) + `:\`
+		rootPtr, err := windows.UTF16PtrFromString(root)
+		if err != nil || windows.GetDriveType(rootPtr) != windows.DRIVE_FIXED {
+			continue
+		}
+		fsName := make([]uint16, windows.MAX_PATH+1)
+		fstype := ""
+		if err := windows.GetVolumeInformation(rootPtr, nil, 0, nil, nil, nil, &fsName[0], uint32(len(fsName))); err == nil {
+			fstype = windows.UTF16ToString(fsName)
+		}
+		drives = append(drives, disk.PartitionStat{Device: root[:2], Mountpoint: root, Fstype: fstype})
+	}
+	return drives, nil
+}
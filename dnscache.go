@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the server DNS cache.
+const (
+	defaultServerDNSTTL             = 300
+	defaultServerDNSRefreshFailures = 3
+)
+
+// dnsEntry is the cached resolution of one host name.
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// dnsCache resolves the monitoring server host names for the shared HTTP client and
+// re-resolves them once their TTL expires or after repeated request failures. Without it,
+// kept-alive connections would stay pinned to a server's old address after it moves.
+type dnsCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	threshold int
+	entries   map[string]*dnsEntry
+	failures  map[string]int
+	dialer    *net.Dialer
+	transport *http.Transport
+}
+
+// newDNSCache creates a cache whose entries live for ttl, dialing with dialer and
+// dropping the idle connections of transport when a server's addresses change.
+func newDNSCache(dialer *net.Dialer, transport *http.Transport, ttl time.Duration, threshold int) *dnsCache {
+	return &dnsCache{
+		ttl:       ttl,
+		threshold: threshold,
+		entries:   make(map[string]*dnsEntry),
+		failures:  make(map[string]int),
+		dialer:    dialer,
+		transport: transport,
+	}
+}
+
+// lookup returns the addresses of host, resolving it if it is not cached or has expired.
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	e := c.entries[host]
+	c.mu.Unlock()
+	if e != nil && time.Now().Before(e.expires) {
+		return e.addrs, nil
+	}
+	return c.resolve(ctx, host, e)
+}
+
+// resolve looks host up and caches the result. When the addresses differ from the
+// previous ones, idle connections are closed so the next requests reach the new address.
+// If the lookup fails, the previous addresses are kept.
+func (c *dnsCache) resolve(ctx context.Context, host string, previous *dnsEntry) ([]string, error) {
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		if previous != nil {
+			fmt.Printf("Error re-resolving %s, keeping %s: %v\n", host, strings.Join(previous.addrs, ","), err)
+			return previous.addrs, nil
+		}
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[host] = &dnsEntry{addrs: addrs, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	if previous != nil && !slices.Equal(previous.addrs, addrs) {
+		fmt.Printf("%s now resolves to %s (was %s), reconnecting\n", host, strings.Join(addrs, ","), strings.Join(previous.addrs, ","))
+		c.transport.CloseIdleConnections()
+	}
+	return addrs, nil
+}
+
+// refreshIfExpired re-resolves a cached host whose TTL has expired. It runs before each
+// request because kept-alive connections are reused without dialing.
+func (c *dnsCache) refreshIfExpired(ctx context.Context, host string) {
+	c.mu.Lock()
+	e := c.entries[host]
+	c.mu.Unlock()
+	if e != nil && !time.Now().Before(e.expires) {
+		c.resolve(ctx, host, e)
+	}
+}
+
+// recordResult counts consecutive failed requests to host. After threshold of them, the
+// cached addresses are dropped and idle connections closed, so the next request resolves
+// the host again and opens a fresh connection.
+func (c *dnsCache) recordResult(ctx context.Context, host string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		delete(c.failures, host)
+		return
+	}
+	// A request abandoned by the agent says nothing about the server.
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+	c.failures[host]++
+	if c.threshold > 0 && c.failures[host] >= c.threshold {
+		fmt.Printf("%d consecutive failures reaching %s, resolving it again\n", c.failures[host], host)
+		delete(c.failures, host)
+		delete(c.entries, host)
+		c.transport.CloseIdleConnections()
+	}
+}
+
+// minDialShare is the least time given to each address of a host when dialing them in turn.
+const minDialShare = 2 * time.Second
+
+// dialContext dials addr, resolving its host through the cache. As net.Dialer does for
+// the host names it resolves itself, the addresses of the first family are raced against
+// those of the other one after the dialer's FallbackDelay (Happy Eyeballs), and the
+// dialer's Timeout bounds the whole dial rather than each address.
+func (c *dnsCache) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return c.dialer.DialContext(ctx, network, addr)
+	}
+	addrs, err := c.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	primaries, fallbacks := splitAddrFamilies(network, addrs)
+	if len(primaries) == 0 {
+		return nil, fmt.Errorf("no %s addresses for %s", network, host)
+	}
+	if c.dialer.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.dialer.Timeout)
+		defer cancel()
+	}
+	if len(fallbacks) == 0 || c.dialer.FallbackDelay < 0 {
+		return c.dialSerial(ctx, network, port, append(primaries, fallbacks...))
+	}
+	delay := c.dialer.FallbackDelay
+	if delay == 0 {
+		delay = 300 * time.Millisecond
+	}
+
+	type dialResult struct {
+		conn    net.Conn
+		err     error
+		primary bool
+	}
+	results := make(chan dialResult)
+	returned := make(chan struct{})
+	defer close(returned)
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	race := func(addrs []string, primary bool) {
+		conn, err := c.dialSerial(raceCtx, network, port, addrs)
+		select {
+		case results <- dialResult{conn, err, primary}:
+		case <-returned:
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}
+	go race(primaries, true)
+	fallbackTimer := time.NewTimer(delay)
+	defer fallbackTimer.Stop()
+
+	var primaryErr error
+	fallbackStarted, pending := false, 1
+	for {
+		select {
+		case <-fallbackTimer.C:
+			if !fallbackStarted {
+				fallbackStarted = true
+				pending++
+				go race(fallbacks, false)
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				return res.conn, nil
+			}
+			if res.primary {
+				primaryErr = res.err
+			}
+			// A failed primary family starts the fallback at once.
+			if !fallbackStarted {
+				fallbackStarted = true
+				pending++
+				go race(fallbacks, false)
+			}
+			if pending == 0 {
+				if primaryErr != nil {
+					return nil, primaryErr
+				}
+				return nil, res.err
+			}
+		}
+	}
+}
+
+// dialSerial dials addrs in turn until one connects. Each is given an equal share of the
+// time left, but at least minDialShare, so one unresponsive address cannot use it all.
+func (c *dnsCache) dialSerial(ctx context.Context, network, port string, addrs []string) (net.Conn, error) {
+	var lastErr error
+	for i, ip := range addrs {
+		dialCtx, cancel := ctx, context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok {
+			share := time.Until(deadline) / time.Duration(len(addrs)-i)
+			if share < minDialShare {
+				share = minDialShare
+			}
+			dialCtx, cancel = context.WithTimeout(ctx, share)
+		}
+		conn, err := c.dialer.DialContext(dialCtx, network, net.JoinHostPort(ip, port))
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// splitAddrFamilies returns the addresses usable on network, split into those of the
+// family of the first one and the others.
+func splitAddrFamilies(network string, addrs []string) (primaries, fallbacks []string) {
+	var primaryIPv4 bool
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+		ipv4 := ip.To4() != nil
+		if (network == "tcp4" && !ipv4) || (network == "tcp6" && ipv4) {
+			continue
+		}
+		if len(primaries) == 0 {
+			primaryIPv4 = ipv4
+		}
+		if ipv4 == primaryIPv4 {
+			primaries = append(primaries, addr)
+		} else {
+			fallbacks = append(fallbacks, addr)
+		}
+	}
+	return primaries, fallbacks
+}
+
+// dnsRefreshTransport keeps the DNS cache current around each request to a server.
+type dnsRefreshTransport struct {
+	base  http.RoundTripper
+	cache *dnsCache
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t dnsRefreshTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	host := r.URL.Hostname()
+	t.cache.refreshIfExpired(r.Context(), host)
+	resp, err := t.base.RoundTrip(r)
+	t.cache.recordResult(r.Context(), host, err)
+	return resp, err
+}
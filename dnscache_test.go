@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testDNSCache returns a cache with host resolving to addrs, whose dialer calls control
+// before connecting to each address.
+func testDNSCache(t *testing.T, addrs []string, control func(address string) error) *dnsCache {
+	t.Helper()
+	dialer := &net.Dialer{
+		Timeout:       5 * time.Second,
+		FallbackDelay: 50 * time.Millisecond,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return control(address)
+		},
+	}
+	c := newDNSCache(dialer, &http.Transport{}, time.Hour, 0)
+	c.entries["server.test"] = &dnsEntry{addrs: addrs, expires: time.Now().Add(time.Hour)}
+	return c
+}
+
+func TestDNSCacheDialRacesFamilies(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	errUnreachable := errors.New("unreachable")
+
+	tests := []struct {
+		name  string
+		addrs []string
+		// down are the addresses that hang for a second, then fail.
+		down []string
+		// want is the address connected to, or empty if the dial fails.
+		want string
+		// within bounds the time the dial may take.
+		within time.Duration
+	}{
+		{"primary family connects", []string{"127.0.0.1", "::1"}, []string{"::1"}, "127.0.0.1", 500 * time.Millisecond},
+		{"fallback family while the primary hangs", []string{"::1", "127.0.0.1"}, []string{"::1"}, "127.0.0.1", 500 * time.Millisecond},
+		{"next address of the family", []string{"::1", "::2", "127.0.0.1"}, []string{"::1", "::2"}, "127.0.0.1", 500 * time.Millisecond},
+		{"every address down", []string{"::1", "::2"}, []string{"::1", "::2"}, "", 3 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testDNSCache(t, tt.addrs, func(address string) error {
+				for _, down := range tt.down {
+					if strings.HasPrefix(address, "["+down+"]") {
+						time.Sleep(time.Second)
+						return errUnreachable
+					}
+				}
+				return nil
+			})
+			start := time.Now()
+			conn, err := c.dialContext(context.Background(), "tcp", net.JoinHostPort("server.test", port))
+			if elapsed := time.Since(start); elapsed > tt.within {
+				t.Errorf("dial took %v, want at most %v", elapsed, tt.within)
+			}
+			if tt.want == "" {
+				if !errors.Is(err, errUnreachable) {
+					t.Errorf("got %v, want the dial error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if host, _, _ := net.SplitHostPort(conn.RemoteAddr().String()); host != tt.want {
+				t.Errorf("connected to %s, want %s", host, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitAddrFamilies(t *testing.T) {
+	addrs := []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2"}
+	tests := []struct {
+		network             string
+		primaries, fallback string
+	}{
+		{"tcp", "2001:db8::1,2001:db8::2", "192.0.2.1,192.0.2.2"},
+		{"tcp4", "192.0.2.1,192.0.2.2", ""},
+		{"tcp6", "2001:db8::1,2001:db8::2", ""},
+	}
+	for _, tt := range tests {
+		primaries, fallbacks := splitAddrFamilies(tt.network, addrs)
+		if got := strings.Join(primaries, ","); got != tt.primaries {
+			t.Errorf("%s primaries: got %s, want %s", tt.network, got, tt.primaries)
+		}
+		if got := strings.Join(fallbacks, ","); got != tt.fallback {
+			t.Errorf("%s fallbacks: got %s, want %s", tt.network, got, tt.fallback)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Server API endpoints, by the name used in SERVER_ENDPOINTS and the handshake.
+const (
+	endpointRegister  = "register"
+	endpointMetrics   = "metrics"
+	endpointHeartbeat = "heartbeat"
+	endpointCrash     = "crash"
+	endpointConfig    = "config"
+	endpointUpload    = "upload"
+)
+
+// defaultEndpointPaths are the paths of the server API endpoints, relative to the server
+// base URL.
+var defaultEndpointPaths = map[string]string{
+	endpointRegister:  "/api/agent/register",
+	endpointMetrics:   "/api/metrics",
+	endpointHeartbeat: "/api/agent/heartbeat",
+	endpointCrash:     "/api/agent/crash",
+	endpointConfig:    "/api/agent/config",
+	endpointUpload:    "/api/agent/upload",
+}
+
+// parseEndpointPaths parses SERVER_ENDPOINTS, a comma-separated list of name=path
+// overrides such as "metrics=/ingest/v2/metrics,register=/agents".
+func parseEndpointPaths() (map[string]string, error) {
+	paths := make(map[string]string)
+	for _, item := range envList("SERVER_ENDPOINTS", nil) {
+		name, path, ok := strings.Cut(item, "=")
+		name, path = strings.TrimSpace(name), strings.TrimSpace(path)
+		if !ok {
+			return nil, fmt.Errorf("invalid SERVER_ENDPOINTS entry %q: use name=path", item)
+		}
+		if err := validateEndpoint(name, path); err != nil {
+			return nil, fmt.Errorf("invalid SERVER_ENDPOINTS entry %q: %v", item, err)
+		}
+		paths[name] = path
+	}
+	return paths, nil
+}
+
+// validateEndpoint checks an endpoint override: a known endpoint name and an absolute path
+// without a query, which the agent adds itself where needed.
+func validateEndpoint(name, path string) error {
+	if _, ok := defaultEndpointPaths[name]; !ok {
+		names := make([]string, 0, len(defaultEndpointPaths))
+		for n := range defaultEndpointPaths {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown endpoint %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, "?#") {
+		return fmt.Errorf("the path of %s must start with / and have no query", name)
+	}
+	return nil
+}
+
+// endpointPath returns the path of a server endpoint from SERVER_ENDPOINTS or the default.
+// It is validated at startup.
+func endpointPath(name string) string {
+	paths, _ := parseEndpointPaths()
+	if path, ok := paths[name]; ok {
+		return path
+	}
+	return defaultEndpointPaths[name]
+}
+
+// validateEndpointPaths checks SERVER_ENDPOINTS at startup.
+func validateEndpointPaths() error {
+	_, err := parseEndpointPaths()
+	return err
+}
+
+// endpoint returns the full URL of a server endpoint on d: the path the server chose in
+// its handshake, else the configured or default one. The registration path cannot come
+// from the handshake, which is its response.
+func (d *destination) endpoint(name string) string {
+	if paths, _ := d.endpoints.Load().(map[string]string); paths[name] != "" {
+		return d.url(paths[name])
+	}
+	return d.url(endpointPath(name))
+}
+
+// applyEndpoints stores the endpoint paths chosen by d in its handshake, ignoring invalid
+// ones.
+func (d *destination) applyEndpoints(endpoints map[string]string) {
+	paths := make(map[string]string)
+	for name, path := range endpoints {
+		if err := validateEndpoint(name, path); err != nil || name == endpointRegister {
+			fmt.Printf("Ignoring endpoint %s=%q requested by %s server\n", name, path, d.name)
+			continue
+		}
+		paths[name] = path
+	}
+	d.endpoints.Store(paths)
+}
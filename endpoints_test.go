@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseEndpointPaths(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    map[string]string
+		wantErr bool
+	}{
+		{env: "", want: map[string]string{}},
+		{env: "metrics=/ingest/v2/metrics", want: map[string]string{"metrics": "/ingest/v2/metrics"}},
+		{env: " metrics = /m , register=/agents ", want: map[string]string{"metrics": "/m", "register": "/agents"}},
+		{env: "metrics", wantErr: true},
+		{env: "metrics=ingest", wantErr: true},
+		{env: "metrics=/ingest?v=2", wantErr: true},
+		{env: "metrics=/ingest#x", wantErr: true},
+		{env: "logs=/logs", wantErr: true},
+		{env: "metrics=/m,bogus", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("SERVER_ENDPOINTS", tt.env)
+		got, err := parseEndpointPaths()
+		if (err != nil) != tt.wantErr || (!tt.wantErr && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("SERVER_ENDPOINTS=%q: got %v, %v; want %v, error %v", tt.env, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDestinationEndpoint(t *testing.T) {
+	t.Setenv("SERVER_ENDPOINTS", "metrics=/custom/metrics")
+	d := newDestination("primary", "https://monitor/prefix", "spool", true)
+	d.applyEndpoints(map[string]string{
+		"heartbeat": "/v2/heartbeat",
+		"register":  "/v2/register",
+		"config":    "relative",
+		"unknown":   "/x",
+	})
+	tests := []struct {
+		name, want string
+	}{
+		{endpointHeartbeat, "https://monitor/prefix/v2/heartbeat"},
+		{endpointMetrics, "https://monitor/prefix/custom/metrics"},
+		// The handshake cannot move registration, and invalid paths are ignored.
+		{endpointRegister, "https://monitor/prefix/api/agent/register"},
+		{endpointConfig, "https://monitor/prefix/api/agent/config"},
+	}
+	for _, tt := range tests {
+		if got := d.endpoint(tt.name); got != tt.want {
+			t.Errorf("endpoint(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bounds on the number of events buffered between two metrics sends.
+const (
+	maxPendingEvents     = 1000
+	maxPendingEventsLite = 100
+)
+
+// Event is a discrete state change detected by the agent, delivered with the next metrics payload.
+type Event struct {
+	Type      string `json:"type"`
+	Message   string `json:"message"`
+	Timestamp int64  `json:"timestamp"`
+	// Attributes carries structured details, such as the variable bindings of an SNMP trap.
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// reservedEventAttributes are the attributes set by the agent to mute events, which
+// submitted events may not set.
+var reservedEventAttributes = []string{"maintenance", "blackout"}
+
+// eventsTruncated counts the events discarded from a full buffer before they were sent.
+var eventsTruncated atomic.Uint64
+
+// pendingEvents holds the events not yet sent to the server.
+var pendingEvents struct {
+	sync.Mutex
+	events []Event
+}
+
+// emitEvent queues an event with a formatted message for delivery.
+func emitEvent(eventType, format string, args ...interface{}) {
+	e := Event{
+		Type:      eventType,
+		Message:   fmt.Sprintf(format, args...),
+		Timestamp: time.Now().UnixMilli(),
+	}
+	queueEvent(e)
+}
+
+// queueEvent queues an event for delivery and logs it. When the buffer is full the oldest event is dropped.
+// During a maintenance or blackout window, events are muted: they are still delivered,
+// carrying the maintenance=true or blackout=<windows> attribute so the server does not
+// alert on them.
+func queueEvent(e Event) {
+	if mute := muteAttributes(); mute != nil {
+		// The mute attributes are applied last, so an event cannot unmute itself.
+		attributes := make(map[string]string, len(e.Attributes)+len(mute))
+		for k, v := range e.Attributes {
+			attributes[k] = v
+		}
+		for k, v := range mute {
+			attributes[k] = v
+		}
+		e.Attributes = attributes
+		fmt.Printf("Event %s (muted): %s\n", e.Type, e.Message)
+	} else {
+		fmt.Printf("Event %s: %s\n", e.Type, e.Message)
+	}
+	limit := maxPendingEvents
+	if liteMode() {
+		limit = maxPendingEventsLite
+	}
+	pendingEvents.Lock()
+	defer pendingEvents.Unlock()
+	if len(pendingEvents.events) >= limit {
+		pendingEvents.events = pendingEvents.events[1:]
+		eventsTruncated.Add(1)
+	}
+	pendingEvents.events = append(pendingEvents.events, e)
+}
+
+// takeEvents returns all pending events and clears the buffer.
+func takeEvents() []Event {
+	pendingEvents.Lock()
+	defer pendingEvents.Unlock()
+	events := pendingEvents.events
+	pendingEvents.events = nil
+	return events
+}
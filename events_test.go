@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueueEventCannotUnmuteItself(t *testing.T) {
+	t.Setenv("STATE_DIR", t.TempDir())
+	takeEvents()
+	t.Cleanup(func() { takeEvents() })
+	if _, err := startMaintenance(time.Hour, "test", "test"); err != nil {
+		t.Fatal(err)
+	}
+	queueEvent(Event{Type: "custom.test", Attributes: map[string]string{"maintenance": "false", "job": "backup"}})
+	events := takeEvents()
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	if got := events[0].Attributes; got["maintenance"] != "true" || got["job"] != "backup" {
+		t.Errorf("got attributes %v, want maintenance=true and the event's own job", got)
+	}
+}
+
+func TestIngestRejectsReservedAttributes(t *testing.T) {
+	t.Setenv("STATE_DIR", t.TempDir())
+	takeEvents()
+	t.Cleanup(func() { takeEvents() })
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"events": [{"type": "backup.ok", "attributes": {"job": "nightly"}}]}`, http.StatusAccepted},
+		{`{"events": [{"type": "backup.ok", "attributes": {"maintenance": "false"}}]}`, http.StatusBadRequest},
+		{`{"events": [{"type": "backup.ok", "attributes": {"blackout": ""}}]}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handleIngest(rec, httptest.NewRequest("POST", "/ingest", strings.NewReader(tt.body)))
+		if rec.Code != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.body, rec.Code, tt.want)
+		}
+	}
+}
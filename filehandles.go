@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// fdWarnPercent is the file handle usage above which an event is emitted.
+const fdWarnPercent = 90
+
+// FileHandleStats reports system-wide file handle allocation and per-process descriptor usage.
+type FileHandleStats struct {
+	Allocated    uint64           `json:"allocated,omitempty"`
+	Max          uint64           `json:"max,omitempty"`
+	UsagePercent float64          `json:"usagePercent,omitempty"`
+	Processes    []ProcessFDUsage `json:"processes,omitempty"`
+}
+
+// ProcessFDUsage is the descriptor usage of one watched process against its RLIMIT_NOFILE.
+type ProcessFDUsage struct {
+	PID          int32   `json:"pid"`
+	Name         string  `json:"name"`
+	Open         int32   `json:"open"`
+	Limit        uint64  `json:"limit,omitempty"`
+	UsagePercent float64 `json:"usagePercent,omitempty"`
+}
+
+// fdAlerted tracks which file handle usages are above the warning threshold, so an
+// event is only emitted when usage crosses it.
+var fdAlerted struct {
+	sync.Mutex
+	system    bool
+	processes map[int32]bool
+}
+
+// readFileNr reads the allocated handle count and fs.file-max from /proc/sys/fs/file-nr.
+func readFileNr() (allocated, max uint64, ok bool) {
+	data, err := os.ReadFile("/proc/sys/fs/file-nr")
+	if err != nil {
+		return 0, 0, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 3 {
+		return 0, 0, false
+	}
+	allocated, err1 := strconv.ParseUint(fields[0], 10, 64)
+	max, err2 := strconv.ParseUint(fields[2], 10, 64)
+	return allocated, max, err1 == nil && err2 == nil
+}
+
+// watchedProcesses returns the running processes whose name is listed in FD_WATCH_PROCESSES.
+func watchedProcesses() []*process.Process {
+	names := envList("FD_WATCH_PROCESSES", nil)
+	if len(names) == 0 {
+		return nil
+	}
+	procs, err := process.Processes()
+	if err != nil {
+		return nil
+	}
+	var watched []*process.Process
+	for _, p := range procs {
+		name, err := p.Name()
+		if err != nil {
+			continue
+		}
+		for _, n := range names {
+			if name == n {
+				watched = append(watched, p)
+				break
+			}
+		}
+	}
+	return watched
+}
+
+// collectFileHandles reports system-wide handle allocation (Linux) and the descriptor
+// usage of the processes listed in FD_WATCH_PROCESSES, emitting fd.system_high and
+// fd.process_high events when usage crosses 90%.
+func collectFileHandles() *FileHandleStats {
+	if !collectorEnabled("FD_STATS", true) {
+		return nil
+	}
+	stats := &FileHandleStats{}
+	if runtime.GOOS == "linux" {
+		if allocated, max, ok := readFileNr(); ok && max > 0 {
+			stats.Allocated, stats.Max = allocated, max
+			stats.UsagePercent = float64(allocated) / float64(max) * 100
+		}
+	}
+	for _, p := range watchedProcesses() {
+		open, err := p.NumFDs()
+		if err != nil {
+			continue
+		}
+		usage := ProcessFDUsage{PID: p.Pid, Open: open}
+		usage.Name, _ = p.Name()
+		if limits, err := p.Rlimit(); err == nil {
+			for _, l := range limits {
+				if l.Resource == process.RLIMIT_NOFILE && l.Soft > 0 {
+					usage.Limit = l.Soft
+					usage.UsagePercent = float64(open) / float64(l.Soft) * 100
+				}
+			}
+		}
+		stats.Processes = append(stats.Processes, usage)
+	}
+
+	fdAlerted.Lock()
+	defer fdAlerted.Unlock()
+	high := stats.UsagePercent >= fdWarnPercent
+	if high && !fdAlerted.system {
+		emitEvent("fd.system_high", "%d of %d system file handles allocated (%.1f%%)", stats.Allocated, stats.Max, stats.UsagePercent)
+	}
+	fdAlerted.system = high
+	current := make(map[int32]bool, len(stats.Processes))
+	for _, u := range stats.Processes {
+		high := u.UsagePercent >= fdWarnPercent
+		if high && !fdAlerted.processes[u.PID] {
+			emitEvent("fd.process_high", "process %s (pid %d) has %d of %d file descriptors open (%.1f%%)", u.Name, u.PID, u.Open, u.Limit, u.UsagePercent)
+		}
+		current[u.PID] = high
+	}
+	fdAlerted.processes = current
+
+	if stats.Max == 0 && stats.Processes == nil {
+		return nil
+	}
+	return stats
+}
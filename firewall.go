@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// firewallInterval is the minimum time between two firewall ruleset snapshots.
+const firewallInterval = 5 * time.Minute
+
+// FirewallInfo summarizes the host firewall ruleset.
+type FirewallInfo struct {
+	Backend   string `json:"backend"`
+	Hash      string `json:"hash"`
+	RuleCount int    `json:"ruleCount"`
+	Chains    int    `json:"chains"`
+}
+
+// nftStateful matches the packet/byte counters and set element expiry times nft prints,
+// which change on every dump. nft -s leaves them out, but is ignored by some versions for
+// named counters and dynamic sets.
+var nftStateful = regexp.MustCompile(` (packets \d+ bytes \d+|expires [0-9dhms.]+)`)
+
+// lastFirewall holds the previous snapshot, used for drift detection and to rate-limit snapshots.
+var lastFirewall struct {
+	sync.Mutex
+	info  *FirewallInfo
+	rules []string
+	taken time.Time
+}
+
+// snapshotFirewall dumps the active ruleset using the first available backend.
+// It returns the backend name and the normalized rule lines.
+func snapshotFirewall() (string, []string, error) {
+	type backend struct {
+		name string
+		cmd  []string
+	}
+	var backends []backend
+	switch runtime.GOOS {
+	case "linux":
+		backends = []backend{
+			{"nftables", []string{"nft", "-s", "list", "ruleset"}},
+			{"iptables", []string{"iptables-save"}},
+		}
+	case "windows":
+		backends = []backend{
+			{"windows-firewall", []string{"netsh", "advfirewall", "firewall", "show", "rule", "name=all"}},
+		}
+	case "freebsd":
+		backends = []backend{
+			{"pf", []string{"pfctl", "-sr"}},
+			{"ipfw", []string{"ipfw", "list"}},
+		}
+	case "darwin", "openbsd":
+		backends = []backend{{"pf", []string{"pfctl", "-sr"}}}
+	}
+
+	var lastErr error = fmt.Errorf("no firewall backend for %s", runtime.GOOS)
+	for _, b := range backends {
+		out, err := exec.Command(b.cmd[0], b.cmd[1:]...).Output()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		var rules []string
+		for _, line := range strings.Split(string(out), "\n") {
+			if line = normalizeRule(b.name, line); line != "" {
+				rules = append(rules, line)
+			}
+		}
+		return b.name, rules, nil
+	}
+	return "", nil, lastErr
+}
+
+// normalizeRule strips a line of a ruleset dump of what changes without the ruleset
+// changing, such as packet counters, and returns "" for blank lines and comments.
+func normalizeRule(backend, line string) string {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ""
+	}
+	switch backend {
+	case "iptables":
+		if strings.HasPrefix(line, ":") {
+			if i := strings.LastIndex(line, " ["); i > 0 {
+				line = line[:i]
+			}
+		}
+	case "nftables":
+		line = nftStateful.ReplaceAllString(line, "")
+	}
+	return line
+}
+
+// summarizeRules computes the hash and the rule/chain counts of a ruleset.
+func summarizeRules(backend string, rules []string) FirewallInfo {
+	sum := sha256.Sum256([]byte(strings.Join(rules, "\n")))
+	info := FirewallInfo{Backend: backend, Hash: hex.EncodeToString(sum[:])}
+	for _, r := range rules {
+		switch {
+		case backend == "iptables" && strings.HasPrefix(r, "-A "),
+			backend == "nftables" && !strings.HasPrefix(r, "table ") && !strings.HasPrefix(r, "chain ") &&
+				!strings.HasPrefix(r, "type ") && r != "}",
+			backend == "windows-firewall" && strings.HasPrefix(r, "Rule Name:"),
+			backend == "pf", backend == "ipfw":
+			info.RuleCount++
+		}
+		if (backend == "iptables" && strings.HasPrefix(r, ":")) || (backend == "nftables" && strings.HasPrefix(r, "chain ")) {
+			info.Chains++
+		}
+	}
+	return info
+}
+
+// diffRules returns the number of rules added and removed between two snapshots.
+func diffRules(previous, current []string) (added, removed int) {
+	counts := make(map[string]int, len(previous))
+	for _, r := range previous {
+		counts[r]++
+	}
+	for _, r := range current {
+		if counts[r] > 0 {
+			counts[r]--
+		} else {
+			added++
+		}
+	}
+	for _, n := range counts {
+		removed += n
+	}
+	return added, removed
+}
+
+// collectFirewall snapshots the firewall ruleset when FIREWALL_INVENTORY is "true",
+// at most every five minutes, and emits a firewall.changed event when the ruleset differs
+// from the previous snapshot. Between snapshots the last summary is reported.
+func collectFirewall() (*FirewallInfo, error) {
+	if !collectorEnabled("FIREWALL_INVENTORY", false) {
+		return nil, nil
+	}
+	lastFirewall.Lock()
+	defer lastFirewall.Unlock()
+	if lastFirewall.info != nil && time.Since(lastFirewall.taken) < firewallInterval {
+		return lastFirewall.info, nil
+	}
+
+	backend, rules, err := snapshotFirewall()
+	if err != nil {
+		return lastFirewall.info, fmt.Errorf("failed to read the firewall ruleset: %v", err)
+	}
+	info := summarizeRules(backend, rules)
+	if prev := lastFirewall.info; prev != nil && prev.Hash != info.Hash {
+		added, removed := diffRules(lastFirewall.rules, rules)
+		emitEvent("firewall.changed", "%s ruleset changed: %d rules added, %d rules removed", backend, added, removed)
+	}
+	lastFirewall.info = &info
+	lastFirewall.rules = rules
+	lastFirewall.taken = time.Now()
+	return &info, nil
+}
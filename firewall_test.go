@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestNormalizeRule(t *testing.T) {
+	tests := []struct {
+		backend, line, want string
+	}{
+		{"nftables", "  tcp dport 22 counter packets 1234 bytes 56789 accept", "tcp dport 22 counter accept"},
+		{"nftables", "elements = { 192.0.2.1 expires 58m12s, 192.0.2.2 expires 1h }", "elements = { 192.0.2.1, 192.0.2.2 }"},
+		{"nftables", "counter ssh-hits { packets 10 bytes 600 }", "counter ssh-hits { }"},
+		{"nftables", "# comment", ""},
+		{"iptables", ":INPUT ACCEPT [1433:120392]", ":INPUT ACCEPT"},
+		{"iptables", "-A INPUT -p tcp --dport 22 -j ACCEPT", "-A INPUT -p tcp --dport 22 -j ACCEPT"},
+		{"pf", "   ", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeRule(tt.backend, tt.line); got != tt.want {
+			t.Errorf("normalizeRule(%q, %q) = %q, want %q", tt.backend, tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestFirewallHashIgnoresCounters(t *testing.T) {
+	dump := func(packets string) []string {
+		var rules []string
+		for _, line := range []string{
+			"table inet filter {",
+			"chain input {",
+			"type filter hook input priority filter; policy drop;",
+			"tcp dport 22 counter packets " + packets + " bytes 100 accept",
+			"}",
+			"}",
+		} {
+			rules = append(rules, normalizeRule("nftables", line))
+		}
+		return rules
+	}
+	first, second := summarizeRules("nftables", dump("1")), summarizeRules("nftables", dump("2"))
+	if first.Hash != second.Hash {
+		t.Errorf("hash changed with the packet counter: %s != %s", first.Hash, second.Hash)
+	}
+	if first.RuleCount != 1 || first.Chains != 1 {
+		t.Errorf("got %d rules in %d chains, want 1 in 1", first.RuleCount, first.Chains)
+	}
+}
+
+func TestDiffRules(t *testing.T) {
+	added, removed := diffRules([]string{"a", "b", "b"}, []string{"b", "c", "d"})
+	if added != 2 || removed != 2 {
+		t.Errorf("got %d added, %d removed, want 2 and 2", added, removed)
+	}
+}
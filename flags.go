@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultConfigPollInterval is how often the remote configuration is fetched from the server.
+const defaultConfigPollInterval = 5 * time.Minute
+
+// RemoteConfig is the per-agent configuration served by the monitoring server. The server
+// resolves it from the agent ID and tags, so flags can target a single agent or a tag group.
+type RemoteConfig struct {
+	// Flags enables or disables features by name, overriding the local configuration.
+	// Names are the environment variables controlling each collector (e.g. TCP_STATS) or
+	// one of the check and debug feature names (LATENCY_CHECKS, BANDWIDTH_TEST, DIAGNOSTICS, REMOTE_LOGS).
+	Flags map[string]bool `json:"flags"`
+	// DisabledCollectors are collectors to stop running, by the name reported in
+	// GET /status (e.g. sql, topProcesses).
+	DisabledCollectors []string `json:"disabledCollectors,omitempty"`
+	// WasmModules are the WASM collectors the agent should run.
+	WasmModules []WasmModule `json:"wasmModules,omitempty"`
+}
+
+// remoteFlags holds the flags received from the server.
+var remoteFlags struct {
+	sync.Mutex
+	flags map[string]bool
+}
+
+// effectiveFlags records the value each feature flag resolved to the last time it was checked.
+var effectiveFlags struct {
+	sync.Mutex
+	flags map[string]bool
+}
+
+// featureEnabled reports whether the feature controlled by name is enabled: a flag set by
+// the server's remote configuration wins over one chosen in the registration handshake,
+// which wins over the local setting def. The outcome is recorded for reporting.
+func featureEnabled(name string, def bool) bool {
+	enabled := def
+	if v, ok := negotiatedFeature(name); ok {
+		enabled = v
+	}
+	remoteFlags.Lock()
+	if v, ok := remoteFlags.flags[name]; ok {
+		enabled = v
+	}
+	remoteFlags.Unlock()
+
+	effectiveFlags.Lock()
+	if effectiveFlags.flags == nil {
+		effectiveFlags.flags = make(map[string]bool)
+	}
+	effectiveFlags.flags[name] = enabled
+	effectiveFlags.Unlock()
+	return enabled
+}
+
+// currentFlags returns a copy of the effective flag set, reported in each metrics payload.
+func currentFlags() map[string]bool {
+	effectiveFlags.Lock()
+	defer effectiveFlags.Unlock()
+	flags := make(map[string]bool, len(effectiveFlags.flags))
+	for k, v := range effectiveFlags.flags {
+		flags[k] = v
+	}
+	return flags
+}
+
+// agentTags returns the tags from AGENT_TAGS, used by the server to group agents.
+func agentTags() []string {
+	return envList("AGENT_TAGS", nil)
+}
+
+// applyRemoteConfig installs the flags from a remote configuration received from the
+// server at baseURL and persists them, so they stay in effect across restarts while the
+// server is unreachable. Flag changes are recorded in the audit log.
+func applyRemoteConfig(baseURL string, cfg RemoteConfig) {
+	remoteFlags.Lock()
+	previous := remoteFlags.flags
+	remoteFlags.flags = cfg.Flags
+	remoteFlags.Unlock()
+	updateState(func(s *AgentState) { s.RemoteFlags = cfg.Flags })
+	if changes := diffFlags(previous, cfg.Flags); changes != "" {
+		writeAudit(AuditEntry{Actor: "server:" + baseURL, Action: "config.flags", Details: changes, Result: "applied"})
+	}
+}
+
+// loadPersistedFlags restores the flags received from the server before the last restart.
+func loadPersistedFlags() {
+	remoteFlags.Lock()
+	remoteFlags.flags = readState().RemoteFlags
+	remoteFlags.Unlock()
+}
+
+// fetchRemoteConfig fetches the agent's remote configuration from the server d.
+// A 404 means the server has no configuration for this agent and clears any remote flags.
+func fetchRemoteConfig(d *destination) error {
+	agentID, err := loadAgentID()
+	if err != nil {
+		return err
+	}
+	query := url.Values{"agentId": {agentID}}
+	if tags := agentTags(); len(tags) > 0 {
+		query.Set("tags", strings.Join(tags, ","))
+	}
+	resp, err := httpClient().Get(d.endpoint(endpointConfig) + "?" + query.Encode())
+	if err != nil {
+		return fmt.Errorf("failed to fetch remote config: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		applyRemoteConfig(d.baseURL, RemoteConfig{})
+		applyRemoteCollectors(d.baseURL, nil)
+		syncWasmModules(d.baseURL, nil)
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("remote config request failed with status: %s", resp.Status)
+	}
+	var cfg RemoteConfig
+	if err := json.NewDecoder(resp.Body).Decode(&cfg); err != nil {
+		return fmt.Errorf("invalid remote config: %v", err)
+	}
+	applyRemoteConfig(d.baseURL, cfg)
+	applyRemoteCollectors(d.baseURL, cfg.DisabledCollectors)
+	syncWasmModules(d.baseURL, cfg.WasmModules)
+	return nil
+}
+
+// startRemoteConfig polls the server for the agent's remote configuration every
+// CONFIG_POLL_INTERVAL seconds. Polling is disabled with CONFIG_POLL_INTERVAL=0.
+func startRemoteConfig(d *destination) {
+	interval := defaultConfigPollInterval
+	if s := os.Getenv("CONFIG_POLL_INTERVAL"); s != "" {
+		seconds, err := strconv.Atoi(s)
+		if err != nil || seconds < 0 {
+			fmt.Printf("Invalid CONFIG_POLL_INTERVAL value, using default %s: %s\n", interval, s)
+		} else if seconds == 0 {
+			return
+		} else {
+			interval = time.Duration(seconds) * time.Second
+		}
+	}
+	supervise("remote config", func() {
+		for {
+			if err := fetchRemoteConfig(d); err != nil {
+				fmt.Printf("Error fetching remote config: %v\n", err)
+			}
+			time.Sleep(interval)
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FreshnessResult holds the outcome of a file freshness check.
+type FreshnessResult struct {
+	Name          string `json:"name"`
+	Path          string `json:"path"`
+	ModifiedAt    int64  `json:"modifiedAt,omitempty"`
+	AgeSeconds    int64  `json:"ageSeconds"`
+	MaxAgeSeconds int64  `json:"maxAgeSeconds"`
+	Stale         bool   `json:"stale"`
+	Error         string `json:"error,omitempty"`
+}
+
+// freshnessCheck is a parsed entry of FILE_FRESHNESS_CHECKS.
+type freshnessCheck struct {
+	name   string
+	path   string
+	maxAge time.Duration
+}
+
+// staleFiles tracks which checks were stale at the previous collection, so an event is
+// only emitted when a file becomes stale.
+var staleFiles struct {
+	sync.Mutex
+	names map[string]bool
+}
+
+// getFreshnessChecks parses the FILE_FRESHNESS_CHECKS environment variable. Entries are
+// comma-separated, in the form [name=]path:maxAge, where maxAge is a duration ("26h") or
+// a number of seconds. The path may be a glob pattern, in which case the newest match is
+// checked. Example: "backup=/var/backups/db-*.sql.gz:26h,cron=/var/run/cron.marker:3600".
+func getFreshnessChecks() []freshnessCheck {
+	var checks []freshnessCheck
+	for _, token := range strings.Split(os.Getenv("FILE_FRESHNESS_CHECKS"), ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		name, spec, ok := strings.Cut(token, "=")
+		if !ok {
+			spec = token
+		}
+		// Split on the last colon so Windows drive letters stay in the path.
+		i := strings.LastIndex(spec, ":")
+		if i <= 0 {
+			fmt.Printf("Invalid FILE_FRESHNESS_CHECKS entry %q: missing max age\n", token)
+			continue
+		}
+		path, ageSpec := spec[:i], spec[i+1:]
+		maxAge, err := time.ParseDuration(ageSpec)
+		if err != nil {
+			seconds, serr := strconv.Atoi(ageSpec)
+			if serr != nil {
+				fmt.Printf("Invalid FILE_FRESHNESS_CHECKS entry %q: %v\n", token, err)
+				continue
+			}
+			maxAge = time.Duration(seconds) * time.Second
+		}
+		if !ok {
+			name = path
+		}
+		checks = append(checks, freshnessCheck{name: name, path: path, maxAge: maxAge})
+	}
+	return checks
+}
+
+// newestMatch returns the modification time of the newest file matching pattern.
+func newestMatch(pattern string) (string, time.Time, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var newest string
+	var newestMod time.Time
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(newestMod) {
+			newest, newestMod = m, info.ModTime()
+		}
+	}
+	if newest == "" {
+		return "", time.Time{}, fmt.Errorf("no file matches %s", pattern)
+	}
+	return newest, newestMod, nil
+}
+
+// collectFreshness runs the file freshness checks, emitting a file.stale event when a file
+// becomes older than its threshold or disappears.
+func collectFreshness() []FreshnessResult {
+	checks := getFreshnessChecks()
+	if len(checks) == 0 || !featureEnabled("FILE_FRESHNESS_CHECKS", true) || checksSuppressed() {
+		return nil
+	}
+	results := make([]FreshnessResult, 0, len(checks))
+	for _, c := range checks {
+		r := FreshnessResult{Name: c.name, Path: c.path, MaxAgeSeconds: int64(c.maxAge.Seconds())}
+		path, mod, err := newestMatch(c.path)
+		if err != nil {
+			r.Error = err.Error()
+			r.Stale = true
+		} else {
+			r.Path = path
+			r.ModifiedAt = mod.UnixMilli()
+			age := time.Since(mod)
+			r.AgeSeconds = int64(age.Seconds())
+			r.Stale = age > c.maxAge
+		}
+		results = append(results, r)
+	}
+
+	staleFiles.Lock()
+	defer staleFiles.Unlock()
+	current := make(map[string]bool, len(results))
+	for _, r := range results {
+		if r.Stale && !staleFiles.names[r.Name] {
+			if r.Error != "" {
+				emitEvent("file.stale", "freshness check %s failed: %s", r.Name, r.Error)
+			} else {
+				emitEvent("file.stale", "%s was last modified %s ago (limit %s)", r.Path,
+					time.Duration(r.AgeSeconds)*time.Second, time.Duration(r.MaxAgeSeconds)*time.Second)
+			}
+		}
+		current[r.Name] = r.Stale
+	}
+	staleFiles.names = current
+	return results
+}
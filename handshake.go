@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Payload schema versions: 1 is the legacy flat format, 2 the structured one.
+const legacySchemaVersion = 1
+
+// Content codings the agent can use for metrics batches.
+const (
+	encodingIdentity = "identity"
+	encodingGzip     = "gzip"
+	encodingZstd     = "zstd"
+)
+
+// zstdEncoder compresses zstd batches; EncodeAll is safe for concurrent use, so every
+// destination shares it.
+var zstdEncoder struct {
+	sync.Once
+	encoder *zstd.Encoder
+	err     error
+}
+
+// AgentCapabilities is sent with the registration so the server can pick the payload
+// schema, compression and features the agent should use.
+type AgentCapabilities struct {
+	Version        string   `json:"version"`
+	OS             string   `json:"os"`
+	Arch           string   `json:"arch"`
+	SchemaVersions []int    `json:"schemaVersions"`
+	Compression    []string `json:"compression"`
+	// Features are the optional protocol features the agent implements.
+	Features []string `json:"features"`
+}
+
+// Handshake is the optional JSON body of a successful registration response: the server's
+// choice among the capabilities offered. Absent fields keep the agent's defaults, so
+// servers that return no body are unaffected.
+type Handshake struct {
+	SchemaVersion int    `json:"schemaVersion,omitempty"`
+	Compression   string `json:"compression,omitempty"`
+	// Features enables or disables features by flag name, like the remote configuration
+	// flags, which take precedence over them.
+	Features map[string]bool `json:"features,omitempty"`
+	// Endpoints overrides the paths of the server endpoints other than registration, by
+	// name: metrics, heartbeat, crash and config.
+	Endpoints map[string]string `json:"endpoints,omitempty"`
+}
+
+// negotiated holds the choices of the primary server's handshake.
+var negotiated struct {
+	sync.Mutex
+	schemaVersion int
+	features      map[string]bool
+}
+
+// agentVersion returns the agent's module version from the build information.
+func agentVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// userAgent identifies the agent in requests to the monitoring servers.
+func userAgent() string {
+	return fmt.Sprintf("cheetah-monitoring-agent/%s (%s; %s)", agentVersion(), runtime.GOOS, runtime.GOARCH)
+}
+
+// agentCapabilities returns the capabilities offered at registration.
+func agentCapabilities() *AgentCapabilities {
+	return &AgentCapabilities{
+		Version:        agentVersion(),
+		OS:             runtime.GOOS,
+		Arch:           runtime.GOARCH,
+		SchemaVersions: []int{legacySchemaVersion, structuredSchemaVersion},
+		Compression:    []string{encodingIdentity, encodingGzip, encodingZstd},
+		Features:       []string{"events", "heartbeat", "remote-config", "wasm", "seq", "maintenance", "endpoints"},
+	}
+}
+
+// readHandshake parses the handshake from a registration response body. An empty or
+// non-JSON body, as sent by servers predating the handshake, yields nil.
+func readHandshake(body io.Reader) *Handshake {
+	data, err := io.ReadAll(io.LimitReader(body, 64<<10))
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	var h Handshake
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil
+	}
+	return &h
+}
+
+// applyHandshake records the server's choices. The compression applies to batches sent to
+// d; the schema and features are taken from the primary server only, since a single
+// payload is built for every destination.
+func (d *destination) applyHandshake(h *Handshake) {
+	if h == nil {
+		return
+	}
+	switch h.Compression {
+	case "", encodingIdentity, encodingGzip, encodingZstd:
+		d.encoding.Store(h.Compression)
+	default:
+		fmt.Printf("Ignoring unsupported compression %q requested by %s server\n", h.Compression, d.name)
+	}
+	d.applyEndpoints(h.Endpoints)
+	if !d.primary {
+		return
+	}
+	negotiated.Lock()
+	defer negotiated.Unlock()
+	switch h.SchemaVersion {
+	case 0, legacySchemaVersion, structuredSchemaVersion:
+		negotiated.schemaVersion = h.SchemaVersion
+	default:
+		fmt.Printf("Ignoring unsupported schema version %d requested by server\n", h.SchemaVersion)
+	}
+	negotiated.features = h.Features
+	fmt.Printf("Negotiated with %s server: schema %d, compression %q, %d features\n", d.name, h.SchemaVersion, h.Compression, len(h.Features))
+}
+
+// negotiatedSchema returns the schema version chosen by the primary server, or 0.
+func negotiatedSchema() int {
+	negotiated.Lock()
+	defer negotiated.Unlock()
+	return negotiated.schemaVersion
+}
+
+// negotiatedFeature returns the value the primary server chose for a feature flag, if any.
+func negotiatedFeature(name string) (bool, bool) {
+	negotiated.Lock()
+	defer negotiated.Unlock()
+	v, ok := negotiated.features[name]
+	return v, ok
+}
+
+// encodeBody compresses a request body with the given content coding.
+func encodeBody(data []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case encodingGzip:
+	case encodingZstd:
+		zstdEncoder.Do(func() {
+			zstdEncoder.encoder, zstdEncoder.err = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		})
+		if zstdEncoder.err != nil {
+			return nil, zstdEncoder.err
+		}
+		return zstdEncoder.encoder.EncodeAll(data, make([]byte, 0, len(data)/4)), nil
+	default:
+		return data, nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// agentTransport sets the agent's User-Agent, unless the request carries one, and its
+// X-Tenant-ID on requests to the monitoring servers, so they can route each request to
+// the right tenant before parsing its body. It records the protocol of each response.
+type agentTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t agentTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	tenant := tenantID()
+	if r.Header.Get("User-Agent") == "" || tenant != "" {
+		r = r.Clone(r.Context())
+		if r.Header.Get("User-Agent") == "" {
+			r.Header.Set("User-Agent", userAgent())
+		}
+		if tenant != "" {
+			r.Header.Set("X-Tenant-ID", tenant)
+		}
+	}
+	resp, err := t.base.RoundTrip(r)
+	if err == nil {
+		recordProtocol(r.URL.Host, resp.Proto)
+	}
+	return resp, err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Heartbeat is the small liveness message sent to /api/agent/heartbeat.
+type Heartbeat struct {
+	AgentID   string `json:"agentId"`
+	TenantID  string `json:"tenantId,omitempty"`
+	Status    string `json:"status"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// heartbeatStatus returns the status reported in heartbeats to d: "registering" until the
+// agent has registered with it, then "maintenance" during a maintenance window, or "ok".
+func heartbeatStatus(d *destination) string {
+	if !d.registered.Load() {
+		return "registering"
+	}
+	if inMaintenance() {
+		return "maintenance"
+	}
+	return "ok"
+}
+
+// sendHeartbeat posts one heartbeat to d, giving up after timeout.
+func sendHeartbeat(d *destination, agentID string, timeout time.Duration) error {
+	data, err := json.Marshal(Heartbeat{AgentID: agentID, TenantID: tenantID(), Status: heartbeatStatus(d), Timestamp: time.Now().UnixMilli()})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.endpoint(endpointHeartbeat), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("heartbeat rejected with status: %s", resp.Status)
+	}
+	return nil
+}
+
+// startHeartbeats sends a heartbeat to every destination each HEARTBEAT_INTERVAL seconds,
+// independently of the metrics pipeline, so the server can detect a down host without
+// waiting for a full SEND_INTERVAL. Heartbeats are not spooled: a missed one is simply
+// replaced by the next. Failures are only logged when the outcome changes.
+func startHeartbeats() {
+	seconds := envInt("HEARTBEAT_INTERVAL", 0)
+	if seconds == 0 {
+		return
+	}
+	interval := time.Duration(seconds) * time.Second
+	for _, d := range destinations {
+		supervise("heartbeat "+d.name, func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			failing := false
+			for {
+				if !featureEnabled("HEARTBEAT", true) {
+					<-ticker.C
+					continue
+				}
+				agentID, err := loadAgentID()
+				if err == nil {
+					err = sendHeartbeat(d, agentID, interval)
+				}
+				if err != nil && !failing {
+					fmt.Printf("Error sending heartbeat to %s server: %v\n", d.name, err)
+				} else if err == nil && failing {
+					fmt.Printf("Heartbeats to %s server resumed\n", d.name)
+				}
+				failing = err != nil
+				<-ticker.C
+			}
+		})
+	}
+}
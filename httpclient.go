@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Defaults of the shared HTTP client used to talk to the monitoring servers.
+const (
+	defaultHTTPTimeout      = 30 * time.Second
+	defaultHTTPIdleConns    = 4
+	defaultHTTPIdleTimeout  = 90 * time.Second
+	defaultHTTPDialTimeout  = 10 * time.Second
+	defaultHTTPTLSHandshake = 10 * time.Second
+	defaultHTTP2PingSeconds = 30
+)
+
+// sharedClient is the HTTP client for registration, metrics, crash reports, remote config
+// and WASM downloads. Reusing it keeps connections to the servers alive between requests.
+var sharedClient struct {
+	sync.Once
+	client *http.Client
+}
+
+// newServerClient builds the server HTTP client from the HTTP_* environment variables.
+// Every request is bounded by HTTP_TIMEOUT, so a hung server cannot block a pipeline stage
+// forever. Server host names are resolved through a dnsCache, unless connections go through
+// an SSH tunnel. Requests share kept-alive connections, multiplexed over a single one per
+// server with HTTP/2 (see httpVersion).
+func newServerClient() *http.Client {
+	timeout := time.Duration(envInt("HTTP_TIMEOUT", int(defaultHTTPTimeout/time.Second))) * time.Second
+	if timeout <= 0 {
+		timeout = defaultHTTPTimeout
+	}
+	dialer := &net.Dialer{
+		Timeout:   defaultHTTPDialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy:                 serverProxy,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConnsPerHost:   envInt("HTTP_MAX_IDLE_CONNS", defaultHTTPIdleConns),
+		IdleConnTimeout:       defaultHTTPIdleTimeout,
+		TLSHandshakeTimeout:   defaultHTTPTLSHandshake,
+		ResponseHeaderTimeout: timeout,
+		DisableKeepAlives:     !envBool("HTTP_KEEPALIVE", true),
+		DisableCompression:    envBool("HTTP_DISABLE_COMPRESSION", false),
+	}
+	switch httpVersion() {
+	case "2":
+		// HTTP/2 only: negotiated with TLS servers, with prior knowledge (h2c) otherwise.
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	case "1.1":
+		transport.ForceAttemptHTTP2 = false
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP1(true)
+	}
+	// Ping idle HTTP/2 connections, so one silently dropped by a NAT or a flaky link is
+	// noticed and replaced before the next request is sent on it.
+	if ping := envInt("HTTP2_PING_INTERVAL", defaultHTTP2PingSeconds); ping > 0 {
+		transport.HTTP2 = &http.HTTP2Config{SendPingTimeout: time.Duration(ping) * time.Second}
+	}
+	var base http.RoundTripper = transport
+	// Through an SSH tunnel, the bastion resolves the server names and no proxy applies. A
+	// tunnel that cannot be set up fails every request rather than connecting directly, which
+	// the network may not allow or the operator may not want; subcommands such as replay do
+	// not run the startup validation.
+	tunnel, err := loadSSHTunnel(dialer)
+	if err == nil && tunnel != nil {
+		err = socksTunnelConflict()
+	}
+	if err != nil {
+		fmt.Printf("Error setting up SSH_TUNNEL, requests to the servers will fail: %v\n", err)
+		return &http.Client{Timeout: timeout, Transport: failingTransport{err: fmt.Errorf("SSH_TUNNEL unavailable: %v", err)}}
+	}
+	if tunnel != nil {
+		transport.Proxy = nil
+		transport.DialContext = tunnel.dialContext
+	}
+	// SERVER_DNS_TTL=0 leaves resolution to the system on every dial.
+	if ttl := envInt("SERVER_DNS_TTL", defaultServerDNSTTL); ttl > 0 && tunnel == nil {
+		cache := newDNSCache(dialer, transport, time.Duration(ttl)*time.Second,
+			envInt("SERVER_DNS_REFRESH_FAILURES", defaultServerDNSRefreshFailures))
+		transport.DialContext = cache.dialContext
+		base = dnsRefreshTransport{base: transport, cache: cache}
+	}
+	return &http.Client{Timeout: timeout, Transport: agentTransport{base: base}}
+}
+
+// failingTransport fails every request with err, in place of a transport that cannot be
+// built as configured.
+type failingTransport struct {
+	err error
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t failingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Body != nil {
+		r.Body.Close()
+	}
+	return nil, t.err
+}
+
+// httpVersion returns the HTTP version used with the servers from HTTP_VERSION: "auto"
+// for HTTP/2 with TLS servers that offer it and HTTP/1.1 otherwise, "2" for HTTP/2 with
+// every server, including plaintext ones, or "1.1".
+func httpVersion() string {
+	switch v := os.Getenv("HTTP_VERSION"); v {
+	case "", "auto":
+		return "auto"
+	case "2", "1.1":
+		return v
+	default:
+		fmt.Printf("Invalid HTTP_VERSION value, using auto: %s\n", v)
+		return "auto"
+	}
+}
+
+// serverProtocols holds the protocol of the last response from each server host, such as
+// "HTTP/2.0", reported in GET /status.
+var serverProtocols sync.Map
+
+// recordProtocol stores the protocol of a response from host, logging when it changes.
+func recordProtocol(host, proto string) {
+	if previous, loaded := serverProtocols.Swap(host, proto); !loaded || previous != proto {
+		fmt.Printf("Connected to %s over %s\n", host, proto)
+	}
+}
+
+// serverProtocol returns the protocol last used with the server at baseURL, or "".
+func serverProtocol(baseURL string) string {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return ""
+	}
+	proto, _ := serverProtocols.Load(u.Host)
+	s, _ := proto.(string)
+	return s
+}
+
+// httpClient returns the shared server HTTP client.
+func httpClient() *http.Client {
+	sharedClient.Do(func() {
+		sharedClient.client = newServerClient()
+	})
+	return sharedClient.client
+}
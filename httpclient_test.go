@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServerClientRefusesDirectWithBrokenTunnel(t *testing.T) {
+	reached := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true }))
+	defer server.Close()
+	t.Setenv("SSH_TUNNEL", "cheetah@bastion.example.com")
+	t.Setenv("SSH_TUNNEL_KEY_FILE", "")
+
+	_, err := newServerClient().Get(server.URL)
+	if err == nil || !strings.Contains(err.Error(), "SSH_TUNNEL") {
+		t.Errorf("got %v, want the tunnel error", err)
+	}
+	if reached {
+		t.Error("request sent directly to the server")
+	}
+}
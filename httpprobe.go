@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// HTTPTimings breaks the duration of an HTTP(S) probe down by phase, so latency
+// regressions can be attributed to DNS, the network, TLS or the server.
+type HTTPTimings struct {
+	DNSMs      float64 `json:"dnsMs"`
+	ConnectMs  float64 `json:"connectMs"`
+	TLSMs      float64 `json:"tlsMs,omitempty"`
+	TTFBMs     float64 `json:"ttfbMs"`
+	StatusCode int     `json:"statusCode,omitempty"`
+	TLSVersion string  `json:"tlsVersion,omitempty"`
+}
+
+// msBetween returns the milliseconds elapsed between start and end, or 0 if either is unset.
+func msBetween(start, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return float64(end.Sub(start).Microseconds()) / 1000
+}
+
+// probeHTTP sends a GET request to url over a fresh connection and measures each phase.
+// TTFB is measured from the moment the request is written to the first response byte.
+// The returned duration covers the whole exchange up to the first response byte.
+func probeHTTP(url string) (time.Duration, *HTTPTimings, error) {
+	// The callbacks can run concurrently: with dual-stack hosts the dialer races one
+	// connection per address family. The first connection attempt and the one that
+	// succeeded are kept.
+	var mu sync.Mutex
+	var dnsStart, dnsDone, connStart, connDone, tlsStart, tlsDone, wrote, firstByte time.Time
+	record := func(t *time.Time) {
+		mu.Lock()
+		*t = time.Now()
+		mu.Unlock()
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { record(&dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { record(&dnsDone) },
+		ConnectStart: func(string, string) {
+			mu.Lock()
+			if connStart.IsZero() {
+				connStart = time.Now()
+			}
+			mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			mu.Lock()
+			if err == nil && connDone.IsZero() {
+				connDone = time.Now()
+			}
+			mu.Unlock()
+		},
+		TLSHandshakeStart:    func() { record(&tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { record(&tlsDone) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { record(&wrote) },
+		GotFirstResponseByte: func() { record(&firstByte) },
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment, DisableKeepAlives: true}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Timeout:   probeTimeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	mu.Lock()
+	defer mu.Unlock()
+	timings := &HTTPTimings{
+		DNSMs:      msBetween(dnsStart, dnsDone),
+		ConnectMs:  msBetween(connStart, connDone),
+		TLSMs:      msBetween(tlsStart, tlsDone),
+		TTFBMs:     msBetween(wrote, firstByte),
+		StatusCode: resp.StatusCode,
+	}
+	if resp.TLS != nil {
+		timings.TLSVersion = tls.VersionName(resp.TLS.Version)
+	}
+	return firstByte.Sub(start), timings, nil
+}
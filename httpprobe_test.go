@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProbeHTTP(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	// localhost may resolve to both ::1 and 127.0.0.1, making the dialer race both families.
+	url := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+	for i := 0; i < 3; i++ {
+		d, timings, err := probeHTTP(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if timings.StatusCode != http.StatusNoContent {
+			t.Errorf("status %d, want 204", timings.StatusCode)
+		}
+		if timings.TTFBMs < 20 || d < 20*time.Millisecond {
+			t.Errorf("TTFB %.1fms and duration %s, want at least the server's 20ms", timings.TTFBMs, d)
+		}
+		if timings.TLSMs != 0 || timings.TLSVersion != "" {
+			t.Errorf("TLS %.3fms (%s) over plain HTTP", timings.TLSMs, timings.TLSVersion)
+		}
+	}
+}
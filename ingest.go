@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Limits on what local applications may submit through POST /ingest.
+const (
+	maxIngestBody          = 64 << 10
+	maxIngestEvents        = 100
+	maxCustomMetrics       = 1000
+	customEventTypePrefix  = "custom."
+	maxIngestMessageLength = 4096
+)
+
+// ingestNamePattern restricts custom event types and metric names.
+var ingestNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,128}$`)
+
+// IngestEvent is a custom event submitted by a local application.
+type IngestEvent struct {
+	Type       string            `json:"type"`
+	Message    string            `json:"message"`
+	Timestamp  int64             `json:"timestamp,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// IngestRequest is the body of POST /ingest.
+type IngestRequest struct {
+	Events  []IngestEvent      `json:"events,omitempty"`
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+}
+
+// customMetrics holds the latest value of each metric submitted since the last send.
+var customMetrics struct {
+	sync.Mutex
+	values map[string]float64
+}
+
+// takeCustomMetrics returns the custom metrics submitted since the last send and clears them.
+func takeCustomMetrics() map[string]float64 {
+	customMetrics.Lock()
+	defer customMetrics.Unlock()
+	values := customMetrics.values
+	customMetrics.values = nil
+	return values
+}
+
+// validateIngest checks a submission against the naming rules and limits.
+func validateIngest(req IngestRequest) error {
+	if len(req.Events) > maxIngestEvents {
+		return fmt.Errorf("too many events: %d (max %d)", len(req.Events), maxIngestEvents)
+	}
+	for _, e := range req.Events {
+		if !ingestNamePattern.MatchString(e.Type) {
+			return fmt.Errorf("invalid event type: %q", e.Type)
+		}
+		if len(e.Message) > maxIngestMessageLength {
+			return fmt.Errorf("event message too long: %d bytes (max %d)", len(e.Message), maxIngestMessageLength)
+		}
+		for _, key := range reservedEventAttributes {
+			if _, ok := e.Attributes[key]; ok {
+				return fmt.Errorf("event attribute %q is reserved", key)
+			}
+		}
+	}
+	for name := range req.Metrics {
+		if !ingestNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid metric name: %q", name)
+		}
+	}
+	return nil
+}
+
+// handleIngest accepts custom events and metrics from local applications and cron jobs.
+// Events are queued with the "custom." type prefix, so they cannot be mistaken for events
+// detected by the agent; metrics are reported in the customMetrics field of the next payload.
+func handleIngest(w http.ResponseWriter, r *http.Request) {
+	if !featureEnabled("INGEST", true) {
+		http.Error(w, "ingestion disabled", http.StatusForbidden)
+		return
+	}
+	var req IngestRequest
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIngestBody))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error reading body: %v", err), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid JSON body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := validateIngest(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Metrics) > 0 {
+		customMetrics.Lock()
+		if customMetrics.values == nil {
+			customMetrics.values = make(map[string]float64)
+		}
+		added := 0
+		for name := range req.Metrics {
+			if _, ok := customMetrics.values[name]; !ok {
+				added++
+			}
+		}
+		if len(customMetrics.values)+added > maxCustomMetrics {
+			customMetrics.Unlock()
+			http.Error(w, fmt.Sprintf("too many custom metrics pending (max %d)", maxCustomMetrics), http.StatusTooManyRequests)
+			return
+		}
+		for name, v := range req.Metrics {
+			customMetrics.values[name] = v
+		}
+		customMetrics.Unlock()
+	}
+	for _, e := range req.Events {
+		timestamp := e.Timestamp
+		if timestamp == 0 {
+			timestamp = time.Now().UnixMilli()
+		}
+		queueEvent(Event{
+			Type:       customEventTypePrefix + e.Type,
+			Message:    e.Message,
+			Timestamp:  timestamp,
+			Attributes: e.Attributes,
+		})
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	psnet "github.com/shirou/gopsutil/v3/net"
+)
+
+// InterfaceStats holds the cumulative traffic counters of one network interface.
+type InterfaceStats struct {
+	Name        string `json:"name"`
+	BytesSent   uint64 `json:"bytesSent"`
+	BytesRecv   uint64 `json:"bytesRecv"`
+	PacketsSent uint64 `json:"packetsSent"`
+	PacketsRecv uint64 `json:"packetsRecv"`
+	ErrorsIn    uint64 `json:"errorsIn"`
+	ErrorsOut   uint64 `json:"errorsOut"`
+	DropsIn     uint64 `json:"dropsIn"`
+	DropsOut    uint64 `json:"dropsOut"`
+}
+
+// collectInterfaces returns per-interface traffic counters, skipping loopback interfaces.
+func collectInterfaces() ([]InterfaceStats, error) {
+	if !collectorEnabled("INTERFACE_STATS", true) {
+		return nil, nil
+	}
+	counters, err := psnet.IOCounters(true)
+	if notImplemented(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read interface counters: %v", err)
+	}
+	loopback := make(map[string]bool)
+	if ifaces, err := psnet.Interfaces(); err == nil {
+		for _, iface := range ifaces {
+			for _, flag := range iface.Flags {
+				if flag == "loopback" {
+					loopback[iface.Name] = true
+				}
+			}
+		}
+	}
+	var stats []InterfaceStats
+	for _, c := range counters {
+		if loopback[c.Name] {
+			continue
+		}
+		stats = append(stats, InterfaceStats{
+			Name:        c.Name,
+			BytesSent:   c.BytesSent,
+			BytesRecv:   c.BytesRecv,
+			PacketsSent: c.PacketsSent,
+			PacketsRecv: c.PacketsRecv,
+			ErrorsIn:    c.Errin,
+			ErrorsOut:   c.Errout,
+			DropsIn:     c.Dropin,
+			DropsOut:    c.Dropout,
+		})
+	}
+	return stats, nil
+}
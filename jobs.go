@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// jobsDirName is the directory in the state directory holding the result of the last run of
+// each job wrapped by the "run" subcommand, which the running agent reports as checks.
+const jobsDirName = "jobs"
+
+// Limits of the "run" subcommand.
+const (
+	// maxJobOutput is how much of the end of a job's output is kept for triage.
+	maxJobOutput = 4096
+	// jobWaitDelay bounds the wait for a killed job's children to release its output.
+	jobWaitDelay = 5 * time.Second
+	// jobExitNotStarted is the exit status of a wrapped command that could not be started.
+	jobExitNotStarted = 127
+)
+
+// jobNamePattern restricts job names to characters safe in file names on every platform.
+var jobNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,127}$`)
+
+// JobResult is the last run of a job wrapped by the "run" subcommand.
+type JobResult struct {
+	Name       string `json:"name"`
+	Command    string `json:"command"`
+	Running    bool   `json:"running,omitempty"`
+	StartedAt  int64  `json:"startedAt"`
+	FinishedAt int64  `json:"finishedAt,omitempty"`
+	DurationMs int64  `json:"durationMs,omitempty"`
+	ExitCode   int    `json:"exitCode"`
+	// Error is set when the command could not be started, timed out or was killed.
+	Error string `json:"error,omitempty"`
+	// Output is the end of the combined stdout and stderr of a failed run.
+	Output string `json:"output,omitempty"`
+	// MaxAgeSeconds is how often the job is expected to run; a job whose last run started
+	// longer ago is overdue.
+	MaxAgeSeconds int64 `json:"maxAgeSeconds,omitempty"`
+	AgeSeconds    int64 `json:"ageSeconds"`
+	Overdue       bool  `json:"overdue,omitempty"`
+}
+
+// failed reports whether the last completed run of the job failed.
+func (j *JobResult) failed() bool {
+	return !j.Running && (j.ExitCode != 0 || j.Error != "")
+}
+
+// jobPath returns the path of the result file of the named job.
+func jobPath(name string) string {
+	return filepath.Join(stateDir(), jobsDirName, name+".json")
+}
+
+// writeJobResult records the state of a job run for the agent.
+func writeJobResult(r *JobResult) error {
+	if err := os.MkdirAll(filepath.Join(stateDir(), jobsDirName), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(jobPath(r.Name), data)
+}
+
+// tailBuffer keeps the last maxJobOutput bytes written to it.
+type tailBuffer struct {
+	sync.Mutex
+	data []byte
+}
+
+// Write implements io.Writer.
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.Lock()
+	defer t.Unlock()
+	t.data = append(t.data, p...)
+	if len(t.data) > maxJobOutput {
+		t.data = t.data[len(t.data)-maxJobOutput:]
+	}
+	return len(p), nil
+}
+
+// String returns the kept output.
+func (t *tailBuffer) String() string {
+	t.Lock()
+	defer t.Unlock()
+	return string(t.data)
+}
+
+// runJob implements the "run" subcommand, a cron wrapper that runs a command, passing its
+// output through, and records its duration and exit status for the agent to report:
+//
+//	run -name nightly-backup -max-age 25h -- /usr/local/bin/backup.sh --full
+//
+// It returns the exit status of the command, so cron still sees failures.
+func runJob(args []string) (int, error) {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	name := fs.String("name", "", "name of the job, reported as the check name")
+	timeout := fs.Duration("timeout", 0, "kill the command after this long (0 for no limit)")
+	maxAge := fs.Duration("max-age", 0, "report the job overdue when it has not run for this long (0 to disable)")
+	if err := fs.Parse(args); err != nil {
+		return 1, err
+	}
+	command := fs.Args()
+	if !jobNamePattern.MatchString(*name) {
+		return 1, fmt.Errorf("invalid or missing -name %q: use up to 128 letters, digits and _.-", *name)
+	}
+	if len(command) == 0 {
+		return 1, fmt.Errorf("usage: run -name NAME [-timeout D] [-max-age D] -- COMMAND [ARGS...]")
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	if *timeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeout(ctx, *timeout)
+		defer stop()
+	}
+	// Record an interrupted run instead of leaving it marked as running.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		sig := <-signals
+		cancel(fmt.Errorf("interrupted by %s", sig))
+	}()
+
+	start := time.Now()
+	result := &JobResult{
+		Name:          *name,
+		Command:       strings.Join(command, " "),
+		Running:       true,
+		StartedAt:     start.UnixMilli(),
+		MaxAgeSeconds: int64(maxAge.Seconds()),
+	}
+	if err := writeJobResult(result); err != nil {
+		fmt.Printf("Error recording job %s: %v\n", *name, err)
+	}
+
+	var output tailBuffer
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = io.MultiWriter(os.Stdout, &output)
+	cmd.Stderr = io.MultiWriter(os.Stderr, &output)
+	cmd.WaitDelay = jobWaitDelay
+	err := cmd.Run()
+
+	result.Running = false
+	result.FinishedAt = time.Now().UnixMilli()
+	result.DurationMs = time.Since(start).Milliseconds()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result.ExitCode, result.Error = 1, fmt.Sprintf("timed out after %s", *timeout)
+	case ctx.Err() != nil:
+		result.ExitCode, result.Error = 1, context.Cause(ctx).Error()
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+		if result.ExitCode < 0 {
+			result.ExitCode, result.Error = 1, err.Error()
+		}
+	default:
+		result.ExitCode, result.Error = jobExitNotStarted, err.Error()
+	}
+	if result.failed() {
+		result.Output = output.String()
+	}
+	if err := writeJobResult(result); err != nil {
+		return result.ExitCode, fmt.Errorf("failed to record job %s: %v", *name, err)
+	}
+	return result.ExitCode, nil
+}
+
+// jobAlerts tracks, by job name, the last run reported as failed and which jobs are
+// overdue, so events are only emitted once per failed run and when a job becomes overdue.
+var jobAlerts struct {
+	sync.Mutex
+	failedRuns map[string]int64
+	overdue    map[string]bool
+}
+
+// collectJobs reports the last run of every job wrapped by the "run" subcommand, emitting
+// job.failed when a run fails and job.overdue when a job with -max-age has not run in time.
+func collectJobs() []JobResult {
+	if !collectorEnabled("JOB_CHECKS", true) {
+		return nil
+	}
+	paths, _ := filepath.Glob(filepath.Join(stateDir(), jobsDirName, "*.json"))
+	var results []JobResult
+	now := time.Now()
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var r JobResult
+		if err := json.Unmarshal(data, &r); err != nil || r.Name == "" {
+			fmt.Printf("Error reading job result %s: %v\n", path, err)
+			continue
+		}
+		age := now.Sub(time.UnixMilli(r.StartedAt))
+		r.AgeSeconds = int64(age.Seconds())
+		r.Overdue = r.MaxAgeSeconds > 0 && age > time.Duration(r.MaxAgeSeconds)*time.Second
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+
+	jobAlerts.Lock()
+	defer jobAlerts.Unlock()
+	if jobAlerts.failedRuns == nil {
+		jobAlerts.failedRuns = make(map[string]int64)
+	}
+	overdue := make(map[string]bool, len(results))
+	for i := range results {
+		r := &results[i]
+		if r.failed() && jobAlerts.failedRuns[r.Name] != r.StartedAt {
+			reason := fmt.Sprintf("exit status %d", r.ExitCode)
+			if r.Error != "" {
+				reason = r.Error
+			}
+			queueEvent(Event{
+				Type:       "job.failed",
+				Message:    fmt.Sprintf("job %s failed after %s: %s", r.Name, time.Duration(r.DurationMs)*time.Millisecond, reason),
+				Timestamp:  r.FinishedAt,
+				Attributes: map[string]string{"job": r.Name, "command": r.Command},
+			})
+			jobAlerts.failedRuns[r.Name] = r.StartedAt
+		}
+		if r.Overdue && !jobAlerts.overdue[r.Name] {
+			emitEvent("job.overdue", "job %s last started %s ago (expected every %s)", r.Name,
+				time.Duration(r.AgeSeconds)*time.Second, time.Duration(r.MaxAgeSeconds)*time.Second)
+		}
+		overdue[r.Name] = r.Overdue
+	}
+	jobAlerts.overdue = overdue
+	return results
+}
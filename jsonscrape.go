@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// Limits for JSON scrape targets that do not set their own.
+const (
+	defaultScrapeTimeout = 5 * time.Second
+	maxScrapeBody        = 4 << 20
+)
+
+// ScrapeTarget is a configured JSON endpoint whose numeric values are extracted as metrics.
+type ScrapeTarget struct {
+	Name    string            `json:"name"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Fields maps metric names to gjson path expressions (e.g. "db.pool.active",
+	// "queues.#.depth").
+	Fields         map[string]string `json:"fields"`
+	TimeoutSeconds int               `json:"timeoutSeconds,omitempty"`
+}
+
+// ScrapeResult holds the values extracted from one JSON endpoint. A path matching a list
+// of numbers is reported as one value per element, keyed by "<field>.<index>".
+type ScrapeResult struct {
+	Name       string             `json:"name"`
+	URL        string             `json:"url"`
+	StatusCode int                `json:"statusCode,omitempty"`
+	Values     map[string]float64 `json:"values,omitempty"`
+	Missing    []string           `json:"missing,omitempty"`
+	Error      string             `json:"error,omitempty"`
+}
+
+// scrapeTargets is the configuration loaded from JSON_SCRAPE_FILE.
+var scrapeTargets struct {
+	sync.Once
+	targets []ScrapeTarget
+}
+
+// loadScrapeTargets reads the scrape configuration once.
+func loadScrapeTargets() []ScrapeTarget {
+	scrapeTargets.Do(func() {
+		file := os.Getenv("JSON_SCRAPE_FILE")
+		if file == "" {
+			return
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Printf("Error reading JSON scrape targets: %v\n", err)
+			return
+		}
+		if err := json.Unmarshal(data, &scrapeTargets.targets); err != nil {
+			fmt.Printf("Invalid JSON scrape targets file: %v\n", err)
+		}
+	})
+	return scrapeTargets.targets
+}
+
+// gjsonNumber converts a gjson result into a number, accepting numeric strings and booleans.
+func gjsonNumber(r gjson.Result) (float64, bool) {
+	switch r.Type {
+	case gjson.Number:
+		return r.Num, true
+	case gjson.True:
+		return 1, true
+	case gjson.False:
+		return 0, true
+	case gjson.String:
+		v, err := strconv.ParseFloat(r.Str, 64)
+		return v, err == nil
+	}
+	return 0, false
+}
+
+// scrapeJSON fetches one endpoint and evaluates its field expressions.
+func scrapeJSON(t ScrapeTarget) ScrapeResult {
+	result := ScrapeResult{Name: t.Name, URL: t.URL}
+	timeout := defaultScrapeTimeout
+	if t.TimeoutSeconds > 0 {
+		timeout = time.Duration(t.TimeoutSeconds) * time.Second
+	}
+	req, err := http.NewRequest("GET", t.URL, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Header.Set("Accept", "application/json")
+	for k, v := range t.Headers {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		result.Error = fmt.Sprintf("unexpected status code: %d", resp.StatusCode)
+		return result
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxScrapeBody))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if !gjson.ValidBytes(body) {
+		result.Error = "response is not valid JSON"
+		return result
+	}
+
+	result.Values = make(map[string]float64)
+	for field, path := range t.Fields {
+		r := gjson.GetBytes(body, path)
+		if r.IsArray() {
+			found := false
+			for i, e := range r.Array() {
+				if v, ok := gjsonNumber(e); ok {
+					result.Values[fmt.Sprintf("%s.%d", field, i)] = v
+					found = true
+				}
+			}
+			if !found {
+				result.Missing = append(result.Missing, field)
+			}
+			continue
+		}
+		if v, ok := gjsonNumber(r); ok {
+			result.Values[field] = v
+		} else {
+			result.Missing = append(result.Missing, field)
+		}
+	}
+	sort.Strings(result.Missing)
+	return result
+}
+
+// collectJSONScrapes fetches the configured JSON endpoints concurrently.
+func collectJSONScrapes() []ScrapeResult {
+	targets := loadScrapeTargets()
+	if len(targets) == 0 || !collectorEnabled("JSON_SCRAPE", true) {
+		return nil
+	}
+	results := make([]ScrapeResult, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = scrapeJSON(t)
+		}()
+	}
+	wg.Wait()
+	return results
+}
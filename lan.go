@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// LAN discovery defaults.
+const (
+	defaultDiscoveryPorts    = "22,80,443,445,3389,9100"
+	defaultDiscoveryMaxHosts = 1024
+	discoveryProbeTimeout    = 500 * time.Millisecond
+)
+
+// DiscoveredDevice is a host found on the local network by the discovery sweep.
+type DiscoveredDevice struct {
+	IP        string `json:"ip"`
+	Hostname  string `json:"hostname,omitempty"`
+	MAC       string `json:"mac,omitempty"`
+	OpenPorts []int  `json:"openPorts,omitempty"`
+	// Ping reports whether the device answered an ICMP echo request.
+	Ping bool `json:"ping"`
+}
+
+// LANDiscovery is the result of the last discovery sweep.
+type LANDiscovery struct {
+	Subnets    []string           `json:"subnets"`
+	ScannedAt  int64              `json:"scannedAt"`
+	DurationMs int64              `json:"durationMs"`
+	Devices    []DiscoveredDevice `json:"devices"`
+}
+
+// latestDiscovery holds the result of the last sweep, which runs in the background.
+var latestDiscovery struct {
+	sync.Mutex
+	result *LANDiscovery
+}
+
+// discoverySubnets returns the IPv4 subnets to sweep: LAN_DISCOVERY_SUBNETS if set,
+// otherwise the subnets of the local non-loopback interfaces.
+func discoverySubnets() []*net.IPNet {
+	var subnets []*net.IPNet
+	if list := envList("LAN_DISCOVERY_SUBNETS", nil); len(list) > 0 {
+		for _, cidr := range list {
+			_, subnet, err := net.ParseCIDR(cidr)
+			if err != nil || subnet.IP.To4() == nil {
+				fmt.Printf("Invalid LAN_DISCOVERY_SUBNETS entry %q\n", cidr)
+				continue
+			}
+			subnets = append(subnets, subnet)
+		}
+		return subnets
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.To4() == nil || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		subnets = append(subnets, &net.IPNet{IP: ipnet.IP.Mask(ipnet.Mask), Mask: ipnet.Mask})
+	}
+	return subnets
+}
+
+// subnetHosts lists the host addresses of an IPv4 subnet, without the network and broadcast
+// addresses, up to limit addresses.
+func subnetHosts(subnet *net.IPNet, limit int) []net.IP {
+	ones, bits := subnet.Mask.Size()
+	size := uint64(1) << uint(bits-ones)
+	base := binary.BigEndian.Uint32(subnet.IP.To4())
+	first, last := uint64(1), size-2
+	if size <= 2 {
+		first, last = 0, size-1
+	}
+	var hosts []net.IP
+	for i := first; i <= last && len(hosts) < limit; i++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, base+uint32(i))
+		hosts = append(hosts, ip)
+	}
+	return hosts
+}
+
+// probeDevice pings ip and probes the discovery ports. A refused connection also proves
+// the host is up.
+func probeDevice(ip string, ports []int) (device DiscoveredDevice, alive bool) {
+	device = DiscoveredDevice{IP: ip}
+	if _, err := probeICMP(ip); err == nil {
+		device.Ping, alive = true, true
+	}
+	for _, port := range ports {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(port)), discoveryProbeTimeout)
+		if err == nil {
+			conn.Close()
+			device.OpenPorts = append(device.OpenPorts, port)
+			alive = true
+		} else if errors.Is(err, syscall.ECONNREFUSED) {
+			alive = true
+		}
+	}
+	return device, alive
+}
+
+// runLANDiscovery sweeps the local subnets with ICMP echo requests and TCP probes of
+// LAN_DISCOVERY_PORTS, then resolves the MAC address and reverse DNS name of each device found.
+func runLANDiscovery() *LANDiscovery {
+	start := time.Now()
+	portSpec := os.Getenv("LAN_DISCOVERY_PORTS")
+	if portSpec == "" {
+		portSpec = defaultDiscoveryPorts
+	}
+	ports, err := parsePorts(portSpec)
+	if err != nil {
+		fmt.Printf("Invalid LAN_DISCOVERY_PORTS value, using default %s: %v\n", defaultDiscoveryPorts, err)
+		ports, _ = parsePorts(defaultDiscoveryPorts)
+	}
+	maxHosts := envInt("LAN_DISCOVERY_MAX_HOSTS", defaultDiscoveryMaxHosts)
+	localIP, _ := getLocalIP()
+
+	result := &LANDiscovery{ScannedAt: start.UnixMilli()}
+	var hosts []net.IP
+	for _, subnet := range discoverySubnets() {
+		result.Subnets = append(result.Subnets, subnet.String())
+		hosts = append(hosts, subnetHosts(subnet, maxHosts-len(hosts))...)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(envInt("LAN_DISCOVERY_CONCURRENCY", 32), 1))
+	for _, ip := range hosts {
+		if ip.String() == localIP {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if device, alive := probeDevice(ip.String(), ports); alive {
+				mu.Lock()
+				result.Devices = append(result.Devices, device)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The probes have populated the neighbor table with the MAC addresses of the devices.
+	macs := make(map[string]string)
+	if neighbors, err := readNeighbors(); err == nil {
+		for _, n := range neighbors {
+			macs[n.IP] = n.MAC
+		}
+	}
+	for i := range result.Devices {
+		d := &result.Devices[i]
+		d.MAC = macs[d.IP]
+		if names, err := net.LookupAddr(d.IP); err == nil && len(names) > 0 {
+			d.Hostname = strings.TrimSuffix(names[0], ".")
+		}
+	}
+	sort.Slice(result.Devices, func(i, j int) bool {
+		a, b := net.ParseIP(result.Devices[i].IP).To4(), net.ParseIP(result.Devices[j].IP).To4()
+		return binary.BigEndian.Uint32(a) < binary.BigEndian.Uint32(b)
+	})
+	result.DurationMs = time.Since(start).Milliseconds()
+	fmt.Printf("LAN discovery of %d addresses found %d devices in %s\n", len(hosts), len(result.Devices), time.Since(start).Round(time.Millisecond))
+	return result
+}
+
+// startLANDiscovery sweeps the local network every LAN_DISCOVERY_INTERVAL seconds in the
+// background, since a sweep can take minutes on larger subnets.
+func startLANDiscovery() {
+	interval := time.Duration(envInt("LAN_DISCOVERY_INTERVAL", 3600)) * time.Second
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	supervise("LAN discovery", func() {
+		for {
+			if collectorEnabled("LAN_DISCOVERY", false) {
+				result := runLANDiscovery()
+				latestDiscovery.Lock()
+				latestDiscovery.result = result
+				latestDiscovery.Unlock()
+			}
+			time.Sleep(interval)
+		}
+	})
+}
+
+// collectLANDiscovery returns the result of the last discovery sweep.
+func collectLANDiscovery() *LANDiscovery {
+	if !collectorEnabled("LAN_DISCOVERY", false) {
+		return nil
+	}
+	latestDiscovery.Lock()
+	defer latestDiscovery.Unlock()
+	return latestDiscovery.result
+}
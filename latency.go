@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// probeTimeout bounds how long a single latency probe may take.
+const probeTimeout = 2 * time.Second
+
+// icmpSeq numbers the echo requests of the agent, so concurrent probes each recognize their
+// own reply.
+var icmpSeq atomic.Uint32
+
+// LatencyResult holds the outcome of a single latency probe.
+type LatencyResult struct {
+	Name     string  `json:"name"`
+	Target   string  `json:"target"`
+	Protocol string  `json:"protocol"`
+	RTTMs    float64 `json:"rttMs"`
+	// HTTP holds the per-phase timings of http and https probes.
+	HTTP  *HTTPTimings `json:"http,omitempty"`
+	Error string       `json:"error,omitempty"`
+}
+
+// latencyTarget is a parsed entry of LATENCY_TARGETS.
+type latencyTarget struct {
+	name     string
+	protocol string
+	address  string
+}
+
+// getLatencyTargets parses the LATENCY_TARGETS environment variable.
+// Entries are comma-separated, in the form [name=]host:port for TCP probes
+// [name=]icmp:host for ICMP echo probes, or [name=]http(s)://host/path for HTTP probes.
+// Example: "db=10.0.0.5:5432,gw=icmp:192.168.1.1,api=https://api.example.com/health".
+func getLatencyTargets() []latencyTarget {
+	var targets []latencyTarget
+	for _, token := range strings.Split(os.Getenv("LATENCY_TARGETS"), ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		name, target, ok := strings.Cut(token, "=")
+		if !ok || strings.Contains(name, "/") {
+			name, target = token, token
+		}
+		if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+			protocol, _, _ := strings.Cut(target, ":")
+			targets = append(targets, latencyTarget{name: name, protocol: protocol, address: target})
+			continue
+		}
+		if host, ok := strings.CutPrefix(target, "icmp:"); ok {
+			targets = append(targets, latencyTarget{name: name, protocol: "icmp", address: host})
+			continue
+		}
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			fmt.Printf("Invalid LATENCY_TARGETS entry %q: %v\n", token, err)
+			continue
+		}
+		targets = append(targets, latencyTarget{name: name, protocol: "tcp", address: target})
+	}
+	return targets
+}
+
+// probeTCP measures the time needed to establish a TCP connection to address.
+func probeTCP(address string) (time.Duration, error) {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", address, probeTimeout)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	conn.Close()
+	return rtt, nil
+}
+
+// probeICMP sends a single ICMP echo request to host and measures the round trip.
+// It first tries an unprivileged datagram socket and falls back to a raw socket.
+func probeICMP(host string) (time.Duration, error) {
+	ipAddr, err := net.ResolveIPAddr("ip4", host)
+	if err != nil {
+		return 0, err
+	}
+
+	var dst net.Addr = &net.UDPAddr{IP: ipAddr.IP}
+	raw := false
+	conn, err := icmp.ListenPacket("udp4", "0.0.0.0")
+	if err != nil {
+		dst, raw = ipAddr, true
+		conn, err = icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+		if err != nil {
+			return 0, fmt.Errorf("failed to open ICMP socket: %v", err)
+		}
+	}
+	defer conn.Close()
+
+	id, seq := os.Getpid()&0xffff, int(icmpSeq.Add(1)&0xffff)
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("cheetah")},
+	}
+	data, err := msg.Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	conn.SetDeadline(time.Now().Add(probeTimeout))
+	start := time.Now()
+	if _, err := conn.WriteTo(data, dst); err != nil {
+		return 0, err
+	}
+	reply := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(reply)
+		if err != nil {
+			return 0, err
+		}
+		if isEchoReply(reply[:n], peer, ipAddr.IP, id, seq, raw) {
+			return time.Since(start), nil
+		}
+	}
+}
+
+// isEchoReply reports whether packet, received from peer, is the reply of dst to the echo
+// request id/seq. A raw socket receives the replies to every echo request of the host, so
+// the ID is checked there; on a datagram socket the kernel delivers only the socket's own
+// replies and replaces the ID with its port.
+func isEchoReply(packet []byte, peer net.Addr, dst net.IP, id, seq int, checkID bool) bool {
+	msg, err := icmp.ParseMessage(1, packet)
+	if err != nil || msg.Type != ipv4.ICMPTypeEchoReply {
+		return false
+	}
+	echo, ok := msg.Body.(*icmp.Echo)
+	if !ok || echo.Seq != seq || (checkID && echo.ID != id) {
+		return false
+	}
+	var from net.IP
+	switch addr := peer.(type) {
+	case *net.UDPAddr:
+		from = addr.IP
+	case *net.IPAddr:
+		from = addr.IP
+	}
+	return from.Equal(dst)
+}
+
+// collectLatency probes every configured target concurrently.
+func collectLatency() []LatencyResult {
+	targets := getLatencyTargets()
+	if len(targets) == 0 || !featureEnabled("LATENCY_CHECKS", true) || checksSuppressed() {
+		return nil
+	}
+	results := make([]LatencyResult, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t latencyTarget) {
+			defer wg.Done()
+			var rtt time.Duration
+			var timings *HTTPTimings
+			var err error
+			switch t.protocol {
+			case "icmp":
+				rtt, err = probeICMP(t.address)
+			case "http", "https":
+				rtt, timings, err = probeHTTP(t.address)
+			default:
+				rtt, err = probeTCP(t.address)
+			}
+			results[i] = LatencyResult{Name: t.name, Target: t.address, Protocol: t.protocol, HTTP: timings}
+			if err != nil {
+				results[i].Error = err.Error()
+			} else {
+				results[i].RTTMs = float64(rtt.Microseconds()) / 1000
+			}
+		}(i, t)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+func TestGetLatencyTargets(t *testing.T) {
+	tests := []struct {
+		env  string
+		want []latencyTarget
+	}{
+		{"", nil},
+		{"10.0.0.5:5432", []latencyTarget{{name: "10.0.0.5:5432", protocol: "tcp", address: "10.0.0.5:5432"}}},
+		{"db=10.0.0.5:5432, gw=icmp:192.168.1.1", []latencyTarget{
+			{name: "db", protocol: "tcp", address: "10.0.0.5:5432"},
+			{name: "gw", protocol: "icmp", address: "192.168.1.1"},
+		}},
+		{"api=https://api.example.com/health?x=1", []latencyTarget{{name: "api", protocol: "https", address: "https://api.example.com/health?x=1"}}},
+		// An unnamed URL whose query contains "=" is not split on it.
+		{"http://example.com/a?b=c", []latencyTarget{{name: "http://example.com/a?b=c", protocol: "http", address: "http://example.com/a?b=c"}}},
+		{"nohostport,icmp:gw", []latencyTarget{{name: "icmp:gw", protocol: "icmp", address: "gw"}}},
+	}
+	for _, tt := range tests {
+		t.Setenv("LATENCY_TARGETS", tt.env)
+		if got := getLatencyTargets(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("LATENCY_TARGETS=%q: got %+v, want %+v", tt.env, got, tt.want)
+		}
+	}
+}
+
+// echoPacket marshals an ICMP echo message.
+func echoPacket(t *testing.T, typ icmp.Type, id, seq int) []byte {
+	t.Helper()
+	msg := icmp.Message{Type: typ, Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("cheetah")}}
+	data, err := msg.Marshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestIsEchoReply(t *testing.T) {
+	dst := net.IPv4(192, 0, 2, 1)
+	other := net.IPv4(192, 0, 2, 2)
+	tests := []struct {
+		name    string
+		packet  []byte
+		peer    net.Addr
+		checkID bool
+		want    bool
+	}{
+		{"raw reply", echoPacket(t, ipv4.ICMPTypeEchoReply, 7, 42), &net.IPAddr{IP: dst}, true, true},
+		{"datagram reply with rewritten ID", echoPacket(t, ipv4.ICMPTypeEchoReply, 51234, 42), &net.UDPAddr{IP: dst}, false, true},
+		{"other probe's sequence", echoPacket(t, ipv4.ICMPTypeEchoReply, 7, 43), &net.IPAddr{IP: dst}, true, false},
+		{"other process's ID", echoPacket(t, ipv4.ICMPTypeEchoReply, 8, 42), &net.IPAddr{IP: dst}, true, false},
+		{"reply from another host", echoPacket(t, ipv4.ICMPTypeEchoReply, 7, 42), &net.IPAddr{IP: other}, true, false},
+		{"echo request", echoPacket(t, ipv4.ICMPTypeEcho, 7, 42), &net.IPAddr{IP: dst}, true, false},
+		{"garbage", []byte{0, 1}, &net.IPAddr{IP: dst}, true, false},
+	}
+	for _, tt := range tests {
+		if got := isEchoReply(tt.packet, tt.peer, dst, 7, 42, tt.checkID); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
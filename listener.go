@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// parsePortRange parses AGENT_LISTEN_PORT: a single port such as "9100" or an inclusive
+// range such as "9100-9199". An empty value means any free port.
+func parsePortRange(s string) (low, high int, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, 0, nil
+	}
+	first, last, isRange := strings.Cut(s, "-")
+	if low, err = strconv.Atoi(strings.TrimSpace(first)); err != nil || low < 1 || low > 65535 {
+		return 0, 0, fmt.Errorf("invalid AGENT_LISTEN_PORT %q: use a port or a range such as 9100-9199", s)
+	}
+	high = low
+	if isRange {
+		if high, err = strconv.Atoi(strings.TrimSpace(last)); err != nil || high < low || high > 65535 {
+			return 0, 0, fmt.Errorf("invalid AGENT_LISTEN_PORT %q: use a port or a range such as 9100-9199", s)
+		}
+	}
+	return low, high, nil
+}
+
+// openListener opens the agent API listener on the first free port of AGENT_LISTEN_PORT,
+// so firewalls can be provisioned for callbacks to the agent, or on a random free port
+// when it is not set.
+func openListener() (net.Listener, error) {
+	low, high, err := parsePortRange(os.Getenv("AGENT_LISTEN_PORT"))
+	if err != nil {
+		return nil, err
+	}
+	if low == 0 {
+		// ":0" assigns an available port.
+		return net.Listen("tcp", ":0")
+	}
+	var lastErr error
+	for port := low; port <= high; port++ {
+		ln, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+		if err == nil {
+			return ln, nil
+		}
+		lastErr = err
+	}
+	if low == high {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("no free port in AGENT_LISTEN_PORT %d-%d: %v", low, high, lastErr)
+}
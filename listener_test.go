@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		value     string
+		low, high int
+		wantErr   bool
+	}{
+		{value: ""},
+		{value: "  "},
+		{value: "9100", low: 9100, high: 9100},
+		{value: "9100-9199", low: 9100, high: 9199},
+		{value: " 9100 - 9101 ", low: 9100, high: 9101},
+		{value: "1-65535", low: 1, high: 65535},
+		{value: "9100-9100", low: 9100, high: 9100},
+		{value: "0", wantErr: true},
+		{value: "65536", wantErr: true},
+		{value: "9199-9100", wantErr: true},
+		{value: "9100-", wantErr: true},
+		{value: "-9100", wantErr: true},
+		{value: "9100-70000", wantErr: true},
+		{value: "http", wantErr: true},
+	}
+	for _, tt := range tests {
+		low, high, err := parsePortRange(tt.value)
+		if (err != nil) != tt.wantErr || low != tt.low || high != tt.high {
+			t.Errorf("parsePortRange(%q) = %d, %d, %v; want %d, %d, error %v", tt.value, low, high, err, tt.low, tt.high, tt.wantErr)
+		}
+	}
+}
+
+func TestOpenListenerSkipsBusyPorts(t *testing.T) {
+	busy, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Skip("cannot listen:", err)
+	}
+	defer busy.Close()
+	port := busy.Addr().(*net.TCPAddr).Port
+	if port == 65535 {
+		t.Skip("no port above the busy one")
+	}
+	t.Setenv("AGENT_LISTEN_PORT", strconv.Itoa(port)+"-"+strconv.Itoa(port+1))
+	ln, err := openListener()
+	if err != nil {
+		// The next port may be in use by another process.
+		t.Skip("no free port in the range:", err)
+	}
+	defer ln.Close()
+	if got := ln.Addr().(*net.TCPAddr).Port; got != port+1 {
+		t.Errorf("listening on %d, want %d", got, port+1)
+	}
+
+	t.Setenv("AGENT_LISTEN_PORT", strconv.Itoa(port))
+	if ln, err := openListener(); err == nil {
+		ln.Close()
+		t.Error("listened on a busy port")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// liteMemoryLimit is the soft Go heap limit applied in lite mode, keeping RSS under ~15 MB.
+const liteMemoryLimit = 10 << 20
+
+// liteMode reports whether the low-footprint embedded mode is enabled with LITE_MODE=true.
+func liteMode() bool {
+	return envBool("LITE_MODE", false)
+}
+
+// collectorEnabled reports whether the optional collector controlled by the environment
+// variable name is enabled. In lite mode every optional collector defaults to disabled,
+// but can still be turned on explicitly. A flag set by the server overrides the local
+// setting, and a collector enabled through the agent API runs whatever both say. While the
+// agent is over its own resource limits all optional collectors are skipped.
+func collectorEnabled(name string, def bool) bool {
+	if selfThrottled() {
+		return false
+	}
+	if liteMode() {
+		def = false
+	}
+	return applyCollectorOverride(name, featureEnabled(name, envBool(name, def)))
+}
+
+// applyLiteMode tunes the runtime for small ARM/embedded devices: a soft memory limit,
+// a more aggressive GC and a single OS thread for Go code.
+func applyLiteMode() {
+	if !liteMode() {
+		return
+	}
+	debug.SetMemoryLimit(liteMemoryLimit)
+	debug.SetGCPercent(50)
+	runtime.GOMAXPROCS(1)
+	fmt.Println("Lite mode enabled: optional collectors and port scanning disabled by default")
+}
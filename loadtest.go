@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// loadStats accumulates request outcomes of the load test between two reports.
+type loadStats struct {
+	sync.Mutex
+	sent, failed int
+	latencies    []time.Duration
+}
+
+// record adds the outcome of one request.
+func (s *loadStats) record(d time.Duration, err error) {
+	s.Lock()
+	defer s.Unlock()
+	if err != nil {
+		s.failed++
+		return
+	}
+	s.sent++
+	s.latencies = append(s.latencies, d)
+}
+
+// report prints and resets the statistics collected over the given period.
+func (s *loadStats) report(period time.Duration) (sent, failed int) {
+	s.Lock()
+	sent, failed, latencies := s.sent, s.failed, s.latencies
+	s.sent, s.failed, s.latencies = 0, 0, nil
+	s.Unlock()
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) time.Duration {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return latencies[int(p*float64(len(latencies)-1))]
+	}
+	fmt.Printf("%d sent (%.1f/s), %d failed, latency p50 %s p99 %s max %s\n",
+		sent, float64(sent)/period.Seconds(), failed,
+		percentile(0.5).Round(time.Microsecond), percentile(0.99).Round(time.Microsecond), percentile(1).Round(time.Microsecond))
+	return sent, failed
+}
+
+// syntheticAgent is a simulated agent whose metrics follow a random walk.
+type syntheticAgent struct {
+	info                AgentInfo
+	rng                 *rand.Rand
+	seq                 uint64
+	cpu, ram, disk      float64
+	ramTotal, diskTotal uint64
+	// bytesSent and bytesRecv are the cumulative counters of each simulated interface.
+	bytesSent, bytesRecv []uint64
+	processes            int
+}
+
+// walk moves v by a random step, keeping it within [0, 100].
+func (a *syntheticAgent) walk(v float64) float64 {
+	v += a.rng.NormFloat64() * 5
+	return min(max(v, 0), 100)
+}
+
+// next returns the agent's next metrics sample.
+func (a *syntheticAgent) next() Metrics {
+	a.seq++
+	a.cpu, a.ram, a.disk = a.walk(a.cpu), a.walk(a.ram), min(max(a.disk+a.rng.Float64()*0.01, 0), 100)
+	m := Metrics{
+		AgentID:        a.info.AgentID,
+		Seq:            a.seq,
+		Hostname:       a.info.Hostname,
+		IP:             a.info.IP,
+		Timestamp:      time.Now().UnixMilli(),
+		CPUUsage:       a.cpu,
+		CPUCores:       8,
+		DiskUsage:      a.disk,
+		DiskTotalBytes: a.diskTotal,
+		DiskUsedBytes:  uint64(a.disk / 100 * float64(a.diskTotal)),
+		RAMUsage:       a.ram,
+		RAMTotalBytes:  a.ramTotal,
+		RAMUsedBytes:   uint64(a.ram / 100 * float64(a.ramTotal)),
+	}
+	for i := range a.bytesSent {
+		a.bytesSent[i] += uint64(a.rng.Intn(1 << 24))
+		a.bytesRecv[i] += uint64(a.rng.Intn(1 << 24))
+		m.Interfaces = append(m.Interfaces, InterfaceStats{
+			Name:      fmt.Sprintf("eth%d", i),
+			BytesSent: a.bytesSent[i],
+			BytesRecv: a.bytesRecv[i],
+		})
+	}
+	for i := 0; i < a.processes; i++ {
+		m.TopProcesses = append(m.TopProcesses, ProcessInfo{
+			PID:        int32(1000 + i),
+			Name:       fmt.Sprintf("proc-%d", i),
+			CPUPercent: a.rng.Float64() * 10,
+		})
+	}
+	return m
+}
+
+// postJSON sends v to url and returns the request duration. Only a 2xx response counts as
+// success.
+func postJSON(client *http.Client, url string, v interface{}) (time.Duration, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, fmt.Errorf("status %s", resp.Status)
+	}
+	return time.Since(start), nil
+}
+
+// defaultServerURL returns the configured primary server base URL, used as the default
+// target of the offline subcommands.
+func defaultServerURL() string {
+	base, err := configuredServerURL()
+	if err != nil {
+		fmt.Println("Error in configuration:", err)
+		return "http://localhost:8080"
+	}
+	return base
+}
+
+// runLoadTest implements the "loadtest" subcommand: it simulates many agents registering
+// with a server and sending metrics at a configurable rate, for capacity testing.
+func runLoadTest(args []string) error {
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	server := fs.String("server", defaultServerURL(), "base URL of the monitoring server")
+	agents := fs.Int("agents", 100, "number of simulated agents")
+	interval := fs.Duration("interval", 10*time.Second, "metrics interval of each simulated agent")
+	duration := fs.Duration("duration", time.Minute, "test duration")
+	interfaces := fs.Int("interfaces", 2, "network interfaces reported by each agent")
+	processes := fs.Int("processes", 10, "top processes reported by each agent")
+	legacy := fs.Bool("legacy", false, "send the legacy flat payload format")
+	register := fs.Bool("register", true, "register the simulated agents before sending metrics")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *agents < 1 || *interval <= 0 {
+		return fmt.Errorf("agents must be at least 1 and interval positive")
+	}
+
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, MaxIdleConnsPerHost: *agents},
+	}
+	fmt.Printf("Simulating %d agents against %s, one sample per agent every %s (%.1f samples/s) for %s\n",
+		*agents, *server, *interval, float64(*agents)/interval.Seconds(), *duration)
+
+	fleet := make([]*syntheticAgent, *agents)
+	for i := range fleet {
+		id, err := newUUID()
+		if err != nil {
+			return err
+		}
+		rng := rand.New(rand.NewSource(int64(i) + time.Now().UnixNano()))
+		fleet[i] = &syntheticAgent{
+			info: AgentInfo{
+				AgentID:   id,
+				Hostname:  fmt.Sprintf("loadtest-%05d", i),
+				IP:        fmt.Sprintf("10.%d.%d.%d", (i>>16)&0xff, (i>>8)&0xff, i&0xff),
+				OpenPorts: []int{22},
+				Timestamp: time.Now().UnixMilli(),
+				Tags:      []string{"loadtest"},
+				Nonce:     id,
+			},
+			rng:       rng,
+			cpu:       rng.Float64() * 100,
+			ram:       rng.Float64() * 100,
+			disk:      rng.Float64() * 100,
+			ramTotal:  16 << 30,
+			diskTotal: 500 << 30,
+			bytesSent: make([]uint64, *interfaces),
+			bytesRecv: make([]uint64, *interfaces),
+			processes: *processes,
+		}
+	}
+
+	if *register {
+		stats := &loadStats{}
+		start := time.Now()
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, 50)
+		for _, a := range fleet {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				stats.record(postJSON(client, *server+endpointPath(endpointRegister), a.info))
+			}()
+		}
+		wg.Wait()
+		fmt.Print("Registration: ")
+		stats.report(time.Since(start))
+	}
+
+	stats := &loadStats{}
+	deadline := time.Now().Add(*duration)
+	var wg sync.WaitGroup
+	for i, a := range fleet {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Spread the agents over the interval so they do not all send at once.
+			time.Sleep(time.Duration(i) * *interval / time.Duration(len(fleet)))
+			ticker := time.NewTicker(*interval)
+			defer ticker.Stop()
+			for time.Now().Before(deadline) {
+				var payload interface{} = a.next()
+				if !*legacy {
+					payload = structurePayload(payload.(Metrics))
+				}
+				stats.record(postJSON(client, *server+endpointPath(endpointMetrics), payload))
+				<-ticker.C
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	reportEvery := 10 * time.Second
+	totalSent, totalFailed := 0, 0
+	last := time.Now()
+	for {
+		select {
+		case <-done:
+			sent, failed := stats.report(time.Since(last))
+			totalSent, totalFailed = totalSent+sent, totalFailed+failed
+			fmt.Printf("Done: %d samples sent, %d failed\n", totalSent, totalFailed)
+			return nil
+		case <-time.After(reportEvery):
+			sent, failed := stats.report(time.Since(last))
+			totalSent, totalFailed = totalSent+sent, totalFailed+failed
+			last = time.Now()
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// logBufferLines is the number of recent log lines kept in memory for crash reports.
+	logBufferLines = 200
+	// maxLogLineBytes truncates the buffered copy of long lines; stdout gets them whole.
+	maxLogLineBytes = 4096
+)
+
+// logBuffer is a ring buffer of the most recent lines written to stdout.
+var logBuffer struct {
+	sync.Mutex
+	lines  []string
+	stdout *os.File
+	pipe   *os.File
+	done   chan struct{}
+}
+
+// captureLogs tees everything written to stdout into the in-memory log buffer,
+// so crash reports can include the last log lines.
+func captureLogs() {
+	r, w, err := os.Pipe()
+	if err != nil {
+		fmt.Println("Error capturing logs:", err)
+		return
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	logBuffer.stdout, logBuffer.pipe, logBuffer.done = stdout, w, make(chan struct{})
+	go func() {
+		defer close(logBuffer.done)
+		teeLogs(r, stdout)
+	}()
+}
+
+// teeLogs copies r to out and records each line in the log buffer until r is closed. Lines
+// of any length are copied, so a long line cannot stop the copy and leave writers to
+// stdout blocked on a full pipe.
+func teeLogs(r io.Reader, out io.Writer) {
+	reader := bufio.NewReader(r)
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		out.Write(chunk)
+		if n := min(len(chunk), maxLogLineBytes-len(line)); n > 0 {
+			line = append(line, chunk[:n]...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if len(line) > 0 {
+			recordLogLine(strings.TrimRight(string(line), "\r\n"))
+			line = line[:0]
+		}
+		if err != nil {
+			if err != io.EOF {
+				io.Copy(out, r)
+			}
+			return
+		}
+	}
+}
+
+// recordLogLine adds a line to the log buffer, dropping the oldest beyond logBufferLines.
+func recordLogLine(line string) {
+	logBuffer.Lock()
+	defer logBuffer.Unlock()
+	if len(logBuffer.lines) >= logBufferLines {
+		logBuffer.lines = logBuffer.lines[1:]
+	}
+	logBuffer.lines = append(logBuffer.lines, line)
+}
+
+// recentLogLines returns a copy of the buffered log lines, oldest first.
+func recentLogLines() []string {
+	logBuffer.Lock()
+	defer logBuffer.Unlock()
+	return append([]string(nil), logBuffer.lines...)
+}
+
+// flushLogs restores the original stdout and waits until every captured line has been written out.
+func flushLogs() {
+	if logBuffer.pipe == nil {
+		return
+	}
+	os.Stdout = logBuffer.stdout
+	logBuffer.pipe.Close()
+	<-logBuffer.done
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTeeLogsLongLines(t *testing.T) {
+	t.Cleanup(func() { logBuffer.lines = nil })
+	logBuffer.lines = nil
+	long := strings.Repeat("x", 200<<10)
+	input := "first\n" + long + "\nlast"
+	var out bytes.Buffer
+	teeLogs(strings.NewReader(input), &out)
+	if out.String() != input {
+		t.Errorf("stdout got %d bytes, want the %d bytes written", out.Len(), len(input))
+	}
+	lines := recentLogLines()
+	if len(lines) != 3 || lines[0] != "first" || lines[1] != long[:maxLogLineBytes] || lines[2] != "last" {
+		t.Errorf("buffered %d lines, want first, the truncated long line and last", len(lines))
+	}
+}
+
+func TestRecordLogLineKeepsRecentLines(t *testing.T) {
+	t.Cleanup(func() { logBuffer.lines = nil })
+	logBuffer.lines = nil
+	var input strings.Builder
+	for i := 0; i < logBufferLines+5; i++ {
+		input.WriteString("line\n")
+	}
+	input.WriteString("newest\n")
+	teeLogs(strings.NewReader(input.String()), &bytes.Buffer{})
+	lines := recentLogLines()
+	if len(lines) != logBufferLines || lines[len(lines)-1] != "newest" {
+		t.Errorf("buffered %d lines ending with %q, want %d ending with newest", len(lines), lines[len(lines)-1], logBufferLines)
+	}
+}
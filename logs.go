@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultLogLines = 100
+	maxLogLines     = 1000
+)
+
+// LogSnippet is the response of the /logs endpoint.
+type LogSnippet struct {
+	File  string   `json:"file"`
+	Lines []string `json:"lines"`
+}
+
+// allowedLogFiles returns the pre-approved log files from the LOG_FILES environment variable.
+func allowedLogFiles() []string {
+	return envList("LOG_FILES", nil)
+}
+
+// tailFile returns the last n lines of the file at path, reading backwards
+// from the end so large files are not loaded into memory.
+func tailFile(path string, n int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	const chunkSize = 4096
+	var buf []byte
+	offset := size
+	for offset > 0 && bytes.Count(buf, []byte("\n")) <= n {
+		readSize := int64(chunkSize)
+		if offset < readSize {
+			readSize = offset
+		}
+		offset -= readSize
+		chunk := make([]byte, readSize)
+		if _, err := f.ReadAt(chunk, offset); err != nil {
+			return nil, err
+		}
+		buf = append(chunk, buf...)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(buf), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	if len(lines) == 1 && lines[0] == "" {
+		return []string{}, nil
+	}
+	return lines, nil
+}
+
+// handleLogs returns the last lines of one of the pre-approved log files.
+// Query parameters: file (required, must be listed in LOG_FILES) and lines (default 100, max 1000).
+func handleLogs(w http.ResponseWriter, r *http.Request) {
+	if !featureEnabled("REMOTE_LOGS", true) {
+		http.Error(w, "log access disabled by server", http.StatusForbidden)
+		return
+	}
+	file := r.URL.Query().Get("file")
+	allowed := false
+	for _, f := range allowedLogFiles() {
+		if f == file {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		http.Error(w, fmt.Sprintf("log file not allowed: %q", file), http.StatusForbidden)
+		return
+	}
+
+	lines := defaultLogLines
+	if s := r.URL.Query().Get("lines"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			http.Error(w, fmt.Sprintf("invalid lines value: %q", s), http.StatusBadRequest)
+			return
+		}
+		lines = n
+	}
+	if lines > maxLogLines {
+		lines = maxLogLines
+	}
+
+	snippet, err := tailFile(file, lines)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read log file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, LogSnippet{File: file, Lines: snippet})
+}
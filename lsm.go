@@ -0,0 +1,46 @@
+package main
+
+import "sync"
+
+// SecurityModules reports the enforcement status of the Linux security modules.
+// Modes are "enforcing", "permissive" (SELinux) or "complain" (AppArmor), and "disabled".
+type SecurityModules struct {
+	SELinux  string `json:"selinux,omitempty"`
+	AppArmor string `json:"apparmor,omitempty"`
+	// AppArmorEnforced and AppArmorComplain count loaded profiles in each mode.
+	AppArmorEnforced int `json:"apparmorEnforced,omitempty"`
+	AppArmorComplain int `json:"apparmorComplain,omitempty"`
+}
+
+// lastSecurityModules remembers the previous enforcement modes, to detect weakening.
+var lastSecurityModules struct {
+	sync.Mutex
+	seen bool
+	prev SecurityModules
+}
+
+// collectSecurityModules reports SELinux/AppArmor status and emits a security.enforcement_changed
+// event when a module leaves enforcing mode.
+func collectSecurityModules() *SecurityModules {
+	if !collectorEnabled("LSM_STATUS", true) {
+		return nil
+	}
+	status := readSecurityModules()
+	if status == nil {
+		return nil
+	}
+	lastSecurityModules.Lock()
+	defer lastSecurityModules.Unlock()
+	if lastSecurityModules.seen {
+		prev := lastSecurityModules.prev
+		if prev.SELinux == "enforcing" && status.SELinux != "enforcing" {
+			emitEvent("security.enforcement_changed", "SELinux changed from enforcing to %s", status.SELinux)
+		}
+		if prev.AppArmor == "enforcing" && status.AppArmor != "enforcing" {
+			emitEvent("security.enforcement_changed", "AppArmor changed from enforcing to %s", status.AppArmor)
+		}
+	}
+	lastSecurityModules.seen = true
+	lastSecurityModules.prev = *status
+	return status
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// readSELinux returns the SELinux mode, or "" if SELinux is not present.
+func readSELinux() string {
+	if enforce, err := readUintFile("/sys/fs/selinux/enforce"); err == nil {
+		if enforce == 1 {
+			return "enforcing"
+		}
+		return "permissive"
+	}
+	// Installed but not mounted: SELinux is disabled at boot.
+	if _, err := os.Stat("/etc/selinux/config"); err == nil {
+		return "disabled"
+	}
+	return ""
+}
+
+// readAppArmor returns the AppArmor mode and profile counts, or "" if AppArmor is not present.
+func readAppArmor() (string, int, int) {
+	enabled, err := os.ReadFile("/sys/module/apparmor/parameters/enabled")
+	if err != nil {
+		return "", 0, 0
+	}
+	if strings.TrimSpace(string(enabled)) != "Y" {
+		return "disabled", 0, 0
+	}
+	enforced, complain := 0, 0
+	if profiles, err := os.ReadFile("/sys/kernel/security/apparmor/profiles"); err == nil {
+		for _, line := range strings.Split(string(profiles), "\n") {
+			switch {
+			case strings.HasSuffix(line, "(enforce)"):
+				enforced++
+			case strings.HasSuffix(line, "(complain)"):
+				complain++
+			}
+		}
+	}
+	switch {
+	case enforced > 0:
+		return "enforcing", enforced, complain
+	case complain > 0:
+		return "complain", enforced, complain
+	}
+	return "disabled", enforced, complain
+}
+
+// readSecurityModules reads the SELinux and AppArmor status from sysfs.
+func readSecurityModules() *SecurityModules {
+	status := &SecurityModules{SELinux: readSELinux()}
+	status.AppArmor, status.AppArmorEnforced, status.AppArmorComplain = readAppArmor()
+	if status.SELinux == "" && status.AppArmor == "" {
+		return nil
+	}
+	return status
+}
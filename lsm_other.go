@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// readSecurityModules is only available on Linux.
+func readSecurityModules() *SecurityModules {
+	return nil
+}
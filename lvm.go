@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// VolumeGroup reports the capacity of an LVM volume group.
+type VolumeGroup struct {
+	Name         string  `json:"name"`
+	SizeBytes    uint64  `json:"sizeBytes"`
+	FreeBytes    uint64  `json:"freeBytes"`
+	UsagePercent float64 `json:"usagePercent"`
+}
+
+// ThinPool reports the data and metadata utilization of an LVM thin pool.
+type ThinPool struct {
+	Name            string  `json:"name"`
+	VolumeGroup     string  `json:"volumeGroup"`
+	SizeBytes       uint64  `json:"sizeBytes"`
+	DataPercent     float64 `json:"dataPercent"`
+	MetadataPercent float64 `json:"metadataPercent"`
+}
+
+// LVMStats groups volume group and thin pool statistics.
+type LVMStats struct {
+	VolumeGroups []VolumeGroup `json:"volumeGroups,omitempty"`
+	ThinPools    []ThinPool    `json:"thinPools,omitempty"`
+}
+
+// thinPoolAlerted records which thin pools are currently above the warning threshold.
+var thinPoolAlerted struct {
+	sync.Mutex
+	pools map[string]bool
+}
+
+// lvmReport runs an LVM reporting command with machine-readable output and returns its rows.
+func lvmReport(command string, columns string) [][]string {
+	out, err := exec.Command(command, "--noheadings", "--units", "b", "--nosuffix", "--separator", ",", "-o", columns).Output()
+	if err != nil {
+		return nil
+	}
+	var rows [][]string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		rows = append(rows, fields)
+	}
+	return rows
+}
+
+// collectLVM reports volume groups and thin pools and emits an lvm.thinpool_high event when
+// a thin pool's data or metadata usage crosses LVM_THIN_WARN_PERCENT (default 80).
+// It is disabled with LVM_STATS=false.
+func collectLVM() *LVMStats {
+	if !collectorEnabled("LVM_STATS", true) {
+		return nil
+	}
+	if _, err := exec.LookPath("vgs"); err != nil {
+		return nil
+	}
+	threshold := 80.0
+	if s := os.Getenv("LVM_THIN_WARN_PERCENT"); s != "" {
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			threshold = v
+		}
+	}
+
+	stats := &LVMStats{}
+	for _, row := range lvmReport("vgs", "vg_name,vg_size,vg_free") {
+		if len(row) < 3 {
+			continue
+		}
+		vg := VolumeGroup{Name: row[0]}
+		vg.SizeBytes, _ = strconv.ParseUint(row[1], 10, 64)
+		vg.FreeBytes, _ = strconv.ParseUint(row[2], 10, 64)
+		if vg.SizeBytes > 0 {
+			vg.UsagePercent = float64(vg.SizeBytes-vg.FreeBytes) / float64(vg.SizeBytes) * 100
+		}
+		stats.VolumeGroups = append(stats.VolumeGroups, vg)
+	}
+	for _, row := range lvmReport("lvs", "lv_name,vg_name,lv_size,data_percent,metadata_percent,segtype") {
+		if len(row) < 6 || row[5] != "thin-pool" {
+			continue
+		}
+		tp := ThinPool{Name: row[0], VolumeGroup: row[1]}
+		tp.SizeBytes, _ = strconv.ParseUint(row[2], 10, 64)
+		tp.DataPercent, _ = strconv.ParseFloat(row[3], 64)
+		tp.MetadataPercent, _ = strconv.ParseFloat(row[4], 64)
+		stats.ThinPools = append(stats.ThinPools, tp)
+	}
+
+	thinPoolAlerted.Lock()
+	defer thinPoolAlerted.Unlock()
+	if thinPoolAlerted.pools == nil {
+		thinPoolAlerted.pools = make(map[string]bool)
+	}
+	for _, tp := range stats.ThinPools {
+		key := tp.VolumeGroup + "/" + tp.Name
+		high := tp.DataPercent >= threshold || tp.MetadataPercent >= threshold
+		if high && !thinPoolAlerted.pools[key] {
+			emitEvent("lvm.thinpool_high", "thin pool %s is %.1f%% data / %.1f%% metadata full", key, tp.DataPercent, tp.MetadataPercent)
+		}
+		thinPoolAlerted.pools[key] = high
+	}
+
+	if stats.VolumeGroups == nil && stats.ThinPools == nil {
+		return nil
+	}
+	return stats
+}
@@ -0,0 +1,18 @@
+package main
+
+// ClusterUsage reports the active residency of an Apple Silicon CPU cluster.
+type ClusterUsage struct {
+	Name               string  `json:"name"`
+	Kind               string  `json:"kind"`
+	ActivePercent      float64 `json:"activePercent"`
+	ActiveFrequencyMHz float64 `json:"activeFrequencyMhz,omitempty"`
+}
+
+// MacPowerStats reports macOS thermal and power state.
+type MacPowerStats struct {
+	ThermalPressure      string         `json:"thermalPressure,omitempty"`
+	ThermalWarningLevel  string         `json:"thermalWarningLevel,omitempty"`
+	CPUSpeedLimitPercent int            `json:"cpuSpeedLimitPercent,omitempty"`
+	Throttled            bool           `json:"throttled"`
+	Clusters             []ClusterUsage `json:"clusters,omitempty"`
+}
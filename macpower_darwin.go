@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// readPmsetTherm parses "pmset -g therm" for the CPU speed limit and thermal warning level.
+func readPmsetTherm(stats *MacPowerStats) {
+	out, err := exec.Command("pmset", "-g", "therm").Output()
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if value, ok := strings.CutPrefix(line, "CPU_Speed_Limit"); ok {
+			value = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(value), "="))
+			stats.CPUSpeedLimitPercent, _ = strconv.Atoi(value)
+		}
+		if strings.Contains(line, "thermal warning level") {
+			if strings.HasPrefix(line, "No ") {
+				stats.ThermalWarningLevel = "none"
+			} else if i := strings.LastIndex(line, " "); i > 0 {
+				stats.ThermalWarningLevel = strings.TrimSuffix(line[i+1:], ".")
+			}
+		}
+	}
+}
+
+// readPowermetrics parses a single powermetrics sample for the thermal pressure level and
+// the active residency of each CPU cluster (E-Cluster, P-Cluster, P0-Cluster, ...).
+// powermetrics requires root; when it is unavailable only the pmset data is reported.
+func readPowermetrics(stats *MacPowerStats) {
+	out, err := exec.Command("powermetrics", "--samplers", "cpu_power,thermal", "-n", "1", "-i", "500").Output()
+	if err != nil {
+		return
+	}
+	clusters := make(map[string]*ClusterUsage)
+	var order []string
+	cluster := func(name string) *ClusterUsage {
+		if c, ok := clusters[name]; ok {
+			return c
+		}
+		kind := "performance"
+		if strings.HasPrefix(name, "E") {
+			kind = "efficiency"
+		}
+		clusters[name] = &ClusterUsage{Name: name, Kind: kind}
+		order = append(order, name)
+		return clusters[name]
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if value, ok := strings.CutPrefix(line, "Current pressure level:"); ok {
+			stats.ThermalPressure = strings.TrimSpace(value)
+			continue
+		}
+		name, rest, ok := strings.Cut(line, "-Cluster HW active ")
+		if !ok {
+			continue
+		}
+		key, value, _ := strings.Cut(rest, ":")
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		n, err := strconv.ParseFloat(strings.TrimSuffix(fields[0], "%"), 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "residency":
+			cluster(name).ActivePercent = n
+		case "frequency":
+			cluster(name).ActiveFrequencyMHz = n
+		}
+	}
+	for _, name := range order {
+		c := clusters[name]
+		c.Name = name + "-Cluster"
+		stats.Clusters = append(stats.Clusters, *c)
+	}
+}
+
+// collectMacPower reports thermal pressure, CPU throttling and, on Apple Silicon, the
+// efficiency/performance cluster utilization. It is disabled with MAC_POWER_STATS=false.
+func collectMacPower() *MacPowerStats {
+	if !collectorEnabled("MAC_POWER_STATS", true) {
+		return nil
+	}
+	stats := &MacPowerStats{}
+	readPmsetTherm(stats)
+	readPowermetrics(stats)
+	stats.Throttled = (stats.CPUSpeedLimitPercent > 0 && stats.CPUSpeedLimitPercent < 100) ||
+		(stats.ThermalPressure != "" && stats.ThermalPressure != "Nominal")
+	return stats
+}
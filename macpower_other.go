@@ -0,0 +1,8 @@
+//go:build !darwin
+
+package main
+
+// collectMacPower is only available on macOS.
+func collectMacPower() *MacPowerStats {
+	return nil
+}
@@ -4,36 +4,116 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/shirou/gopsutil/cpu"
-	"github.com/shirou/gopsutil/disk"
-	"github.com/shirou/gopsutil/mem"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/mem"
 )
 
 // AgentInfo represents the registration data to be sent to the monitoring server.
 type AgentInfo struct {
-	Hostname  string   `json:"hostname"`
-	IP        string   `json:"ip"`
-	OpenPorts []int    `json:"openPorts"`
-	Timestamp int64    `json:"timestamp"`
-	AgentPort int      `json:"agentPort"`
+	AgentID   string `json:"agentId"`
+	TenantID  string `json:"tenantId,omitempty"`
+	Hostname  string `json:"hostname"`
+	IP        string `json:"ip"`
+	OpenPorts []int  `json:"openPorts"`
+	// PortServices identifies the services on the open ports when SCAN_BANNERS is set.
+	PortServices []PortService `json:"portServices,omitempty"`
+	Timestamp    int64         `json:"timestamp"`
+	AgentPort    int           `json:"agentPort"`
+	AgentTLS     bool          `json:"agentTls"`
+	// AdvertisedAddress and AdvertisedPort, when set, are where the server reaches the agent
+	// API instead of IP and AgentPort, for agents behind NAT or port forwarding.
+	AdvertisedAddress string           `json:"advertisedAddress,omitempty"`
+	AdvertisedPort    int              `json:"advertisedPort,omitempty"`
+	Tags              []string         `json:"tags,omitempty"`
+	Site              *SiteInfo        `json:"site,omitempty"`
+	Security          *SecurityModules `json:"securityModules,omitempty"`
+	Nonce             string           `json:"registrationNonce"`
+	// Capabilities are offered for the server's handshake in the registration response.
+	Capabilities *AgentCapabilities `json:"capabilities,omitempty"`
 }
 
 // Metrics represents the system metrics to be sent.
 type Metrics struct {
-	Hostname  string  `json:"hostname"`
-	IP        string  `json:"ip"`
-	Timestamp int64   `json:"timestamp"`
-	CPUUsage  float64 `json:"cpuUsage"`
-	DiskUsage float64 `json:"diskUsage"`
-	RAMUsage  float64 `json:"ramUsage"`
+	AgentID  string `json:"agentId"`
+	TenantID string `json:"tenantId,omitempty"`
+	// Maintenance is set while a maintenance window is active.
+	Maintenance bool `json:"maintenance,omitempty"`
+	// Blackout lists the blackout windows active at collection time.
+	Blackout []string `json:"blackout,omitempty"`
+	Seq      uint64   `json:"seq"`
+	Hostname string   `json:"hostname"`
+	IP       string   `json:"ip"`
+	// Timestamp is when the collection ended, in Unix milliseconds (UTC). It stays in
+	// milliseconds for compatibility; CollectedAt has nanosecond precision.
+	Timestamp        int64                   `json:"timestamp"`
+	CPUUsage         float64                 `json:"cpuUsage"`
+	CPUCores         int                     `json:"cpuCores"`
+	CPUPhysicalCores int                     `json:"cpuPhysicalCores,omitempty"`
+	DiskUsage        float64                 `json:"diskUsage"`
+	DiskUsedBytes    uint64                  `json:"diskUsedBytes"`
+	DiskTotalBytes   uint64                  `json:"diskTotalBytes"`
+	RAMUsage         float64                 `json:"ramUsage"`
+	RAMUsedBytes     uint64                  `json:"ramUsedBytes"`
+	RAMTotalBytes    uint64                  `json:"ramTotalBytes"`
+	Container        bool                    `json:"containerized,omitempty"`
+	Cgroup           *CgroupStats            `json:"cgroup,omitempty"`
+	Containers       *ContainerRuntimeStats  `json:"containers,omitempty"`
+	Disks            []DiskUsage             `json:"disks,omitempty"`
+	DiskBusy         []DeviceBusy            `json:"diskBusy,omitempty"`
+	Latency          []LatencyResult         `json:"latency,omitempty"`
+	Bandwidth        *BandwidthResult        `json:"bandwidth,omitempty"`
+	Freshness        []FreshnessResult       `json:"fileFreshness,omitempty"`
+	Jobs             []JobResult             `json:"jobs,omitempty"`
+	PortStatus       []PortStatus            `json:"portStatus,omitempty"`
+	Interfaces       []InterfaceStats        `json:"interfaces,omitempty"`
+	Neighbors        *NeighborStats          `json:"neighbors,omitempty"`
+	LANDiscovery     *LANDiscovery           `json:"lanDiscovery,omitempty"`
+	Assets           []DiscoveredAsset       `json:"discoveredAssets,omitempty"`
+	Route            *RouteInfo              `json:"route,omitempty"`
+	Firewall         *FirewallInfo           `json:"firewall,omitempty"`
+	ProcNet          []ProcessNetStats       `json:"processNetwork,omitempty"`
+	TopProcesses     []ProcessInfo           `json:"topProcesses,omitempty"`
+	ProcessCounts    *ProcessCounts          `json:"processCounts,omitempty"`
+	TCP              *TCPStats               `json:"tcp,omitempty"`
+	Conntrack        *ConntrackStats         `json:"conntrack,omitempty"`
+	MemTopo          *MemoryTopology         `json:"memoryTopology,omitempty"`
+	Pressure         *PressureStats          `json:"pressure,omitempty"`
+	FileHandles      *FileHandleStats        `json:"fileHandles,omitempty"`
+	Reboot           *RebootStatus           `json:"reboot,omitempty"`
+	Packages         *PackageUpdates         `json:"packageUpdates,omitempty"`
+	WindowsInventory *WindowsInventory       `json:"windowsInventory,omitempty"`
+	IIS              *IISStats               `json:"iis,omitempty"`
+	MSSQL            []MSSQLInstance         `json:"mssql,omitempty"`
+	Security         *SecurityModules        `json:"securityModules,omitempty"`
+	Pools            []StoragePool           `json:"storagePools,omitempty"`
+	RAID             []RAIDArray             `json:"raid,omitempty"`
+	LVM              *LVMStats               `json:"lvm,omitempty"`
+	MacPower         *MacPowerStats          `json:"macPower,omitempty"`
+	Self             *SelfStats              `json:"self,omitempty"`
+	Plugins          map[string]PluginResult `json:"plugins,omitempty"`
+	Wasm             map[string]PluginResult `json:"wasm,omitempty"`
+	Scripts          map[string]PluginResult `json:"scripts,omitempty"`
+	SQL              []SQLResult             `json:"sql,omitempty"`
+	JSONScrapes      []ScrapeResult          `json:"jsonScrapes,omitempty"`
+	// CollectorHealth reports the collectors that are degraded or disabled, by name.
+	CollectorHealth    map[string]CollectorHealth `json:"collectorHealth,omitempty"`
+	DisabledCollectors []string                   `json:"disabledCollectors,omitempty"`
+	Custom             map[string]float64         `json:"customMetrics,omitempty"`
+	Flags              map[string]bool            `json:"flags,omitempty"`
+	Events             []Event                    `json:"events,omitempty"`
+	// CollectedAt is when each collector's data was gathered, in Unix nanoseconds (UTC), by
+	// collector name.
+	CollectedAt map[string]int64 `json:"collectedAt,omitempty"`
 }
 
 // getHostname retrieves the system hostname.
@@ -96,7 +176,8 @@ func parsePorts(s string) ([]int, error) {
 
 // getOpenPorts returns the list of ports to be included in the AgentInfo.
 // If the PORTS environment variable is set, it returns exactly that list (without checking if they are open).
-// Otherwise, it scans all ports (1 to 65535) and returns only those that are open.
+// Otherwise, it scans the ports configured by SCAN_RANGE (all ports by default) and returns
+// only those that are open, except in lite mode.
 func getOpenPorts() []int {
 	portsEnv := os.Getenv("PORTS")
 	if portsEnv != "" {
@@ -108,75 +189,121 @@ func getOpenPorts() []int {
 			return p
 		}
 	}
-	// If PORTS is not set or parsing fails, scan all ports and return only the open ones.
-	var openPorts []int
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-
-	const startPort = 1
-	const endPort = 65535
-	timeout := 200 * time.Millisecond
-
-	// Limit concurrency to 100 workers.
-	sem := make(chan struct{}, 100)
-
-	for port := startPort; port <= endPort; port++ {
-		wg.Add(1)
-		sem <- struct{}{} // Acquire a slot.
-		go func(p int) {
-			defer wg.Done()
-			defer func() { <-sem }() // Release the slot.
-			address := fmt.Sprintf("127.0.0.1:%d", p)
-			conn, err := net.DialTimeout("tcp", address, timeout)
-			if err == nil {
-				mu.Lock()
-				openPorts = append(openPorts, p)
-				mu.Unlock()
-				conn.Close()
-			}
-		}(port)
+	// Lite mode never performs the full port scan.
+	if liteMode() {
+		return nil
 	}
+	// If PORTS is not set or parsing fails, scan the local ports and return only the open ones.
+	return scanLocalPorts()
+}
+
+// lastScan holds the result of the most recent port scan, used to compute rescan diffs.
+var lastScan struct {
+	sync.Mutex
+	ports []int
+}
 
-	wg.Wait()
-	return openPorts
+// recordOpenPorts stores ports as the latest scan result and returns the previous one.
+func recordOpenPorts(ports []int) []int {
+	lastScan.Lock()
+	defer lastScan.Unlock()
+	previous := lastScan.ports
+	lastScan.ports = ports
+	return previous
+}
+
+// diffPorts returns the ports present in current but not in previous (added)
+// and those present in previous but not in current (removed), both sorted.
+func diffPorts(previous, current []int) (added, removed []int) {
+	seen := make(map[int]bool, len(previous))
+	for _, p := range previous {
+		seen[p] = true
+	}
+	for _, p := range current {
+		if !seen[p] {
+			added = append(added, p)
+		}
+		delete(seen, p)
+	}
+	for p := range seen {
+		removed = append(removed, p)
+	}
+	sort.Ints(added)
+	sort.Ints(removed)
+	return added, removed
 }
 
 // registerAgent sends the agent registration information to the monitoring server.
-func registerAgent(agentInfo AgentInfo, serverURL string) error {
+// Registration is idempotent: the server upserts the record keyed by the agent ID, and the
+// nonce lets it recognise retries of the same registration. A 409 Conflict means the server
+// already knows this host under another identity, which the agent then adopts if
+// adoptIdentity is set. A successful response may carry the server's handshake.
+func registerAgent(agentInfo AgentInfo, serverURL string, adoptIdentity bool) (*Handshake, error) {
 	jsonData, err := json.Marshal(agentInfo)
 	if err != nil {
-		return fmt.Errorf("failed to marshal agent info: %v", err)
+		return nil, fmt.Errorf("failed to marshal agent info: %v", err)
 	}
 
-	resp, err := http.Post(serverURL, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := httpClient().Post(serverURL, "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
-		return fmt.Errorf("failed to send registration: %v", err)
+		return nil, fmt.Errorf("failed to send registration: %v", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("registration failed with status: %s", resp.Status)
+	if resp.StatusCode == http.StatusConflict {
+		if !adoptIdentity {
+			fmt.Println("Agent already registered")
+			return nil, nil
+		}
+		id, err := fetchExistingIdentity(resp)
+		if err != nil {
+			return nil, fmt.Errorf("registration conflict: %v", err)
+		}
+		if id != agentInfo.AgentID {
+			if err := storeAgentID(id); err != nil {
+				return nil, err
+			}
+			fmt.Printf("Adopted existing agent identity %s\n", id)
+		}
+		return nil, nil
+	}
+	if err := busyError(resp); err != nil {
+		return nil, fmt.Errorf("registration failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("registration failed with status: %s", resp.Status)
 	}
 
 	fmt.Printf("Agent registration successful: %s\n", resp.Status)
-	return nil
+	return readHandshake(resp.Body), nil
 }
 
-// sendMetrics sends the collected system metrics to the monitoring server.
-func sendMetrics(metrics Metrics, serverURL string) error {
-	jsonData, err := json.Marshal(metrics)
-	if err != nil {
-		return fmt.Errorf("failed to marshal metrics: %v", err)
+// fetchExistingIdentity returns the agent ID of the existing record reported by a 409
+// registration response. The record is fetched from the Location header if present,
+// otherwise it is read from the response body.
+func fetchExistingIdentity(resp *http.Response) (string, error) {
+	body := resp.Body
+	if loc, err := resp.Location(); err == nil {
+		existing, err := httpClient().Get(loc.String())
+		if err != nil {
+			return "", fmt.Errorf("failed to fetch existing identity: %v", err)
+		}
+		defer existing.Body.Close()
+		if existing.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("failed to fetch existing identity: %s", existing.Status)
+		}
+		body = existing.Body
 	}
-
-	resp, err := http.Post(serverURL, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to send metrics: %v", err)
+	var identity struct {
+		AgentID string `json:"agentId"`
 	}
-	defer resp.Body.Close()
-
-	fmt.Printf("Metrics sent: %s\n", resp.Status)
-	return nil
+	if err := json.NewDecoder(io.LimitReader(body, 1<<20)).Decode(&identity); err != nil {
+		return "", fmt.Errorf("invalid identity response: %v", err)
+	}
+	if identity.AgentID == "" {
+		return "", fmt.Errorf("identity response has no agentId")
+	}
+	return identity.AgentID, nil
 }
 
 // collectMetrics gathers system metrics using gopsutil.
@@ -189,13 +316,24 @@ func collectMetrics() (Metrics, error) {
 	if err != nil {
 		return Metrics{}, fmt.Errorf("failed to get local IP: %v", err)
 	}
+	agentID, err := loadAgentID()
+	if err != nil {
+		return Metrics{}, fmt.Errorf("failed to get agent ID: %v", err)
+	}
+
+	// Each collector's data is timestamped when it was gathered, so a slow collector does
+	// not skew the correlation of the others.
+	times := collectionTimes{}
 
 	// Get CPU usage (averaged over one second)
 	cpuPercents, err := cpu.Percent(time.Second, false)
 	if err != nil || len(cpuPercents) == 0 {
 		return Metrics{}, fmt.Errorf("failed to get CPU usage: %v", err)
 	}
+	times.mark("cpu")
 	cpuUsage := cpuPercents[0]
+	cpuCores, _ := cpu.Counts(true)
+	cpuPhysicalCores, _ := cpu.Counts(false)
 
 	// Get memory usage
 	vmStat, err := mem.VirtualMemory()
@@ -203,47 +341,226 @@ func collectMetrics() (Metrics, error) {
 		return Metrics{}, fmt.Errorf("failed to get memory usage: %v", err)
 	}
 	ramUsage := vmStat.UsedPercent
+	ramUsed, ramTotal := vmStat.Used, vmStat.Total
+	times.mark("ram")
 
-	// Get disk usage (for "/" mount point)
-	diskStat, err := disk.Usage("/")
+	// Get disk usage (for "/" mount point, or the system drive on Windows)
+	diskStat, err := usageWithTimeout(rootMountpoint(), diskTimeout())
 	if err != nil {
 		return Metrics{}, fmt.Errorf("failed to get disk usage: %v", err)
 	}
 	diskUsage := diskStat.UsedPercent
+	times.mark("disk")
+
+	// Inside a container, report usage relative to the cgroup limits instead of the host.
+	cgroup := timedCollect(times, "cgroup", collectCgroup)
+	if cgroup != nil {
+		if cgroup.MemoryLimitBytes > 0 {
+			ramUsage = float64(cgroup.MemoryUsageBytes) / float64(cgroup.MemoryLimitBytes) * 100
+			ramUsed, ramTotal = cgroup.MemoryUsageBytes, cgroup.MemoryLimitBytes
+		}
+		if cgroup.CPUUsagePercent > 0 {
+			cpuUsage = cgroup.CPUUsagePercent
+		}
+	}
 
 	return Metrics{
-		Hostname:  hostname,
-		IP:        ip,
-		Timestamp: time.Now().UnixMilli(),
-		CPUUsage:  cpuUsage,
-		DiskUsage: diskUsage,
-		RAMUsage:  ramUsage,
+		AgentID:            agentID,
+		TenantID:           tenantID(),
+		Maintenance:        trackMaintenance(),
+		Blackout:           trackBlackouts(),
+		Hostname:           hostname,
+		IP:                 ip,
+		Timestamp:          time.Now().UnixMilli(),
+		CPUUsage:           cpuUsage,
+		CPUCores:           cpuCores,
+		CPUPhysicalCores:   cpuPhysicalCores,
+		DiskUsage:          diskUsage,
+		DiskUsedBytes:      diskStat.Used,
+		DiskTotalBytes:     diskStat.Total,
+		RAMUsage:           ramUsage,
+		RAMUsedBytes:       ramUsed,
+		RAMTotalBytes:      ramTotal,
+		Container:          cgroup != nil,
+		Cgroup:             cgroup,
+		Containers:         checkedCollect(times, "containers", collectContainers),
+		Disks:              checkedCollect(times, "disks", collectDisks),
+		DiskBusy:           checkedCollect(times, "diskBusy", collectDeviceBusy),
+		Latency:            timedCollect(times, "latency", collectLatency),
+		Bandwidth:          takeBandwidthResult(),
+		Freshness:          timedCollect(times, "fileFreshness", collectFreshness),
+		Jobs:               timedCollect(times, "jobs", collectJobs),
+		PortStatus:         timedCollect(times, "portStatus", collectPortStatus),
+		Interfaces:         checkedCollect(times, "interfaces", collectInterfaces),
+		Neighbors:          checkedCollect(times, "neighbors", collectNeighbors),
+		LANDiscovery:       timedCollect(times, "lanDiscovery", collectLANDiscovery),
+		Assets:             timedCollect(times, "discoveredAssets", collectAssets),
+		Route:              checkedCollect(times, "route", collectRoutes),
+		Firewall:           checkedCollect(times, "firewall", collectFirewall),
+		ProcNet:            checkedCollect(times, "processNetwork", collectProcessNet),
+		TopProcesses:       checkedCollect(times, "topProcesses", collectTopProcesses),
+		ProcessCounts:      checkedCollect(times, "processCounts", collectProcessCounts),
+		TCP:                checkedCollect(times, "tcp", collectTCPStats),
+		Conntrack:          checkedCollect(times, "conntrack", collectConntrack),
+		MemTopo:            timedCollect(times, "memoryTopology", collectMemoryTopology),
+		Pressure:           timedCollect(times, "pressure", collectPressure),
+		FileHandles:        timedCollect(times, "fileHandles", collectFileHandles),
+		Reboot:             timedCollect(times, "reboot", collectReboot),
+		Packages:           timedCollect(times, "packageUpdates", collectPackageUpdates),
+		WindowsInventory:   checkedCollect(times, "windowsInventory", collectWindowsInventory),
+		IIS:                checkedCollect(times, "iis", collectIIS),
+		MSSQL:              checkedCollect(times, "mssql", collectMSSQL),
+		Security:           timedCollect(times, "securityModules", collectSecurityModules),
+		Pools:              timedCollect(times, "storagePools", collectStoragePools),
+		RAID:               checkedCollect(times, "raid", collectRAID),
+		LVM:                timedCollect(times, "lvm", collectLVM),
+		MacPower:           timedCollect(times, "macPower", collectMacPower),
+		Self:               timedCollect(times, "self", collectSelfStats),
+		Plugins:            timedCollect(times, "plugins", collectPlugins),
+		Wasm:               timedCollect(times, "wasm", collectWasm),
+		Scripts:            timedCollect(times, "scripts", collectScripts),
+		SQL:                timedCollect(times, "sql", collectSQL),
+		JSONScrapes:        timedCollect(times, "jsonScrapes", collectJSONScrapes),
+		CollectorHealth:    unhealthyCollectors(),
+		DisabledCollectors: disabledCollectors(),
+		Custom:             takeCustomMetrics(),
+		Flags:              currentFlags(),
+		Events:             takeEvents(),
+		CollectedAt:        times,
 	}, nil
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "encrypt" {
+		if err := runEncrypt(os.Args[2:]); err != nil {
+			fmt.Println("Error encrypting values:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := runReplay(os.Args[2:]); err != nil {
+			fmt.Println("Error replaying batches:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "mockserver" {
+		if err := runMockServer(os.Args[2:]); err != nil {
+			fmt.Println("Error running mock server:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "maintenance" {
+		if err := runMaintenance(os.Args[2:]); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		code, err := runJob(os.Args[2:])
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+		os.Exit(code)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "loadtest" {
+		if err := runLoadTest(os.Args[2:]); err != nil {
+			fmt.Println("Error running load test:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Decrypt any ENC[...] configuration values before reading the configuration.
+	if err := decryptEnv(); err != nil {
+		fmt.Println("Error decrypting configuration:", err)
+		return
+	}
+	if err := checkStateDir(); err != nil {
+		fmt.Println("Error in configuration:", err)
+		return
+	}
+	applyLiteMode()
+	applySelfLimits()
+
+	// Capture recent log lines and crash output so fatal errors leave a crash report behind.
+	captureLogs()
+	defer flushLogs()
+	installCrashHandling()
+	defer handleFatalPanic()
+	defer stopPlugins()
+
+	if err := loadTransformRules(); err != nil {
+		fmt.Println("Error loading transform rules:", err)
+		return
+	}
+	if err := validateTenantID(); err != nil {
+		fmt.Println("Error in configuration:", err)
+		return
+	}
+	if err := validateServerURLs(); err != nil {
+		fmt.Println("Error in configuration:", err)
+		return
+	}
+	if err := validateEndpointPaths(); err != nil {
+		fmt.Println("Error in configuration:", err)
+		return
+	}
+	if err := validateAdvertisedAddress(); err != nil {
+		fmt.Println("Error in configuration:", err)
+		return
+	}
+	if err := validateSite(); err != nil {
+		fmt.Println("Error in configuration:", err)
+		return
+	}
+	if err := validateSpoolEncryption(); err != nil {
+		fmt.Println("Error in configuration:", err)
+		return
+	}
+	if err := validateSSHTunnel(); err != nil {
+		fmt.Println("Error in configuration:", err)
+		return
+	}
+	if err := validateSOCKSProxies(); err != nil {
+		fmt.Println("Error in configuration:", err)
+		return
+	}
+	if err := loadBlackoutWindows(); err != nil {
+		fmt.Println("Error loading blackout windows:", err)
+		return
+	}
+
 	// === Part 1: Agent Registration ===
-	// Open a listener on a random port; ":0" assigns an available port.
-	ln, err := net.Listen("tcp", ":0")
+	// Open a listener on a random port, or on the port configured in AGENT_LISTEN_PORT.
+	ln, err := openListener()
 	if err != nil {
 		fmt.Println("Error starting listener:", err)
 		return
 	}
 	agentPort := ln.Addr().(*net.TCPAddr).Port
 
-	// Start a dummy TCP server to keep the port open.
-	go func() {
-		for {
-			conn, err := ln.Accept()
-			if err != nil {
-				fmt.Println("Error accepting connection:", err)
-				continue
-			}
-			// Optionally, handle the connection (e.g., log, read, respond)
-			// For now, simply close it immediately.
-			conn.Close()
-		}
-	}()
+	// Serve the agent API on the listener; this also keeps the port open for reachability checks.
+	// TLS is enabled when AGENT_TLS_CERT and AGENT_TLS_KEY are set.
+	credentials, err := loadAPICredentials()
+	if err != nil {
+		fmt.Println("Error loading agent API credentials:", err)
+		return
+	}
+	if len(credentials) == 0 {
+		fmt.Println("AGENT_TOKEN not set, authenticated agent API endpoints are disabled")
+	}
+	tlsConfig, err := loadAgentTLS()
+	if err != nil {
+		fmt.Println("Error in configuration:", err)
+		return
+	}
+	agentTLS := tlsConfig != nil
+	api := newAgentAPI(credentials)
+	go api.serve(ln, tlsConfig)
 
 	hostname, err := getHostname()
 	if err != nil {
@@ -259,70 +576,104 @@ func main() {
 
 	// Retrieve open ports based on the PORTS environment variable (or scan all if not set).
 	openPorts := getOpenPorts()
+	recordOpenPorts(openPorts)
 
-	agentInfo := AgentInfo{
-		Hostname:  hostname,
-		IP:        ip,
-		OpenPorts: openPorts,
-		Timestamp: time.Now().UnixMilli(),
-		AgentPort: agentPort,
+	agentID, err := loadAgentID()
+	if err != nil {
+		fmt.Println("Error loading agent ID:", err)
+		return
 	}
+	loadPersistedFlags()
+	loadCollectorOverrides()
 
-	// Build the server registration URL using environment variables.
-	hostEnv := os.Getenv("MONITORING_SERVER_HOST")
-	if hostEnv == "" {
-		hostEnv = "localhost"
+	// The nonce is shared by every registration attempt of this process.
+	nonce, err := newUUID()
+	if err != nil {
+		fmt.Println("Error generating registration nonce:", err)
+		return
 	}
-	portEnv := os.Getenv("MONITORING_SERVER_PORT")
-	if portEnv == "" {
-		portEnv = "8080"
+
+	advertisedHost, advertisedPort := advertisedAddress()
+	agentInfo := AgentInfo{
+		AgentID:      agentID,
+		TenantID:     tenantID(),
+		Hostname:     hostname,
+		IP:           ip,
+		OpenPorts:    openPorts,
+		PortServices: grabBanners(openPorts),
+		Timestamp:    time.Now().UnixMilli(),
+		AgentPort:    agentPort,
+		AgentTLS:     agentTLS,
+		// Set for NAT'd hosts, where the server cannot dial the local address.
+		AdvertisedAddress: advertisedHost,
+		AdvertisedPort:    advertisedPort,
+		Tags:              agentTags(),
+		Site:              agentSite(),
+		Security:          readSecurityModules(),
+		Nonce:             nonce,
+		// Offer the payload schemas, compressions and features the server may choose from.
+		Capabilities: agentCapabilities(),
 	}
-	registrationURL := "http://" + hostEnv + ":" + portEnv + "/api/agent/register"
-	fmt.Printf("Registering agent to: %s\n", registrationURL)
 
-	if err := registerAgent(agentInfo, registrationURL); err != nil {
-		fmt.Println("Error registering agent:", err)
-		return
+	// Advertise the agent in Consul, if configured, at the address the servers can reach.
+	consulHost, consulPort := reachableAddress(ip, agentPort)
+	registerConsulService(agentID, consulHost, consulPort, agentTLS)
+
+	// Resolve the configured or discovered server URL, plus the optional DR server.
+	setupDestinations(serverURL())
+
+	// Register with every destination. If a server is not reachable yet, registration keeps
+	// retrying in the background while metrics are collected and spooled locally. Once
+	// registered, crash reports left by previous runs are uploaded to the primary server and
+	// anything spooled while the server was unreachable is delivered.
+	for _, d := range destinations {
+		d.register(agentInfo, func() {
+			if d.primary {
+				uploadCrashReports(d.endpoint(endpointCrash))
+				startRemoteConfig(d)
+			}
+			d.requestFlush()
+		})
 	}
 
 	// === Part 2: Metrics Sending ===
-	// Build the metrics endpoint URL.
-	metricsURL := "http://" + hostEnv + ":" + portEnv + "/api/metrics"
-	fmt.Printf("Sending metrics to: %s\n", metricsURL)
+	for _, d := range destinations {
+		fmt.Printf("Sending metrics to %s server: %s\n", d.name, d.endpoint(endpointMetrics))
+	}
 
 	// Read the send interval from the environment variable SEND_INTERVAL (in seconds).
 	sendIntervalStr := os.Getenv("SEND_INTERVAL")
 	sendInterval := 60 * time.Second // default value
+	if liteMode() {
+		sendInterval = 300 * time.Second
+	}
 	if sendIntervalStr != "" {
 		if seconds, err := strconv.Atoi(sendIntervalStr); err == nil {
 			sendInterval = time.Duration(seconds) * time.Second
 		} else {
-			fmt.Printf("Invalid SEND_INTERVAL value, using default 60 seconds: %v\n", err)
+			fmt.Printf("Invalid SEND_INTERVAL value, using default %s: %v\n", sendInterval, err)
 		}
 	}
 
-	ticker := time.NewTicker(sendInterval)
-	defer ticker.Stop()
-
-	// Send metrics immediately at startup.
-	metrics, err := collectMetrics()
-	if err != nil {
-		fmt.Printf("Error collecting metrics: %v\n", err)
-	} else {
-		if err := sendMetrics(metrics, metricsURL); err != nil {
-			fmt.Printf("Error sending metrics: %v\n", err)
-		}
-	}
-
-	// Periodically send metrics.
-	for range ticker.C {
-		metrics, err := collectMetrics()
-		if err != nil {
-			fmt.Printf("Error collecting metrics: %v\n", err)
-			continue
-		}
-		if err := sendMetrics(metrics, metricsURL); err != nil {
-			fmt.Printf("Error sending metrics: %v\n", err)
-		}
-	}
-}
\ No newline at end of file
+	// Start the optional scheduled bandwidth self-test, package update checks, port rescans,
+	// LAN and mDNS/SSDP discovery and the SNMP trap receiver.
+	startBandwidthTests()
+	startPackageUpdateChecks()
+	startPortScans()
+	startLANDiscovery()
+	startAssetDiscovery()
+	startSNMPTrapReceiver()
+
+	// Collect and send metrics immediately at startup, then every send interval, with
+	// optional heartbeats in between and large payloads uploaded in chunks.
+	openMetricsCache()
+	startPipeline(sendInterval)
+	startHeartbeats()
+	startUploads()
+
+	// Allow the server to request an immediate collection outside the regular interval.
+	api.handleCommand("POST /collect", "collect", scopeTriggerCollect, handleCollect)
+	api.handleCommand("POST /rescan", "rescan", scopeTriggerCollect, handleRescan)
+	api.handle("POST /ingest", scopeTriggerCollect, handleIngest)
+	select {}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffPorts(t *testing.T) {
+	tests := []struct {
+		previous, current []int
+		added, removed    []int
+	}{
+		{nil, nil, nil, nil},
+		{nil, []int{443, 22}, []int{22, 443}, nil},
+		{[]int{22, 80}, nil, nil, []int{22, 80}},
+		{[]int{22, 80, 443}, []int{443, 22, 80}, nil, nil},
+		{[]int{22, 80, 8080}, []int{22, 443, 3306}, []int{443, 3306}, []int{80, 8080}},
+		{[]int{22, 22}, []int{22}, nil, nil},
+	}
+	for _, tt := range tests {
+		added, removed := diffPorts(tt.previous, tt.current)
+		if !reflect.DeepEqual(added, tt.added) || !reflect.DeepEqual(removed, tt.removed) {
+			t.Errorf("diffPorts(%v, %v) = %v, %v; want %v, %v", tt.previous, tt.current, added, removed, tt.added, tt.removed)
+		}
+	}
+}
+
+func TestRecordOpenPorts(t *testing.T) {
+	t.Cleanup(func() { lastScan.ports = nil })
+	recordOpenPorts([]int{22})
+	if previous := recordOpenPorts([]int{22, 80}); !reflect.DeepEqual(previous, []int{22}) {
+		t.Errorf("got previous scan %v, want [22]", previous)
+	}
+}
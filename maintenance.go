@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// maintenanceFileName is the file in the state directory recording an active maintenance
+// window. The agent API and the "maintenance" subcommand both write it, so a window set
+// from the command line takes effect in the running agent without a restart.
+const maintenanceFileName = "maintenance.json"
+
+// maxMaintenanceDuration bounds a maintenance window, so a forgotten one ends on its own.
+const maxMaintenanceDuration = 7 * 24 * time.Hour
+
+// MaintenanceWindow is a period during which the agent reports its metrics flagged with
+// maintenance=true and mutes its alerts, so planned work does not page anyone.
+type MaintenanceWindow struct {
+	Until  int64  `json:"until"`
+	Reason string `json:"reason,omitempty"`
+	// StartedBy is who started the window: an agent API client, a local user or the server.
+	StartedBy string `json:"startedBy,omitempty"`
+}
+
+// maintenanceCache holds the last maintenance file read, reloaded when the file changes.
+var maintenanceCache struct {
+	sync.Mutex
+	modTime time.Time
+	window  *MaintenanceWindow
+	// active is the state seen by the last call to trackMaintenance.
+	active bool
+}
+
+// maintenancePath returns the path of the maintenance file.
+func maintenancePath() string {
+	return filepath.Join(stateDir(), maintenanceFileName)
+}
+
+// readMaintenanceFile returns the window recorded in the maintenance file, or nil.
+func readMaintenanceFile() *MaintenanceWindow {
+	data, err := os.ReadFile(maintenancePath())
+	if err != nil {
+		return nil
+	}
+	var w MaintenanceWindow
+	if err := json.Unmarshal(data, &w); err != nil {
+		fmt.Printf("Error reading maintenance file: %v\n", err)
+		return nil
+	}
+	return &w
+}
+
+// currentMaintenance returns the active maintenance window, or nil. A window is active
+// while the maintenance file sets one that has not expired, or while the server sets the
+// MAINTENANCE remote flag, which has no end time.
+func currentMaintenance() *MaintenanceWindow {
+	if featureEnabled("MAINTENANCE", false) {
+		return &MaintenanceWindow{StartedBy: "server"}
+	}
+	maintenanceCache.Lock()
+	defer maintenanceCache.Unlock()
+	info, err := os.Stat(maintenancePath())
+	if err != nil {
+		maintenanceCache.modTime, maintenanceCache.window = time.Time{}, nil
+		return nil
+	}
+	if !info.ModTime().Equal(maintenanceCache.modTime) {
+		maintenanceCache.modTime, maintenanceCache.window = info.ModTime(), readMaintenanceFile()
+	}
+	w := maintenanceCache.window
+	if w == nil || time.Now().UnixMilli() >= w.Until {
+		return nil
+	}
+	active := *w
+	return &active
+}
+
+// inMaintenance reports whether a maintenance window is active.
+func inMaintenance() bool {
+	return currentMaintenance() != nil
+}
+
+// startMaintenance records a maintenance window of the given duration.
+func startMaintenance(duration time.Duration, reason, startedBy string) (*MaintenanceWindow, error) {
+	if duration <= 0 || duration > maxMaintenanceDuration {
+		return nil, fmt.Errorf("duration must be positive and at most %s", maxMaintenanceDuration)
+	}
+	w := &MaintenanceWindow{Until: time.Now().Add(duration).UnixMilli(), Reason: reason, StartedBy: startedBy}
+	data, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(maintenancePath(), data); err != nil {
+		return nil, fmt.Errorf("failed to write maintenance file: %v", err)
+	}
+	return w, nil
+}
+
+// endMaintenance ends the maintenance window set through the API or the command line. A
+// window set by the MAINTENANCE remote flag lasts until the server clears the flag.
+func endMaintenance() error {
+	if err := os.Remove(maintenancePath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove maintenance file: %v", err)
+	}
+	return nil
+}
+
+// trackMaintenance is called once per collection: it emits maintenance.started and
+// maintenance.ended events when a window begins or ends, including when one expires, and
+// returns whether the agent is in maintenance.
+func trackMaintenance() bool {
+	w := currentMaintenance()
+	maintenanceCache.Lock()
+	changed := (w != nil) != maintenanceCache.active
+	maintenanceCache.active = w != nil
+	maintenanceCache.Unlock()
+	if changed {
+		if w == nil {
+			emitEvent("maintenance.ended", "Maintenance ended, alerts are no longer muted")
+		} else {
+			queueEvent(Event{
+				Type:       "maintenance.started",
+				Message:    fmt.Sprintf("Maintenance started by %s, alerts are muted", w.StartedBy),
+				Timestamp:  time.Now().UnixMilli(),
+				Attributes: map[string]string{"until": formatMaintenanceUntil(w), "reason": w.Reason},
+			})
+		}
+	}
+	return w != nil
+}
+
+// formatMaintenanceUntil returns the end time of w in RFC 3339, or "" when it has none.
+func formatMaintenanceUntil(w *MaintenanceWindow) string {
+	if w.Until == 0 {
+		return ""
+	}
+	return time.UnixMilli(w.Until).UTC().Format(time.RFC3339)
+}
+
+// handleMaintenanceStatus returns the active maintenance window, or null.
+func handleMaintenanceStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, currentMaintenance())
+}
+
+// handleStartMaintenance starts a maintenance window for the duration given in the query,
+// e.g. POST /maintenance?duration=2h&reason=kernel+upgrade.
+func (a *agentAPI) handleStartMaintenance(w http.ResponseWriter, r *http.Request) {
+	duration, err := time.ParseDuration(r.URL.Query().Get("duration"))
+	if err != nil {
+		http.Error(w, "invalid or missing duration, e.g. duration=2h", http.StatusBadRequest)
+		return
+	}
+	startedBy := "api"
+	if cred, ok := a.authenticate(r); ok {
+		startedBy = "api:" + cred.Name
+	}
+	window, err := startMaintenance(duration, r.URL.Query().Get("reason"), startedBy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	trackMaintenance()
+	writeJSON(w, window)
+}
+
+// handleEndMaintenance ends the maintenance window.
+func handleEndMaintenance(w http.ResponseWriter, r *http.Request) {
+	if err := endMaintenance(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	trackMaintenance()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runMaintenance implements the "maintenance" subcommand, which starts, ends or shows the
+// maintenance window of the agent using the same STATE_DIR:
+//
+//	maintenance start -duration 2h -reason "kernel upgrade"
+//	maintenance end
+//	maintenance status
+func runMaintenance(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: maintenance start|end|status")
+	}
+	actor := "cli"
+	if u, err := user.Current(); err == nil {
+		actor = "cli:" + u.Username
+	}
+	switch args[0] {
+	case "start":
+		fs := flag.NewFlagSet("maintenance start", flag.ContinueOnError)
+		duration := fs.Duration("duration", time.Hour, "length of the maintenance window")
+		reason := fs.String("reason", "", "reason recorded with the window")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		w, err := startMaintenance(*duration, *reason, actor)
+		if err != nil {
+			return err
+		}
+		writeAudit(AuditEntry{Actor: actor, Action: "maintenance.start", Details: "duration=" + duration.String(), Result: "ok"})
+		fmt.Printf("Maintenance mode until %s\n", formatMaintenanceUntil(w))
+	case "end":
+		if err := endMaintenance(); err != nil {
+			return err
+		}
+		writeAudit(AuditEntry{Actor: actor, Action: "maintenance.end", Result: "ok"})
+		fmt.Println("Maintenance mode ended")
+	case "status":
+		w := readMaintenanceFile()
+		if w == nil || time.Now().UnixMilli() >= w.Until {
+			fmt.Println("Not in maintenance mode")
+			return nil
+		}
+		fmt.Printf("Maintenance mode until %s, started by %s: %s\n", formatMaintenanceUntil(w), w.StartedBy, w.Reason)
+	default:
+		return fmt.Errorf("unknown maintenance command %q: use start, end or status", args[0])
+	}
+	return nil
+}
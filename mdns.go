@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mdnsAddr is the IPv4 mDNS multicast group.
+var mdnsAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// dnssdServices is the DNS-SD meta-query name listing every advertised service type.
+const dnssdServices = "_services._dns-sd._udp.local."
+
+// mdnsQuery builds a PTR query for the given names.
+func mdnsQuery(names []string) ([]byte, error) {
+	msg := dnsmessage.Message{}
+	for _, n := range names {
+		name, err := dnsmessage.NewName(n)
+		if err != nil {
+			continue
+		}
+		msg.Questions = append(msg.Questions, dnsmessage.Question{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET})
+	}
+	return msg.Pack()
+}
+
+// mdnsServiceType returns the service type of a DNS-SD instance name, e.g. "_ipp._tcp" for
+// "Office Printer._ipp._tcp.local.", and the instance label.
+func mdnsServiceType(instance string) (label, serviceType string) {
+	name := trimLocal(instance)
+	if i := strings.Index(name, "._"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+// handleMDNS records the services and names found in an mDNS response from ip. Service
+// types found by the meta-query are added to types.
+func handleMDNS(set *assetSet, data []byte, ip net.IP, types map[string]bool) {
+	var msg dnsmessage.Message
+	if msg.Unpack(data) != nil || !msg.Header.Response {
+		return
+	}
+	set.Lock()
+	defer set.Unlock()
+	a := set.get("mdns", ip.String())
+	records := append(append(msg.Answers, msg.Authorities...), msg.Additionals...)
+	for _, r := range records {
+		switch body := r.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if r.Header.Name.String() == dnssdServices {
+				types[body.PTR.String()] = true
+				set.addService(a, trimLocal(body.PTR.String()))
+				continue
+			}
+			label, serviceType := mdnsServiceType(body.PTR.String())
+			set.addService(a, serviceType)
+			if a.Name == "" {
+				a.Name = label
+			}
+			if a.Type == "" {
+				a.Type = serviceType
+			}
+		case *dnsmessage.SRVResource:
+			if a.Name == "" {
+				a.Name = trimLocal(body.Target.String())
+			}
+		case *dnsmessage.AResource:
+			if a.Name == "" && net.IP(body.A[:]).Equal(ip) {
+				a.Name = trimLocal(r.Header.Name.String())
+			}
+		}
+	}
+}
+
+// discoverMDNS sends a DNS-SD meta-query, then a query for every service type found, and
+// records the devices that answer. Answers sent to the multicast group, including
+// announcements by other hosts, are recorded too.
+func discoverMDNS(set *assetSet, window time.Duration) error {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	if group, err := net.ListenMulticastUDP("udp4", nil, mdnsAddr); err == nil {
+		defer group.Close()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			readPackets(group, time.Now().Add(window), func(data []byte, from net.IP) {
+				handleMDNS(set, data, from, make(map[string]bool))
+			})
+		}()
+	}
+
+	// The meta-query lists the service types, the second query their instances.
+	types := make(map[string]bool)
+	query, err := mdnsQuery([]string{dnssdServices})
+	if err != nil {
+		return err
+	}
+	if _, err := conn.WriteTo(query, mdnsAddr); err != nil {
+		return err
+	}
+	readPackets(conn, time.Now().Add(window/2), func(data []byte, from net.IP) {
+		handleMDNS(set, data, from, types)
+	})
+	if len(types) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(types))
+	for t := range types {
+		names = append(names, t)
+	}
+	if query, err = mdnsQuery(names); err != nil {
+		return err
+	}
+	if _, err := conn.WriteTo(query, mdnsAddr); err != nil {
+		return err
+	}
+	readPackets(conn, time.Now().Add(window/2), func(data []byte, from net.IP) {
+		handleMDNS(set, data, from, types)
+	})
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RAIDArray reports the state of a Linux software RAID (md) array.
+type RAIDArray struct {
+	Name          string   `json:"name"`
+	State         string   `json:"state"`
+	Level         string   `json:"level"`
+	TotalDevices  int      `json:"totalDevices"`
+	ActiveDevices int      `json:"activeDevices"`
+	Degraded      bool     `json:"degraded"`
+	FailedMembers []string `json:"failedMembers,omitempty"`
+	SyncAction    string   `json:"syncAction,omitempty"`
+	SyncPercent   float64  `json:"syncPercent,omitempty"`
+}
+
+var (
+	mdStatusPattern = regexp.MustCompile(`\[(\d+)/(\d+)\]\s+\[([U_]+)\]`)
+	mdSyncPattern   = regexp.MustCompile(`(resync|recovery|reshape|check)\s*=\s*([\d.]+)%`)
+)
+
+// lastRAIDDegraded holds the previous degraded state of each array, used to emit change events.
+var lastRAIDDegraded struct {
+	sync.Mutex
+	degraded map[string]bool
+}
+
+// parseMdstat parses the content of /proc/mdstat.
+func parseMdstat(data string) []RAIDArray {
+	var arrays []RAIDArray
+	var current *RAIDArray
+	for _, line := range strings.Split(data, "\n") {
+		if name, rest, ok := strings.Cut(line, " : "); ok && strings.HasPrefix(name, "md") {
+			fields := strings.Fields(rest)
+			arrays = append(arrays, RAIDArray{Name: name})
+			current = &arrays[len(arrays)-1]
+			if len(fields) > 0 {
+				current.State = fields[0]
+			}
+			for _, f := range fields[1:] {
+				if strings.HasPrefix(f, "raid") || f == "linear" {
+					current.Level = f
+				}
+				// Members look like "sdb1[1]" or, when failed, "sdb1[1](F)".
+				if strings.HasSuffix(f, "(F)") {
+					member, _, _ := strings.Cut(f, "[")
+					current.FailedMembers = append(current.FailedMembers, member)
+				}
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if m := mdStatusPattern.FindStringSubmatch(line); m != nil {
+			current.TotalDevices, _ = strconv.Atoi(m[1])
+			current.ActiveDevices, _ = strconv.Atoi(m[2])
+			current.Degraded = strings.Contains(m[3], "_")
+		}
+		if m := mdSyncPattern.FindStringSubmatch(line); m != nil {
+			current.SyncAction = m[1]
+			current.SyncPercent, _ = strconv.ParseFloat(m[2], 64)
+		}
+	}
+	for i := range arrays {
+		if len(arrays[i].FailedMembers) > 0 || arrays[i].State == "inactive" {
+			arrays[i].Degraded = true
+		}
+	}
+	return arrays
+}
+
+// collectRAID reports md arrays from /proc/mdstat and emits raid.degraded / raid.recovered
+// events when an array's degraded state changes. It is disabled with RAID_STATS=false.
+func collectRAID() ([]RAIDArray, error) {
+	if !collectorEnabled("RAID_STATS", true) {
+		return nil, nil
+	}
+	data, err := os.ReadFile("/proc/mdstat")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	arrays := parseMdstat(string(data))
+
+	lastRAIDDegraded.Lock()
+	defer lastRAIDDegraded.Unlock()
+	if lastRAIDDegraded.degraded == nil {
+		lastRAIDDegraded.degraded = make(map[string]bool)
+	}
+	for _, a := range arrays {
+		prev, known := lastRAIDDegraded.degraded[a.Name]
+		switch {
+		case a.Degraded && (!known || !prev):
+			failed := ""
+			if len(a.FailedMembers) > 0 {
+				failed = fmt.Sprintf(", failed members: %s", strings.Join(a.FailedMembers, ", "))
+			}
+			emitEvent("raid.degraded", "array %s (%s) is degraded: %d/%d devices active%s",
+				a.Name, a.Level, a.ActiveDevices, a.TotalDevices, failed)
+		case !a.Degraded && known && prev:
+			emitEvent("raid.recovered", "array %s (%s) is no longer degraded", a.Name, a.Level)
+		}
+		lastRAIDDegraded.degraded[a.Name] = a.Degraded
+	}
+	return arrays, nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Limits of the local metrics history returned by GET /history.
+const (
+	defaultHistoryLimit = 1000
+	maxHistoryLimit     = 10000
+)
+
+// metricsCacheSchema creates the table of cached samples. The headline gauges have their
+// own columns so they can be aggregated in SQL; the full payload is kept as JSON, encrypted
+// with SPOOL_ENCRYPTION (see sealText).
+const metricsCacheSchema = `
+CREATE TABLE IF NOT EXISTS samples (
+	seq       INTEGER NOT NULL,
+	timestamp INTEGER NOT NULL,
+	cpu       REAL NOT NULL,
+	ram       REAL NOT NULL,
+	disk      REAL NOT NULL,
+	payload   TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS samples_timestamp ON samples (timestamp);
+`
+
+// metricsCache is the local SQLite database holding the recent metrics history.
+var metricsCache struct {
+	sync.Mutex
+	db        *sql.DB
+	retention time.Duration
+}
+
+// openMetricsCache opens the local metrics history when METRICS_CACHE_HOURS is set. The
+// database lives in METRICS_CACHE_FILE, or metrics.db in the state directory; failing to
+// open it only disables the cache.
+func openMetricsCache() {
+	hours := envInt("METRICS_CACHE_HOURS", 0)
+	if hours <= 0 {
+		return
+	}
+	path := os.Getenv("METRICS_CACHE_FILE")
+	if path == "" {
+		path = filepath.Join(stateDir(), "metrics.db")
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err == nil {
+		// A single connection serializes writes from the processor with API queries.
+		db.SetMaxOpenConns(1)
+		_, err = db.Exec(metricsCacheSchema)
+	}
+	if err != nil {
+		fmt.Printf("Error opening metrics cache, local history disabled: %v\n", err)
+		return
+	}
+	metricsCache.Lock()
+	metricsCache.db = db
+	metricsCache.retention = time.Duration(hours) * time.Hour
+	metricsCache.Unlock()
+	fmt.Printf("Keeping %d hours of metrics history in %s\n", hours, path)
+}
+
+// cacheMetrics stores a sample in the local history with data, its payload as processed for
+// delivery, and prunes the samples older than the retention.
+func cacheMetrics(m Metrics, data []byte) {
+	metricsCache.Lock()
+	db, retention := metricsCache.db, metricsCache.retention
+	metricsCache.Unlock()
+	if db == nil || !featureEnabled("METRICS_CACHE", true) {
+		return
+	}
+	stored, err := sealText(data)
+	if err != nil {
+		fmt.Printf("Error caching metrics: %v\n", err)
+		return
+	}
+	if _, err := db.Exec(`INSERT INTO samples (seq, timestamp, cpu, ram, disk, payload) VALUES (?, ?, ?, ?, ?, ?)`,
+		m.Seq, m.Timestamp, m.CPUUsage, m.RAMUsage, m.DiskUsage, stored); err != nil {
+		fmt.Printf("Error caching metrics: %v\n", err)
+		return
+	}
+	cutoff := time.Now().Add(-retention).UnixMilli()
+	if _, err := db.Exec(`DELETE FROM samples WHERE timestamp < ?`, cutoff); err != nil {
+		fmt.Printf("Error pruning metrics cache: %v\n", err)
+	}
+}
+
+// HistorySample is a cached sample returned by GET /history. Payload is only included
+// when requested with full=true.
+type HistorySample struct {
+	Seq       uint64          `json:"seq"`
+	Timestamp int64           `json:"timestamp"`
+	CPUUsage  float64         `json:"cpuUsage"`
+	RAMUsage  float64         `json:"ramUsage"`
+	DiskUsage float64         `json:"diskUsage"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+// HistoryBucket aggregates the cached samples of one step of GET /history?step=.
+type HistoryBucket struct {
+	Timestamp int64   `json:"timestamp"`
+	Samples   int     `json:"samples"`
+	CPUAvg    float64 `json:"cpuAvg"`
+	CPUMax    float64 `json:"cpuMax"`
+	RAMAvg    float64 `json:"ramAvg"`
+	RAMMax    float64 `json:"ramMax"`
+	DiskMax   float64 `json:"diskMax"`
+}
+
+// historyRange reads the time range of a history query: from and to in Unix milliseconds,
+// or since as a duration back from now, defaulting to the whole retention.
+func historyRange(r *http.Request, retention time.Duration) (int64, int64, error) {
+	now := time.Now()
+	from, to := now.Add(-retention).UnixMilli(), now.UnixMilli()
+	q := r.URL.Query()
+	if s := q.Get("since"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return 0, 0, fmt.Errorf("invalid since, use a duration such as 30m")
+		}
+		from = now.Add(-d).UnixMilli()
+	}
+	for name, v := range map[string]*int64{"from": &from, "to": &to} {
+		if s := q.Get(name); s != "" {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("invalid %s, use Unix milliseconds", name)
+			}
+			*v = n
+		}
+	}
+	return from, to, nil
+}
+
+// handleHistory returns the cached metrics history, oldest first: the raw samples, or with
+// step=<duration> their averages and maxima per step.
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	metricsCache.Lock()
+	db, retention := metricsCache.db, metricsCache.retention
+	metricsCache.Unlock()
+	if db == nil {
+		http.Error(w, "metrics cache disabled, set METRICS_CACHE_HOURS", http.StatusNotFound)
+		return
+	}
+	from, to, err := historyRange(r, retention)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := defaultHistoryLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			limit = min(n, maxHistoryLimit)
+		}
+	}
+
+	if s := r.URL.Query().Get("step"); s != "" {
+		step, err := time.ParseDuration(s)
+		if err != nil || step < time.Second {
+			http.Error(w, "invalid step, use a duration of at least 1s", http.StatusBadRequest)
+			return
+		}
+		ms := step.Milliseconds()
+		rows, err := db.Query(`SELECT (timestamp / ?) * ?, COUNT(*), AVG(cpu), MAX(cpu), AVG(ram), MAX(ram), MAX(disk)
+			FROM samples WHERE timestamp >= ? AND timestamp <= ? GROUP BY 1 ORDER BY 1 LIMIT ?`, ms, ms, from, to, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		buckets := []HistoryBucket{}
+		for rows.Next() {
+			var b HistoryBucket
+			if err := rows.Scan(&b.Timestamp, &b.Samples, &b.CPUAvg, &b.CPUMax, &b.RAMAvg, &b.RAMMax, &b.DiskMax); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			buckets = append(buckets, b)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, buckets)
+		return
+	}
+
+	full := r.URL.Query().Get("full") == "true"
+	rows, err := db.Query(`SELECT seq, timestamp, cpu, ram, disk, payload FROM samples
+		WHERE timestamp >= ? AND timestamp <= ? ORDER BY timestamp LIMIT ?`, from, to, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	samples := []HistorySample{}
+	for rows.Next() {
+		var s HistorySample
+		var payload string
+		if err := rows.Scan(&s.Seq, &s.Timestamp, &s.CPUUsage, &s.RAMUsage, &s.DiskUsage, &payload); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if full {
+			// A payload that cannot be decrypted, after the spool key changed, is left out.
+			if data, err := openText(payload); err == nil {
+				s.Payload = json.RawMessage(data)
+			}
+		}
+		samples = append(samples, s)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, samples)
+}
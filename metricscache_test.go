@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheMetricsStoresProcessedPayload(t *testing.T) {
+	useSpoolKey(t)
+	t.Setenv("METRICS_CACHE_HOURS", "1")
+	openMetricsCache()
+	t.Cleanup(func() {
+		metricsCache.db.Close()
+		metricsCache.db = nil
+		transformRules = nil
+	})
+	transformRules = []TransformRule{{Action: "drop", Field: "hostname"}}
+
+	m := Metrics{Seq: 1, Timestamp: time.Now().UnixMilli(), Hostname: "secret-host", CPUUsage: 12}
+	b, err := processMetrics(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheMetrics(m, b.data)
+
+	var stored string
+	if err := metricsCache.db.QueryRow(`SELECT payload FROM samples`).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stored, "secret-host") {
+		t.Error("payload stored in plain text")
+	}
+
+	rec := httptest.NewRecorder()
+	handleHistory(rec, httptest.NewRequest("GET", "/history?from=0&full=true", nil))
+	var samples []HistorySample
+	if err := json.Unmarshal(rec.Body.Bytes(), &samples); err != nil {
+		t.Fatalf("invalid response %q: %v", rec.Body, err)
+	}
+	if len(samples) != 1 || samples[0].CPUUsage != 12 {
+		t.Fatalf("got %+v, want one sample at 12%% CPU", samples)
+	}
+	if strings.Contains(string(samples[0].Payload), "secret-host") {
+		t.Errorf("dropped field served by the history: %s", samples[0].Payload)
+	}
+}
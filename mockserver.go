@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// mockServer is a development stand-in for the monitoring server that prints what the
+// agent sends and can inject failures and latency.
+type mockServer struct {
+	failRate   float64
+	failStatus int
+	latency    time.Duration
+	jitter     time.Duration
+	retryAfter time.Duration
+	config     []byte
+	handshake  []byte
+	pretty     bool
+	quiet      bool
+
+	mu       sync.Mutex
+	received map[string]int
+	// uploads are the bytes received so far of each chunked upload, by upload ID.
+	uploads map[string][]byte
+}
+
+// delay sleeps for the configured latency plus a random jitter.
+func (s *mockServer) delay() {
+	d := s.latency
+	if s.jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(s.jitter)))
+	}
+	time.Sleep(d)
+}
+
+// handle returns a handler that accepts a POSTed payload with the given success status and
+// response body, unless a failure is injected.
+func (s *mockServer) handle(status int, response []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var reader io.Reader = r.Body
+		switch r.Header.Get("Content-Encoding") {
+		case encodingGzip:
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid gzip body: %v", err), http.StatusBadRequest)
+				return
+			}
+			reader = zr
+		case encodingZstd:
+			zr, err := zstd.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid zstd body: %v", err), http.StatusBadRequest)
+				return
+			}
+			defer zr.Close()
+			reader = zr
+		}
+		body, err := io.ReadAll(io.LimitReader(reader, 64<<20))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error reading body: %v", err), http.StatusBadRequest)
+			return
+		}
+		s.delay()
+		code := status
+		if s.failRate > 0 && rand.Float64() < s.failRate {
+			code = s.failStatus
+		}
+		s.mu.Lock()
+		s.received[r.URL.Path]++
+		n := s.received[r.URL.Path]
+		s.mu.Unlock()
+		encoding := r.Header.Get("Content-Encoding")
+		if encoding == "" {
+			encoding = encodingIdentity
+		}
+		fmt.Printf("%s %s %s #%d from %s (%s): %d bytes %s -> %d\n",
+			time.Now().Format(time.RFC3339), r.Method, r.URL.Path, n, r.RemoteAddr, r.UserAgent(), len(body), encoding, code)
+		if !s.quiet {
+			s.printBody(body)
+		}
+		if code != status && s.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(s.retryAfter.Seconds())))
+		}
+		if code != status || response == nil {
+			w.WriteHeader(code)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		w.Write(response)
+	}
+}
+
+// printBody prints a received payload, indented when it is JSON and -pretty is set.
+func (s *mockServer) printBody(body []byte) {
+	if s.pretty {
+		var out bytes.Buffer
+		if json.Indent(&out, body, "", "  ") == nil {
+			body = out.Bytes()
+		}
+	}
+	fmt.Printf("%s\n", body)
+}
+
+// handleConfig serves the remote configuration file, or 404 when none was given, which
+// the agent treats as "no remote configuration".
+func (s *mockServer) handleConfig(w http.ResponseWriter, r *http.Request) {
+	s.delay()
+	fmt.Printf("%s %s %s from %s\n", time.Now().Format(time.RFC3339), r.Method, r.URL.RequestURI(), r.RemoteAddr)
+	if s.config == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(s.config)
+}
+
+// handleUpload receives a chunk of an upload, answering with the offset of the next chunk
+// in Upload-Offset, and 409 when the chunk does not start there. Completed uploads are
+// printed like the other payloads.
+func (s *mockServer) handleUpload(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var start, end, total int64
+	if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &total); err != nil || end < start || end >= total {
+		http.Error(w, "missing or invalid Content-Range", http.StatusBadRequest)
+		return
+	}
+	chunk, err := io.ReadAll(io.LimitReader(r.Body, 64<<20))
+	if err != nil || int64(len(chunk)) != end-start+1 {
+		http.Error(w, "chunk does not match Content-Range", http.StatusBadRequest)
+		return
+	}
+	s.delay()
+	if s.failRate > 0 && rand.Float64() < s.failRate {
+		fmt.Printf("%s PUT %s bytes %d-%d/%d -> %d\n", time.Now().Format(time.RFC3339), r.URL.Path, start, end, total, s.failStatus)
+		if s.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(s.retryAfter.Seconds())))
+		}
+		w.WriteHeader(s.failStatus)
+		return
+	}
+	s.mu.Lock()
+	data := s.uploads[id]
+	if int64(len(data)) != start {
+		s.mu.Unlock()
+		fmt.Printf("%s PUT %s bytes %d-%d/%d -> 409, expected byte %d\n", time.Now().Format(time.RFC3339), r.URL.Path, start, end, total, len(data))
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	data = append(data, chunk...)
+	s.uploads[id] = data
+	s.mu.Unlock()
+	fmt.Printf("%s PUT %s bytes %d-%d/%d (%s) from %s -> 200\n", time.Now().Format(time.RFC3339), r.URL.Path, start, end, total, r.Header.Get("Upload-Kind"), r.RemoteAddr)
+	w.Header().Set("Upload-Offset", strconv.Itoa(len(data)))
+	if int64(len(data)) == total {
+		fmt.Printf("Upload %s complete: %d bytes, valid JSON: %t\n", id, total, json.Valid(data))
+		if !s.quiet {
+			s.printBody(data)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// runMockServer implements the "mockserver" subcommand: a local server implementing the
+// registration, metrics, heartbeat, crash report, upload and remote configuration endpoints, for testing
+// collector and transport changes without the real backend.
+func runMockServer(args []string) error {
+	fs := flag.NewFlagSet("mockserver", flag.ContinueOnError)
+	listen := fs.String("listen", "127.0.0.1:8080", "address to listen on")
+	failRate := fs.Float64("fail-rate", 0, "fraction of registration, metrics, heartbeat and crash requests to fail (0-1)")
+	failStatus := fs.Int("fail-status", http.StatusServiceUnavailable, "HTTP status returned by injected failures")
+	latency := fs.Duration("latency", 0, "delay added to every response")
+	jitter := fs.Duration("jitter", 0, "random extra delay of up to this duration")
+	retryAfter := fs.Duration("retry-after", 0, "Retry-After sent with injected failures")
+	configFile := fs.String("config", "", "JSON file served as the remote configuration (404 when empty)")
+	handshakeFile := fs.String("handshake", "", "JSON file returned as the handshake in registration responses")
+	pretty := fs.Bool("pretty", false, "indent received JSON payloads")
+	quiet := fs.Bool("quiet", false, "print one line per request instead of the payloads")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *failRate < 0 || *failRate > 1 {
+		return fmt.Errorf("fail-rate must be between 0 and 1")
+	}
+	if *failStatus < 100 || *failStatus > 599 {
+		return fmt.Errorf("invalid fail-status: %d", *failStatus)
+	}
+	s := &mockServer{
+		failRate:   *failRate,
+		failStatus: *failStatus,
+		latency:    *latency,
+		jitter:     *jitter,
+		retryAfter: *retryAfter,
+		pretty:     *pretty,
+		quiet:      *quiet,
+		received:   make(map[string]int),
+		uploads:    make(map[string][]byte),
+	}
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return fmt.Errorf("failed to read remote config: %v", err)
+		}
+		if !json.Valid(data) {
+			return fmt.Errorf("remote config %s is not valid JSON", *configFile)
+		}
+		s.config = data
+	}
+	if *handshakeFile != "" {
+		data, err := os.ReadFile(*handshakeFile)
+		if err != nil {
+			return fmt.Errorf("failed to read handshake: %v", err)
+		}
+		if !json.Valid(data) {
+			return fmt.Errorf("handshake %s is not valid JSON", *handshakeFile)
+		}
+		s.handshake = data
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/agent/register", s.handle(http.StatusCreated, s.handshake))
+	mux.Handle("/api/metrics", s.handle(http.StatusOK, nil))
+	mux.Handle("/api/agent/crash", s.handle(http.StatusOK, nil))
+	mux.Handle("/api/agent/heartbeat", s.handle(http.StatusOK, nil))
+	mux.HandleFunc("/api/agent/config", s.handleConfig)
+	mux.HandleFunc("PUT /api/agent/upload/{id}", s.handleUpload)
+	fmt.Printf("Mock monitoring server listening on %s (fail rate %g, latency %s + up to %s)\n", *listen, *failRate, *latency, *jitter)
+	// Accept HTTP/2 with prior knowledge too, for agents run with HTTP_VERSION=2.
+	server := &http.Server{Addr: *listen, Handler: mux, Protocols: new(http.Protocols)}
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetUnencryptedHTTP2(true)
+	return server.ListenAndServe()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Neighbor is an entry of the ARP/NDP neighbor table.
+type Neighbor struct {
+	IP        string `json:"ip"`
+	MAC       string `json:"mac"`
+	Interface string `json:"interface,omitempty"`
+}
+
+// NeighborStats summarizes the neighbor table and the MAC addresses seen for the first time.
+type NeighborStats struct {
+	Count   int        `json:"count"`
+	NewMACs []Neighbor `json:"newMacs,omitempty"`
+}
+
+// knownMACs holds every MAC address observed since startup.
+var knownMACs struct {
+	sync.Mutex
+	seen map[string]bool
+}
+
+var (
+	arpIPPattern  = regexp.MustCompile(`\(?(\d+\.\d+\.\d+\.\d+)\)?`)
+	arpMACPattern = regexp.MustCompile(`([0-9A-Fa-f]{1,2}[:-]){5}[0-9A-Fa-f]{1,2}`)
+)
+
+// normalizeMAC lower-cases a MAC address and uses colons as separators.
+func normalizeMAC(mac string) string {
+	return strings.ToLower(strings.ReplaceAll(mac, "-", ":"))
+}
+
+// readProcARP parses the Linux IPv4 neighbor table from /proc/net/arp.
+func readProcARP() ([]Neighbor, error) {
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var neighbors []Neighbor
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // Skip the header line.
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[3] == "00:00:00:00:00:00" {
+			continue
+		}
+		neighbors = append(neighbors, Neighbor{IP: fields[0], MAC: normalizeMAC(fields[3]), Interface: fields[5]})
+	}
+	return neighbors, scanner.Err()
+}
+
+// readIPNeigh parses the IPv6 neighbor table from "ip -6 neigh show".
+func readIPNeigh() []Neighbor {
+	out, err := exec.Command("ip", "-6", "neigh", "show").Output()
+	if err != nil {
+		return nil
+	}
+	var neighbors []Neighbor
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		n := Neighbor{}
+		for i := 0; i+1 < len(fields); i++ {
+			switch fields[i] {
+			case "dev":
+				n.Interface = fields[i+1]
+			case "lladdr":
+				n.MAC = normalizeMAC(fields[i+1])
+			}
+		}
+		if len(fields) > 0 && n.MAC != "" {
+			n.IP = fields[0]
+			neighbors = append(neighbors, n)
+		}
+	}
+	return neighbors
+}
+
+// readARPCommand parses the output of "arp -a", used on platforms without /proc/net/arp.
+func readARPCommand() ([]Neighbor, error) {
+	args := []string{"-an"}
+	if runtime.GOOS == "windows" {
+		args = []string{"-a"}
+	}
+	out, err := exec.Command("arp", args...).Output()
+	if err != nil {
+		return nil, err
+	}
+	var neighbors []Neighbor
+	for _, line := range strings.Split(string(out), "\n") {
+		ip := arpIPPattern.FindStringSubmatch(line)
+		mac := arpMACPattern.FindString(line)
+		if ip == nil || mac == "" {
+			continue
+		}
+		neighbors = append(neighbors, Neighbor{IP: ip[1], MAC: normalizeMAC(mac)})
+	}
+	return neighbors, nil
+}
+
+// readNeighbors returns the current ARP/NDP neighbor table.
+func readNeighbors() ([]Neighbor, error) {
+	if runtime.GOOS == "linux" {
+		neighbors, err := readProcARP()
+		if err != nil {
+			return nil, err
+		}
+		return append(neighbors, readIPNeigh()...), nil
+	}
+	return readARPCommand()
+}
+
+// collectNeighbors reads the neighbor table when NEIGHBOR_TABLE is "true" and reports
+// MAC addresses not seen before. The first collection only establishes the baseline.
+func collectNeighbors() (*NeighborStats, error) {
+	if !collectorEnabled("NEIGHBOR_TABLE", false) {
+		return nil, nil
+	}
+	neighbors, err := readNeighbors()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the neighbor table: %v", err)
+	}
+
+	knownMACs.Lock()
+	defer knownMACs.Unlock()
+	baseline := knownMACs.seen == nil
+	if baseline {
+		knownMACs.seen = make(map[string]bool)
+	}
+	stats := &NeighborStats{Count: len(neighbors)}
+	for _, n := range neighbors {
+		if knownMACs.seen[n.MAC] {
+			continue
+		}
+		knownMACs.seen[n.MAC] = true
+		if !baseline {
+			stats.NewMACs = append(stats.NewMACs, n)
+			emitEvent("neighbor.new", "new MAC address %s at %s on %s", n.MAC, n.IP, n.Interface)
+		}
+	}
+	return stats, nil
+}
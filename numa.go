@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// NUMANode reports the memory usage of a single NUMA node.
+type NUMANode struct {
+	Node       int    `json:"node"`
+	TotalBytes uint64 `json:"totalBytes"`
+	FreeBytes  uint64 `json:"freeBytes"`
+	UsedBytes  uint64 `json:"usedBytes"`
+}
+
+// HugePages reports the hugepage allocation of the host.
+type HugePages struct {
+	Total         uint64 `json:"total"`
+	Free          uint64 `json:"free"`
+	Reserved      uint64 `json:"reserved"`
+	Surplus       uint64 `json:"surplus"`
+	PageSizeBytes uint64 `json:"pageSizeBytes"`
+}
+
+// MemoryTopology groups NUMA and hugepage statistics.
+type MemoryTopology struct {
+	NUMANodes []NUMANode `json:"numaNodes,omitempty"`
+	HugePages *HugePages `json:"hugePages,omitempty"`
+}
+
+// readNUMANodes parses /sys/devices/system/node/node*/meminfo.
+func readNUMANodes() []NUMANode {
+	paths, _ := filepath.Glob("/sys/devices/system/node/node[0-9]*/meminfo")
+	var nodes []NUMANode
+	for _, path := range paths {
+		var id int
+		if _, err := fmt.Sscanf(filepath.Base(filepath.Dir(path)), "node%d", &id); err != nil {
+			continue
+		}
+		values, err := readMeminfo(path, fmt.Sprintf("Node %d ", id))
+		if err != nil {
+			continue
+		}
+		nodes = append(nodes, NUMANode{
+			Node:       id,
+			TotalBytes: values["MemTotal"],
+			FreeBytes:  values["MemFree"],
+			UsedBytes:  values["MemUsed"],
+		})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Node < nodes[j].Node })
+	return nodes
+}
+
+// collectMemoryTopology reports per-NUMA-node memory on multi-node hosts and hugepage
+// counts when hugepages are configured. It returns nil when neither applies or
+// NUMA_STATS is "false".
+func collectMemoryTopology() *MemoryTopology {
+	if !collectorEnabled("NUMA_STATS", true) {
+		return nil
+	}
+	topo := &MemoryTopology{}
+	if nodes := readNUMANodes(); len(nodes) > 1 {
+		topo.NUMANodes = nodes
+	}
+	if values, err := readMeminfo("/proc/meminfo", ""); err == nil && values["HugePages_Total"] > 0 {
+		topo.HugePages = &HugePages{
+			Total:         values["HugePages_Total"],
+			Free:          values["HugePages_Free"],
+			Reserved:      values["HugePages_Rsvd"],
+			Surplus:       values["HugePages_Surp"],
+			PageSizeBytes: values["Hugepagesize"],
+		}
+	}
+	if topo.NUMANodes == nil && topo.HugePages == nil {
+		return nil
+	}
+	return topo
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// agentAPIVersion is the version of the agent API, bumped when endpoints change
+// incompatibly.
+const agentAPIVersion = "1.0.0"
+
+// apiRoute is an endpoint registered on the agent API.
+type apiRoute struct {
+	method, path, scope string
+}
+
+// apiParam is a query parameter of an agent API endpoint, or a path parameter when path
+// is set.
+type apiParam struct {
+	name, description string
+	required, path    bool
+}
+
+// endpointDoc describes an agent API endpoint in the OpenAPI document.
+type endpointDoc struct {
+	summary string
+	params  []apiParam
+}
+
+// endpointDocs are the descriptions of the agent API endpoints, by pattern. Endpoints
+// without an entry are still listed, without a summary.
+var endpointDocs = map[string]endpointDoc{
+	"GET /healthz":           {summary: "Liveness probe, returns ok."},
+	"GET /openapi.json":      {summary: "This OpenAPI document."},
+	"GET /status":            {summary: "Basic information about the running agent and its delivery progress."},
+	"GET /processes":         {summary: "The full current process list."},
+	"POST /processes/upload": {summary: "Sends the full process list to the servers as a chunked upload and returns its uploadId."},
+	"GET /logs": {summary: "The last lines of a file listed in LOG_FILES.", params: []apiParam{
+		{name: "file", description: "Path of the log file.", required: true},
+		{name: "lines", description: "Number of lines to return (default 100, max 1000)."},
+	}},
+	"GET /history": {summary: "The recent metrics history kept in the local cache, oldest first.", params: []apiParam{
+		{name: "since", description: "Duration to look back, e.g. 30m (default: the whole retention)."},
+		{name: "from", description: "Start of the range, in Unix milliseconds."},
+		{name: "to", description: "End of the range, in Unix milliseconds."},
+		{name: "step", description: "Aggregate the samples into buckets of this duration, e.g. 5m."},
+		{name: "limit", description: "Maximum number of samples or buckets (default 1000, max 10000)."},
+		{name: "full", description: "true to include the full payload of each sample."},
+	}},
+	"POST /diagnostics": {summary: "Runs a network diagnostic from the agent host and streams its output.", params: []apiParam{
+		{name: "type", description: "ping, traceroute or dns.", required: true},
+		{name: "target", description: "Host name or address to diagnose.", required: true},
+	}},
+	"POST /collectors/{name}/enable":  {summary: "Enables a collector disabled at runtime or after repeated failures.", params: collectorParams},
+	"POST /collectors/{name}/disable": {summary: "Stops running a collector until it is enabled again, across restarts.", params: collectorParams},
+	"DELETE /collectors/{name}":       {summary: "Drops the override set through the API, so the collector runs as configured.", params: collectorParams},
+	"GET /maintenance":                {summary: "The active maintenance window, or null."},
+	"POST /maintenance": {summary: "Starts a maintenance window: metrics are flagged maintenance=true and alerts are muted.", params: []apiParam{
+		{name: "duration", description: "Length of the window, e.g. 2h (max 168h).", required: true},
+		{name: "reason", description: "Reason recorded with the window."},
+	}},
+	"DELETE /maintenance": {summary: "Ends the maintenance window."},
+	"POST /collect":       {summary: "Collects metrics immediately, queues them for sending and returns them."},
+	"POST /rescan":        {summary: "Reruns the port scan and returns the ports added and removed since the previous scan."},
+	"POST /ingest":        {summary: "Accepts custom events and metrics from local applications."},
+}
+
+// collectorParams are the parameters of the endpoints switching a collector on or off.
+var collectorParams = []apiParam{{name: "name", description: "Name of the collector, as listed in GET /status.", required: true, path: true}}
+
+// addRoute records an endpoint for the OpenAPI document.
+func (a *agentAPI) addRoute(pattern, scope string) {
+	method, path, _ := strings.Cut(pattern, " ")
+	a.routesMu.Lock()
+	a.routes = append(a.routes, apiRoute{method: method, path: path, scope: scope})
+	a.routesMu.Unlock()
+}
+
+// openAPIDocument builds an OpenAPI 3.0 document from the registered endpoints. The scope
+// an endpoint requires is given in its x-scope extension.
+func (a *agentAPI) openAPIDocument() map[string]interface{} {
+	a.routesMu.Lock()
+	routes := append([]apiRoute(nil), a.routes...)
+	a.routesMu.Unlock()
+	sort.Slice(routes, func(i, j int) bool { return routes[i].path < routes[j].path })
+	paths := make(map[string]map[string]interface{})
+	for _, route := range routes {
+		doc := endpointDocs[route.method+" "+route.path]
+		responses := map[string]interface{}{"200": map[string]string{"description": "Success"}}
+		op := map[string]interface{}{"summary": doc.summary, "responses": responses}
+		if route.scope != "" {
+			op["security"] = []map[string][]string{{"bearerAuth": {}}}
+			op["x-scope"] = route.scope
+			responses["401"] = map[string]string{"description": "Missing or unknown token"}
+			responses["403"] = map[string]string{"description": "Token lacks the " + route.scope + " scope"}
+		}
+		var params []map[string]interface{}
+		for _, p := range doc.params {
+			in := "query"
+			if p.path {
+				in = "path"
+			}
+			params = append(params, map[string]interface{}{
+				"name":        p.name,
+				"in":          in,
+				"description": p.description,
+				"required":    p.required,
+				"schema":      map[string]string{"type": "string"},
+			})
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		if paths[route.path] == nil {
+			paths[route.path] = make(map[string]interface{})
+		}
+		paths[route.path][strings.ToLower(route.method)] = op
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":          "Cheetah Monitoring Agent API",
+			"version":        agentAPIVersion,
+			"x-agentVersion": agentVersion(),
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]string{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+// handleOpenAPI serves the OpenAPI document of the agent API. It needs no token, so
+// tooling can discover the endpoints before it is given credentials.
+func (a *agentAPI) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.openAPIDocument())
+}
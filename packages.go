@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultPackageUpdatesInterval is how often pending package updates are counted.
+	defaultPackageUpdatesInterval = 6 * time.Hour
+	// packageCheckTimeout bounds a check, so a package manager stuck on a lock or an
+	// unreachable repository cannot hang the checks.
+	packageCheckTimeout = 10 * time.Minute
+	// packageCommandWaitDelay bounds the wait for a killed command's children to release
+	// its output.
+	packageCommandWaitDelay = 5 * time.Second
+)
+
+// PackageUpdates reports the number of available package updates.
+type PackageUpdates struct {
+	Manager         string `json:"manager"`
+	Updates         int    `json:"updates"`
+	SecurityUpdates int    `json:"securityUpdates"`
+	CheckedAt       int64  `json:"checkedAt"`
+	Error           string `json:"error,omitempty"`
+}
+
+// latestPackageUpdates holds the result of the last package update check. current is set
+// once a check has started since the collector was enabled.
+var latestPackageUpdates struct {
+	sync.Mutex
+	result  *PackageUpdates
+	current bool
+}
+
+// packageCheckRequests wakes the background checks to run one right away, when the
+// collector is enabled at runtime.
+var packageCheckRequests = make(chan struct{}, 1)
+
+// packageCommand returns a package manager command that is killed when ctx ends.
+func packageCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = packageCommandWaitDelay
+	return cmd
+}
+
+// nonEmptyLines counts the non-blank lines of command output.
+func nonEmptyLines(out []byte) int {
+	n := 0
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.TrimSpace(line) != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// checkApt counts updates with update-notifier's apt-check, falling back to a simulated upgrade.
+func checkApt(ctx context.Context) (int, int, error) {
+	if _, err := os.Stat("/usr/lib/update-notifier/apt-check"); err == nil {
+		// apt-check prints "<updates>;<security updates>" on stderr.
+		var stderr bytes.Buffer
+		cmd := packageCommand(ctx, "/usr/lib/update-notifier/apt-check")
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err == nil {
+			if a, b, ok := strings.Cut(strings.TrimSpace(stderr.String()), ";"); ok {
+				updates, err1 := strconv.Atoi(a)
+				security, err2 := strconv.Atoi(b)
+				if err1 == nil && err2 == nil {
+					return updates, security, nil
+				}
+			}
+		}
+	}
+	out, err := packageCommand(ctx, "apt-get", "-s", "-o", "Debug::NoLocking=true", "upgrade").Output()
+	if err != nil {
+		return 0, 0, fmt.Errorf("apt-get -s upgrade: %v", err)
+	}
+	updates, security := 0, 0
+	for _, line := range strings.Split(string(out), "\n") {
+		if !strings.HasPrefix(line, "Inst ") {
+			continue
+		}
+		updates++
+		if strings.Contains(line, "-security") {
+			security++
+		}
+	}
+	return updates, security, nil
+}
+
+// checkDnf counts updates with dnf or yum. check-update exits with status 100 when
+// updates are available.
+func checkDnf(ctx context.Context, tool string) (int, int, error) {
+	out, err := packageCommand(ctx, tool, "-q", "check-update").Output()
+	if exitErr, ok := err.(*exec.ExitError); err != nil && !(ok && exitErr.ExitCode() == 100) {
+		return 0, 0, fmt.Errorf("%s check-update: %v", tool, err)
+	}
+	updates := 0
+	for _, line := range strings.Split(string(out), "\n") {
+		// Package lines have three columns; "Obsoleting Packages" sections are indented.
+		if fields := strings.Fields(line); len(fields) == 3 && !strings.HasPrefix(line, " ") {
+			updates++
+		}
+	}
+	security := 0
+	if out, err := packageCommand(ctx, tool, "-q", "updateinfo", "list", "--security").Output(); err == nil {
+		security = nonEmptyLines(out)
+	}
+	return updates, security, nil
+}
+
+// checkZypper counts updates and security patches with zypper.
+func checkZypper(ctx context.Context) (int, int, error) {
+	out, err := packageCommand(ctx, "zypper", "--quiet", "--non-interactive", "list-updates").Output()
+	if err != nil {
+		return 0, 0, fmt.Errorf("zypper list-updates: %v", err)
+	}
+	updates := 0
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "v ") {
+			updates++
+		}
+	}
+	security := 0
+	// zypper exits with 100/101 when patches are needed, so the output is used regardless.
+	out, _ = packageCommand(ctx, "zypper", "--quiet", "--non-interactive", "list-patches", "--category", "security").Output()
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.Contains(line, "| security") && strings.Contains(line, "| needed") {
+			security++
+		}
+	}
+	return updates, security, nil
+}
+
+// checkPkg counts outdated packages and packages with known vulnerabilities on FreeBSD.
+func checkPkg(ctx context.Context) (int, int, error) {
+	out, err := packageCommand(ctx, "pkg", "version", "-vRL=").Output()
+	if err != nil {
+		return 0, 0, fmt.Errorf("pkg version: %v", err)
+	}
+	updates := nonEmptyLines(out)
+	// pkg audit exits with status 1 when vulnerable packages are found.
+	audit, _ := packageCommand(ctx, "pkg", "audit", "-q").Output()
+	return updates, nonEmptyLines(audit), nil
+}
+
+// checkPackageUpdates counts pending updates with the first supported package manager
+// found, giving up after packageCheckTimeout.
+func checkPackageUpdates() *PackageUpdates {
+	managers := []struct {
+		name, bin string
+		check     func(context.Context) (int, int, error)
+	}{
+		{"apt", "apt-get", checkApt},
+		{"dnf", "dnf", func(ctx context.Context) (int, int, error) { return checkDnf(ctx, "dnf") }},
+		{"yum", "yum", func(ctx context.Context) (int, int, error) { return checkDnf(ctx, "yum") }},
+		{"zypper", "zypper", checkZypper},
+		{"pkg", "pkg", checkPkg},
+	}
+	for _, m := range managers {
+		if _, err := exec.LookPath(m.bin); err != nil {
+			continue
+		}
+		result := &PackageUpdates{Manager: m.name, CheckedAt: time.Now().UnixMilli()}
+		ctx, cancel := context.WithTimeout(context.Background(), packageCheckTimeout)
+		updates, security, err := m.check(ctx)
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("check timed out after %s", packageCheckTimeout)
+		}
+		cancel()
+		if err != nil {
+			result.Error = err.Error()
+		}
+		result.Updates, result.SecurityUpdates = updates, security
+		return result
+	}
+	return nil
+}
+
+// startPackageUpdateChecks counts pending package updates every PACKAGE_UPDATES_INTERVAL
+// seconds in the background, since package managers can take a long time to answer. A
+// collector enabled at runtime is checked right away rather than at the next interval.
+func startPackageUpdateChecks() {
+	interval := defaultPackageUpdatesInterval
+	if s := os.Getenv("PACKAGE_UPDATES_INTERVAL"); s != "" {
+		if seconds, err := strconv.Atoi(s); err == nil && seconds > 0 {
+			interval = time.Duration(seconds) * time.Second
+		} else {
+			fmt.Printf("Invalid PACKAGE_UPDATES_INTERVAL value, using default %s: %s\n", interval, s)
+		}
+	}
+	supervise("package update check", func() {
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+			case <-packageCheckRequests:
+				timer.Stop()
+			}
+			if collectorEnabled("PACKAGE_UPDATES", false) {
+				latestPackageUpdates.Lock()
+				latestPackageUpdates.current = true
+				latestPackageUpdates.Unlock()
+				result := checkPackageUpdates()
+				latestPackageUpdates.Lock()
+				latestPackageUpdates.result = result
+				latestPackageUpdates.Unlock()
+				// Requests made before or during this check are answered by it.
+				select {
+				case <-packageCheckRequests:
+				default:
+				}
+			}
+			timer.Reset(interval)
+		}
+	})
+}
+
+// collectPackageUpdates returns the result of the last package update check, and requests
+// a check when none has run since the collector was enabled.
+func collectPackageUpdates() *PackageUpdates {
+	enabled := collectorEnabled("PACKAGE_UPDATES", false)
+	latestPackageUpdates.Lock()
+	defer latestPackageUpdates.Unlock()
+	if !enabled {
+		latestPackageUpdates.result, latestPackageUpdates.current = nil, false
+		return nil
+	}
+	if !latestPackageUpdates.current {
+		select {
+		case packageCheckRequests <- struct{}{}:
+		default:
+		}
+	}
+	return latestPackageUpdates.result
+}
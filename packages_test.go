@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestCollectPackageUpdatesRequestsFirstCheck(t *testing.T) {
+	t.Cleanup(func() {
+		latestPackageUpdates.result, latestPackageUpdates.current = nil, false
+		select {
+		case <-packageCheckRequests:
+		default:
+		}
+	})
+	requested := func() bool {
+		select {
+		case <-packageCheckRequests:
+			return true
+		default:
+			return false
+		}
+	}
+
+	t.Setenv("PACKAGE_UPDATES", "false")
+	if collectPackageUpdates() != nil || requested() {
+		t.Fatal("disabled collector requested a check")
+	}
+	t.Setenv("PACKAGE_UPDATES", "true")
+	collectPackageUpdates()
+	if !requested() {
+		t.Fatal("enabled collector did not request its first check")
+	}
+
+	latestPackageUpdates.current = true
+	latestPackageUpdates.result = &PackageUpdates{Manager: "apt", Updates: 3}
+	if got := collectPackageUpdates(); got == nil || got.Updates != 3 || requested() {
+		t.Fatalf("got %+v, want the last result without a new check", got)
+	}
+
+	// Disabling the collector forgets the result, so enabling it again checks right away.
+	t.Setenv("PACKAGE_UPDATES", "false")
+	collectPackageUpdates()
+	t.Setenv("PACKAGE_UPDATES", "true")
+	if got := collectPackageUpdates(); got != nil || !requested() {
+		t.Errorf("got %+v after re-enabling, want no result and a new check", got)
+	}
+}
+
+func TestPackageCommandTimeout(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep not available")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := packageCommand(ctx, "sleep", "10").Run(); err == nil {
+		t.Fatal("command outlived its context")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("command stopped after %s", d)
+	}
+}
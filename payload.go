@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// structuredSchemaVersion identifies the sectioned payload format.
+const structuredSchemaVersion = 2
+
+// StructuredMetrics is the sectioned metrics payload. It carries the same data as the
+// legacy flat Metrics format, grouped by subsystem.
+type StructuredMetrics struct {
+	SchemaVersion int                        `json:"schemaVersion"`
+	AgentID       string                     `json:"agentId"`
+	TenantID      string                     `json:"tenantId,omitempty"`
+	Maintenance   bool                       `json:"maintenance,omitempty"`
+	Blackout      []string                   `json:"blackout,omitempty"`
+	Seq           uint64                     `json:"seq"`
+	Hostname      string                     `json:"hostname"`
+	IP            string                     `json:"ip"`
+	Timestamp     int64                      `json:"timestamp"`
+	Host          *HostSection               `json:"host,omitempty"`
+	CPU           CPUSection                 `json:"cpu"`
+	Memory        MemorySection              `json:"memory"`
+	Disks         []DiskUsage                `json:"disks"`
+	Networks      []InterfaceStats           `json:"networks"`
+	Processes     []ProcessInfo              `json:"processes"`
+	ProcessCounts *ProcessCounts             `json:"processCounts,omitempty"`
+	Checks        []Check                    `json:"checks"`
+	Container     *ContainerSection          `json:"container,omitempty"`
+	Containers    *ContainerRuntimeStats     `json:"containers,omitempty"`
+	Storage       *StorageSection            `json:"storage,omitempty"`
+	Apps          *AppsSection               `json:"apps,omitempty"`
+	Network       *NetworkSection            `json:"network,omitempty"`
+	Pressure      *PressureStats             `json:"pressure,omitempty"`
+	FileHandles   *FileHandleStats           `json:"fileHandles,omitempty"`
+	Power         *MacPowerStats             `json:"power,omitempty"`
+	Agent         *SelfStats                 `json:"agent,omitempty"`
+	Plugins       map[string]PluginResult    `json:"plugins,omitempty"`
+	Wasm          map[string]PluginResult    `json:"wasm,omitempty"`
+	Scripts       map[string]PluginResult    `json:"scripts,omitempty"`
+	SQL           []SQLResult                `json:"sql,omitempty"`
+	JSONScrapes   []ScrapeResult             `json:"jsonScrapes,omitempty"`
+	Collectors    map[string]CollectorHealth `json:"collectorHealth,omitempty"`
+	Disabled      []string                   `json:"disabledCollectors,omitempty"`
+	Custom        map[string]float64         `json:"customMetrics,omitempty"`
+	Flags         map[string]bool            `json:"flags,omitempty"`
+	Events        []Event                    `json:"events,omitempty"`
+	CollectedAt   map[string]int64           `json:"collectedAt,omitempty"`
+}
+
+// HostSection holds host metadata such as patch compliance state.
+type HostSection struct {
+	Reboot   *RebootStatus    `json:"reboot,omitempty"`
+	Packages *PackageUpdates  `json:"packageUpdates,omitempty"`
+	Security *SecurityModules `json:"securityModules,omitempty"`
+	// Inventory is only present in the payload following each Windows inventory snapshot.
+	Inventory *WindowsInventory `json:"inventory,omitempty"`
+}
+
+// CPUSection is the cpu section of the structured payload.
+type CPUSection struct {
+	UsagePercent  float64 `json:"usagePercent"`
+	Cores         int     `json:"cores"`
+	PhysicalCores int     `json:"physicalCores,omitempty"`
+}
+
+// MemorySection is the memory section of the structured payload.
+type MemorySection struct {
+	UsagePercent float64         `json:"usagePercent"`
+	UsedBytes    uint64          `json:"usedBytes"`
+	TotalBytes   uint64          `json:"totalBytes"`
+	Topology     *MemoryTopology `json:"topology,omitempty"`
+}
+
+// Check is the result of one check (latency probe, file freshness check, port check,
+// bandwidth test or wrapped job).
+type Check struct {
+	Type      string           `json:"type"`
+	Name      string           `json:"name"`
+	OK        bool             `json:"ok"`
+	Latency   *LatencyResult   `json:"latency,omitempty"`
+	Bandwidth *BandwidthResult `json:"bandwidth,omitempty"`
+	Freshness *FreshnessResult `json:"fileFreshness,omitempty"`
+	Port      *PortStatus      `json:"port,omitempty"`
+	Job       *JobResult       `json:"job,omitempty"`
+}
+
+// ContainerSection describes the container the agent runs in.
+type ContainerSection struct {
+	Cgroup *CgroupStats `json:"cgroup,omitempty"`
+}
+
+// StorageSection groups block device and volume manager data.
+type StorageSection struct {
+	Busy  []DeviceBusy  `json:"busy,omitempty"`
+	Pools []StoragePool `json:"pools,omitempty"`
+	RAID  []RAIDArray   `json:"raid,omitempty"`
+	LVM   *LVMStats     `json:"lvm,omitempty"`
+}
+
+// AppsSection groups the counters of the server applications running on the host.
+type AppsSection struct {
+	IIS   *IISStats       `json:"iis,omitempty"`
+	MSSQL []MSSQLInstance `json:"mssql,omitempty"`
+}
+
+// NetworkSection groups host-wide network stack data.
+type NetworkSection struct {
+	TCP            *TCPStats         `json:"tcp,omitempty"`
+	Conntrack      *ConntrackStats   `json:"conntrack,omitempty"`
+	Route          *RouteInfo        `json:"route,omitempty"`
+	Neighbors      *NeighborStats    `json:"neighbors,omitempty"`
+	Discovery      *LANDiscovery     `json:"discovery,omitempty"`
+	Assets         []DiscoveredAsset `json:"assets,omitempty"`
+	Firewall       *FirewallInfo     `json:"firewall,omitempty"`
+	ProcessNetwork []ProcessNetStats `json:"processNetwork,omitempty"`
+}
+
+// legacyPayload reports whether the flat pre-sections payload format is selected with
+// PAYLOAD_FORMAT=legacy, for servers that do not understand the structured format yet.
+// Without PAYLOAD_FORMAT, the schema chosen in the server's handshake applies.
+func legacyPayload() bool {
+	switch format := os.Getenv("PAYLOAD_FORMAT"); format {
+	case "":
+		return negotiatedSchema() == legacySchemaVersion
+	case "structured":
+		return false
+	case "legacy":
+		return true
+	default:
+		fmt.Printf("Invalid PAYLOAD_FORMAT value, using structured: %s\n", format)
+		return false
+	}
+}
+
+// buildPayload returns the metrics in the configured payload format.
+func buildPayload(m Metrics) interface{} {
+	if legacyPayload() {
+		return m
+	}
+	return structurePayload(m)
+}
+
+// structurePayload arranges flat metrics into the structured payload sections.
+func structurePayload(m Metrics) StructuredMetrics {
+	p := StructuredMetrics{
+		SchemaVersion: structuredSchemaVersion,
+		AgentID:       m.AgentID,
+		TenantID:      m.TenantID,
+		Maintenance:   m.Maintenance,
+		Blackout:      m.Blackout,
+		Seq:           m.Seq,
+		Hostname:      m.Hostname,
+		IP:            m.IP,
+		Timestamp:     m.Timestamp,
+		CPU: CPUSection{
+			UsagePercent:  m.CPUUsage,
+			Cores:         m.CPUCores,
+			PhysicalCores: m.CPUPhysicalCores,
+		},
+		Memory: MemorySection{
+			UsagePercent: m.RAMUsage,
+			UsedBytes:    m.RAMUsedBytes,
+			TotalBytes:   m.RAMTotalBytes,
+			Topology:     m.MemTopo,
+		},
+		Disks:         disksWithRoot(m),
+		Networks:      m.Interfaces,
+		Processes:     m.TopProcesses,
+		ProcessCounts: m.ProcessCounts,
+		Checks:        checkResults(m),
+		Containers:    m.Containers,
+		Pressure:      m.Pressure,
+		FileHandles:   m.FileHandles,
+		Power:         m.MacPower,
+		Agent:         m.Self,
+		Plugins:       m.Plugins,
+		Wasm:          m.Wasm,
+		Scripts:       m.Scripts,
+		SQL:           m.SQL,
+		JSONScrapes:   m.JSONScrapes,
+		Collectors:    m.CollectorHealth,
+		Disabled:      m.DisabledCollectors,
+		Custom:        m.Custom,
+		Flags:         m.Flags,
+		Events:        m.Events,
+		CollectedAt:   m.CollectedAt,
+	}
+	if m.Reboot != nil || m.Packages != nil || m.Security != nil || m.WindowsInventory != nil {
+		p.Host = &HostSection{Reboot: m.Reboot, Packages: m.Packages, Security: m.Security, Inventory: m.WindowsInventory}
+	}
+	if m.Container {
+		p.Container = &ContainerSection{Cgroup: m.Cgroup}
+	}
+	if m.DiskBusy != nil || m.Pools != nil || m.RAID != nil || m.LVM != nil {
+		p.Storage = &StorageSection{Busy: m.DiskBusy, Pools: m.Pools, RAID: m.RAID, LVM: m.LVM}
+	}
+	if m.IIS != nil || m.MSSQL != nil {
+		p.Apps = &AppsSection{IIS: m.IIS, MSSQL: m.MSSQL}
+	}
+	if m.TCP != nil || m.Conntrack != nil || m.Route != nil || m.Neighbors != nil || m.LANDiscovery != nil || m.Assets != nil || m.Firewall != nil || m.ProcNet != nil {
+		p.Network = &NetworkSection{
+			TCP:            m.TCP,
+			Conntrack:      m.Conntrack,
+			Route:          m.Route,
+			Neighbors:      m.Neighbors,
+			Discovery:      m.LANDiscovery,
+			Assets:         m.Assets,
+			Firewall:       m.Firewall,
+			ProcessNetwork: m.ProcNet,
+		}
+	}
+	return p
+}
+
+// disksWithRoot returns the mounted filesystems, making sure the root filesystem (whose
+// usage is always collected) is included.
+func disksWithRoot(m Metrics) []DiskUsage {
+	root := rootMountpoint()
+	for _, d := range m.Disks {
+		if d.Mountpoint == root {
+			return m.Disks
+		}
+	}
+	rootDisk := DiskUsage{
+		Mountpoint:   root,
+		TotalBytes:   m.DiskTotalBytes,
+		UsedBytes:    m.DiskUsedBytes,
+		UsagePercent: m.DiskUsage,
+	}
+	return append([]DiskUsage{rootDisk}, m.Disks...)
+}
+
+// checkResults lists latency probes, file freshness checks, port checks and the bandwidth
+// test as check results.
+func checkResults(m Metrics) []Check {
+	var list []Check
+	for i := range m.Latency {
+		l := &m.Latency[i]
+		list = append(list, Check{Type: "latency", Name: l.Name, OK: l.Error == "", Latency: l})
+	}
+	for i := range m.Freshness {
+		f := &m.Freshness[i]
+		list = append(list, Check{Type: "file_freshness", Name: f.Name, OK: !f.Stale, Freshness: f})
+	}
+	for i := range m.PortStatus {
+		p := &m.PortStatus[i]
+		list = append(list, Check{Type: "port", Name: strconv.Itoa(p.Port), OK: p.Open, Port: p})
+	}
+	for i := range m.Jobs {
+		j := &m.Jobs[i]
+		list = append(list, Check{Type: "job", Name: j.Name, OK: !j.failed() && !j.Overdue, Job: j})
+	}
+	if m.Bandwidth != nil {
+		list = append(list, Check{Type: "bandwidth", Name: "bandwidth", OK: m.Bandwidth.Error == "", Bandwidth: m.Bandwidth})
+	}
+	return list
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStructurePayloadSections(t *testing.T) {
+	tests := []struct {
+		name                                    string
+		m                                       Metrics
+		host, container, storage, apps, network bool
+	}{
+		{name: "bare"},
+		{name: "reboot", m: Metrics{Reboot: &RebootStatus{Pending: true}}, host: true},
+		{name: "container", m: Metrics{Container: true}, container: true},
+		{name: "not containerized", m: Metrics{Cgroup: &CgroupStats{}}},
+		{name: "raid", m: Metrics{RAID: []RAIDArray{{}}}, storage: true},
+		{name: "iis", m: Metrics{IIS: &IISStats{State: "running"}}, apps: true},
+		{name: "tcp", m: Metrics{TCP: &TCPStats{OutSegs: 10}}, network: true},
+	}
+	for _, tt := range tests {
+		p := structurePayload(tt.m)
+		got := []bool{p.Host != nil, p.Container != nil, p.Storage != nil, p.Apps != nil, p.Network != nil}
+		want := []bool{tt.host, tt.container, tt.storage, tt.apps, tt.network}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: host, container, storage, apps, network sections present = %v, want %v", tt.name, got, want)
+		}
+		if p.SchemaVersion != structuredSchemaVersion {
+			t.Errorf("%s: schema version %d, want %d", tt.name, p.SchemaVersion, structuredSchemaVersion)
+		}
+	}
+}
+
+func TestStructurePayloadFields(t *testing.T) {
+	m := Metrics{
+		AgentID: "agent-1", Seq: 7, Hostname: "web-1", Timestamp: 1000,
+		CPUUsage: 42, CPUCores: 8, RAMUsage: 60, RAMUsedBytes: 6, RAMTotalBytes: 10,
+		Reboot: &RebootStatus{Pending: true}, TCP: &TCPStats{RetransRate: 0.5},
+	}
+	p := structurePayload(m)
+	if p.AgentID != "agent-1" || p.Seq != 7 || p.Hostname != "web-1" || p.Timestamp != 1000 {
+		t.Errorf("identity fields not copied: %+v", p)
+	}
+	if p.CPU != (CPUSection{UsagePercent: 42, Cores: 8}) {
+		t.Errorf("cpu section = %+v", p.CPU)
+	}
+	if p.Memory.UsagePercent != 60 || p.Memory.UsedBytes != 6 || p.Memory.TotalBytes != 10 {
+		t.Errorf("memory section = %+v", p.Memory)
+	}
+	if p.Host.Reboot != m.Reboot || p.Network.TCP != m.TCP {
+		t.Error("sections do not hold the collected data")
+	}
+}
+
+func TestDisksWithRoot(t *testing.T) {
+	root := rootMountpoint()
+	other := DiskUsage{Mountpoint: "/data", TotalBytes: 100}
+	tests := []struct {
+		name  string
+		disks []DiskUsage
+		want  []DiskUsage
+	}{
+		{"no disks listed", nil, []DiskUsage{{Mountpoint: root, TotalBytes: 50, UsedBytes: 25, UsagePercent: 50}}},
+		{"root missing", []DiskUsage{other}, []DiskUsage{{Mountpoint: root, TotalBytes: 50, UsedBytes: 25, UsagePercent: 50}, other}},
+		{"root listed", []DiskUsage{other, {Mountpoint: root, Device: "sda1"}}, []DiskUsage{other, {Mountpoint: root, Device: "sda1"}}},
+	}
+	for _, tt := range tests {
+		m := Metrics{Disks: tt.disks, DiskTotalBytes: 50, DiskUsedBytes: 25, DiskUsage: 50}
+		if got := disksWithRoot(m); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckResults(t *testing.T) {
+	m := Metrics{
+		Latency:    []LatencyResult{{Name: "db"}, {Name: "gw", Error: "timeout"}},
+		Freshness:  []FreshnessResult{{Name: "backup", Stale: true}},
+		PortStatus: []PortStatus{{Port: 443, Open: true}},
+		Bandwidth:  &BandwidthResult{DownloadMbps: 100},
+	}
+	type result struct {
+		typ, name string
+		ok        bool
+	}
+	var got []result
+	for _, c := range checkResults(m) {
+		got = append(got, result{c.Type, c.Name, c.OK})
+	}
+	want := []result{
+		{"latency", "db", true},
+		{"latency", "gw", false},
+		{"file_freshness", "backup", false},
+		{"port", "443", true},
+		{"bandwidth", "bandwidth", true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
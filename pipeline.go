@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// defaultQueueSize is the capacity of each pipeline queue.
+const defaultQueueSize = 10
+
+// Queue drop policies, selected with PIPELINE_DROP_POLICY.
+const (
+	// dropOldest discards the oldest queued item to make room for a new one.
+	dropOldest = "drop_oldest"
+	// dropNewest discards the new item when the queue is full.
+	dropNewest = "drop_newest"
+	// blockWhenFull makes the producing stage wait for room (backpressure): the collector
+	// then skips the ticks it misses instead of piling up collections.
+	blockWhenFull = "block"
+)
+
+// batch is a numbered, serialized metrics payload ready for delivery.
+type batch struct {
+	seq  uint64
+	data []byte
+}
+
+// boundedQueue is a fixed-capacity channel between two pipeline stages with a drop policy.
+type boundedQueue[T any] struct {
+	name    string
+	ch      chan T
+	policy  string
+	dropped atomic.Uint64
+}
+
+// newBoundedQueue creates a queue with the configured capacity and drop policy.
+func newBoundedQueue[T any](name string, size int, policy string) *boundedQueue[T] {
+	return &boundedQueue[T]{name: name, ch: make(chan T, size), policy: policy}
+}
+
+// push adds v to the queue according to its drop policy and reports whether v was queued.
+// Unless wait is set, the block policy drops v like drop_newest instead of waiting for room.
+func (q *boundedQueue[T]) push(v T, wait bool) bool {
+	switch {
+	case q.policy == blockWhenFull && wait:
+		q.ch <- v
+		return true
+	case q.policy == dropNewest, q.policy == blockWhenFull:
+		select {
+		case q.ch <- v:
+			return true
+		default:
+			q.dropped.Add(1)
+			fmt.Printf("Pipeline queue %s is full, dropping the new item\n", q.name)
+			return false
+		}
+	default:
+		for {
+			select {
+			case q.ch <- v:
+				return true
+			default:
+			}
+			select {
+			case <-q.ch:
+				q.dropped.Add(1)
+				fmt.Printf("Pipeline queue %s is full, dropping the oldest item\n", q.name)
+			default:
+			}
+		}
+	}
+}
+
+// pipeline connects the collector, processor and output stages. Each stage runs in its
+// own goroutine, so a slow collector, a slow transformation or an unreachable server
+// only stalls its own stage; the queue to the processor absorbs short hiccups and applies
+// the drop policy when it falls behind for longer. The processor spools each batch itself,
+// so the outputs only receive a flush signal (see destination.requestFlush) and a slow
+// server never makes the pipeline drop a batch.
+var pipeline struct {
+	collected *boundedQueue[Metrics]
+}
+
+// pipelineDropPolicy reads PIPELINE_DROP_POLICY.
+func pipelineDropPolicy() string {
+	switch policy := os.Getenv("PIPELINE_DROP_POLICY"); policy {
+	case "":
+		return dropOldest
+	case dropOldest, dropNewest, blockWhenFull:
+		return policy
+	default:
+		fmt.Printf("Invalid PIPELINE_DROP_POLICY value, using %s: %s\n", dropOldest, policy)
+		return dropOldest
+	}
+}
+
+// processMetrics is the processor stage: it turns a numbered metrics sample into the
+// transformed payload sent to the servers.
+func processMetrics(metrics Metrics) (batch, error) {
+	data, err := json.Marshal(buildPayload(metrics))
+	if err != nil {
+		return batch{}, fmt.Errorf("failed to marshal metrics: %v", err)
+	}
+	if data, err = transformPayload(data); err != nil {
+		return batch{}, fmt.Errorf("failed to transform metrics: %v", err)
+	}
+	return batch{seq: metrics.Seq, data: data}, nil
+}
+
+// spoolOutputs writes a processed batch to the spool of every destination and signals
+// their output stages. Batches stay in the spool until the server acknowledges them,
+// giving at-least-once delivery; servers can deduplicate on (agentId, seq).
+func spoolOutputs(b batch) {
+	for _, d := range destinations {
+		if err := d.spoolBatch(b.seq, b.data); err != nil {
+			fmt.Printf("Error spooling metrics for %s server: %v\n", d.name, err)
+			continue
+		}
+		d.requestFlush()
+	}
+}
+
+// submitMetrics numbers a collected sample and hands it to the processor stage, reporting
+// whether it was queued. Numbering happens before queueing, so a sample dropped anywhere
+// in the pipeline or spool leaves a gap in the sequence the server can detect. Unless
+// wait is set, the sample is dropped rather than waiting for room with the block policy,
+// so callers such as API requests never hang on a stalled pipeline.
+func submitMetrics(metrics *Metrics, wait bool) bool {
+	metrics.Seq = nextSeq()
+	return pipeline.collected.push(*metrics, wait)
+}
+
+// startPipeline starts the pipeline stages: a collector collecting every interval, starting
+// immediately, a processor, and one output per destination.
+func startPipeline(interval time.Duration) {
+	size := envInt("PIPELINE_QUEUE_SIZE", defaultQueueSize)
+	if size < 1 {
+		size = 1
+	}
+	policy := pipelineDropPolicy()
+	pipeline.collected = newBoundedQueue[Metrics]("collected", size, policy)
+	for _, d := range destinations {
+		supervise("output "+d.name, func() {
+			for range d.flushes {
+				if err := d.flushSpool(); err != nil {
+					fmt.Printf("Error sending metrics to %s server: %v\n", d.name, err)
+				}
+			}
+		})
+	}
+
+	supervise("processor", func() {
+		for metrics := range pipeline.collected.ch {
+			b, err := processMetrics(metrics)
+			if err != nil {
+				fmt.Printf("Error processing metrics: %v\n", err)
+				continue
+			}
+			cacheMetrics(metrics, b.data)
+			spoolOutputs(b)
+		}
+	})
+
+	supervise("collector", func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			metrics, err := collectMetrics()
+			if err != nil {
+				fmt.Printf("Error collecting metrics: %v\n", err)
+			} else {
+				submitMetrics(&metrics, true)
+			}
+			<-ticker.C
+		}
+	})
+}
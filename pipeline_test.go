@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBoundedQueuePush(t *testing.T) {
+	tests := []struct {
+		policy  string
+		wait    bool
+		want    []int
+		dropped uint64
+	}{
+		{dropOldest, false, []int{2, 3}, 1},
+		{dropNewest, false, []int{1, 2}, 1},
+		// Without wait the block policy drops the new item instead of blocking the caller.
+		{blockWhenFull, false, []int{1, 2}, 1},
+	}
+	for _, tt := range tests {
+		q := newBoundedQueue[int]("test", 2, tt.policy)
+		for v := 1; v <= 3; v++ {
+			q.push(v, tt.wait)
+		}
+		var got []int
+		for len(q.ch) > 0 {
+			got = append(got, <-q.ch)
+		}
+		if len(got) != len(tt.want) || got[0] != tt.want[0] || got[1] != tt.want[1] || q.dropped.Load() != tt.dropped {
+			t.Errorf("%s: got %v with %d dropped, want %v with %d", tt.policy, got, q.dropped.Load(), tt.want, tt.dropped)
+		}
+	}
+}
+
+func TestBoundedQueueBlocks(t *testing.T) {
+	q := newBoundedQueue[int]("test", 1, blockWhenFull)
+	q.push(1, true)
+	done := make(chan bool)
+	go func() { done <- q.push(2, true) }()
+	select {
+	case <-done:
+		t.Fatal("push returned while the queue was full")
+	case <-time.After(20 * time.Millisecond):
+	}
+	<-q.ch
+	if !<-done || <-q.ch != 2 {
+		t.Error("blocked item was not queued once room was made")
+	}
+}
+
+func TestRequestFlushCoalesces(t *testing.T) {
+	d := newDestination("primary", "http://127.0.0.1", "spool", true)
+	for range 3 {
+		d.requestFlush()
+	}
+	if len(d.flushes) != 1 {
+		t.Errorf("got %d pending flushes, want 1", len(d.flushes))
+	}
+}
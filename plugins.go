@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// pluginCollectMethod is the gRPC method every collector plugin serves:
+//
+//	service Collector { rpc Collect(google.protobuf.Empty) returns (google.protobuf.Struct); }
+const pluginCollectMethod = "/cheetah.plugin.Collector/Collect"
+
+// pluginHandshake must match the handshake configured by collector plugins.
+var pluginHandshake = plugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "CHEETAH_PLUGIN",
+	MagicCookieValue: "collector",
+}
+
+// PluginResult holds the metrics returned by one external collector plugin.
+type PluginResult struct {
+	Metrics map[string]interface{} `json:"metrics,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// collectorPlugin is the host side of the go-plugin "collector" plugin type. Only gRPC is supported.
+type collectorPlugin struct {
+	plugin.NetRPCUnsupportedPlugin
+}
+
+// GRPCServer is only implemented by plugins; the agent never serves collectors.
+func (collectorPlugin) GRPCServer(*plugin.GRPCBroker, *grpc.Server) error {
+	return fmt.Errorf("collector plugins are served by plugin processes only")
+}
+
+// GRPCClient returns the client used by the agent to call a plugin.
+func (collectorPlugin) GRPCClient(_ context.Context, _ *plugin.GRPCBroker, conn *grpc.ClientConn) (interface{}, error) {
+	return &pluginCollector{conn: conn}, nil
+}
+
+// pluginCollector calls the Collect method of a running plugin.
+type pluginCollector struct {
+	conn *grpc.ClientConn
+}
+
+// Collect asks the plugin for its current metrics.
+func (c *pluginCollector) Collect(ctx context.Context) (map[string]interface{}, error) {
+	out := &structpb.Struct{}
+	if err := c.conn.Invoke(ctx, pluginCollectMethod, &emptypb.Empty{}, out); err != nil {
+		return nil, err
+	}
+	return out.AsMap(), nil
+}
+
+// runningPlugin is a launched plugin process and its client.
+type runningPlugin struct {
+	client    *plugin.Client
+	collector *pluginCollector
+}
+
+// plugins holds the launched plugin processes, keyed by executable path.
+var plugins struct {
+	sync.Mutex
+	running map[string]*runningPlugin
+}
+
+// pluginTimeout returns the maximum time a plugin may take to answer, from PLUGIN_TIMEOUT in seconds.
+func pluginTimeout() time.Duration {
+	if s := os.Getenv("PLUGIN_TIMEOUT"); s != "" {
+		if seconds, err := strconv.Atoi(s); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		fmt.Printf("Invalid PLUGIN_TIMEOUT value, using default 10 seconds: %s\n", s)
+	}
+	return 10 * time.Second
+}
+
+// pluginExecutables returns the plugin executables found in dir.
+func pluginExecutables(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		fmt.Printf("Error reading plugin directory: %v\n", err)
+		return nil
+	}
+	var paths []string
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if runtime.GOOS == "windows" {
+			if !strings.EqualFold(filepath.Ext(e.Name()), ".exe") {
+				continue
+			}
+		} else if info.Mode().Perm()&0o111 == 0 {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, e.Name()))
+	}
+	return paths
+}
+
+// startPlugin launches a plugin process and connects to it.
+func startPlugin(path string) (*runningPlugin, error) {
+	client := plugin.NewClient(&plugin.ClientConfig{
+		HandshakeConfig:  pluginHandshake,
+		Plugins:          map[string]plugin.Plugin{"collector": collectorPlugin{}},
+		Cmd:              exec.Command(path),
+		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+		Managed:          true,
+		Logger: hclog.New(&hclog.LoggerOptions{
+			Name:   "plugin",
+			Output: os.Stdout,
+			Level:  hclog.Warn,
+		}),
+	})
+	rpcClient, err := client.Client()
+	if err != nil {
+		client.Kill()
+		return nil, fmt.Errorf("failed to start plugin: %v", err)
+	}
+	raw, err := rpcClient.Dispense("collector")
+	if err != nil {
+		client.Kill()
+		return nil, fmt.Errorf("failed to dispense plugin: %v", err)
+	}
+	return &runningPlugin{client: client, collector: raw.(*pluginCollector)}, nil
+}
+
+// pluginFor returns the running plugin for path, (re)starting it if it is not running.
+func pluginFor(path string) (*runningPlugin, error) {
+	plugins.Lock()
+	defer plugins.Unlock()
+	if p, ok := plugins.running[path]; ok && !p.client.Exited() {
+		return p, nil
+	}
+	p, err := startPlugin(path)
+	if err != nil {
+		return nil, err
+	}
+	if plugins.running == nil {
+		plugins.running = make(map[string]*runningPlugin)
+	}
+	plugins.running[path] = p
+	return p, nil
+}
+
+// stopRemovedPlugins kills plugins whose executable is no longer in the plugin directory.
+func stopRemovedPlugins(paths []string) {
+	present := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		present[path] = true
+	}
+	plugins.Lock()
+	defer plugins.Unlock()
+	for path, p := range plugins.running {
+		if !present[path] {
+			p.client.Kill()
+			delete(plugins.running, path)
+		}
+	}
+}
+
+// collectPlugins runs every external collector plugin in PLUGIN_DIR concurrently and returns
+// their metrics keyed by executable name. Plugins run as separate processes speaking gRPC
+// (hashicorp/go-plugin), are kept running between cycles and restarted if they exit.
+func collectPlugins() map[string]PluginResult {
+	dir := os.Getenv("PLUGIN_DIR")
+	if dir == "" || !collectorEnabled("PLUGINS", true) {
+		return nil
+	}
+	paths := pluginExecutables(dir)
+	stopRemovedPlugins(paths)
+	if len(paths) == 0 {
+		recordPartDurations("plugins", nil)
+		return nil
+	}
+
+	results := make(map[string]PluginResult, len(paths))
+	durations := make(map[string]time.Duration, len(paths))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, path := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			var result PluginResult
+			p, err := pluginFor(path)
+			if err == nil {
+				ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout())
+				result.Metrics, err = p.collector.Collect(ctx)
+				cancel()
+			}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+			mu.Lock()
+			results[name] = result
+			durations[name] = time.Since(start)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	recordPartDurations("plugins", durations)
+	return results
+}
+
+// stopPlugins kills every running plugin process.
+func stopPlugins() {
+	plugin.CleanupClients()
+}
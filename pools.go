@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// StoragePool reports the health of a ZFS pool or Btrfs filesystem.
+type StoragePool struct {
+	Type        string  `json:"type"`
+	Name        string  `json:"name"`
+	Health      string  `json:"health"`
+	SizeBytes   uint64  `json:"sizeBytes"`
+	AllocBytes  uint64  `json:"allocBytes"`
+	FragPercent float64 `json:"fragPercent,omitempty"`
+	Scrub       string  `json:"scrub,omitempty"`
+	Errors      uint64  `json:"errors"`
+}
+
+// lastPoolHealth holds the previous health of each pool, used to emit change events.
+var lastPoolHealth struct {
+	sync.Mutex
+	health map[string]string
+}
+
+// readZFSPools lists ZFS pools with their health, capacity, fragmentation and scrub status.
+func readZFSPools() []StoragePool {
+	out, err := exec.Command("zpool", "list", "-H", "-p", "-o", "name,health,size,alloc,frag").Output()
+	if err != nil {
+		return nil
+	}
+	var pools []StoragePool
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 5 {
+			continue
+		}
+		pool := StoragePool{Type: "zfs", Name: fields[0], Health: fields[1]}
+		pool.SizeBytes, _ = strconv.ParseUint(fields[2], 10, 64)
+		pool.AllocBytes, _ = strconv.ParseUint(fields[3], 10, 64)
+		pool.FragPercent, _ = strconv.ParseFloat(strings.TrimSuffix(fields[4], "%"), 64)
+		if status, err := exec.Command("zpool", "status", "-p", pool.Name).Output(); err == nil {
+			for _, l := range strings.Split(string(status), "\n") {
+				l = strings.TrimSpace(l)
+				if scan, ok := strings.CutPrefix(l, "scan:"); ok {
+					pool.Scrub = strings.TrimSpace(scan)
+				}
+				if errs, ok := strings.CutPrefix(l, "errors:"); ok && !strings.Contains(errs, "No known data errors") {
+					pool.Errors = 1
+				}
+			}
+		}
+		pools = append(pools, pool)
+	}
+	return pools
+}
+
+// readBtrfsPools reports every mounted Btrfs filesystem with its device error counters and scrub status.
+func readBtrfsPools() []StoragePool {
+	partitions, err := disk.Partitions(false)
+	if err != nil {
+		return nil
+	}
+	var pools []StoragePool
+	seen := make(map[string]bool)
+	for _, p := range partitions {
+		if p.Fstype != "btrfs" || seen[p.Device] {
+			continue
+		}
+		seen[p.Device] = true
+		pool := StoragePool{Type: "btrfs", Name: p.Mountpoint, Health: "ONLINE"}
+		if usage, err := disk.Usage(p.Mountpoint); err == nil {
+			pool.SizeBytes = usage.Total
+			pool.AllocBytes = usage.Used
+		}
+		// Output lines look like "[/dev/sda].write_io_errs    0".
+		if out, err := exec.Command("btrfs", "device", "stats", p.Mountpoint).Output(); err == nil {
+			for _, l := range strings.Split(string(out), "\n") {
+				fields := strings.Fields(l)
+				if len(fields) == 2 {
+					n, _ := strconv.ParseUint(fields[1], 10, 64)
+					pool.Errors += n
+				}
+			}
+		}
+		if pool.Errors > 0 {
+			pool.Health = "DEGRADED"
+		}
+		if out, err := exec.Command("btrfs", "scrub", "status", p.Mountpoint).Output(); err == nil {
+			for _, l := range strings.Split(string(out), "\n") {
+				if status, ok := strings.CutPrefix(strings.TrimSpace(l), "Status:"); ok {
+					pool.Scrub = strings.TrimSpace(status)
+				}
+			}
+		}
+		pools = append(pools, pool)
+	}
+	return pools
+}
+
+// collectStoragePools reports ZFS pools and Btrfs filesystems and emits a pool.health_changed
+// event whenever a pool's health changes. It is disabled with STORAGE_POOLS=false.
+func collectStoragePools() []StoragePool {
+	if !collectorEnabled("STORAGE_POOLS", true) {
+		return nil
+	}
+	pools := append(readZFSPools(), readBtrfsPools()...)
+
+	lastPoolHealth.Lock()
+	defer lastPoolHealth.Unlock()
+	if lastPoolHealth.health == nil {
+		lastPoolHealth.health = make(map[string]string)
+	}
+	for _, p := range pools {
+		key := p.Type + ":" + p.Name
+		if prev, ok := lastPoolHealth.health[key]; ok && prev != p.Health {
+			emitEvent("pool.health_changed", "%s pool %s health changed from %s to %s", p.Type, p.Name, prev, p.Health)
+		}
+		lastPoolHealth.health[key] = p.Health
+	}
+	return pools
+}
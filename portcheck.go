@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"sync"
+)
+
+// PortStatus is the observed state of a port declared in PORTS.
+type PortStatus struct {
+	Port int  `json:"port"`
+	Open bool `json:"open"`
+	// Address is the address the port answered on (loopback or the agent's IP).
+	Address string `json:"address,omitempty"`
+}
+
+// closedPorts remembers which declared ports were closed at the previous check, so the
+// port.closed event is emitted once per closure.
+var closedPorts struct {
+	sync.Mutex
+	ports map[int]bool
+}
+
+// collectPortStatus checks whether every port declared in PORTS accepts connections, on the
+// loopback interface or, for services bound to a specific address, on the agent's IP.
+// A port.closed event is emitted when an expected port stops accepting connections.
+func collectPortStatus() []PortStatus {
+	portsEnv := os.Getenv("PORTS")
+	if portsEnv == "" || !collectorEnabled("PORTS_VERIFY", false) || checksSuppressed() {
+		return nil
+	}
+	ports, err := parsePorts(portsEnv)
+	if err != nil {
+		return nil
+	}
+	hosts := []string{"127.0.0.1"}
+	if ip, err := getLocalIP(); err == nil {
+		hosts = append(hosts, ip)
+	}
+	timeout := scanTimeout()
+
+	results := make([]PortStatus, len(ports))
+	sem := make(chan struct{}, max(envInt("SCAN_CONCURRENCY", 100), minScanConcurrency))
+	var wg sync.WaitGroup
+	for i, port := range ports {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = PortStatus{Port: port}
+			for _, host := range hosts {
+				if probePort(host, port, timeout) == portOpen {
+					results[i].Open = true
+					results[i].Address = host
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	closedPorts.Lock()
+	defer closedPorts.Unlock()
+	current := make(map[int]bool)
+	for _, r := range results {
+		if !r.Open {
+			if !closedPorts.ports[r.Port] {
+				emitEvent("port.closed", "expected port %d is not accepting connections", r.Port)
+			}
+			current[r.Port] = true
+		}
+	}
+	closedPorts.ports = current
+	return results
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Port scan tuning limits.
+const (
+	minScanConcurrency = 4
+	scanBatchSize      = 1024
+	// scanErrorThreshold is the share of probes in a batch that may time out or fail for
+	// lack of resources before the scan concurrency is halved.
+	scanErrorThreshold = 0.05
+)
+
+// scanConfig is the port scan configuration read from the SCAN_* environment variables.
+type scanConfig struct {
+	ports       []int
+	concurrency int
+	timeout     time.Duration
+}
+
+// loadScanConfig builds the scan configuration: the ports in SCAN_RANGE minus those in
+// SCAN_EXCLUDE, probed by up to SCAN_CONCURRENCY workers with a SCAN_TIMEOUT_MS timeout.
+func loadScanConfig() scanConfig {
+	rangeSpec := os.Getenv("SCAN_RANGE")
+	if rangeSpec == "" {
+		rangeSpec = "1-65535"
+	}
+	ports, err := parsePorts(rangeSpec)
+	if err != nil {
+		fmt.Printf("Invalid SCAN_RANGE value, scanning all ports: %v\n", err)
+		ports, _ = parsePorts("1-65535")
+	}
+	excluded := make(map[int]bool)
+	if s := os.Getenv("SCAN_EXCLUDE"); s != "" {
+		list, err := parsePorts(s)
+		if err != nil {
+			fmt.Printf("Invalid SCAN_EXCLUDE value, ignoring it: %v\n", err)
+		}
+		for _, p := range list {
+			excluded[p] = true
+		}
+	}
+	seen := make(map[int]bool, len(ports))
+	var selected []int
+	for _, p := range ports {
+		if p < 1 || p > 65535 || excluded[p] || seen[p] {
+			continue
+		}
+		seen[p] = true
+		selected = append(selected, p)
+	}
+	sort.Ints(selected)
+
+	concurrency := envInt("SCAN_CONCURRENCY", 100)
+	if concurrency < minScanConcurrency {
+		concurrency = minScanConcurrency
+	}
+	return scanConfig{ports: selected, concurrency: concurrency, timeout: scanTimeout()}
+}
+
+// scanTimeout returns the SCAN_TIMEOUT_MS timeout of a single port probe. Values that are
+// not positive fall back to the default, since a probe without a timeout could hang.
+func scanTimeout() time.Duration {
+	timeout := time.Duration(envInt("SCAN_TIMEOUT_MS", 200)) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 200 * time.Millisecond
+	}
+	return timeout
+}
+
+// probeResult classifies the outcome of a single port probe.
+type probeResult int
+
+const (
+	portClosed probeResult = iota
+	portOpen
+	// portTimeout means the probe got no answer; the port is treated as closed
+	// (filtered), but timeouts on the loopback interface indicate an overloaded host.
+	portTimeout
+	// portRetry means the probe failed for lack of local resources (file descriptors,
+	// ephemeral ports, buffers) and says nothing about the port.
+	portRetry
+)
+
+// probePort tries to connect to a TCP port on host.
+func probePort(host string, port int, timeout time.Duration) probeResult {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err == nil {
+		conn.Close()
+		return portOpen
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return portTimeout
+	}
+	for _, errno := range []syscall.Errno{syscall.EMFILE, syscall.ENFILE, syscall.EADDRNOTAVAIL, syscall.ENOBUFS} {
+		if errors.Is(err, errno) {
+			return portRetry
+		}
+	}
+	return portClosed
+}
+
+// scanBatch probes ports with the given number of workers. It returns the open ports, the
+// ports to probe again and the number of probes that timed out or failed.
+func scanBatch(ports []int, workers int, timeout time.Duration) (open, retry []int, errorCount int) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	work := make(chan int)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range work {
+				result := probePort("127.0.0.1", p, timeout)
+				if result == portClosed {
+					continue
+				}
+				mu.Lock()
+				switch result {
+				case portOpen:
+					open = append(open, p)
+				case portTimeout:
+					errorCount++
+				case portRetry:
+					errorCount++
+					retry = append(retry, p)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, p := range ports {
+		work <- p
+	}
+	close(work)
+	wg.Wait()
+	return open, retry, errorCount
+}
+
+// scanLocalPorts scans the configured local ports and returns the open ones, sorted.
+// Ports are probed in batches; the concurrency is halved after a batch with too many
+// timeouts or resource errors and grows back gradually up to SCAN_CONCURRENCY, so a full
+// scan neither exhausts the host nor floods it with connection attempts. Probes that failed
+// for lack of resources are retried, once, at the end.
+func scanLocalPorts() []int {
+	cfg := loadScanConfig()
+	start := time.Now()
+	workers := cfg.concurrency
+	var openPorts, retryPorts []int
+	retried := false
+	pending := cfg.ports
+	for len(pending) > 0 {
+		n := scanBatchSize
+		if n > len(pending) {
+			n = len(pending)
+		}
+		batch := pending[:n]
+		pending = pending[n:]
+
+		open, retry, errorCount := scanBatch(batch, workers, cfg.timeout)
+		openPorts = append(openPorts, open...)
+		retryPorts = append(retryPorts, retry...)
+		if float64(errorCount)/float64(len(batch)) > scanErrorThreshold {
+			workers = max(workers/2, minScanConcurrency)
+		} else if workers < cfg.concurrency {
+			workers = min(workers+max(workers/10, 1), cfg.concurrency)
+		}
+		if len(pending) == 0 && len(retryPorts) > 0 && !retried {
+			retried = true
+			pending, retryPorts = retryPorts, nil
+		}
+	}
+	sort.Ints(openPorts)
+	fmt.Printf("Port scan of %d ports finished in %s: %d open, final concurrency %d\n",
+		len(cfg.ports), time.Since(start).Round(time.Millisecond), len(openPorts), workers)
+	return openPorts
+}
+
+// startPortScans rescans the local ports every SCAN_INTERVAL seconds when the ports are
+// discovered by scanning, emitting a ports.changed event when the set of open ports changes.
+func startPortScans() {
+	interval := envInt("SCAN_INTERVAL", 0)
+	if interval == 0 || os.Getenv("PORTS") != "" || liteMode() {
+		return
+	}
+	supervise("port scan", func() {
+		for {
+			time.Sleep(time.Duration(interval) * time.Second)
+			current := scanLocalPorts()
+			added, removed := diffPorts(recordOpenPorts(current), current)
+			if len(added) > 0 || len(removed) > 0 {
+				emitEvent("ports.changed", "open ports changed: added %v, removed %v", added, removed)
+			}
+		}
+	})
+}
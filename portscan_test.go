@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScanTimeout(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 200 * time.Millisecond},
+		{"500", 500 * time.Millisecond},
+		{"0", 200 * time.Millisecond},
+		{"-1", 200 * time.Millisecond},
+		{"fast", 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Setenv("SCAN_TIMEOUT_MS", tt.value)
+		if got := scanTimeout(); got != tt.want {
+			t.Errorf("SCAN_TIMEOUT_MS=%q: got %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
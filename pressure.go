@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PressureValues holds the PSI stall percentages averaged over 10, 60 and 300 seconds.
+type PressureValues struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+}
+
+// ResourcePressure holds the "some" and "full" stall lines of a PSI resource.
+// CPU has no meaningful "full" line on older kernels, in which case it is omitted.
+type ResourcePressure struct {
+	Some PressureValues  `json:"some"`
+	Full *PressureValues `json:"full,omitempty"`
+}
+
+// PressureStats reports Linux pressure stall information (kernel 4.20+).
+type PressureStats struct {
+	CPU    *ResourcePressure `json:"cpu,omitempty"`
+	Memory *ResourcePressure `json:"memory,omitempty"`
+	IO     *ResourcePressure `json:"io,omitempty"`
+}
+
+// readPressure parses a /proc/pressure/<resource> file.
+func readPressure(resource string) *ResourcePressure {
+	data, err := os.ReadFile("/proc/pressure/" + resource)
+	if err != nil {
+		return nil
+	}
+	rp := &ResourcePressure{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var v PressureValues
+		for _, f := range fields[1:] {
+			key, value, _ := strings.Cut(f, "=")
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			switch key {
+			case "avg10":
+				v.Avg10 = n
+			case "avg60":
+				v.Avg60 = n
+			case "avg300":
+				v.Avg300 = n
+			}
+		}
+		switch fields[0] {
+		case "some":
+			rp.Some = v
+		case "full":
+			rp.Full = &v
+		}
+	}
+	return rp
+}
+
+// collectPressure reads CPU, memory and IO pressure stall information. It returns nil
+// when PSI is unavailable (non-Linux or kernel < 4.20) or PSI_STATS is "false".
+func collectPressure() *PressureStats {
+	if !collectorEnabled("PSI_STATS", true) {
+		return nil
+	}
+	stats := &PressureStats{
+		CPU:    readPressure("cpu"),
+		Memory: readPressure("memory"),
+		IO:     readPressure("io"),
+	}
+	if stats.CPU == nil && stats.Memory == nil && stats.IO == nil {
+		return nil
+	}
+	return stats
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// Defaults of the process count alerts.
+const (
+	defaultZombieWarn     = 100
+	pidUsageWarnPercent   = 90
+	maxZombieParentsShown = 5
+)
+
+// ProcessCounts summarizes the process table, so fork loops and zombie accumulation show
+// up before the host runs out of PIDs.
+type ProcessCounts struct {
+	Total    int `json:"total"`
+	Threads  int `json:"threads"`
+	Running  int `json:"running"`
+	Sleeping int `json:"sleeping"`
+	Stopped  int `json:"stopped,omitempty"`
+	Zombies  int `json:"zombies"`
+	// ForksPerSec is the rate of process creation since the previous sample (Linux).
+	ForksPerSec float64 `json:"forksPerSec,omitempty"`
+	// PIDMax and ThreadsMax are the kernel limits on PIDs and threads (Linux).
+	PIDMax     uint64 `json:"pidMax,omitempty"`
+	ThreadsMax uint64 `json:"threadsMax,omitempty"`
+	// ZombieParents are the processes with the most unreaped children.
+	ZombieParents []ZombieParent `json:"zombieParents,omitempty"`
+}
+
+// ZombieParent is a process that has not reaped its exited children.
+type ZombieParent struct {
+	PID     int32  `json:"pid"`
+	Name    string `json:"name"`
+	Zombies int    `json:"zombies"`
+}
+
+// processCountState holds the previous fork counter and which alerts are raised, so events
+// are only emitted when a threshold is crossed.
+var processCountState struct {
+	sync.Mutex
+	forks     uint64
+	forksAt   time.Time
+	zombies   bool
+	pidsHigh  bool
+	populated bool
+}
+
+// readForkCount reads the number of processes created since boot from /proc/stat.
+func readForkCount() (uint64, bool) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if rest, ok := strings.CutPrefix(line, "processes "); ok {
+			n, err := strconv.ParseUint(strings.TrimSpace(rest), 10, 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+// collectProcessCounts counts the processes by state and their threads, and emits
+// process.zombies_high when the zombies reach ZOMBIE_WARN_THRESHOLD and process.pids_high
+// when the threads use 90% of the kernel's thread or PID limit.
+func collectProcessCounts() (*ProcessCounts, error) {
+	if !collectorEnabled("PROCESS_COUNTS", true) {
+		return nil, nil
+	}
+	procs, err := process.Processes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %v", err)
+	}
+	counts := &ProcessCounts{Total: len(procs)}
+	zombieChildren := make(map[int32]int)
+	for _, p := range procs {
+		if n, err := p.NumThreads(); err == nil {
+			counts.Threads += int(n)
+		}
+		status, err := p.Status()
+		if err != nil || len(status) == 0 {
+			continue
+		}
+		switch status[0] {
+		case process.Running:
+			counts.Running++
+		case process.Sleep, process.Idle, process.Wait, process.Lock:
+			counts.Sleeping++
+		case process.Stop:
+			counts.Stopped++
+		case process.Zombie:
+			counts.Zombies++
+			if ppid, err := p.Ppid(); err == nil {
+				zombieChildren[ppid]++
+			}
+		}
+	}
+	for pid, n := range zombieChildren {
+		parent := ZombieParent{PID: pid, Zombies: n}
+		if p, err := process.NewProcess(pid); err == nil {
+			parent.Name, _ = p.Name()
+		}
+		counts.ZombieParents = append(counts.ZombieParents, parent)
+	}
+	sort.Slice(counts.ZombieParents, func(i, j int) bool {
+		a, b := counts.ZombieParents[i], counts.ZombieParents[j]
+		return a.Zombies > b.Zombies || a.Zombies == b.Zombies && a.PID < b.PID
+	})
+	if len(counts.ZombieParents) > maxZombieParentsShown {
+		counts.ZombieParents = counts.ZombieParents[:maxZombieParentsShown]
+	}
+
+	processCountState.Lock()
+	defer processCountState.Unlock()
+	if runtime.GOOS == "linux" {
+		counts.PIDMax, _ = readUintFile("/proc/sys/kernel/pid_max")
+		counts.ThreadsMax, _ = readUintFile("/proc/sys/kernel/threads-max")
+		if forks, ok := readForkCount(); ok {
+			now := time.Now()
+			if processCountState.populated && forks >= processCountState.forks {
+				if elapsed := now.Sub(processCountState.forksAt).Seconds(); elapsed > 0 {
+					counts.ForksPerSec = float64(forks-processCountState.forks) / elapsed
+				}
+			}
+			processCountState.forks, processCountState.forksAt, processCountState.populated = forks, now, true
+		}
+	}
+
+	warn := envInt("ZOMBIE_WARN_THRESHOLD", defaultZombieWarn)
+	high := warn > 0 && counts.Zombies >= warn
+	if high && !processCountState.zombies {
+		top := ""
+		if len(counts.ZombieParents) > 0 {
+			top = fmt.Sprintf(" (most under %s, pid %d)", counts.ZombieParents[0].Name, counts.ZombieParents[0].PID)
+		}
+		emitEvent("process.zombies_high", "%d zombie processes%s", counts.Zombies, top)
+	}
+	processCountState.zombies = high
+
+	// Every thread takes a PID, so the lower of the two limits applies.
+	limit := counts.PIDMax
+	if counts.ThreadsMax > 0 && (limit == 0 || counts.ThreadsMax < limit) {
+		limit = counts.ThreadsMax
+	}
+	pidsHigh := limit > 0 && float64(counts.Threads) >= float64(limit)*pidUsageWarnPercent/100
+	if pidsHigh && !processCountState.pidsHigh {
+		emitEvent("process.pids_high", "%d threads running, the kernel limit is %d", counts.Threads, limit)
+	}
+	processCountState.pidsHigh = pidsHigh
+	return counts, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// ProcessInfo describes a single running process.
+type ProcessInfo struct {
+	PID      int32  `json:"pid"`
+	PPID     int32  `json:"ppid"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Status   string `json:"status"`
+	Cmdline  string `json:"cmdline"`
+	// CPUPercent is the CPU usage since the previous process listing, in percent of one
+	// CPU, or averaged over the process's lifetime the first time it is listed.
+	CPUPercent float64 `json:"cpuPercent"`
+	MemPercent float32 `json:"memPercent"`
+	RSS        uint64  `json:"rss"`
+	NumThreads int32   `json:"numThreads"`
+	CreateTime int64   `json:"createTime"`
+}
+
+// ProcessList is the response of the /processes endpoint.
+type ProcessList struct {
+	Timestamp int64         `json:"timestamp"`
+	Count     int           `json:"count"`
+	Processes []ProcessInfo `json:"processes"`
+}
+
+// cachedProcess is a process handle kept between listings, with the creation time telling
+// it apart from a later process reusing its PID.
+type cachedProcess struct {
+	p          *process.Process
+	createTime int64
+}
+
+// processCache holds the handles of the processes seen in the previous listing, by PID.
+// A handle remembers the CPU times it last read, so the CPU usage of a process can be
+// measured over the time since then.
+var processCache struct {
+	sync.Mutex
+	procs map[int32]cachedProcess
+}
+
+// listProcesses returns the current process list sorted by PID.
+// Fields that cannot be read (e.g. for processes owned by other users) are left empty.
+func listProcesses() ([]ProcessInfo, error) {
+	procs, err := process.Processes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %v", err)
+	}
+	processCache.Lock()
+	defer processCache.Unlock()
+	seen := make(map[int32]cachedProcess, len(procs))
+	infos := make([]ProcessInfo, 0, len(procs))
+	for _, p := range procs {
+		info := ProcessInfo{PID: p.Pid}
+		info.CreateTime, _ = p.CreateTime()
+		if cached, ok := processCache.procs[p.Pid]; ok && cached.createTime == info.CreateTime {
+			p = cached.p
+			info.CPUPercent, _ = p.Percent(0)
+		} else {
+			// The first reading of a process only records its CPU times.
+			info.CPUPercent, _ = p.CPUPercent()
+			p.Percent(0)
+		}
+		seen[p.Pid] = cachedProcess{p: p, createTime: info.CreateTime}
+		info.PPID, _ = p.Ppid()
+		info.Name, _ = p.Name()
+		info.Username, _ = p.Username()
+		info.Cmdline, _ = p.Cmdline()
+		info.MemPercent, _ = p.MemoryPercent()
+		info.NumThreads, _ = p.NumThreads()
+		if status, err := p.Status(); err == nil && len(status) > 0 {
+			info.Status = status[0]
+		}
+		if mi, err := p.MemoryInfo(); err == nil {
+			info.RSS = mi.RSS
+		}
+		infos = append(infos, info)
+	}
+	processCache.procs = seen
+	sort.Slice(infos, func(i, j int) bool { return infos[i].PID < infos[j].PID })
+	return infos, nil
+}
+
+// defaultTopProcesses is the number of processes reported in each metrics payload.
+const defaultTopProcesses = 10
+
+// collectTopProcesses returns the processes using the most CPU since the previous
+// collection, TOP_PROCESSES of them.
+func collectTopProcesses() ([]ProcessInfo, error) {
+	if !collectorEnabled("PROCESS_STATS", true) {
+		return nil, nil
+	}
+	n := defaultTopProcesses
+	if s := os.Getenv("TOP_PROCESSES"); s != "" {
+		if v, err := strconv.Atoi(s); err == nil && v >= 0 {
+			n = v
+		}
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	procs, err := listProcesses()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].CPUPercent > procs[j].CPUPercent })
+	if len(procs) > n {
+		procs = procs[:n]
+	}
+	return procs, nil
+}
+
+// handleProcesses returns the full current process list for incident triage.
+func handleProcesses(w http.ResponseWriter, r *http.Request) {
+	procs, err := listProcesses()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, ProcessList{
+		Timestamp: time.Now().UnixMilli(),
+		Count:     len(procs),
+		Processes: procs,
+	})
+}
+
+// handleUploadProcesses sends the full process list to the servers as a chunked upload of
+// kind "processes", for hosts whose process list is too large to fetch or report at once.
+func handleUploadProcesses(w http.ResponseWriter, r *http.Request) {
+	if !chunkedUploads() {
+		http.Error(w, "chunked uploads disabled: set CHUNKED_UPLOAD=true", http.StatusConflict)
+		return
+	}
+	procs, err := listProcesses()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id, err := stageUpload("processes", func(w io.Writer) error {
+		return writeJSONArray(w, procs)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, map[string]interface{}{"uploadId": id, "count": len(procs)})
+}
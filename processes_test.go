@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// processCPU returns the CPU usage listed for pid.
+func processCPU(t *testing.T, pid int) float64 {
+	t.Helper()
+	procs, err := listProcesses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range procs {
+		if int(p.PID) == pid {
+			return p.CPUPercent
+		}
+	}
+	t.Fatalf("process %d not listed", pid)
+	return 0
+}
+
+func TestListProcessesMeasuresRecentCPU(t *testing.T) {
+	idle := exec.Command("sleep", "5")
+	if err := idle.Start(); err != nil {
+		t.Skip("sleep not available:", err)
+	}
+	defer idle.Process.Kill()
+
+	var stop atomic.Bool
+	go func() {
+		for !stop.Load() {
+		}
+	}()
+	defer stop.Store(true)
+
+	processCPU(t, os.Getpid())
+	time.Sleep(300 * time.Millisecond)
+	if cpu := processCPU(t, os.Getpid()); cpu < 20 {
+		t.Errorf("busy process at %.1f%% CPU over the interval, want at least 20%%", cpu)
+	}
+	if cpu := processCPU(t, idle.Process.Pid); cpu > 5 {
+		t.Errorf("idle process at %.1f%% CPU over the interval", cpu)
+	}
+}
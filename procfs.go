@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readUintFile reads a file containing a single unsigned integer, such as a /proc or /sys tunable.
+func readUintFile(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// readMeminfo parses a meminfo-style file ("Key: value [kB]") into a map of values,
+// converting kB quantities to bytes. Lines may carry a fixed prefix such as "Node 0 ".
+func readMeminfo(path, prefix string) (map[string]uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]uint64)
+	for _, line := range strings.Split(string(data), "\n") {
+		key, rest, ok := strings.Cut(strings.TrimPrefix(line, prefix), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) > 1 && fields[1] == "kB" {
+			v *= 1024
+		}
+		values[strings.TrimSpace(key)] = v
+	}
+	return values, nil
+}
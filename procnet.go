@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// maxProcessNetEntries is the number of top processes reported by the per-process network collector.
+const maxProcessNetEntries = 20
+
+// ProcessNetStats reports the TCP bytes sent and received by a process since the previous collection.
+type ProcessNetStats struct {
+	PID       uint32 `json:"pid"`
+	Name      string `json:"name"`
+	BytesSent uint64 `json:"bytesSent"`
+	BytesRecv uint64 `json:"bytesRecv"`
+}
+
+// processNetCounters holds the cumulative per-process byte counters read from the kernel.
+type processNetCounters struct {
+	sent uint64
+	recv uint64
+}
+
+// lastProcessNet holds the counters of the previous collection, used to compute deltas.
+var lastProcessNet struct {
+	sync.Mutex
+	counters map[uint32]processNetCounters
+}
+
+// collectProcessNet reports the processes that moved the most TCP traffic since the previous
+// collection, when EBPF_PROCESS_NET is "true" and the platform supports eBPF accounting.
+func collectProcessNet() ([]ProcessNetStats, error) {
+	if !collectorEnabled("EBPF_PROCESS_NET", false) {
+		return nil, nil
+	}
+	counters, err := readProcessNetCounters()
+	if err != nil {
+		return nil, err
+	}
+
+	lastProcessNet.Lock()
+	previous := lastProcessNet.counters
+	lastProcessNet.counters = counters
+	lastProcessNet.Unlock()
+	if previous == nil {
+		return nil, nil
+	}
+
+	var stats []ProcessNetStats
+	for pid, c := range counters {
+		p := previous[pid]
+		if c.sent < p.sent || c.recv < p.recv {
+			p = processNetCounters{}
+		}
+		if c.sent == p.sent && c.recv == p.recv {
+			continue
+		}
+		stats = append(stats, ProcessNetStats{PID: pid, BytesSent: c.sent - p.sent, BytesRecv: c.recv - p.recv})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].BytesSent+stats[i].BytesRecv > stats[j].BytesSent+stats[j].BytesRecv
+	})
+	if len(stats) > maxProcessNetEntries {
+		stats = stats[:maxProcessNetEntries]
+	}
+	for i := range stats {
+		if p, err := process.NewProcess(int32(stats[i].PID)); err == nil {
+			stats[i].Name, _ = p.Name()
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,149 @@
+//go:build amd64 || arm64
+
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/rlimit"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// processNetMaxPIDs bounds the number of processes tracked by the eBPF maps.
+const processNetMaxPIDs = 16384
+
+// processNetProbe holds the eBPF maps and kprobe links of the per-process network accounting.
+var processNetProbe struct {
+	once  sync.Once
+	err   error
+	sent  *ebpf.Map
+	recv  *ebpf.Map
+	links []link.Link
+}
+
+// accountingProgram builds a kprobe program that adds the byte count found at argOffset
+// in the probed function's registers to the calling process's entry in m.
+// If word is true the argument is a 32-bit int and non-positive values are ignored.
+func accountingProgram(m *ebpf.Map, argOffset int16, word bool) asm.Instructions {
+	load := asm.LoadMem(asm.R7, asm.R6, argOffset, asm.DWord)
+	if word {
+		load = asm.LoadMem(asm.R7, asm.R6, argOffset, asm.Word)
+	}
+	return asm.Instructions{
+		asm.Mov.Reg(asm.R6, asm.R1),
+		load,
+		asm.JEq.Imm(asm.R7, 0, "exit"),
+		asm.JGT.Imm(asm.R7, 0x7fffffff, "exit"),
+		// key = tgid (upper 32 bits of pid_tgid)
+		asm.FnGetCurrentPidTgid.Call(),
+		asm.RSh.Imm(asm.R0, 32),
+		asm.StoreMem(asm.RFP, -4, asm.R0, asm.Word),
+		asm.LoadMapPtr(asm.R1, m.FD()),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, -4),
+		asm.FnMapLookupElem.Call(),
+		asm.JEq.Imm(asm.R0, 0, "init"),
+		asm.StoreXAdd(asm.R0, asm.R7, asm.DWord),
+		asm.Ja.Label("exit"),
+		// First bytes for this process: insert the initial value.
+		asm.StoreMem(asm.RFP, -16, asm.R7, asm.DWord).WithSymbol("init"),
+		asm.LoadMapPtr(asm.R1, m.FD()),
+		asm.Mov.Reg(asm.R2, asm.RFP),
+		asm.Add.Imm(asm.R2, -4),
+		asm.Mov.Reg(asm.R3, asm.RFP),
+		asm.Add.Imm(asm.R3, -16),
+		asm.Mov.Imm(asm.R4, 0),
+		asm.FnMapUpdateElem.Call(),
+		asm.Mov.Imm(asm.R0, 0).WithSymbol("exit"),
+		asm.Return(),
+	}
+}
+
+// attachKprobe loads an accounting program and attaches it to symbol.
+func attachKprobe(symbol string, insns asm.Instructions) (link.Link, error) {
+	prog, err := ebpf.NewProgram(&ebpf.ProgramSpec{
+		Type:         ebpf.Kprobe,
+		Instructions: insns,
+		License:      "GPL",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load program for %s: %v", symbol, err)
+	}
+	l, err := link.Kprobe(symbol, prog, nil)
+	prog.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach kprobe %s: %v", symbol, err)
+	}
+	return l, nil
+}
+
+// startProcessNetProbe creates the maps and attaches kprobes to tcp_sendmsg and tcp_cleanup_rbuf.
+func startProcessNetProbe() error {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		return fmt.Errorf("failed to remove memlock limit: %v", err)
+	}
+	newMap := func() (*ebpf.Map, error) {
+		return ebpf.NewMap(&ebpf.MapSpec{Type: ebpf.Hash, KeySize: 4, ValueSize: 8, MaxEntries: processNetMaxPIDs})
+	}
+	var err error
+	if processNetProbe.sent, err = newMap(); err != nil {
+		return fmt.Errorf("failed to create eBPF map: %v", err)
+	}
+	if processNetProbe.recv, err = newMap(); err != nil {
+		return fmt.Errorf("failed to create eBPF map: %v", err)
+	}
+	// tcp_sendmsg(struct sock *sk, struct msghdr *msg, size_t size)
+	sendLink, err := attachKprobe("tcp_sendmsg", accountingProgram(processNetProbe.sent, kprobeArg3Offset, false))
+	if err != nil {
+		return err
+	}
+	// tcp_cleanup_rbuf(struct sock *sk, int copied)
+	recvLink, err := attachKprobe("tcp_cleanup_rbuf", accountingProgram(processNetProbe.recv, kprobeArg2Offset, true))
+	if err != nil {
+		sendLink.Close()
+		return err
+	}
+	processNetProbe.links = []link.Link{sendLink, recvLink}
+	return nil
+}
+
+// readProcessNetCounters returns the cumulative per-process TCP byte counters,
+// attaching the eBPF probes on first use and pruning entries of exited processes.
+func readProcessNetCounters() (map[uint32]processNetCounters, error) {
+	processNetProbe.once.Do(func() {
+		processNetProbe.err = startProcessNetProbe()
+		if processNetProbe.err != nil {
+			fmt.Printf("eBPF per-process network accounting unavailable: %v\n", processNetProbe.err)
+		}
+	})
+	if processNetProbe.err != nil {
+		return nil, processNetProbe.err
+	}
+
+	counters := make(map[uint32]processNetCounters)
+	read := func(m *ebpf.Map, set func(c *processNetCounters, v uint64)) {
+		var pid uint32
+		var bytes uint64
+		var stale []uint32
+		iter := m.Iterate()
+		for iter.Next(&pid, &bytes) {
+			if exists, _ := process.PidExists(int32(pid)); !exists {
+				stale = append(stale, pid)
+				continue
+			}
+			c := counters[pid]
+			set(&c, bytes)
+			counters[pid] = c
+		}
+		for _, pid := range stale {
+			m.Delete(pid)
+		}
+	}
+	read(processNetProbe.sent, func(c *processNetCounters, v uint64) { c.sent = v })
+	read(processNetProbe.recv, func(c *processNetCounters, v uint64) { c.recv = v })
+	return counters, nil
+}
@@ -0,0 +1,7 @@
+package main
+
+// Offsets of the second and third function arguments (rsi, rdx) in struct pt_regs.
+const (
+	kprobeArg2Offset = 104
+	kprobeArg3Offset = 96
+)
@@ -0,0 +1,7 @@
+package main
+
+// Offsets of the second and third function arguments (x1, x2) in struct user_pt_regs.
+const (
+	kprobeArg2Offset = 8
+	kprobeArg3Offset = 16
+)
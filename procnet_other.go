@@ -0,0 +1,10 @@
+//go:build !linux || !(amd64 || arm64)
+
+package main
+
+import "fmt"
+
+// readProcessNetCounters is not supported on this platform.
+func readProcessNetCounters() (map[uint32]processNetCounters, error) {
+	return nil, fmt.Errorf("eBPF per-process network accounting is only supported on linux/amd64 and linux/arm64")
+}
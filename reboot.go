@@ -0,0 +1,38 @@
+package main
+
+import "sync"
+
+// RebootStatus reports whether the host needs a reboot to finish applying updates.
+type RebootStatus struct {
+	Pending         bool     `json:"pending"`
+	Reasons         []string `json:"reasons,omitempty"`
+	RunningKernel   string   `json:"runningKernel,omitempty"`
+	InstalledKernel string   `json:"installedKernel,omitempty"`
+}
+
+// lastReboot remembers whether a reboot was pending at the previous check.
+var lastReboot struct {
+	sync.Mutex
+	pending bool
+}
+
+// collectReboot reports pending reboot state and kernel drift, emitting a reboot.pending
+// event when a reboot becomes necessary.
+func collectReboot() *RebootStatus {
+	if !collectorEnabled("REBOOT_CHECK", true) {
+		return nil
+	}
+	status := checkReboot()
+	if status == nil {
+		return nil
+	}
+	status.Pending = len(status.Reasons) > 0
+
+	lastReboot.Lock()
+	defer lastReboot.Unlock()
+	if status.Pending && !lastReboot.pending {
+		emitEvent("reboot.pending", "reboot required: %v", status.Reasons)
+	}
+	lastReboot.pending = status.Pending
+	return status
+}
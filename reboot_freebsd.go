@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// checkReboot compares the running kernel with the installed one using freebsd-version.
+func checkReboot() *RebootStatus {
+	running, err := exec.Command("freebsd-version", "-r").Output()
+	if err != nil {
+		return nil
+	}
+	installed, err := exec.Command("freebsd-version", "-k").Output()
+	if err != nil {
+		return nil
+	}
+	status := &RebootStatus{
+		RunningKernel:   strings.TrimSpace(string(running)),
+		InstalledKernel: strings.TrimSpace(string(installed)),
+	}
+	if status.RunningKernel != status.InstalledKernel {
+		status.Reasons = append(status.Reasons, "kernel "+status.InstalledKernel+" installed, "+status.RunningKernel+" running")
+	}
+	return status
+}
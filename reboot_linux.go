@@ -0,0 +1,139 @@
+package main
+
+import (
+	"cmp"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/sys/unix"
+)
+
+// runningKernel returns the release of the running kernel.
+func runningKernel() string {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return ""
+	}
+	return unix.ByteSliceToString(uts.Release[:])
+}
+
+// newestInstalledKernel returns the newest installed kernel release, the highest version
+// among the /lib/modules directories. Directory times are not used: they change when
+// modules of an older kernel are rebuilt, e.g. by DKMS.
+func newestInstalledKernel() string {
+	dirs, _ := filepath.Glob("/lib/modules/*")
+	var newest string
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, "modules.dep")); err != nil {
+			continue
+		}
+		if release := filepath.Base(dir); newest == "" || compareKernelReleases(release, newest) > 0 {
+			newest = release
+		}
+	}
+	return newest
+}
+
+// compareKernelReleases compares two kernel releases such as 6.8.0-45-generic or
+// 5.14.0-427.13.1.el9_4.x86_64 the way rpm compares versions: segment by segment, numbers
+// numerically and letters lexically, ignoring separators. A number is newer than letters
+// and a release with more segments is newer. It returns -1, 0 or +1.
+func compareKernelReleases(a, b string) int {
+	isSeparator := func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }
+	for {
+		a, b = strings.TrimLeftFunc(a, isSeparator), strings.TrimLeftFunc(b, isSeparator)
+		if a == "" || b == "" {
+			return cmp.Compare(len(a), len(b))
+		}
+		var sa, sb string
+		sa, a = kernelSegment(a)
+		sb, b = kernelSegment(b)
+		numA, numB := unicode.IsDigit(rune(sa[0])), unicode.IsDigit(rune(sb[0]))
+		if numA != numB {
+			if numA {
+				return 1
+			}
+			return -1
+		}
+		if numA {
+			sa, sb = strings.TrimLeft(sa, "0"), strings.TrimLeft(sb, "0")
+			if c := cmp.Compare(len(sa), len(sb)); c != 0 {
+				return c
+			}
+		}
+		if c := strings.Compare(sa, sb); c != 0 {
+			return c
+		}
+	}
+}
+
+// kernelSegment splits off the leading run of digits or letters of s.
+func kernelSegment(s string) (string, string) {
+	digit := unicode.IsDigit(rune(s[0]))
+	i := strings.IndexFunc(s, func(r rune) bool { return unicode.IsDigit(r) != digit || !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i:]
+}
+
+// needsRestartingInterval is how long a needs-restarting result is reused; the command
+// queries the package database and can take several seconds.
+const needsRestartingInterval = time.Hour
+
+// lastNeedsRestarting caches the result of needs-restarting -r.
+var lastNeedsRestarting struct {
+	sync.Mutex
+	at     time.Time
+	reboot bool
+}
+
+// needsRestarting reports whether RHEL's needs-restarting -r, if installed, says a reboot
+// is required. It exits with status 1 in that case.
+func needsRestarting() bool {
+	lastNeedsRestarting.Lock()
+	defer lastNeedsRestarting.Unlock()
+	if time.Since(lastNeedsRestarting.at) < needsRestartingInterval {
+		return lastNeedsRestarting.reboot
+	}
+	lastNeedsRestarting.at = time.Now()
+	lastNeedsRestarting.reboot = false
+	path, err := exec.LookPath("needs-restarting")
+	if err != nil {
+		return false
+	}
+	if err := exec.Command(path, "-r").Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			lastNeedsRestarting.reboot = true
+		}
+	}
+	return lastNeedsRestarting.reboot
+}
+
+// checkReboot detects a pending reboot from the Debian/Ubuntu reboot-required marker,
+// RHEL's needs-restarting and a running kernel that is not the newest installed one.
+func checkReboot() *RebootStatus {
+	status := &RebootStatus{RunningKernel: runningKernel(), InstalledKernel: newestInstalledKernel()}
+	if _, err := os.Stat("/var/run/reboot-required"); err == nil {
+		reason := "reboot-required"
+		if pkgs, err := os.ReadFile("/var/run/reboot-required.pkgs"); err == nil {
+			if list := strings.Fields(string(pkgs)); len(list) > 0 {
+				reason += " (" + strings.Join(list, ", ") + ")"
+			}
+		}
+		status.Reasons = append(status.Reasons, reason)
+	}
+	if needsRestarting() {
+		status.Reasons = append(status.Reasons, "needs-restarting")
+	}
+	// Inside a container the running kernel belongs to the host, so kernel drift is meaningless.
+	if !isContainerized() && status.RunningKernel != "" && status.InstalledKernel != "" && status.RunningKernel != status.InstalledKernel {
+		status.Reasons = append(status.Reasons, "kernel "+status.InstalledKernel+" installed, "+status.RunningKernel+" running")
+	}
+	return status
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestCompareKernelReleases(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"6.8.0-45-generic", "6.8.0-45-generic", 0},
+		{"6.8.0-101-generic", "6.8.0-45-generic", 1},
+		{"6.8.0-45-generic", "6.11.0-8-generic", -1},
+		{"6.1.0-18-amd64", "6.1.0-9-amd64", 1},
+		{"5.14.0-427.13.1.el9_4.x86_64", "5.14.0-427.el9.x86_64", 1},
+		{"5.14.0-503.el9.x86_64", "5.14.0-427.13.1.el9_4.x86_64", 1},
+		{"6.6.010", "6.6.9", 1},
+		{"6.6.0", "6.6", 1},
+		{"6.6.1", "6.6.rc1", 1},
+	}
+	for _, tt := range tests {
+		if got := compareKernelReleases(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareKernelReleases(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareKernelReleases(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareKernelReleases(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
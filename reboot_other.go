@@ -0,0 +1,8 @@
+//go:build !linux && !freebsd && !windows
+
+package main
+
+// checkReboot is not implemented on this platform.
+func checkReboot() *RebootStatus {
+	return nil
+}
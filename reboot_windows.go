@@ -0,0 +1,30 @@
+package main
+
+import (
+	"golang.org/x/sys/windows/registry"
+)
+
+// rebootRegistryKeys are registry keys whose presence means Windows has a reboot pending.
+var rebootRegistryKeys = map[string]string{
+	`SOFTWARE\Microsoft\Windows\CurrentVersion\Component Based Servicing\RebootPending`:  "component-based-servicing",
+	`SOFTWARE\Microsoft\Windows\CurrentVersion\WindowsUpdate\Auto Update\RebootRequired`: "windows-update",
+}
+
+// checkReboot looks for the registry markers set by Windows Update and servicing, and for
+// file renames queued until the next boot.
+func checkReboot() *RebootStatus {
+	status := &RebootStatus{}
+	for path, reason := range rebootRegistryKeys {
+		if k, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.QUERY_VALUE); err == nil {
+			k.Close()
+			status.Reasons = append(status.Reasons, reason)
+		}
+	}
+	if k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Control\Session Manager`, registry.QUERY_VALUE); err == nil {
+		if renames, _, err := k.GetStringsValue("PendingFileRenameOperations"); err == nil && len(renames) > 0 {
+			status.Reasons = append(status.Reasons, "pending-file-renames")
+		}
+		k.Close()
+	}
+	return status
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Backoff bounds between registration attempts.
+const (
+	minRegisterDelay = 5 * time.Second
+	maxRegisterDelay = 5 * time.Minute
+)
+
+// retryRegistration keeps trying to register with d using exponential backoff until it
+// succeeds, then marks the agent as registered and runs onRegistered. A Retry-After sent
+// with a 429 or 503 response, including the failure err of the first attempt, lengthens
+// the wait.
+func retryRegistration(d *destination, agentInfo AgentInfo, err error, onRegistered func()) {
+	delay := minRegisterDelay
+	wait := delay
+	if requested, ok := retryAfter(err); ok && requested > wait {
+		wait = requested
+	}
+	for {
+		fmt.Printf("Retrying registration with %s server in %s\n", d.name, wait)
+		time.Sleep(wait)
+		agentInfo.Timestamp = time.Now().UnixMilli()
+		handshake, err := registerAgent(agentInfo, d.endpoint(endpointRegister), d.primary)
+		if err != nil {
+			fmt.Printf("Error registering agent with %s server: %v\n", d.name, err)
+			delay *= 2
+			if delay > maxRegisterDelay {
+				delay = maxRegisterDelay
+			}
+			wait = delay
+			if requested, ok := retryAfter(err); ok && requested > wait {
+				wait = requested
+			}
+			continue
+		}
+		d.applyHandshake(handshake)
+		d.registered.Store(true)
+		runSafely("post-registration", onRegistered)
+		return
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// replayItem is a batch read from a spool file.
+type replayItem struct {
+	// path is the file holding only this batch, removed after the server acknowledges it
+	// when -remove is set.
+	path string
+	data []byte
+}
+
+// readReplayBatches reads the batches in path: a spool directory or a spool's dead-letter
+// directory (one batch per *.json file, in sequence order), or a single batch file. Files
+// encrypted with the spool key are decrypted. Files of a directory that cannot be read or
+// decrypted are reported and skipped.
+func readReplayBatches(path string) ([]replayItem, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		files, err := filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		var items []replayItem
+		for _, f := range files {
+			data, err := os.ReadFile(f)
+			if err == nil {
+				data, err = openBatch(data)
+			}
+			if err != nil {
+				fmt.Printf("Skipping %s: %v\n", f, err)
+				continue
+			}
+			items = append(items, replayItem{path: f, data: data})
+		}
+		return items, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = openBatch(data); err != nil {
+		return nil, err
+	}
+	return []replayItem{{path: path, data: data}}, nil
+}
+
+// runReplay implements the "replay" subcommand: it re-sends the batches of spool
+// directories, including their dead-letter directories, to a server at a limited rate, so
+// a server recovering from a long outage is not flooded with the backlog. Encrypted
+// configuration values, such as SPOOL_KEY, are decrypted first.
+func runReplay(args []string) error {
+	if err := decryptEnv(); err != nil {
+		return fmt.Errorf("failed to decrypt configuration: %v", err)
+	}
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	server := fs.String("server", defaultServerURL(), "base URL of the monitoring server")
+	rate := fs.Float64("rate", 5, "maximum batches sent per second")
+	remove := fs.Bool("remove", false, "delete spooled batch files once the server acknowledges them")
+	keepGoing := fs.Bool("continue", false, "keep sending after a rejected batch instead of stopping")
+	dryRun := fs.Bool("dry-run", false, "list the batches without sending them")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cheetah-monitoring-agent replay [flags] <spool dir | dead-letter dir | batch file>...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no spool directory or batch file given")
+	}
+	if *rate <= 0 {
+		return fmt.Errorf("rate must be positive")
+	}
+
+	var items []replayItem
+	for _, path := range fs.Args() {
+		batches, err := readReplayBatches(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", path, err)
+		}
+		items = append(items, batches...)
+	}
+	url := *server + endpointPath(endpointMetrics)
+	fmt.Printf("Replaying %d batches to %s at up to %g batches/s\n", len(items), url, *rate)
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+	defer ticker.Stop()
+	sent, failed := 0, 0
+	for i, item := range items {
+		if *dryRun {
+			fmt.Printf("%s (%d bytes)\n", item.path, len(item.data))
+			continue
+		}
+		if i > 0 {
+			<-ticker.C
+		}
+		if _, err := postBatch(item.data, url, ""); err != nil {
+			failed++
+			fmt.Printf("Failed to replay %s: %v\n", item.path, err)
+			if !*keepGoing {
+				return fmt.Errorf("stopped after %d of %d batches", sent, len(items))
+			}
+			continue
+		}
+		sent++
+		if *remove {
+			os.Remove(item.path)
+		}
+	}
+	fmt.Printf("Replay done: %d sent, %d failed\n", sent, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d batches were rejected", failed)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadReplayBatches(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "00000000000000000002.json"), []byte(`{"seq":2}`), 0o600)
+	os.WriteFile(filepath.Join(dir, "00000000000000000001.json"), []byte(`{"seq":1}`), 0o600)
+	// Encrypted with a key that is not available: skipped.
+	os.WriteFile(filepath.Join(dir, "00000000000000000003.json"), append([]byte(sealedBatchMagic), make([]byte, 40)...), 0o600)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0o600)
+	t.Setenv("STATE_DIR", t.TempDir())
+
+	items, err := readReplayBatches(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || string(items[0].data) != `{"seq":1}` || string(items[1].data) != `{"seq":2}` {
+		t.Errorf("got %d batches %v, want batches 1 and 2 in order", len(items), items)
+	}
+
+	single, err := readReplayBatches(filepath.Join(dir, "00000000000000000002.json"))
+	if err != nil || len(single) != 1 || string(single[0].data) != `{"seq":2}` {
+		t.Errorf("single file: got %v, %v", single, err)
+	}
+	if _, err := readReplayBatches(filepath.Join(dir, "00000000000000000003.json")); err == nil {
+		t.Error("undecryptable single file read without error")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bounds on a pause requested by a server with Retry-After.
+const (
+	// defaultRetryAfter applies to 429 and 503 responses without a usable Retry-After.
+	defaultRetryAfter = 30 * time.Second
+	// maxRetryAfter caps the pause, so a bogus header cannot silence the agent for days.
+	maxRetryAfter = time.Hour
+)
+
+// serverBusyError is returned for 429 Too Many Requests and 503 Service Unavailable
+// responses, carrying how long the server asked the agent to wait.
+type serverBusyError struct {
+	status     string
+	retryAfter time.Duration
+}
+
+// Error implements the error interface.
+func (e *serverBusyError) Error() string {
+	return fmt.Sprintf("server busy (%s), retry after %s", e.status, e.retryAfter)
+}
+
+// parseRetryAfter parses a Retry-After header value, given either in seconds or as an HTTP
+// date, into a delay from now, bounded by maxRetryAfter.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		delay = time.Duration(seconds) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		delay = max(t.Sub(now), 0)
+	} else {
+		return 0, false
+	}
+	return min(delay, maxRetryAfter), true
+}
+
+// busyError returns a serverBusyError for 429 and 503 responses, or nil.
+func busyError(resp *http.Response) error {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil
+	}
+	delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		delay = defaultRetryAfter
+	}
+	return &serverBusyError{status: resp.Status, retryAfter: delay}
+}
+
+// retryAfter returns the delay requested by a serverBusyError in err's chain.
+func retryAfter(err error) (time.Duration, bool) {
+	var busy *serverBusyError
+	if errors.As(err, &busy) {
+		return busy.retryAfter, true
+	}
+	return 0, false
+}
+
+// pause stops sends to d for the given delay. Batches keep being spooled meanwhile.
+func (d *destination) pause(delay time.Duration) {
+	d.pausedUntil.Store(time.Now().Add(delay).UnixNano())
+	fmt.Printf("Pausing sends to %s server for %s as requested\n", d.name, delay.Round(time.Second))
+}
+
+// paused reports whether sends to d are paused, and until when.
+func (d *destination) paused() (time.Time, bool) {
+	until := time.Unix(0, d.pausedUntil.Load())
+	return until, time.Now().Before(until)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{" 0 ", 0, true},
+		{"-5", 0, false},
+		{"86400", maxRetryAfter, true},
+		{"Wed, 01 May 2024 12:00:45 GMT", 45 * time.Second, true},
+		{"Wed, 01 May 2024 11:59:00 GMT", 0, true},
+		{"Thu, 02 May 2024 12:00:00 GMT", maxRetryAfter, true},
+		{"soon", 0, false},
+		{"1.5", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRetryAfter(%q) = %s, %v; want %s, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestBusyError(t *testing.T) {
+	tests := []struct {
+		status     int
+		retryAfter string
+		want       time.Duration
+		wantBusy   bool
+	}{
+		{http.StatusOK, "10", 0, false},
+		{http.StatusInternalServerError, "10", 0, false},
+		{http.StatusTooManyRequests, "10", 10 * time.Second, true},
+		{http.StatusServiceUnavailable, "", defaultRetryAfter, true},
+		{http.StatusServiceUnavailable, "garbage", defaultRetryAfter, true},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: tt.status, Status: http.StatusText(tt.status), Header: http.Header{}}
+		if tt.retryAfter != "" {
+			resp.Header.Set("Retry-After", tt.retryAfter)
+		}
+		err := busyError(resp)
+		got, busy := retryAfter(fmt.Errorf("failed to send metrics: %w", err))
+		if busy != tt.wantBusy || got != tt.want {
+			t.Errorf("status %d, Retry-After %q: got %s, %v; want %s, %v", tt.status, tt.retryAfter, got, busy, tt.want, tt.wantBusy)
+		}
+	}
+}
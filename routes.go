@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// RouteInfo describes the default route and the size of the routing table.
+type RouteInfo struct {
+	DefaultGateway string `json:"defaultGateway"`
+	Interface      string `json:"interface,omitempty"`
+	RouteCount     int    `json:"routeCount"`
+}
+
+// lastRoute holds the previously observed routing state, used to detect changes.
+var lastRoute struct {
+	sync.Mutex
+	info *RouteInfo
+}
+
+// readProcRoute parses the Linux IPv4 routing table from /proc/net/route.
+func readProcRoute() (RouteInfo, error) {
+	var info RouteInfo
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // Skip the header line.
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 {
+			continue
+		}
+		info.RouteCount++
+		// Destination and mask 0 identify the default route; the gateway is little-endian hex.
+		if fields[1] == "00000000" && fields[7] == "00000000" && info.DefaultGateway == "" {
+			if raw, err := hex.DecodeString(fields[2]); err == nil && len(raw) == 4 {
+				ip := make(net.IP, 4)
+				binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(raw))
+				info.DefaultGateway = ip.String()
+				info.Interface = fields[0]
+			}
+		}
+	}
+	return info, scanner.Err()
+}
+
+// readNetstatRoute parses "netstat -rn", used on platforms without /proc/net/route.
+func readNetstatRoute() (RouteInfo, error) {
+	var info RouteInfo
+	out, err := exec.Command("netstat", "-rn").Output()
+	if err != nil {
+		return info, err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || net.ParseIP(fields[1]) == nil {
+			continue
+		}
+		info.RouteCount++
+		if info.DefaultGateway != "" {
+			continue
+		}
+		switch {
+		case fields[0] == "default":
+			// macOS/FreeBSD: "default  192.168.1.1  UGScg  en0"
+			// OpenBSD: "default  192.168.1.1  UGS  5  120  -  8  em0"
+			info.DefaultGateway = fields[1]
+			if runtime.GOOS == "openbsd" && len(fields) >= 8 {
+				info.Interface = fields[7]
+			} else if len(fields) >= 4 {
+				info.Interface = fields[3]
+			}
+		case fields[0] == "0.0.0.0" && len(fields) >= 4 && fields[1] == "0.0.0.0":
+			// Windows: "0.0.0.0  0.0.0.0  192.168.1.1  192.168.1.10  25"
+			info.DefaultGateway = fields[2]
+			info.Interface = fields[3]
+		}
+	}
+	return info, nil
+}
+
+// collectRoutes reads the routing state when ROUTE_MONITORING is enabled (the default)
+// and emits an event when the default route changes or disappears.
+func collectRoutes() (*RouteInfo, error) {
+	if !collectorEnabled("ROUTE_MONITORING", true) {
+		return nil, nil
+	}
+	var info RouteInfo
+	var err error
+	if runtime.GOOS == "linux" {
+		info, err = readProcRoute()
+	} else {
+		info, err = readNetstatRoute()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the routing table: %v", err)
+	}
+
+	lastRoute.Lock()
+	defer lastRoute.Unlock()
+	if prev := lastRoute.info; prev != nil {
+		switch {
+		case prev.DefaultGateway != "" && info.DefaultGateway == "":
+			emitEvent("route.default_missing", "default route via %s disappeared", prev.DefaultGateway)
+		case prev.DefaultGateway == "" && info.DefaultGateway != "":
+			emitEvent("route.default_changed", "default route added via %s (%s)", info.DefaultGateway, info.Interface)
+		case prev.DefaultGateway != info.DefaultGateway || prev.Interface != info.Interface:
+			emitEvent("route.default_changed", "default route changed from %s (%s) to %s (%s)",
+				prev.DefaultGateway, prev.Interface, info.DefaultGateway, info.Interface)
+		}
+	}
+	lastRoute.info = &info
+	return &info, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	starlarkjson "go.starlark.net/lib/json"
+	starlarkmath "go.starlark.net/lib/math"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// Limits applied to every Starlark script.
+const (
+	scriptMaxSteps     = 10_000_000
+	scriptMaxReadBytes = 1 << 20
+)
+
+// scriptBuiltins returns the helpers available to custom metric scripts.
+func scriptBuiltins(ctx context.Context) starlark.StringDict {
+	return starlark.StringDict{
+		"json": starlarkjson.Module,
+		"math": starlarkmath.Module,
+		"read_file": starlark.NewBuiltin("read_file", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var path string
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &path); err != nil {
+				return nil, err
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			data, err := io.ReadAll(io.LimitReader(f, scriptMaxReadBytes))
+			if err != nil {
+				return nil, err
+			}
+			return starlark.String(data), nil
+		}),
+		"run": starlark.NewBuiltin("run", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if len(args) == 0 || len(kwargs) > 0 {
+				return nil, fmt.Errorf("%s: want run(command, *args)", b.Name())
+			}
+			argv := make([]string, len(args))
+			for i, a := range args {
+				s, ok := starlark.AsString(a)
+				if !ok {
+					return nil, fmt.Errorf("%s: argument %d is not a string", b.Name(), i+1)
+				}
+				argv[i] = s
+			}
+			out, err := exec.CommandContext(ctx, argv[0], argv[1:]...).Output()
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %v", b.Name(), argv[0], err)
+			}
+			return starlark.String(out), nil
+		}),
+	}
+}
+
+// starlarkToGo converts a script result into a JSON-compatible Go value.
+func starlarkToGo(v starlark.Value) (interface{}, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return v.String(), nil
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.String:
+		return string(v), nil
+	case *starlark.Dict:
+		m := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			key, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("dict key %s is not a string", item[0])
+			}
+			val, err := starlarkToGo(item[1])
+			if err != nil {
+				return nil, err
+			}
+			m[key] = val
+		}
+		return m, nil
+	case starlark.Indexable:
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			val, err := starlarkToGo(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			list[i] = val
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("unsupported value of type %s", v.Type())
+}
+
+// runScript executes a Starlark script and calls its collect() function, which must return
+// a dict of metric values.
+func runScript(path string) (map[string]interface{}, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout())
+	defer cancel()
+	thread := &starlark.Thread{Name: filepath.Base(path), Print: func(_ *starlark.Thread, msg string) {
+		fmt.Printf("[%s] %s\n", filepath.Base(path), msg)
+	}}
+	thread.SetMaxExecutionSteps(scriptMaxSteps)
+	stop := context.AfterFunc(ctx, func() { thread.Cancel("timeout") })
+	defer stop()
+
+	globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, thread, path, src, scriptBuiltins(ctx))
+	if err != nil {
+		return nil, err
+	}
+	collect, ok := globals["collect"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("script does not define collect()")
+	}
+	result, err := starlark.Call(thread, collect, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := result.(*starlark.Dict); !ok {
+		return nil, fmt.Errorf("collect() returned %s, want dict", result.Type())
+	}
+	value, err := starlarkToGo(result)
+	if err != nil {
+		return nil, err
+	}
+	return value.(map[string]interface{}), nil
+}
+
+// collectScripts runs every Starlark script (*.star) in SCRIPT_DIR and returns their
+// metrics keyed by script name.
+func collectScripts() map[string]PluginResult {
+	dir := os.Getenv("SCRIPT_DIR")
+	if dir == "" || !collectorEnabled("SCRIPTS", true) {
+		return nil
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.star"))
+	if len(paths) == 0 {
+		recordPartDurations("scripts", nil)
+		return nil
+	}
+	results := make(map[string]PluginResult, len(paths))
+	durations := make(map[string]time.Duration, len(paths))
+	for _, path := range paths {
+		start := time.Now()
+		var result PluginResult
+		metrics, err := runScript(path)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		result.Metrics = metrics
+		name := strings.TrimSuffix(filepath.Base(path), ".star")
+		results[name] = result
+		durations[name] = time.Since(start)
+	}
+	recordPartDurations("scripts", durations)
+	return results
+}
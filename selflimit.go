@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// SelfStats reports the agent's own resource usage and whether its configured limits were hit.
+type SelfStats struct {
+	RSSBytes       uint64  `json:"rssBytes"`
+	HeapBytes      uint64  `json:"heapBytes"`
+	CPUPercent     float64 `json:"cpuPercent"`
+	Goroutines     int     `json:"goroutines"`
+	CPULimitHit    bool    `json:"cpuLimitHit,omitempty"`
+	MemoryLimitHit bool    `json:"memoryLimitHit,omitempty"`
+	// Delivery reports the queue and spool depths and the samples retried, dropped or
+	// truncated since the previous payload.
+	Delivery *DeliveryStats `json:"delivery,omitempty"`
+	// ServerRTT reports the round-trip times of the metrics posts to each server.
+	ServerRTT []ServerRTT `json:"serverRtt,omitempty"`
+	// CollectorMs is how long the last run of each collector took, in milliseconds.
+	CollectorMs map[string]float64 `json:"collectorMs,omitempty"`
+}
+
+// selfLimits holds the configured self-resource limits and the current throttling state.
+var selfLimits struct {
+	sync.Mutex
+	cpuPercent  float64
+	memoryBytes uint64
+	throttled   bool
+	proc        *process.Process
+}
+
+// applySelfLimits configures the agent's own resource caps from AGENT_NICE,
+// AGENT_CPU_LIMIT_PERCENT and AGENT_MEMORY_LIMIT_MB.
+func applySelfLimits() {
+	if s := os.Getenv("AGENT_NICE"); s != "" {
+		if n, err := strconv.Atoi(s); err != nil {
+			fmt.Printf("Invalid AGENT_NICE value: %v\n", err)
+		} else if err := setNice(n); err != nil {
+			fmt.Printf("Error setting agent priority: %v\n", err)
+		}
+	}
+
+	selfLimits.Lock()
+	defer selfLimits.Unlock()
+	if s := os.Getenv("AGENT_CPU_LIMIT_PERCENT"); s != "" {
+		if v, err := strconv.ParseFloat(s, 64); err == nil && v > 0 {
+			selfLimits.cpuPercent = v
+		} else {
+			fmt.Printf("Invalid AGENT_CPU_LIMIT_PERCENT value: %s\n", s)
+		}
+	}
+	if s := os.Getenv("AGENT_MEMORY_LIMIT_MB"); s != "" {
+		if v, err := strconv.Atoi(s); err == nil && v > 0 {
+			selfLimits.memoryBytes = uint64(v) << 20
+			// Use most of the budget as the Go soft limit so the GC works harder before the cap is hit.
+			debug.SetMemoryLimit(int64(selfLimits.memoryBytes) * 8 / 10)
+		} else {
+			fmt.Printf("Invalid AGENT_MEMORY_LIMIT_MB value: %s\n", s)
+		}
+	}
+	selfLimits.proc, _ = process.NewProcess(int32(os.Getpid()))
+}
+
+// selfThrottled reports whether the agent is over one of its limits, in which case
+// optional collectors are skipped until usage drops again.
+func selfThrottled() bool {
+	selfLimits.Lock()
+	defer selfLimits.Unlock()
+	return selfLimits.throttled
+}
+
+// collectSelfStats measures the agent's own CPU and memory usage, updates the throttling
+// state and emits an agent.limit_exceeded event when a limit starts being exceeded.
+func collectSelfStats() *SelfStats {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	stats := &SelfStats{HeapBytes: ms.HeapAlloc, Goroutines: runtime.NumGoroutine(), Delivery: deliveryInterval(), ServerRTT: takeServerRTT(), CollectorMs: lastCollectorDurations()}
+
+	selfLimits.Lock()
+	defer selfLimits.Unlock()
+	if selfLimits.proc != nil {
+		if mi, err := selfLimits.proc.MemoryInfo(); err == nil {
+			stats.RSSBytes = mi.RSS
+		}
+		// Percent(0) measures usage since the previous call, i.e. over the last interval.
+		stats.CPUPercent, _ = selfLimits.proc.Percent(0)
+	}
+	stats.CPULimitHit = selfLimits.cpuPercent > 0 && stats.CPUPercent > selfLimits.cpuPercent
+	stats.MemoryLimitHit = selfLimits.memoryBytes > 0 && stats.RSSBytes > selfLimits.memoryBytes
+
+	throttled := stats.CPULimitHit || stats.MemoryLimitHit
+	if throttled && !selfLimits.throttled {
+		emitEvent("agent.limit_exceeded", "agent over its resource limits (cpu %.1f%%, rss %d MB), optional collectors paused",
+			stats.CPUPercent, stats.RSSBytes>>20)
+	}
+	if stats.MemoryLimitHit {
+		debug.FreeOSMemory()
+	}
+	selfLimits.throttled = throttled
+	return stats
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// setNice sets the scheduling priority of the agent process.
+func setNice(n int) error {
+	return unix.Setpriority(unix.PRIO_PROCESS, 0, n)
+}
@@ -0,0 +1,17 @@
+package main
+
+import "golang.org/x/sys/windows"
+
+// setNice maps a Unix nice value onto a Windows priority class.
+func setNice(n int) error {
+	class := uint32(windows.NORMAL_PRIORITY_CLASS)
+	switch {
+	case n >= 15:
+		class = windows.IDLE_PRIORITY_CLASS
+	case n > 0:
+		class = windows.BELOW_NORMAL_PRIORITY_CLASS
+	case n < 0:
+		class = windows.ABOVE_NORMAL_PRIORITY_CLASS
+	}
+	return windows.SetPriorityClass(windows.CurrentProcess(), class)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// parseServerURL validates a monitoring server base URL, such as
+// https://[2001:db8::1]:8443/monitoring, and returns it without a trailing slash. The
+// path, if any, is a prefix prepended to every API path.
+func parseServerURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid server URL %q: %v", raw, err)
+	}
+	switch {
+	case u.Scheme != "http" && u.Scheme != "https":
+		return "", fmt.Errorf("invalid server URL %q: the scheme must be http or https", raw)
+	case u.Hostname() == "":
+		return "", fmt.Errorf("invalid server URL %q: missing host", raw)
+	case strings.Contains(u.Hostname(), ":") && !strings.HasPrefix(u.Host, "["):
+		return "", fmt.Errorf("invalid server URL %q: IPv6 addresses must be enclosed in brackets, e.g. http://[::1]:8080", raw)
+	case u.User != nil:
+		return "", fmt.Errorf("invalid server URL %q: credentials in the URL are not supported", raw)
+	case u.RawQuery != "" || u.Fragment != "":
+		return "", fmt.Errorf("invalid server URL %q: a query or fragment is not allowed", raw)
+	}
+	if port := u.Port(); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", fmt.Errorf("invalid server URL %q: invalid port %q", raw, port)
+		}
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// withHostPort returns base with its host and port replaced, keeping the scheme and path
+// prefix. IPv6 hosts are bracketed.
+func withHostPort(base, host, port string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return base
+	}
+	u.Host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+	return u.String()
+}
+
+// urlPort returns the port of a validated server URL, or the default port of its scheme.
+func urlPort(base string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return ""
+	}
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if u.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// configuredServerURL returns the primary server base URL from MONITORING_SERVER_URL or,
+// when it is not set, from MONITORING_SERVER_HOST and MONITORING_SERVER_PORT.
+func configuredServerURL() (string, error) {
+	if raw := os.Getenv("MONITORING_SERVER_URL"); raw != "" {
+		return parseServerURL(raw)
+	}
+	host := os.Getenv("MONITORING_SERVER_HOST")
+	if host == "" {
+		host = "localhost"
+	}
+	port := os.Getenv("MONITORING_SERVER_PORT")
+	if port == "" {
+		port = "8080"
+	}
+	base, err := parseServerURL(withHostPort("http://localhost", host, port))
+	if err != nil {
+		return "", fmt.Errorf("invalid MONITORING_SERVER_HOST or MONITORING_SERVER_PORT: %v", err)
+	}
+	return base, nil
+}
+
+// configuredDRServerURL returns the disaster recovery server base URL from DR_SERVER_URL
+// or DR_SERVER_HOST and DR_SERVER_PORT, or "" when none is configured. A server given by
+// host uses the scheme and path prefix of primary, and its port unless DR_SERVER_PORT is set.
+func configuredDRServerURL(primary string) (string, error) {
+	if raw := os.Getenv("DR_SERVER_URL"); raw != "" {
+		return parseServerURL(raw)
+	}
+	host := os.Getenv("DR_SERVER_HOST")
+	if host == "" {
+		return "", nil
+	}
+	port := os.Getenv("DR_SERVER_PORT")
+	if port == "" {
+		port = urlPort(primary)
+	}
+	base, err := parseServerURL(withHostPort(primary, host, port))
+	if err != nil {
+		return "", fmt.Errorf("invalid DR_SERVER_HOST or DR_SERVER_PORT: %v", err)
+	}
+	return base, nil
+}
+
+// validateServerURLs checks the configured server addresses at startup, so a malformed
+// URL is reported immediately instead of as endless registration failures.
+func validateServerURLs() error {
+	primary, err := configuredServerURL()
+	if err != nil {
+		return err
+	}
+	_, err = configuredDRServerURL(primary)
+	return err
+}
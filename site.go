@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SiteInfo places the agent at a branch or edge site, so the server can group agents by
+// site and show them on a map.
+type SiteInfo struct {
+	Code      string   `json:"code,omitempty"`
+	Name      string   `json:"name,omitempty"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+}
+
+// parseCoordinate reads a coordinate in decimal degrees from the named variable, with
+// limit its largest absolute value. It returns nil when the variable is not set.
+func parseCoordinate(name string, limit float64) (*float64, error) {
+	s := strings.TrimSpace(os.Getenv(name))
+	if s == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < -limit || v > limit {
+		return nil, fmt.Errorf("invalid %s %q: use decimal degrees between -%g and %g", name, s, limit, limit)
+	}
+	return &v, nil
+}
+
+// loadSite reads the site from SITE_CODE, SITE_NAME, SITE_LATITUDE and SITE_LONGITUDE. It
+// returns nil when none is set.
+func loadSite() (*SiteInfo, error) {
+	site := &SiteInfo{
+		Code: strings.TrimSpace(os.Getenv("SITE_CODE")),
+		Name: strings.TrimSpace(os.Getenv("SITE_NAME")),
+	}
+	if site.Code != "" && !tenantIDPattern.MatchString(site.Code) {
+		return nil, fmt.Errorf("invalid SITE_CODE %q: use up to 128 letters, digits and _.:-", site.Code)
+	}
+	var err error
+	if site.Latitude, err = parseCoordinate("SITE_LATITUDE", 90); err != nil {
+		return nil, err
+	}
+	if site.Longitude, err = parseCoordinate("SITE_LONGITUDE", 180); err != nil {
+		return nil, err
+	}
+	if (site.Latitude == nil) != (site.Longitude == nil) {
+		return nil, fmt.Errorf("SITE_LATITUDE and SITE_LONGITUDE must be set together")
+	}
+	if *site == (SiteInfo{}) {
+		return nil, nil
+	}
+	return site, nil
+}
+
+// agentSite returns the configured site, or nil. It is validated at startup.
+func agentSite() *SiteInfo {
+	site, _ := loadSite()
+	return site
+}
+
+// validateSite checks the site variables at startup, so a mistyped coordinate does not
+// put the agent in the wrong place on the map.
+func validateSite() error {
+	_, err := loadSite()
+	return err
+}
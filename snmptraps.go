@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// snmpTrapOID is the varbind carrying the trap identifier of SNMPv2c traps.
+const snmpTrapOID = ".1.3.6.1.6.3.1.1.4.1.0"
+
+// snmpValue formats a varbind value for an event attribute.
+func snmpValue(pdu gosnmp.SnmpPDU) string {
+	switch v := pdu.Value.(type) {
+	case []byte:
+		if utf8.Valid(v) {
+			return string(v)
+		}
+		return hex.EncodeToString(v)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(pdu.Value)
+}
+
+// trapEvent converts a received trap into an snmp.trap event.
+func trapEvent(packet *gosnmp.SnmpPacket, from *net.UDPAddr) Event {
+	attributes := map[string]string{"source": from.IP.String()}
+	trapID := ""
+	switch packet.Version {
+	case gosnmp.Version1:
+		attributes["version"] = "1"
+		attributes["enterprise"] = packet.Enterprise
+		attributes["agentAddress"] = packet.AgentAddress
+		attributes["genericTrap"] = fmt.Sprint(packet.GenericTrap)
+		attributes["specificTrap"] = fmt.Sprint(packet.SpecificTrap)
+		trapID = fmt.Sprintf("%s generic %d specific %d", packet.Enterprise, packet.GenericTrap, packet.SpecificTrap)
+	default:
+		attributes["version"] = "2c"
+	}
+	if packet.PDUType == gosnmp.InformRequest {
+		attributes["inform"] = "true"
+	}
+	for _, v := range packet.Variables {
+		value := snmpValue(v)
+		if v.Name == snmpTrapOID {
+			trapID = value
+		}
+		attributes[v.Name] = value
+	}
+	if trapID != "" {
+		attributes["trapOid"] = trapID
+	}
+	return Event{
+		Type:       "snmp.trap",
+		Message:    fmt.Sprintf("SNMP trap %s from %s", trapID, from.IP),
+		Timestamp:  time.Now().UnixMilli(),
+		Attributes: attributes,
+	}
+}
+
+// startSNMPTrapReceiver listens for SNMPv1 and SNMPv2c traps and informs on
+// SNMP_TRAP_LISTEN and forwards each one to the server as an snmp.trap event. When
+// SNMP_TRAP_COMMUNITIES is set, traps with any other community string are dropped.
+func startSNMPTrapReceiver() {
+	addr := os.Getenv("SNMP_TRAP_LISTEN")
+	if addr == "" {
+		return
+	}
+	communities := make(map[string]bool)
+	for _, c := range envList("SNMP_TRAP_COMMUNITIES", nil) {
+		communities[c] = true
+	}
+	supervise("SNMP trap receiver", func() {
+		listener := gosnmp.NewTrapListener()
+		listener.Params = &gosnmp.GoSNMP{Version: gosnmp.Version2c}
+		listener.OnNewTrap = func(packet *gosnmp.SnmpPacket, from *net.UDPAddr) {
+			if !featureEnabled("SNMP_TRAPS", true) {
+				return
+			}
+			if packet.Version == gosnmp.Version3 {
+				fmt.Printf("Dropping SNMPv3 trap from %s: only v1 and v2c traps are supported\n", from.IP)
+				return
+			}
+			if len(communities) > 0 && !communities[packet.Community] {
+				fmt.Printf("Dropping SNMP trap from %s: unknown community\n", from.IP)
+				return
+			}
+			queueEvent(trapEvent(packet, from))
+		}
+		fmt.Printf("Listening for SNMP traps on %s\n", addr)
+		if err := listener.Listen(addr); err != nil {
+			fmt.Printf("SNMP trap receiver stopped: %v\n", err)
+		}
+	})
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultSpoolMaxBatches bounds the number of unacknowledged batches kept on disk.
+const defaultSpoolMaxBatches = 10000
+
+// deadLetterDirName is the directory in each spool holding the batches the server rejected
+// permanently or that cannot be decrypted, in the spool's format so they can be replayed.
+const deadLetterDirName = "dead-letter"
+
+// maxDeadLetterBatches bounds the number of batches kept in a dead-letter directory.
+const maxDeadLetterBatches = 1000
+
+// rejectedError is a response to a batch that sending it again cannot change: a 4xx
+// status other than 408 Request Timeout and 429 Too Many Requests.
+type rejectedError struct {
+	status string
+}
+
+func (e *rejectedError) Error() string {
+	return "metrics rejected with status: " + e.status
+}
+
+// permanentRejection reports whether a response status rejects a batch for good.
+func permanentRejection(status int) bool {
+	return status >= 400 && status <= 499 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
+
+// nextSeq returns the next sample sequence number, persisted so numbering continues across restarts.
+func nextSeq() uint64 {
+	var seq uint64
+	updateState(func(s *AgentState) {
+		s.LastSeq++
+		seq = s.LastSeq
+	})
+	return seq
+}
+
+// dir returns the directory holding this destination's unacknowledged metrics batches.
+func (d *destination) dir() string {
+	dir := filepath.Join(stateDir(), d.spoolDir)
+	os.MkdirAll(dir, 0o700)
+	return dir
+}
+
+// spoolFiles returns the spooled batch files ordered by sequence number.
+func (d *destination) spoolFiles() []string {
+	paths, _ := filepath.Glob(filepath.Join(d.dir(), "*.json"))
+	sort.Strings(paths)
+	return paths
+}
+
+// spoolBatch writes a batch to the spool, encrypted with SPOOL_ENCRYPTION, and applies the
+// retention policy.
+func (d *destination) spoolBatch(seq uint64, data []byte) error {
+	d.spoolMu.Lock()
+	defer d.spoolMu.Unlock()
+	sealed, err := sealBatch(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt batch %d: %v", seq, err)
+	}
+	path := filepath.Join(d.dir(), fmt.Sprintf("%020d.json", seq))
+	if err := writeFileAtomic(path, sealed); err != nil {
+		return fmt.Errorf("failed to spool batch %d: %v", seq, err)
+	}
+	d.enforceRetention()
+	return nil
+}
+
+// enforceRetention evicts the oldest spooled batches beyond SPOOL_MAX_BATCHES, older than
+// SPOOL_MAX_AGE_HOURS or beyond SPOOL_MAX_MB in total, so a long outage cannot fill the
+// disk. The newest batch is always kept. The caller holds spoolMu.
+func (d *destination) enforceRetention() {
+	maxBatches := defaultSpoolMaxBatches
+	if s := os.Getenv("SPOOL_MAX_BATCHES"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			maxBatches = n
+		}
+	}
+	maxAgeHours := envInt("SPOOL_MAX_AGE_HOURS", 0)
+	maxAge := time.Duration(maxAgeHours) * time.Hour
+	maxBytes := int64(envInt("SPOOL_MAX_MB", 0)) << 20
+
+	type spooled struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []spooled
+	var total int64
+	for _, path := range d.spoolFiles() {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		files = append(files, spooled{path, info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+	for len(files) > 1 {
+		oldest := files[0]
+		var reason string
+		switch {
+		case len(files) > maxBatches:
+			reason = "full"
+			d.evicted.Add(1)
+		case maxAge > 0 && time.Since(oldest.modTime) > maxAge:
+			reason = fmt.Sprintf("older than %dh", maxAgeHours)
+			d.evictedAge.Add(1)
+		case maxBytes > 0 && total > maxBytes:
+			reason = fmt.Sprintf("over %d MB", maxBytes>>20)
+			d.evictedSize.Add(1)
+		default:
+			return
+		}
+		os.Remove(oldest.path)
+		fmt.Printf("Spool for %s server %s, dropped batch %s\n", d.name, reason, filepath.Base(oldest.path))
+		files = files[1:]
+		total -= oldest.size
+	}
+}
+
+// deadLetter moves a spooled batch to the dead-letter directory, so it no longer holds back
+// the batches after it, and drops the oldest dead letters beyond maxDeadLetterBatches.
+func (d *destination) deadLetter(path string, reason error) {
+	dir := filepath.Join(d.dir(), deadLetterDirName)
+	d.deadLettered.Add(1)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		fmt.Printf("Error creating dead-letter directory, dropped batch %s: %v\n", filepath.Base(path), err)
+		os.Remove(path)
+		return
+	}
+	if err := os.Rename(path, filepath.Join(dir, filepath.Base(path))); err != nil {
+		fmt.Printf("Error moving batch %s to the dead-letter directory, dropped it: %v\n", filepath.Base(path), err)
+		os.Remove(path)
+		return
+	}
+	fmt.Printf("Moved batch %s for %s server to %s: %v\n", filepath.Base(path), d.name, dir, reason)
+	letters, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	sort.Strings(letters)
+	for len(letters) > maxDeadLetterBatches {
+		os.Remove(letters[0])
+		letters = letters[1:]
+	}
+}
+
+// spoolBytes returns the total size of the spooled batches.
+func (d *destination) spoolBytes() int64 {
+	var total int64
+	for _, path := range d.spoolFiles() {
+		if info, err := os.Stat(path); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// evictions returns the number of spooled batches evicted since the agent started, by any
+// retention limit.
+func (d *destination) evictions() uint64 {
+	return d.evicted.Load() + d.evictedAge.Load() + d.evictedSize.Load()
+}
+
+// postBatch sends a serialized batch to the server, compressed with the given content
+// coding, and returns the round-trip time from sending the request to receiving the
+// response headers. Only a 2xx response counts as an acknowledgement; a permanent
+// rejection is returned as a *rejectedError.
+func postBatch(data []byte, serverURL, encoding string) (time.Duration, error) {
+	body, err := encodeBody(data, encoding)
+	if err != nil {
+		return 0, fmt.Errorf("failed to compress metrics: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, serverURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to send metrics: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if encoding != "" && encoding != encodingIdentity {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	start := time.Now()
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send metrics: %v", err)
+	}
+	rtt := time.Since(start)
+	defer resp.Body.Close()
+	// Drain the body so the connection can be reused for the next batch.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if err := busyError(resp); err != nil {
+		return rtt, fmt.Errorf("metrics rejected: %w", err)
+	}
+	if permanentRejection(resp.StatusCode) {
+		return rtt, &rejectedError{status: resp.Status}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return rtt, fmt.Errorf("metrics rejected with status: %s", resp.Status)
+	}
+	fmt.Printf("Metrics sent: %s (%s)\n", resp.Status, rtt.Round(time.Microsecond))
+	return rtt, nil
+}
+
+// flushSpool sends spooled batches in sequence order, removing each one only after the
+// server acknowledges it. It stops at the first retryable failure (a network error, a 5xx,
+// 408 or 429 response) so ordering is preserved. Batches the server rejects permanently and
+// batches that cannot be decrypted are moved to the dead-letter directory instead, and the
+// flush goes on with the next one. Nothing is sent until the agent has registered, nor while
+// the server has asked the agent to back off with a 429 or 503 response.
+//
+// Spool files are written atomically, so the flush reads them without holding spoolMu; a
+// batch evicted by the retention policy while it is being sent is simply removed already.
+func (d *destination) flushSpool() error {
+	d.flushMu.Lock()
+	defer d.flushMu.Unlock()
+	if !d.registered.Load() {
+		fmt.Printf("Agent not registered with %s server yet, %d batches spooled\n", d.name, len(d.spoolFiles()))
+		return nil
+	}
+	if until, ok := d.paused(); ok {
+		fmt.Printf("Sends to %s server paused until %s, %d batches spooled\n", d.name, until.Format(time.RFC3339), len(d.spoolFiles()))
+		return nil
+	}
+	for _, path := range d.spoolFiles() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if data, err = openBatch(data); err != nil {
+			d.deadLetter(path, err)
+			continue
+		}
+		rtt, err := postBatch(data, d.endpoint(endpointMetrics), d.contentEncoding())
+		if rtt > 0 {
+			d.rtt.record(rtt)
+		}
+		var rejected *rejectedError
+		if errors.As(err, &rejected) {
+			d.deadLetter(path, err)
+			continue
+		}
+		if err != nil {
+			d.retried.Add(1)
+			if delay, ok := retryAfter(err); ok {
+				d.pause(delay)
+			}
+			return err
+		}
+		os.Remove(path)
+		var sent struct {
+			Timestamp int64 `json:"timestamp"`
+		}
+		json.Unmarshal(data, &sent)
+		seq, _ := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), ".json"), 10, 64)
+		updateState(func(s *AgentState) {
+			// Replace the map rather than mutating it: readState hands out shallow copies.
+			acks := map[string]uint64{d.name: seq}
+			for name, v := range s.LastAckSeq {
+				if name != d.name {
+					acks[name] = v
+				}
+			}
+			s.LastAckSeq = acks
+			if d.primary && sent.Timestamp != 0 {
+				s.LastSentTimestamp = sent.Timestamp
+			}
+		})
+	}
+	return nil
+}
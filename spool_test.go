@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPermanentRejection(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusBadRequest, true},
+		{http.StatusUnauthorized, true},
+		{http.StatusRequestEntityTooLarge, true},
+		{http.StatusRequestTimeout, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusServiceUnavailable, false},
+	}
+	for _, tt := range tests {
+		if got := permanentRejection(tt.status); got != tt.want {
+			t.Errorf("permanentRejection(%d) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestFlushSpoolDeadLetters(t *testing.T) {
+	t.Setenv("STATE_DIR", t.TempDir())
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body [64]byte
+		n, _ := r.Body.Read(body[:])
+		received = append(received, string(body[:n]))
+		if string(body[:n]) == `{"bad":true}` {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	d := newDestination("primary", server.URL, "spool", true)
+	d.registered.Store(true)
+	d.spoolBatch(1, []byte(`{"seq":1}`))
+	d.spoolBatch(2, []byte(`{"bad":true}`))
+	d.spoolBatch(3, []byte(`{"seq":3}`))
+	// A batch encrypted with a key that is no longer available.
+	os.WriteFile(filepath.Join(d.dir(), "00000000000000000004.json"), append([]byte(sealedBatchMagic), make([]byte, 40)...), 0o600)
+
+	if err := d.flushSpool(); err != nil {
+		t.Fatalf("flushSpool: %v", err)
+	}
+	if len(received) != 3 || received[2] != `{"seq":3}` {
+		t.Errorf("server received %q, want the three readable batches in order", received)
+	}
+	if files := d.spoolFiles(); len(files) != 0 {
+		t.Errorf("spool still holds %v", files)
+	}
+	letters, _ := filepath.Glob(filepath.Join(d.dir(), deadLetterDirName, "*.json"))
+	if len(letters) != 2 || d.deadLettered.Load() != 2 {
+		t.Errorf("dead letters %v (counted %d), want batches 2 and 4", letters, d.deadLettered.Load())
+	}
+}
+
+func TestEnforceRetention(t *testing.T) {
+	type file struct {
+		ageHours int
+		kb       int
+	}
+	tests := []struct {
+		name                   string
+		batches, age, mb       string
+		files                  []file
+		keep                   int
+		full, tooOld, tooLarge uint64
+	}{
+		{name: "within limits", files: []file{{3, 1}, {2, 1}, {1, 1}}, keep: 3},
+		{name: "too many batches", batches: "2", files: []file{{3, 1}, {2, 1}, {1, 1}}, keep: 2, full: 1},
+		{name: "too old", age: "2", files: []file{{5, 1}, {3, 1}, {1, 1}, {0, 1}}, keep: 2, tooOld: 2},
+		{name: "too large", mb: "1", files: []file{{3, 600}, {2, 600}, {1, 600}}, keep: 1, tooLarge: 2},
+		{name: "newest kept", age: "1", mb: "1", files: []file{{5, 2000}}, keep: 1},
+		{name: "invalid count ignored", batches: "-1", files: []file{{2, 1}, {1, 1}}, keep: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("STATE_DIR", t.TempDir())
+			t.Setenv("SPOOL_MAX_BATCHES", tt.batches)
+			t.Setenv("SPOOL_MAX_AGE_HOURS", tt.age)
+			t.Setenv("SPOOL_MAX_MB", tt.mb)
+			d := newDestination("primary", "http://127.0.0.1", "spool", true)
+			var paths []string
+			for i, f := range tt.files {
+				path := filepath.Join(d.dir(), fmt.Sprintf("%020d.json", i+1))
+				if err := os.WriteFile(path, make([]byte, f.kb<<10), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				mtime := time.Now().Add(-time.Duration(f.ageHours)*time.Hour - time.Minute)
+				os.Chtimes(path, mtime, mtime)
+				paths = append(paths, path)
+			}
+			d.enforceRetention()
+			if got, want := d.spoolFiles(), paths[len(paths)-tt.keep:]; !reflect.DeepEqual(got, want) {
+				t.Errorf("spool holds %v, want %v", got, want)
+			}
+			if d.evicted.Load() != tt.full || d.evictedAge.Load() != tt.tooOld || d.evictedSize.Load() != tt.tooLarge {
+				t.Errorf("evicted %d full, %d too old, %d too large; want %d, %d, %d",
+					d.evicted.Load(), d.evictedAge.Load(), d.evictedSize.Load(), tt.full, tt.tooOld, tt.tooLarge)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Markers of the files encrypted at rest. They start with a NUL byte, which a JSON
+// document never does, so plaintext files written before encryption was enabled are still
+// read as they are.
+var (
+	sealedBatchMagic  = []byte("\x00CSE1")
+	sealedUploadMagic = []byte("\x00CSU1")
+)
+
+// sealedSegmentSize is the plaintext size of each separately sealed segment of an
+// encrypted upload, so its chunks can be read without decrypting the whole file.
+const sealedSegmentSize = 64 << 10
+
+// spoolKeyFileName is the key generated in the state directory when neither SPOOL_KEY nor
+// SPOOL_KEY_FILE is set.
+const spoolKeyFileName = "spool.key"
+
+// spoolCipher caches the cipher of the spool key once loaded.
+var spoolCipher struct {
+	sync.Mutex
+	aead cipher.AEAD
+}
+
+// spoolEncryption reports whether spooled batches and staged uploads are encrypted at rest.
+func spoolEncryption() bool {
+	return envBool("SPOOL_ENCRYPTION", false)
+}
+
+// spoolKeyPath returns the path of the spool key file, from SPOOL_KEY_FILE or the default
+// in the state directory.
+func spoolKeyPath() string {
+	if path := os.Getenv("SPOOL_KEY_FILE"); path != "" {
+		return path
+	}
+	return filepath.Join(stateDir(), spoolKeyFileName)
+}
+
+// loadSpoolKey reads the 32-byte AES key of the spool from SPOOL_KEY (base64) or, if unset,
+// the key file. With create, a missing default key file is generated, readable only by the
+// agent's user.
+func loadSpoolKey(create bool) ([]byte, error) {
+	encoded := os.Getenv("SPOOL_KEY")
+	if encoded == "" {
+		path := spoolKeyPath()
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) && create && os.Getenv("SPOOL_KEY_FILE") == "" {
+			key := make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return nil, err
+			}
+			if err := writeFileAtomic(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n")); err != nil {
+				return nil, fmt.Errorf("failed to write spool key: %v", err)
+			}
+			fmt.Printf("Generated spool encryption key %s\n", path)
+			return key, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read spool key file: %v", err)
+		}
+		encoded = strings.TrimSpace(string(data))
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spool key encoding: %v", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid spool key length: got %d bytes, want 32", len(key))
+	}
+	return key, nil
+}
+
+// spoolAEAD returns the cipher of the spool key, loading the key on first use.
+func spoolAEAD(create bool) (cipher.AEAD, error) {
+	spoolCipher.Lock()
+	defer spoolCipher.Unlock()
+	if spoolCipher.aead != nil {
+		return spoolCipher.aead, nil
+	}
+	key, err := loadSpoolKey(create)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	spoolCipher.aead = aead
+	return aead, nil
+}
+
+// validateSpoolEncryption loads or generates the spool key at startup when SPOOL_ENCRYPTION
+// is enabled, so a missing or invalid key is reported before any batch is spooled.
+func validateSpoolEncryption() error {
+	if !spoolEncryption() {
+		return nil
+	}
+	_, err := spoolAEAD(true)
+	return err
+}
+
+// sealBatch encrypts a batch for the spool when SPOOL_ENCRYPTION is enabled.
+func sealBatch(data []byte) ([]byte, error) {
+	if !spoolEncryption() {
+		return data, nil
+	}
+	aead, err := spoolAEAD(true)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(append([]byte(nil), sealedBatchMagic...), nonce...)
+	return aead.Seal(out, nonce, data, sealedBatchMagic), nil
+}
+
+// openBatch decrypts a batch read from the spool, including its dead-letter directory, if
+// it is encrypted, whatever SPOOL_ENCRYPTION is now, and returns plaintext batches
+// unchanged.
+func openBatch(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, sealedBatchMagic) {
+		return data, nil
+	}
+	aead, err := spoolAEAD(false)
+	if err != nil {
+		return nil, err
+	}
+	sealed := data[len(sealedBatchMagic):]
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted batch too short")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], sealedBatchMagic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt batch: %v", err)
+	}
+	return plaintext, nil
+}
+
+// sealText encrypts a payload stored in a text column, such as the local metrics history,
+// when SPOOL_ENCRYPTION is enabled, as the base64 encoding of the sealed batch.
+func sealText(data []byte) (string, error) {
+	if !spoolEncryption() {
+		return string(data), nil
+	}
+	sealed, err := sealBatch(data)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openText decrypts a payload stored by sealText. JSON stored before encryption was
+// enabled is returned unchanged.
+func openText(text string) ([]byte, error) {
+	if strings.HasPrefix(text, "{") {
+		return []byte(text), nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted payload: %v", err)
+	}
+	return openBatch(sealed)
+}
+
+// segmentAAD returns the additional data of segment index of an encrypted upload. It binds
+// the segment's position and whether it is the last one, so that reordered, dropped or
+// truncated segments fail to decrypt.
+func segmentAAD(index int64, last bool) []byte {
+	aad := binary.BigEndian.AppendUint64(append([]byte(nil), sealedUploadMagic...), uint64(index))
+	if last {
+		return append(aad, 1)
+	}
+	return append(aad, 0)
+}
+
+// sealedWriter encrypts what is written to it in segments of sealedSegmentSize, each
+// sealed with its own nonce and its segmentAAD, after the sealedUploadMagic marker.
+type sealedWriter struct {
+	w    io.Writer
+	aead cipher.AEAD
+	buf  []byte
+	// index is the number of segments written so far.
+	index int64
+}
+
+// newSealedWriter writes the marker of an encrypted upload to w and returns a writer
+// encrypting into it. Close must be called to write the last segment.
+func newSealedWriter(w io.Writer) (*sealedWriter, error) {
+	aead, err := spoolAEAD(true)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(sealedUploadMagic); err != nil {
+		return nil, err
+	}
+	return &sealedWriter{w: w, aead: aead, buf: make([]byte, 0, sealedSegmentSize)}, nil
+}
+
+// Write implements io.Writer. A full segment is only sealed once more data follows, so
+// that Close can seal the last one as such.
+func (s *sealedWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if len(s.buf) == sealedSegmentSize {
+			if err := s.flush(false); err != nil {
+				return 0, err
+			}
+		}
+		k := min(len(p), sealedSegmentSize-len(s.buf))
+		s.buf = append(s.buf, p[:k]...)
+		p = p[k:]
+	}
+	return n, nil
+}
+
+// flush seals and writes the buffered segment.
+func (s *sealedWriter) flush(last bool) error {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	_, err := s.w.Write(s.aead.Seal(nonce, nonce, s.buf, segmentAAD(s.index, last)))
+	s.buf = s.buf[:0]
+	s.index++
+	return err
+}
+
+// Close writes the last segment, which may be partial or empty.
+func (s *sealedWriter) Close() error {
+	return s.flush(true)
+}
+
+// sealedReaderAt reads the plaintext of an upload encrypted by sealedWriter at any
+// offset, decrypting only the segments it covers.
+type sealedReaderAt struct {
+	r    io.ReaderAt
+	aead cipher.AEAD
+}
+
+// newSealedReaderAt returns a reader of the plaintext of the encrypted upload in r.
+func newSealedReaderAt(r io.ReaderAt) (*sealedReaderAt, error) {
+	magic := make([]byte, len(sealedUploadMagic))
+	if _, err := r.ReadAt(magic, 0); err != nil || !bytes.Equal(magic, sealedUploadMagic) {
+		return nil, fmt.Errorf("not an encrypted upload")
+	}
+	aead, err := spoolAEAD(false)
+	if err != nil {
+		return nil, err
+	}
+	return &sealedReaderAt{r: r, aead: aead}, nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (s *sealedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	overhead := int64(s.aead.NonceSize() + s.aead.Overhead())
+	segment := make([]byte, sealedSegmentSize+overhead)
+	n := 0
+	for n < len(p) {
+		index := (off + int64(n)) / sealedSegmentSize
+		start := int64(len(sealedUploadMagic)) + index*(sealedSegmentSize+overhead)
+		read, err := s.r.ReadAt(segment, start)
+		if read == 0 && err != nil {
+			return n, err
+		}
+		if int64(read) < overhead {
+			return n, io.ErrUnexpectedEOF
+		}
+		// The last segment of the file must have been sealed as the last one, so a file
+		// truncated at a segment boundary fails to decrypt.
+		last := read < len(segment)
+		if !last {
+			var next [1]byte
+			k, _ := s.r.ReadAt(next[:], start+int64(read))
+			last = k == 0
+		}
+		nonce := segment[:s.aead.NonceSize()]
+		plaintext, err := s.aead.Open(nil, nonce, segment[s.aead.NonceSize():read], segmentAAD(index, last))
+		if err != nil {
+			return n, fmt.Errorf("failed to decrypt upload: %v", err)
+		}
+		skip := (off + int64(n)) % sealedSegmentSize
+		if skip >= int64(len(plaintext)) {
+			return n, io.EOF
+		}
+		n += copy(p[n:], plaintext[skip:])
+	}
+	return n, nil
+}
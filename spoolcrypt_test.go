@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"testing"
+)
+
+// useSpoolKey enables spool encryption with a fixed key for the duration of a test.
+func useSpoolKey(t *testing.T) {
+	t.Helper()
+	t.Setenv("STATE_DIR", t.TempDir())
+	t.Setenv("SPOOL_ENCRYPTION", "true")
+	t.Setenv("SPOOL_KEY", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+	reset := func() {
+		spoolCipher.Lock()
+		spoolCipher.aead = nil
+		spoolCipher.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestSealBatchRoundTrip(t *testing.T) {
+	useSpoolKey(t)
+	batch := []byte(`{"seq":42,"hostname":"web-1"}`)
+	sealed, err := sealBatch(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("web-1")) {
+		t.Fatal("sealed batch contains the plaintext")
+	}
+	opened, err := openBatch(sealed)
+	if err != nil || !bytes.Equal(opened, batch) {
+		t.Fatalf("openBatch = %q, %v", opened, err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := openBatch(sealed); err == nil {
+		t.Error("tampered batch opened")
+	}
+	if opened, err := openBatch(batch); err != nil || !bytes.Equal(opened, batch) {
+		t.Errorf("plaintext batch: got %q, %v", opened, err)
+	}
+}
+
+func TestSealTextRoundTrip(t *testing.T) {
+	payload := []byte(`{"cpuUsage":12.5}`)
+	if text, _ := sealText(payload); text != string(payload) {
+		t.Errorf("without encryption got %q", text)
+	}
+	useSpoolKey(t)
+	text, err := sealText(payload)
+	if err != nil || text == string(payload) {
+		t.Fatalf("sealText = %q, %v", text, err)
+	}
+	for _, stored := range []string{text, string(payload)} {
+		if got, err := openText(stored); err != nil || !bytes.Equal(got, payload) {
+			t.Errorf("openText(%q) = %q, %v", stored, got, err)
+		}
+	}
+}
+
+// sealUpload encrypts data as a staged upload.
+func sealUpload(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := newSealedWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Odd-sized writes cross the segment boundaries.
+	for len(data) > 0 {
+		k := min(len(data), 10007)
+		if _, err := w.Write(data[:k]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[k:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSealedReaderAt(t *testing.T) {
+	useSpoolKey(t)
+	for _, size := range []int{1, sealedSegmentSize - 1, sealedSegmentSize, sealedSegmentSize + 1, 3*sealedSegmentSize + 5} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i * 31)
+		}
+		r, err := newSealedReaderAt(bytes.NewReader(sealUpload(t, data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, span := range [][2]int{{0, size}, {size / 2, size}, {size - 1, size}, {0, min(size, 1000)}} {
+			got := make([]byte, span[1]-span[0])
+			n, err := r.ReadAt(got, int64(span[0]))
+			if err != nil && err != io.EOF || n != len(got) || !bytes.Equal(got, data[span[0]:span[1]]) {
+				t.Errorf("size %d: ReadAt [%d, %d) read %d bytes, %v", size, span[0], span[1], n, err)
+			}
+		}
+	}
+}
+
+func TestSealedReaderAtDetectsTampering(t *testing.T) {
+	useSpoolKey(t)
+	data := bytes.Repeat([]byte("x"), 3*sealedSegmentSize)
+	sealed := sealUpload(t, data)
+	segment := (len(sealed) - len(sealedUploadMagic)) / 3
+	first := len(sealedUploadMagic)
+
+	swapped := bytes.Clone(sealed)
+	copy(swapped[first:], sealed[first+segment:first+2*segment])
+	copy(swapped[first+segment:], sealed[first:first+segment])
+	truncated := sealed[:first+2*segment]
+
+	for name, file := range map[string][]byte{"reordered": swapped, "truncated": truncated} {
+		r, err := newSealedReaderAt(bytes.NewReader(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, len(data))
+		if _, err := r.ReadAt(buf, 0); err == nil || err == io.EOF {
+			t.Errorf("%s upload read without a decryption error (%v)", name, err)
+		}
+	}
+}
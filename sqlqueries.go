@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+)
+
+// Defaults for SQL queries that do not set their own limits.
+const (
+	defaultSQLTimeout = 5 * time.Second
+	defaultSQLMaxRows = 100
+)
+
+// SQLQuery is a configured read-only query whose numeric result is reported as a metric.
+type SQLQuery struct {
+	Name string `json:"name"`
+	// Driver is "postgres" or "mysql".
+	Driver string `json:"driver"`
+	// DSN is the connection string; DSNEnv names an environment variable holding it
+	// instead, so it can be stored encrypted.
+	DSN            string `json:"dsn,omitempty"`
+	DSNEnv         string `json:"dsnEnv,omitempty"`
+	Query          string `json:"query"`
+	TimeoutSeconds int    `json:"timeoutSeconds,omitempty"`
+	MaxRows        int    `json:"maxRows,omitempty"`
+}
+
+// SQLResult holds the outcome of one SQL query. A single-row, single-column result is
+// reported in Value; otherwise each row's last column is reported in Values, keyed by the
+// row's other columns joined with ".".
+type SQLResult struct {
+	Name   string             `json:"name"`
+	Value  *float64           `json:"value,omitempty"`
+	Values map[string]float64 `json:"values,omitempty"`
+	Error  string             `json:"error,omitempty"`
+}
+
+// sqlQueries is the configuration loaded from SQL_QUERIES_FILE.
+var sqlQueries struct {
+	sync.Mutex
+	loaded  bool
+	queries []SQLQuery
+	dbs     map[string]*sql.DB
+}
+
+// loadSQLQueries reads the query configuration once.
+func loadSQLQueries() []SQLQuery {
+	sqlQueries.Lock()
+	defer sqlQueries.Unlock()
+	if sqlQueries.loaded {
+		return sqlQueries.queries
+	}
+	sqlQueries.loaded = true
+	file := os.Getenv("SQL_QUERIES_FILE")
+	if file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Printf("Error reading SQL queries: %v\n", err)
+		return nil
+	}
+	if err := json.Unmarshal(data, &sqlQueries.queries); err != nil {
+		fmt.Printf("Invalid SQL queries file: %v\n", err)
+	}
+	return sqlQueries.queries
+}
+
+// sqlDB returns a connection pool for the query's driver and DSN, opening it on first use.
+func sqlDB(q SQLQuery) (*sql.DB, error) {
+	dsn := q.DSN
+	if q.DSNEnv != "" {
+		dsn = os.Getenv(q.DSNEnv)
+	}
+	if dsn == "" {
+		return nil, fmt.Errorf("no DSN configured")
+	}
+	sqlQueries.Lock()
+	defer sqlQueries.Unlock()
+	key := q.Driver + "\x00" + dsn
+	if db, ok := sqlQueries.dbs[key]; ok {
+		return db, nil
+	}
+	db, err := sql.Open(q.Driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+	if sqlQueries.dbs == nil {
+		sqlQueries.dbs = make(map[string]*sql.DB)
+	}
+	sqlQueries.dbs[key] = db
+	return db, nil
+}
+
+// toFloat converts a scanned column value into a number.
+func toFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case []byte:
+		return strconv.ParseFloat(string(v), 64)
+	case string:
+		return strconv.ParseFloat(v, 64)
+	case nil:
+		return 0, fmt.Errorf("NULL value")
+	}
+	return 0, fmt.Errorf("unsupported value type %T", v)
+}
+
+// runSQLQuery runs one query in a read-only transaction, within its timeout and row limit.
+func runSQLQuery(q SQLQuery) SQLResult {
+	result := SQLResult{Name: q.Name}
+	timeout := defaultSQLTimeout
+	if q.TimeoutSeconds > 0 {
+		timeout = time.Duration(q.TimeoutSeconds) * time.Second
+	}
+	maxRows := defaultSQLMaxRows
+	if q.MaxRows > 0 {
+		maxRows = q.MaxRows
+	}
+	db, err := sqlDB(q)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, q.Query)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil || len(cols) == 0 {
+		result.Error = fmt.Sprintf("no result columns: %v", err)
+		return result
+	}
+
+	values := make(map[string]float64)
+	n := 0
+	for rows.Next() {
+		if n++; n > maxRows {
+			break
+		}
+		raw := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range raw {
+			ptrs[i] = &raw[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		v, err := toFloat(raw[len(raw)-1])
+		if err != nil {
+			result.Error = fmt.Sprintf("column %s: %v", cols[len(cols)-1], err)
+			return result
+		}
+		labels := make([]string, len(raw)-1)
+		for i, l := range raw[:len(raw)-1] {
+			if b, ok := l.([]byte); ok {
+				l = string(b)
+			}
+			labels[i] = fmt.Sprint(l)
+		}
+		values[strings.Join(labels, ".")] = v
+	}
+	if err := rows.Err(); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if v, ok := values[""]; ok && len(values) == 1 && len(cols) == 1 {
+		result.Value = &v
+	} else {
+		result.Values = values
+	}
+	return result
+}
+
+// collectSQL runs the configured SQL queries concurrently.
+func collectSQL() []SQLResult {
+	queries := loadSQLQueries()
+	if len(queries) == 0 || !collectorEnabled("SQL_QUERIES", true) {
+		return nil
+	}
+	results := make([]SQLResult, len(queries))
+	var wg sync.WaitGroup
+	for i, q := range queries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = runSQLQuery(q)
+		}()
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ssdpAddr is the SSDP multicast group.
+var ssdpAddr = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+// ssdpSearch is the M-SEARCH request asking every UPnP device to answer.
+const ssdpSearch = "M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: 239.255.255.250:1900\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"MX: 2\r\n" +
+	"ST: ssdp:all\r\n\r\n"
+
+// upnpDescription is the part of a UPnP device description reported for SSDP assets.
+type upnpDescription struct {
+	Device struct {
+		DeviceType   string `xml:"deviceType"`
+		FriendlyName string `xml:"friendlyName"`
+		Manufacturer string `xml:"manufacturer"`
+		ModelName    string `xml:"modelName"`
+	} `xml:"device"`
+}
+
+// ssdpHeaders parses an SSDP search response or NOTIFY announcement.
+func ssdpHeaders(data []byte) (http.Header, bool) {
+	reader := bufio.NewReader(bytes.NewReader(data))
+	if bytes.HasPrefix(data, []byte("HTTP/")) {
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			return nil, false
+		}
+		resp.Body.Close()
+		return resp.Header, true
+	}
+	req, err := http.ReadRequest(reader)
+	if err != nil || req.Method != "NOTIFY" {
+		return nil, false
+	}
+	if req.Header.Get("NTS") == "ssdp:byebye" {
+		return nil, false
+	}
+	return req.Header, true
+}
+
+// fetchUPnPDescription downloads the device description at location.
+func fetchUPnPDescription(location string) (*upnpDescription, error) {
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var desc upnpDescription
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 256<<10)).Decode(&desc); err != nil {
+		return nil, err
+	}
+	return &desc, nil
+}
+
+// upnpTypeName shortens a UPnP type URN to its type name, e.g. "MediaRenderer" for
+// "urn:schemas-upnp-org:device:MediaRenderer:1".
+func upnpTypeName(urn string) string {
+	parts := strings.Split(urn, ":")
+	if len(parts) >= 5 && parts[0] == "urn" {
+		return parts[3]
+	}
+	return urn
+}
+
+// discoverSSDP sends an SSDP M-SEARCH and records the devices that answer or announce
+// themselves, then fetches each device's UPnP description for its name and model.
+func discoverSSDP(set *assetSet, window time.Duration) error {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var mu sync.Mutex
+	locations := make(map[string]string)
+	handle := func(data []byte, from net.IP) {
+		header, ok := ssdpHeaders(data)
+		if !ok {
+			return
+		}
+		set.Lock()
+		a := set.get("ssdp", from.String())
+		if a.Server == "" {
+			a.Server = header.Get("Server")
+		}
+		target := header.Get("ST")
+		if target == "" {
+			target = header.Get("NT")
+		}
+		set.addService(a, target)
+		set.Unlock()
+		if location := header.Get("Location"); location != "" {
+			mu.Lock()
+			if locations[from.String()] == "" {
+				locations[from.String()] = location
+			}
+			mu.Unlock()
+		}
+	}
+
+	var wg sync.WaitGroup
+	deadline := time.Now().Add(window)
+	if group, err := net.ListenMulticastUDP("udp4", nil, ssdpAddr); err == nil {
+		defer group.Close()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			readPackets(group, deadline, handle)
+		}()
+	}
+	if _, err := conn.WriteTo([]byte(ssdpSearch), ssdpAddr); err != nil {
+		return err
+	}
+	readPackets(conn, deadline, handle)
+	wg.Wait()
+
+	for address, location := range locations {
+		// Only descriptions served by the announcing device itself are fetched.
+		if u, err := url.Parse(location); err != nil || u.Hostname() != address {
+			continue
+		}
+		desc, err := fetchUPnPDescription(location)
+		if err != nil {
+			continue
+		}
+		set.Lock()
+		a := set.get("ssdp", address)
+		a.Name = desc.Device.FriendlyName
+		a.Type = upnpTypeName(desc.Device.DeviceType)
+		a.Manufacturer = desc.Device.Manufacturer
+		a.Model = desc.Device.ModelName
+		set.Unlock()
+	}
+	return nil
+}
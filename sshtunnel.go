@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Defaults of the SSH tunnel to the monitoring servers.
+const (
+	defaultSSHPort         = "22"
+	sshKeepaliveInterval   = 30 * time.Second
+	sshHandshakeTimeout    = 15 * time.Second
+	sshKeepaliveRequest    = "keepalive@openssh.com"
+	defaultKnownHostsInDir = ".ssh/known_hosts"
+)
+
+// sshTunnel forwards the connections to the monitoring servers through an SSH bastion,
+// for networks where only SSH egress is allowed. The SSH connection is opened on the first
+// dial and reopened when it drops.
+type sshTunnel struct {
+	addr   string
+	config *ssh.ClientConfig
+	dialer *net.Dialer
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// parseSSHTunnel parses SSH_TUNNEL, user@host[:port] of the bastion, and returns the
+// bastion address and user.
+func parseSSHTunnel(s string) (addr, user string, err error) {
+	user, host, ok := strings.Cut(s, "@")
+	if !ok || user == "" || host == "" {
+		return "", "", fmt.Errorf("invalid SSH_TUNNEL %q: use user@host[:port]", s)
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), defaultSSHPort)
+	}
+	return host, user, nil
+}
+
+// knownHostsPath returns the known_hosts file used to verify the bastion, from
+// SSH_TUNNEL_KNOWN_HOSTS or ~/.ssh/known_hosts.
+func knownHostsPath() string {
+	if path := os.Getenv("SSH_TUNNEL_KNOWN_HOSTS"); path != "" {
+		return path
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, defaultKnownHostsInDir)
+}
+
+// loadSSHTunnel builds the tunnel configured with SSH_TUNNEL, authenticating with the
+// private key in SSH_TUNNEL_KEY_FILE (decrypted with SSH_TUNNEL_KEY_PASSPHRASE if set) and
+// verifying the bastion's host key against the known_hosts file. It returns nil when
+// SSH_TUNNEL is not set.
+func loadSSHTunnel(dialer *net.Dialer) (*sshTunnel, error) {
+	spec := strings.TrimSpace(os.Getenv("SSH_TUNNEL"))
+	if spec == "" {
+		return nil, nil
+	}
+	addr, user, err := parseSSHTunnel(spec)
+	if err != nil {
+		return nil, err
+	}
+	keyFile := os.Getenv("SSH_TUNNEL_KEY_FILE")
+	if keyFile == "" {
+		return nil, fmt.Errorf("SSH_TUNNEL requires SSH_TUNNEL_KEY_FILE")
+	}
+	pem, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH_TUNNEL_KEY_FILE: %v", err)
+	}
+	var signer ssh.Signer
+	if passphrase := os.Getenv("SSH_TUNNEL_KEY_PASSPHRASE"); passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(pem)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSH_TUNNEL_KEY_FILE: %v", err)
+	}
+	hostKeys, err := knownhosts.New(knownHostsPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts for SSH_TUNNEL: %v", err)
+	}
+	return &sshTunnel{
+		addr: addr,
+		config: &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeys,
+			Timeout:         sshHandshakeTimeout,
+		},
+		dialer: dialer,
+	}, nil
+}
+
+// validateSSHTunnel checks the SSH tunnel configuration at startup, so a missing key or
+// known_hosts entry is reported before the first connection to the servers.
+func validateSSHTunnel() error {
+	_, err := loadSSHTunnel(&net.Dialer{})
+	return err
+}
+
+// connect returns the SSH connection to the bastion, opening it if needed.
+func (t *sshTunnel) connect(ctx context.Context) (*ssh.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client != nil {
+		return t.client, nil
+	}
+	conn, err := t.dialer.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach SSH bastion %s: %v", t.addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, t.addr, t.config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SSH handshake with %s failed: %v", t.addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+	client := ssh.NewClient(c, chans, reqs)
+	t.client = client
+	fmt.Printf("SSH tunnel to %s established\n", t.addr)
+	go t.keepalive(client)
+	return client, nil
+}
+
+// keepalive pings the bastion until the connection fails, then drops it so the next dial
+// reconnects.
+func (t *sshTunnel) keepalive(client *ssh.Client) {
+	ticker := time.NewTicker(sshKeepaliveInterval)
+	defer ticker.Stop()
+	done := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			t.drop(client, fmt.Errorf("connection closed"))
+			return
+		case <-ticker.C:
+			if _, _, err := client.SendRequest(sshKeepaliveRequest, true, nil); err != nil {
+				t.drop(client, err)
+				return
+			}
+		}
+	}
+}
+
+// drop closes client and forgets it if it is still the current connection.
+func (t *sshTunnel) drop(client *ssh.Client, reason error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client == client {
+		t.client = nil
+		fmt.Printf("SSH tunnel to %s lost: %v\n", t.addr, reason)
+	}
+	client.Close()
+}
+
+// dialContext opens a connection to addr through the bastion, which resolves the server
+// host names. A connection that fails on a stale SSH session is retried on a new one.
+func (t *sshTunnel) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	for attempt := 0; ; attempt++ {
+		client, err := t.connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := client.DialContext(ctx, network, addr)
+		if err == nil {
+			return conn, nil
+		}
+		// The bastion refusing the forwarding keeps the session usable; other errors mean
+		// the connection is gone.
+		if _, ok := err.(*ssh.OpenChannelError); ok || attempt > 0 || ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to open %s through SSH tunnel %s: %v", addr, t.addr, err)
+		}
+		t.drop(client, err)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Files kept in the state directory.
+const (
+	agentIDFile   = "agent-id"
+	stateFileName = "state.json"
+)
+
+// AgentState is the small piece of runtime state persisted across restarts and upgrades.
+type AgentState struct {
+	// LastSentTimestamp is the timestamp of the last metrics payload accepted by the server.
+	LastSentTimestamp int64 `json:"lastSentTimestamp,omitempty"`
+	// LastSeq is the sequence number assigned to the most recent metrics sample.
+	LastSeq uint64 `json:"lastSeq,omitempty"`
+	// LastAckSeq is the sequence number of the last batch acknowledged by each server, by
+	// destination name.
+	LastAckSeq map[string]uint64 `json:"lastAckSeq,omitempty"`
+	// RemoteFlags are the feature flags last received from the server.
+	RemoteFlags map[string]bool `json:"remoteFlags,omitempty"`
+	// CollectorOverrides are the collectors switched on or off through the agent API, and
+	// RemoteDisabledCollectors those disabled by the remote configuration.
+	CollectorOverrides       map[string]bool `json:"collectorOverrides,omitempty"`
+	RemoteDisabledCollectors []string        `json:"remoteDisabledCollectors,omitempty"`
+}
+
+// cachedAgentID holds the agent ID once loaded.
+var cachedAgentID struct {
+	sync.Mutex
+	id string
+}
+
+// persistentState holds the loaded state and serializes updates to the state file.
+var persistentState struct {
+	sync.Mutex
+	state  AgentState
+	loaded bool
+}
+
+// defaultStateDir is the state directory used when STATE_DIR is not set, chosen once.
+var defaultStateDir struct {
+	sync.Once
+	dir string
+}
+
+// stateDir returns the directory holding the agent's persistent state, from STATE_DIR
+// or a platform default, creating it if needed. When the platform default cannot be
+// created, such as /var/lib/cheetah-agent for an unprivileged user, the user's cache
+// directory is used instead.
+func stateDir() string {
+	if dir := os.Getenv("STATE_DIR"); dir != "" {
+		os.MkdirAll(dir, 0o700)
+		return dir
+	}
+	defaultStateDir.Do(func() {
+		dir := filepath.Join(os.Getenv("ProgramData"), "cheetah-agent")
+		if runtime.GOOS != "windows" {
+			dir = "/var/lib/cheetah-agent"
+		}
+		err := os.MkdirAll(dir, 0o700)
+		if err == nil {
+			defaultStateDir.dir = dir
+			return
+		}
+		if cache, cerr := os.UserCacheDir(); cerr == nil {
+			fallback := filepath.Join(cache, "cheetah-agent")
+			if os.MkdirAll(fallback, 0o700) == nil {
+				fmt.Printf("Cannot create state directory %s (%v), using %s; set STATE_DIR to choose another\n", dir, err, fallback)
+				defaultStateDir.dir = fallback
+				return
+			}
+		}
+		defaultStateDir.dir = dir
+	})
+	return defaultStateDir.dir
+}
+
+// checkStateDir verifies at startup that the state directory exists and is writable, so a
+// misconfigured service fails with a clear message.
+func checkStateDir() error {
+	dir := stateDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory %s, set STATE_DIR to a writable directory: %v", dir, err)
+	}
+	f, err := os.CreateTemp(dir, ".write-test-*")
+	if err != nil {
+		return fmt.Errorf("state directory %s is not writable, set STATE_DIR to a writable directory: %v", dir, err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
+}
+
+// writeFileAtomic writes data to path through a temporary file and a rename,
+// so a crash mid-write never leaves a truncated state file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// loadAgentID returns the agent's persistent UUID, generating and storing it on first start.
+func loadAgentID() (string, error) {
+	cachedAgentID.Lock()
+	defer cachedAgentID.Unlock()
+	if cachedAgentID.id != "" {
+		return cachedAgentID.id, nil
+	}
+	path := filepath.Join(stateDir(), agentIDFile)
+	if data, err := os.ReadFile(path); err == nil {
+		if id := strings.TrimSpace(string(data)); id != "" {
+			cachedAgentID.id = id
+			return id, nil
+		}
+	}
+	id, err := newUUID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate agent ID: %v", err)
+	}
+	if err := writeFileAtomic(path, []byte(id+"\n")); err != nil {
+		return "", fmt.Errorf("failed to store agent ID: %v", err)
+	}
+	cachedAgentID.id = id
+	return id, nil
+}
+
+// storeAgentID replaces the agent's persistent UUID, e.g. with an identity assigned by the server.
+func storeAgentID(id string) error {
+	cachedAgentID.Lock()
+	defer cachedAgentID.Unlock()
+	if err := writeFileAtomic(filepath.Join(stateDir(), agentIDFile), []byte(id+"\n")); err != nil {
+		return fmt.Errorf("failed to store agent ID: %v", err)
+	}
+	cachedAgentID.id = id
+	return nil
+}
+
+// loadStateLocked reads the state file once; persistentState must be locked.
+func loadStateLocked() {
+	if persistentState.loaded {
+		return
+	}
+	persistentState.loaded = true
+	data, err := os.ReadFile(filepath.Join(stateDir(), stateFileName))
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &persistentState.state); err != nil {
+		fmt.Printf("Error reading state file, starting from empty state: %v\n", err)
+	}
+}
+
+// readState returns a copy of the persisted state.
+func readState() AgentState {
+	persistentState.Lock()
+	defer persistentState.Unlock()
+	loadStateLocked()
+	return persistentState.state
+}
+
+// updateState applies fn to the persisted state and writes it back to disk.
+func updateState(fn func(s *AgentState)) {
+	persistentState.Lock()
+	defer persistentState.Unlock()
+	loadStateLocked()
+	fn(&persistentState.state)
+	data, err := json.MarshalIndent(persistentState.state, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := writeFileAtomic(filepath.Join(stateDir(), stateFileName), data); err != nil {
+		fmt.Printf("Error writing state file: %v\n", err)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckStateDir(t *testing.T) {
+	base := t.TempDir()
+	file := filepath.Join(base, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		dir     string
+		wantErr bool
+	}{
+		{filepath.Join(base, "state"), false},
+		{filepath.Join(base, "nested", "state"), false},
+		{filepath.Join(file, "state"), true},
+	}
+	for _, tt := range tests {
+		t.Setenv("STATE_DIR", tt.dir)
+		if err := checkStateDir(); (err != nil) != tt.wantErr {
+			t.Errorf("STATE_DIR=%s: got error %v, want error %v", tt.dir, err, tt.wantErr)
+		}
+	}
+}
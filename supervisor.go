@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"time"
+)
+
+// Backoff bounds for restarting a supervised component.
+const (
+	minRestartDelay = time.Second
+	maxRestartDelay = time.Minute
+)
+
+// reportPanic logs a recovered panic with its stack and emits an agent.crash event.
+func reportPanic(component string, r interface{}) {
+	fmt.Printf("Recovered panic in %s: %v\n%s\n", component, r, debug.Stack())
+	emitEvent("agent.crash", "%s panicked: %v", component, r)
+}
+
+// runSafely runs fn, recovering from any panic. It reports whether fn completed normally.
+func runSafely(component string, fn func()) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			reportPanic(component, r)
+			ok = false
+		}
+	}()
+	fn()
+	return true
+}
+
+// safeCollect runs a collector, returning its zero value and the panic message if it
+// panics, so one faulty collector cannot take down the whole payload.
+func safeCollect[T any](name string, collect func() T) (result T, panicMsg string) {
+	defer func() {
+		if r := recover(); r != nil {
+			reportPanic("collector "+name, r)
+			panicMsg = fmt.Sprintf("panic: %v", r)
+		}
+	}()
+	return collect(), ""
+}
+
+// collectionTimes records when each collector's data was gathered, as Unix nanoseconds
+// (UTC), keyed by collector name.
+type collectionTimes map[string]int64
+
+// mark records that the named collector's data was gathered now.
+func (t collectionTimes) mark(name string) {
+	t[name] = time.Now().UnixNano()
+}
+
+// timedCollect runs a collector that cannot fail like checkedCollect.
+func timedCollect[T any](times collectionTimes, name string, collect func() T) T {
+	return checkedCollect(times, name, func() (T, error) { return collect(), nil })
+}
+
+// checkedCollect runs a collector like safeCollect, records when its data was gathered,
+// unless it returned nothing, and tracks its health and how long it took. A run fails when
+// the collector panics, returns an error or returns a result whose Error field is set. A
+// collector disabled after repeated failures returns nothing until its next retry.
+func checkedCollect[T any](times collectionTimes, name string, collect func() (T, error)) T {
+	if collectorSkipped(name) {
+		var zero T
+		return zero
+	}
+	start := time.Now()
+	var err error
+	result, panicMsg := safeCollect(name, func() T {
+		var result T
+		result, err = collect()
+		return result
+	})
+	recordCollectorDuration(name, time.Since(start))
+	switch {
+	case panicMsg != "":
+		recordCollectorRun(name, panicMsg)
+	case err != nil:
+		fmt.Printf("Error in collector %s: %v\n", name, err)
+		recordCollectorRun(name, err.Error())
+	default:
+		recordCollectorRun(name, resultError(result))
+	}
+	if !reflect.ValueOf(&result).Elem().IsZero() {
+		times.mark(name)
+	}
+	return result
+}
+
+// supervise runs fn in a background goroutine and restarts it with exponential backoff
+// whenever it panics or returns.
+func supervise(component string, fn func()) {
+	go func() {
+		delay := minRestartDelay
+		for {
+			start := time.Now()
+			if runSafely(component, fn) {
+				fmt.Printf("%s stopped, restarting in %s\n", component, delay)
+			} else {
+				fmt.Printf("%s crashed, restarting in %s\n", component, delay)
+			}
+			// A component that ran for a while before failing starts again from the minimum delay.
+			if time.Since(start) > maxRestartDelay {
+				delay = minRestartDelay
+			}
+			time.Sleep(delay)
+			delay *= 2
+			if delay > maxRestartDelay {
+				delay = maxRestartDelay
+			}
+		}
+	}()
+}
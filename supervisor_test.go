@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckedCollectRecordsErrors(t *testing.T) {
+	t.Setenv("COLLECTOR_MAX_FAILURES", "2")
+	t.Cleanup(func() {
+		collectorHealth.Lock()
+		delete(collectorHealth.collectors, "test")
+		collectorHealth.Unlock()
+	})
+	fail := func() (*int, error) { return nil, errors.New("source unreadable") }
+	times := collectionTimes{}
+
+	checkedCollect(times, "test", fail)
+	if h := collectorStatus("test"); h.State != collectorDegraded || h.LastError != "source unreadable" {
+		t.Fatalf("after one error: %+v", h.CollectorHealth)
+	}
+	checkedCollect(times, "test", fail)
+	if h := collectorStatus("test"); h.State != collectorFailed || h.ConsecutiveErrors != 2 {
+		t.Fatalf("after two errors: %+v", h.CollectorHealth)
+	}
+	if _, ok := times["test"]; ok {
+		t.Error("failed collector marked as collected")
+	}
+
+	// A failed collector is skipped until its retry; once reset, a success clears it.
+	resetCollectorHealth("test")
+	v := 1
+	if got := checkedCollect(times, "test", func() (*int, error) { return &v, nil }); got != &v {
+		t.Errorf("got %v, want the collector's result", got)
+	}
+	if h := collectorStatus("test"); h.State != collectorOK || h.TotalErrors != 2 {
+		t.Errorf("after a success: %+v", h.CollectorHealth)
+	}
+}
+
+func TestResultError(t *testing.T) {
+	type withError struct{ Error string }
+	tests := []struct {
+		result interface{}
+		want   string
+	}{
+		{nil, ""},
+		{(*withError)(nil), ""},
+		{&withError{Error: "boom"}, "boom"},
+		{withError{}, ""},
+		{[]int{1}, ""},
+	}
+	for _, tt := range tests {
+		if got := resultError(tt.result); got != tt.want {
+			t.Errorf("resultError(%#v) = %q, want %q", tt.result, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	psnet "github.com/shirou/gopsutil/v3/net"
+)
+
+// TCPStats reports TCP retransmissions and round-trip times.
+type TCPStats struct {
+	OutSegs     int64   `json:"outSegs"`
+	RetransSegs int64   `json:"retransSegs"`
+	RetransRate float64 `json:"retransRate"`
+	Connections int     `json:"connections,omitempty"`
+	AvgRTTMs    float64 `json:"avgRttMs,omitempty"`
+	MaxRTTMs    float64 `json:"maxRttMs,omitempty"`
+}
+
+// lastTCPCounters holds the previous segment counters, used to compute the retransmit rate.
+var lastTCPCounters struct {
+	sync.Mutex
+	outSegs     int64
+	retransSegs int64
+}
+
+// readSocketRTT parses the smoothed RTT of every established TCP connection from "ss -ti"
+// and returns the connection count, average and maximum RTT in milliseconds.
+func readSocketRTT() (int, float64, float64) {
+	out, err := exec.Command("ss", "-tin", "state", "established").Output()
+	if err != nil {
+		return 0, 0, 0
+	}
+	var count int
+	var sum, max float64
+	for _, field := range strings.Fields(string(out)) {
+		value, ok := strings.CutPrefix(field, "rtt:")
+		if !ok {
+			continue
+		}
+		// Format: rtt:<srtt>/<rttvar>
+		srtt, _, _ := strings.Cut(value, "/")
+		rtt, err := strconv.ParseFloat(srtt, 64)
+		if err != nil {
+			continue
+		}
+		count++
+		sum += rtt
+		if rtt > max {
+			max = rtt
+		}
+	}
+	if count == 0 {
+		return 0, 0, 0
+	}
+	return count, sum / float64(count), max
+}
+
+// collectTCPStats reports the TCP retransmit rate since the previous collection and,
+// where "ss" is available, the smoothed RTT of established connections.
+// It is enabled by default and can be disabled with TCP_STATS=false.
+func collectTCPStats() (*TCPStats, error) {
+	if !collectorEnabled("TCP_STATS", true) {
+		return nil, nil
+	}
+	counters, err := psnet.ProtoCounters([]string{"tcp"})
+	if notImplemented(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TCP counters: %v", err)
+	}
+	if len(counters) == 0 {
+		return nil, nil
+	}
+	stats := &TCPStats{
+		OutSegs:     counters[0].Stats["OutSegs"],
+		RetransSegs: counters[0].Stats["RetransSegs"],
+	}
+
+	lastTCPCounters.Lock()
+	outDelta := stats.OutSegs - lastTCPCounters.outSegs
+	retransDelta := stats.RetransSegs - lastTCPCounters.retransSegs
+	first := lastTCPCounters.outSegs == 0
+	lastTCPCounters.outSegs = stats.OutSegs
+	lastTCPCounters.retransSegs = stats.RetransSegs
+	lastTCPCounters.Unlock()
+	if !first && outDelta > 0 && retransDelta >= 0 {
+		stats.RetransRate = float64(retransDelta) / float64(outDelta) * 100
+	}
+
+	stats.Connections, stats.AvgRTTMs, stats.MaxRTTMs = readSocketRTT()
+	return stats, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// tenantIDPattern restricts tenant identifiers to characters safe in URLs, headers and
+// database keys.
+var tenantIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,128}$`)
+
+// tenantID returns the tenant or organization the agent belongs to, from TENANT_ID.
+func tenantID() string {
+	return os.Getenv("TENANT_ID")
+}
+
+// validateTenantID checks TENANT_ID at startup, so a typo does not scatter an agent's data
+// under an unknown tenant.
+func validateTenantID() error {
+	if id := tenantID(); id != "" && !tenantIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid TENANT_ID %q: use up to 128 letters, digits and _.:-", id)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// TransformRule is one step of the metric transformation pipeline applied to every payload
+// before it is sent. Fields are dotted paths into the JSON payload (e.g. "cgroup.memoryUsageBytes");
+// a "*" segment or a glob pattern matches every key or list element at that level.
+type TransformRule struct {
+	// Action is one of rename, drop, scale or derive.
+	Action string `json:"action"`
+	// Field is the field to act on; for derive, the field to create.
+	Field string `json:"field"`
+	// To is the new path of a renamed field.
+	To string `json:"to,omitempty"`
+	// Factor multiplies the value of a scaled or derived field.
+	Factor float64 `json:"factor,omitempty"`
+	// From and Op define a derived field as From[0] <Op> From[1] (op: add, sub, mul, div).
+	From []string `json:"from,omitempty"`
+	Op   string   `json:"op,omitempty"`
+}
+
+// deriveOps are the operators supported by derive rules.
+var deriveOps = map[string]bool{"add": true, "sub": true, "mul": true, "div": true}
+
+// transformRules is the configured pipeline, loaded at startup.
+var transformRules []TransformRule
+
+// loadTransformRules reads the pipeline from the JSON file named by TRANSFORM_RULES_FILE.
+func loadTransformRules() error {
+	file := os.Getenv("TRANSFORM_RULES_FILE")
+	if file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read transform rules: %v", err)
+	}
+	var rules []TransformRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("invalid transform rules: %v", err)
+	}
+	for i, r := range rules {
+		switch {
+		case r.Field == "":
+			return fmt.Errorf("transform rule %d: field is required", i+1)
+		case r.Action == "rename" && r.To == "":
+			return fmt.Errorf("transform rule %d: rename requires to", i+1)
+		case r.Action == "scale" && r.Factor == 0:
+			return fmt.Errorf("transform rule %d: scale requires factor", i+1)
+		case r.Action == "derive" && (len(r.From) != 2 || !deriveOps[r.Op]):
+			return fmt.Errorf("transform rule %d: derive requires two from fields and an op", i+1)
+		case r.Action != "rename" && r.Action != "drop" && r.Action != "scale" && r.Action != "derive":
+			return fmt.Errorf("transform rule %d: unknown action %q", i+1, r.Action)
+		}
+	}
+	transformRules = rules
+	fmt.Printf("Loaded %d transform rules\n", len(rules))
+	return nil
+}
+
+// transformPayload applies the pipeline to a serialized payload. Without rules the
+// payload is returned unchanged.
+func transformPayload(data []byte) ([]byte, error) {
+	if len(transformRules) == 0 {
+		return data, nil
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	for _, r := range transformRules {
+		applyTransformRule(doc, r)
+	}
+	return json.Marshal(doc)
+}
+
+// applyTransformRule applies a single rule to the decoded payload.
+func applyTransformRule(doc map[string]interface{}, r TransformRule) {
+	switch r.Action {
+	case "drop":
+		visitField(doc, strings.Split(r.Field, "."), func(parent map[string]interface{}, key string) {
+			delete(parent, key)
+		})
+	case "scale":
+		visitField(doc, strings.Split(r.Field, "."), func(parent map[string]interface{}, key string) {
+			if v, ok := parent[key].(float64); ok {
+				parent[key] = v * r.Factor
+			}
+		})
+	case "rename":
+		if v, ok := getField(doc, r.Field); ok {
+			deleteField(doc, r.Field)
+			setField(doc, r.To, v)
+		}
+	case "derive":
+		a, okA := getField(doc, r.From[0])
+		b, okB := getField(doc, r.From[1])
+		x, okX := a.(float64)
+		y, okY := b.(float64)
+		if !okA || !okB || !okX || !okY {
+			return
+		}
+		var v float64
+		switch r.Op {
+		case "add":
+			v = x + y
+		case "sub":
+			v = x - y
+		case "mul":
+			v = x * y
+		case "div":
+			if y == 0 {
+				return
+			}
+			v = x / y
+		}
+		if r.Factor != 0 {
+			v *= r.Factor
+		}
+		setField(doc, r.Field, v)
+	}
+}
+
+// visitField calls fn for every field matching the path segments, descending through
+// nested objects and lists. Segments may be glob patterns.
+func visitField(node interface{}, segments []string, fn func(parent map[string]interface{}, key string)) {
+	switch n := node.(type) {
+	case []interface{}:
+		// Lists are traversed transparently, and a "*" segment also matches their elements,
+		// so both disks.device and disks.*.device reach the device of every disk.
+		for _, item := range n {
+			visitField(item, segments, fn)
+			if segments[0] == "*" && len(segments) > 1 {
+				visitField(item, segments[1:], fn)
+			}
+		}
+	case map[string]interface{}:
+		for key, child := range n {
+			if ok, _ := path.Match(segments[0], key); !ok {
+				continue
+			}
+			if len(segments) == 1 {
+				fn(n, key)
+			} else {
+				visitField(child, segments[1:], fn)
+			}
+		}
+	}
+}
+
+// getField returns the value at a dotted path of nested objects.
+func getField(doc map[string]interface{}, field string) (interface{}, bool) {
+	var node interface{} = doc
+	for _, seg := range strings.Split(field, ".") {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if node, ok = m[seg]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// setField stores a value at a dotted path, creating intermediate objects as needed.
+func setField(doc map[string]interface{}, field string, v interface{}) {
+	segments := strings.Split(field, ".")
+	m := doc
+	for _, seg := range segments[:len(segments)-1] {
+		child, ok := m[seg].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			m[seg] = child
+		}
+		m = child
+	}
+	m[segments[len(segments)-1]] = v
+}
+
+// deleteField removes the value at a dotted path.
+func deleteField(doc map[string]interface{}, field string) {
+	segments := strings.Split(field, ".")
+	m := doc
+	for _, seg := range segments[:len(segments)-1] {
+		child, ok := m[seg].(map[string]interface{})
+		if !ok {
+			return
+		}
+		m = child
+	}
+	delete(m, segments[len(segments)-1])
+}
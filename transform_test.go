@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTransformPayload(t *testing.T) {
+	const payload = `{"cpu": {"usagePercent": 50, "cores": 4}, "disks": [{"name": "sda", "usedBytes": 2048, "totalBytes": 4096}, {"name": "sdb", "usedBytes": 1024, "totalBytes": 0}], "hostname": "web-1", "ip": "10.0.0.5"}`
+	tests := []struct {
+		name  string
+		rules []TransformRule
+		want  string
+	}{
+		{"no rules", nil, payload},
+		{"drop", []TransformRule{{Action: "drop", Field: "ip"}}, `{"cpu": {"usagePercent": 50, "cores": 4}, "disks": [{"name": "sda", "usedBytes": 2048, "totalBytes": 4096}, {"name": "sdb", "usedBytes": 1024, "totalBytes": 0}], "hostname": "web-1"}`},
+		{"drop in lists with glob", []TransformRule{{Action: "drop", Field: "disks.*.*Bytes"}}, `{"cpu": {"usagePercent": 50, "cores": 4}, "disks": [{"name": "sda"}, {"name": "sdb"}], "hostname": "web-1", "ip": "10.0.0.5"}`},
+		{"drop in lists", []TransformRule{{Action: "drop", Field: "disks.name"}}, `{"cpu": {"usagePercent": 50, "cores": 4}, "disks": [{"usedBytes": 2048, "totalBytes": 4096}, {"usedBytes": 1024, "totalBytes": 0}], "hostname": "web-1", "ip": "10.0.0.5"}`},
+		{"scale", []TransformRule{{Action: "scale", Field: "disks.usedBytes", Factor: 1.0 / 1024}}, `{"cpu": {"usagePercent": 50, "cores": 4}, "disks": [{"name": "sda", "usedBytes": 2, "totalBytes": 4096}, {"name": "sdb", "usedBytes": 1, "totalBytes": 0}], "hostname": "web-1", "ip": "10.0.0.5"}`},
+		{"scale ignores strings", []TransformRule{{Action: "scale", Field: "hostname", Factor: 2}}, payload},
+		{"rename", []TransformRule{{Action: "rename", Field: "cpu.usagePercent", To: "host.cpu.load"}}, `{"cpu": {"cores": 4}, "host": {"cpu": {"load": 50}}, "disks": [{"name": "sda", "usedBytes": 2048, "totalBytes": 4096}, {"name": "sdb", "usedBytes": 1024, "totalBytes": 0}], "hostname": "web-1", "ip": "10.0.0.5"}`},
+		{"rename missing", []TransformRule{{Action: "rename", Field: "cpu.steal", To: "steal"}}, payload},
+		{"derive", []TransformRule{{Action: "derive", Field: "cpu.perCore", From: []string{"cpu.usagePercent", "cpu.cores"}, Op: "div"}}, `{"cpu": {"usagePercent": 50, "cores": 4, "perCore": 12.5}, "disks": [{"name": "sda", "usedBytes": 2048, "totalBytes": 4096}, {"name": "sdb", "usedBytes": 1024, "totalBytes": 0}], "hostname": "web-1", "ip": "10.0.0.5"}`},
+		{"derive with factor", []TransformRule{{Action: "derive", Field: "cpu.idle", From: []string{"cpu.usagePercent", "cpu.usagePercent"}, Op: "sub", Factor: 3}}, `{"cpu": {"usagePercent": 50, "cores": 4, "idle": 0}, "disks": [{"name": "sda", "usedBytes": 2048, "totalBytes": 4096}, {"name": "sdb", "usedBytes": 1024, "totalBytes": 0}], "hostname": "web-1", "ip": "10.0.0.5"}`},
+		{"derive from a string", []TransformRule{{Action: "derive", Field: "x", From: []string{"hostname", "cpu.cores"}, Op: "add"}}, payload},
+		{"derive dividing by zero", []TransformRule{{Action: "derive", Field: "x", From: []string{"cpu.cores", "disks"}, Op: "div"}}, payload},
+		{"rules in order", []TransformRule{
+			{Action: "rename", Field: "cpu.cores", To: "cores"},
+			{Action: "scale", Field: "cores", Factor: 2},
+			{Action: "drop", Field: "cpu"},
+		}, `{"cores": 8, "disks": [{"name": "sda", "usedBytes": 2048, "totalBytes": 4096}, {"name": "sdb", "usedBytes": 1024, "totalBytes": 0}], "hostname": "web-1", "ip": "10.0.0.5"}`},
+	}
+	t.Cleanup(func() { transformRules = nil })
+	for _, tt := range tests {
+		transformRules = tt.rules
+		data, err := transformPayload([]byte(payload))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var got, want interface{}
+		json.Unmarshal(data, &got)
+		json.Unmarshal([]byte(tt.want), &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %s, want %s", tt.name, data, tt.want)
+		}
+	}
+}
+
+func TestLoadTransformRules(t *testing.T) {
+	t.Cleanup(func() { transformRules = nil })
+	tests := []struct {
+		file    string
+		wantErr bool
+	}{
+		{`[{"action": "drop", "field": "ip"}, {"action": "scale", "field": "x", "factor": 0.5}]`, false},
+		{`[{"action": "drop"}]`, true},
+		{`[{"action": "rename", "field": "a"}]`, true},
+		{`[{"action": "scale", "field": "a"}]`, true},
+		{`[{"action": "derive", "field": "a", "from": ["b"], "op": "add"}]`, true},
+		{`[{"action": "derive", "field": "a", "from": ["b", "c"], "op": "pow"}]`, true},
+		{`[{"action": "hash", "field": "a"}]`, true},
+		{`{"action": "drop"}`, true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "rules.json")
+		if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("TRANSFORM_RULES_FILE", path)
+		if err := loadTransformRules(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.file, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// uploadsDirName is the directory in the state directory holding the large payloads
+// waiting to be uploaded in chunks, each as <id>.json with its progress in <id>.meta.
+const uploadsDirName = "uploads"
+
+// Limits of chunked uploads.
+const (
+	defaultUploadChunkKB = 256
+	maxPendingUploads    = 10
+	uploadRetryInterval  = 30 * time.Second
+)
+
+// uploadMeta records a staged upload and how much of it each server has acknowledged.
+type uploadMeta struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	Size      int64  `json:"size"`
+	CreatedAt int64  `json:"createdAt"`
+	// Encrypted is set when the staged file is encrypted with the spool key.
+	Encrypted bool `json:"encrypted,omitempty"`
+	// Offsets are the bytes acknowledged by each server, by destination name.
+	Offsets map[string]int64 `json:"offsets"`
+}
+
+// uploads serializes the changes to the uploads directory, and wakes up the sender when an
+// upload is staged. It is not held while chunks are sent, so staging never waits for the
+// network.
+var uploads struct {
+	sync.Mutex
+	kick chan struct{}
+}
+
+// chunkedUploads reports whether large payloads, such as the Windows inventory, are sent
+// as chunked uploads instead of inside the metrics payload.
+func chunkedUploads() bool {
+	return featureEnabled("CHUNKED_UPLOAD", envBool("CHUNKED_UPLOAD", false))
+}
+
+// uploadsDir returns the directory of the staged uploads.
+func uploadsDir() string {
+	return filepath.Join(stateDir(), uploadsDirName)
+}
+
+// writeJSONArray encodes items as a JSON array one element at a time, so the encoded array
+// is never held in memory as a whole.
+func writeJSONArray[T any](w io.Writer, items []T) error {
+	enc := json.NewEncoder(w)
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	for i := range items {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		if err := enc.Encode(items[i]); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "]")
+	return err
+}
+
+// stageUpload streams a payload of the given kind to a file in the uploads directory and
+// wakes up the sender. write produces the JSON of the data field; the document is
+// {"agentId", "uploadId", "kind", "timestamp", "data"}. It returns the upload ID.
+func stageUpload(kind string, write func(w io.Writer) error) (string, error) {
+	id, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+	agentID, err := loadAgentID()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(uploadsDir(), 0o700); err != nil {
+		return "", fmt.Errorf("failed to create uploads directory: %v", err)
+	}
+	path := filepath.Join(uploadsDir(), id+".json")
+	f, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", fmt.Errorf("failed to stage upload: %v", err)
+	}
+	// The staged file is encrypted with SPOOL_ENCRYPTION; its size is that of the plaintext.
+	var sealed *sealedWriter
+	counter := &countingWriter{w: f}
+	if spoolEncryption() {
+		if sealed, err = newSealedWriter(f); err != nil {
+			f.Close()
+			os.Remove(path + ".tmp")
+			return "", fmt.Errorf("failed to stage upload: %v", err)
+		}
+		counter.w = sealed
+	}
+	w := bufio.NewWriter(counter)
+	header, _ := json.Marshal(map[string]interface{}{"agentId": agentID, "uploadId": id, "kind": kind, "timestamp": time.Now().UnixMilli()})
+	w.Write(header[:len(header)-1])
+	io.WriteString(w, `,"data":`)
+	err = write(w)
+	if err == nil {
+		_, err = io.WriteString(w, "}")
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil && sealed != nil {
+		err = sealed.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return "", fmt.Errorf("failed to stage upload: %v", err)
+	}
+
+	uploads.Lock()
+	defer uploads.Unlock()
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return "", fmt.Errorf("failed to stage upload: %v", err)
+	}
+	meta := &uploadMeta{ID: id, Kind: kind, Size: counter.n, Encrypted: sealed != nil, CreatedAt: time.Now().UnixMilli(), Offsets: map[string]int64{}}
+	if err := saveUploadMeta(meta); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to stage upload: %v", err)
+	}
+	pending := pendingUploads()
+	for len(pending) > maxPendingUploads {
+		fmt.Printf("Too many pending uploads, dropped %s upload %s\n", pending[0].Kind, pending[0].ID)
+		removeUpload(pending[0])
+		pending = pending[1:]
+	}
+	fmt.Printf("Staged %s upload %s (%d bytes)\n", kind, id, meta.Size)
+	if uploads.kick != nil {
+		select {
+		case uploads.kick <- struct{}{}:
+		default:
+		}
+	}
+	return id, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements io.Writer.
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// saveUploadMeta persists the progress of an upload.
+func saveUploadMeta(meta *uploadMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(uploadsDir(), meta.ID+".meta"), data)
+}
+
+// pendingUploads returns the staged uploads, oldest first.
+func pendingUploads() []*uploadMeta {
+	paths, _ := filepath.Glob(filepath.Join(uploadsDir(), "*.meta"))
+	var metas []*uploadMeta
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var meta uploadMeta
+		if err := json.Unmarshal(data, &meta); err != nil || meta.ID == "" {
+			fmt.Printf("Removing unreadable upload %s\n", filepath.Base(path))
+			os.Remove(path)
+			os.Remove(strings.TrimSuffix(path, ".meta") + ".json")
+			continue
+		}
+		if meta.Offsets == nil {
+			meta.Offsets = map[string]int64{}
+		}
+		metas = append(metas, &meta)
+	}
+	sort.Slice(metas, func(i, j int) bool { return metas[i].CreatedAt < metas[j].CreatedAt })
+	return metas
+}
+
+// removeUpload deletes a staged upload.
+func removeUpload(meta *uploadMeta) {
+	os.Remove(filepath.Join(uploadsDir(), meta.ID+".json"))
+	os.Remove(filepath.Join(uploadsDir(), meta.ID+".meta"))
+}
+
+// startUploads sends the staged uploads to every registered server, when one is staged
+// and periodically to resume interrupted ones, including those left by a previous run.
+func startUploads() {
+	uploads.Lock()
+	uploads.kick = make(chan struct{}, 1)
+	kick := uploads.kick
+	uploads.Unlock()
+	supervise("uploads", func() {
+		ticker := time.NewTicker(uploadRetryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-kick:
+			case <-ticker.C:
+			}
+			flushUploads()
+		}
+	})
+}
+
+// flushUploads sends the pending part of every staged upload to each server, removing the
+// uploads every server has received. It only runs in the uploads goroutine.
+func flushUploads() {
+	uploads.Lock()
+	pending := pendingUploads()
+	uploads.Unlock()
+	for _, meta := range pending {
+		complete := true
+		for _, d := range destinations {
+			if meta.Offsets[d.name] >= meta.Size {
+				continue
+			}
+			if _, paused := d.paused(); d.registered.Load() && !paused {
+				if err := d.sendUpload(meta); err != nil {
+					fmt.Printf("Error uploading %s to %s server, resuming later: %v\n", meta.ID, d.name, err)
+				}
+			}
+			complete = complete && meta.Offsets[d.name] >= meta.Size
+		}
+		if complete {
+			fmt.Printf("Upload %s (%s, %d bytes) complete\n", meta.ID, meta.Kind, meta.Size)
+			uploads.Lock()
+			removeUpload(meta)
+			uploads.Unlock()
+		}
+	}
+}
+
+// sendUpload sends an upload to d in UPLOAD_CHUNK_KB chunks, each a PUT of the upload's
+// byte range (Content-Range) to the upload endpoint followed by the upload ID, resuming
+// from the offset the server acknowledged last. The server may answer with an
+// Upload-Offset header, including on a 409 or 416 response, to say where the next chunk
+// must start.
+func (d *destination) sendUpload(meta *uploadMeta) error {
+	f, err := os.Open(filepath.Join(uploadsDir(), meta.ID+".json"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var file io.ReaderAt = f
+	if meta.Encrypted {
+		if file, err = newSealedReaderAt(f); err != nil {
+			return err
+		}
+	}
+	chunkSize := int64(envInt("UPLOAD_CHUNK_KB", defaultUploadChunkKB)) << 10
+	if chunkSize <= 0 {
+		chunkSize = defaultUploadChunkKB << 10
+	}
+	buf := make([]byte, chunkSize)
+	for offset := meta.Offsets[d.name]; offset < meta.Size; {
+		n, err := file.ReadAt(buf[:min(chunkSize, meta.Size-offset)], offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodPut, d.endpoint(endpointUpload)+"/"+meta.ID, bytes.NewReader(buf[:n]))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(n)-1, meta.Size))
+		req.Header.Set("Upload-Kind", meta.Kind)
+		resp, err := httpClient().Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+
+		next := offset + int64(n)
+		serverOffset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+		realign := err == nil && serverOffset >= 0 && serverOffset <= meta.Size
+		if realign {
+			next = serverOffset
+		}
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode <= 299 && next <= offset:
+			// An accepted chunk must move the upload forward, or it would be resent forever.
+			return fmt.Errorf("chunk at byte %d accepted without progress, server is at byte %d", offset, next)
+		case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		case (resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable) && realign && next != offset:
+			fmt.Printf("Resuming upload %s to %s server at byte %d\n", meta.ID, d.name, next)
+		default:
+			if busy := busyError(resp); busy != nil {
+				delay, _ := retryAfter(busy)
+				d.pause(delay)
+			}
+			return fmt.Errorf("chunk at byte %d rejected with status: %s", offset, resp.Status)
+		}
+		offset = next
+		meta.Offsets[d.name] = offset
+		if err := saveUploadProgress(meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveUploadProgress persists the progress of an upload being sent, unless it was dropped
+// meanwhile to make room for newer ones.
+func saveUploadProgress(meta *uploadMeta) error {
+	uploads.Lock()
+	defer uploads.Unlock()
+	if _, err := os.Stat(filepath.Join(uploadsDir(), meta.ID+".meta")); err != nil {
+		return fmt.Errorf("upload dropped")
+	}
+	return saveUploadMeta(meta)
+}
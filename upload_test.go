@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// uploadServer accepts chunked uploads in order, answering 409 with the offset it expects
+// to chunks starting elsewhere, and fails the PUT numbered failAt.
+type uploadServer struct {
+	received []byte
+	puts     int
+	failAt   int
+}
+
+func (s *uploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.puts++
+	var start, end, total int64
+	fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &total)
+	body, _ := io.ReadAll(r.Body)
+	switch {
+	case s.puts == s.failAt:
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	case start != int64(len(s.received)):
+		w.Header().Set("Upload-Offset", fmt.Sprint(len(s.received)))
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	s.received = append(s.received, body...)
+	w.Header().Set("Upload-Offset", fmt.Sprint(len(s.received)))
+}
+
+func TestSendUploadOffsets(t *testing.T) {
+	t.Setenv("STATE_DIR", t.TempDir())
+	t.Setenv("UPLOAD_CHUNK_KB", "1")
+	items := make([]string, 500)
+	for i := range items {
+		items[i] = fmt.Sprintf("item-%03d", i)
+	}
+	id, err := stageUpload("test", func(w io.Writer) error { return writeJSONArray(w, items) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged, _ := os.ReadFile(filepath.Join(uploadsDir(), id+".json"))
+
+	server := &uploadServer{failAt: 3}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	d := newDestination("primary", ts.URL, "spool", true)
+
+	meta := pendingUploads()[0]
+	// A stale offset beyond what the server holds is realigned by its 409 answer.
+	meta.Offsets[d.name] = 2048
+	if err := d.sendUpload(meta); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("got %v, want the injected failure", err)
+	}
+	// The progress acknowledged before the failure is persisted and resumed from.
+	meta = pendingUploads()[0]
+	if meta.Offsets[d.name] != 1024 {
+		t.Fatalf("persisted offset %d, want 1024", meta.Offsets[d.name])
+	}
+	if err := d.sendUpload(meta); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(server.received, staged) {
+		t.Fatalf("server received %d bytes, want the %d staged", len(server.received), len(staged))
+	}
+	var doc struct {
+		UploadID string   `json:"uploadId"`
+		Data     []string `json:"data"`
+	}
+	if err := json.Unmarshal(server.received, &doc); err != nil || doc.UploadID != id || len(doc.Data) != len(items) {
+		t.Errorf("uploaded document %q with %d items (%v), want upload %s with %d", doc.UploadID, len(doc.Data), err, id, len(items))
+	}
+	if meta := pendingUploads()[0]; meta.Offsets[d.name] != meta.Size {
+		t.Errorf("final offset %d, want %d", meta.Offsets[d.name], meta.Size)
+	}
+}
+
+func TestWindowsInventoryWriteJSON(t *testing.T) {
+	inventories := []*WindowsInventory{
+		{},
+		{
+			Services: []WindowsService{{Name: "W3SVC", StartType: "auto", State: "running"}},
+			Software: []InstalledSoftware{{Name: "Go", Version: "1.24", Architecture: "x64"}, {Name: "Git", Architecture: "x64"}},
+		},
+	}
+	for _, inv := range inventories {
+		var buf bytes.Buffer
+		if err := inv.writeJSON(&buf); err != nil {
+			t.Fatal(err)
+		}
+		var got WindowsInventory
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("invalid JSON %s: %v", buf.Bytes(), err)
+		}
+		if len(got.Services) != len(inv.Services) || len(got.Software) != len(inv.Software) {
+			t.Errorf("got %+v, want %+v", got, *inv)
+		}
+	}
+}
+
+func TestSendUploadRequiresProgress(t *testing.T) {
+	t.Setenv("STATE_DIR", t.TempDir())
+	t.Setenv("UPLOAD_CHUNK_KB", "1")
+	data := bytes.Repeat([]byte("x"), 4096)
+	if _, err := stageUpload("test", func(w io.Writer) error { _, err := w.Write(data); return err }); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		start        int64
+		serverOffset string
+	}{
+		{"offset unchanged", 0, "0"},
+		{"offset moved back", 2048, "1024"},
+	}
+	for _, tt := range tests {
+		var requests atomic.Int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			io.Copy(io.Discard, r.Body)
+			w.Header().Set("Upload-Offset", tt.serverOffset)
+		}))
+		d := newDestination("primary", ts.URL, "spool", true)
+		meta := pendingUploads()[0]
+		meta.Offsets[d.name] = tt.start
+		done := make(chan error, 1)
+		go func() { done <- d.sendUpload(meta) }()
+		select {
+		case err := <-done:
+			if err == nil || !strings.Contains(err.Error(), "without progress") {
+				t.Errorf("%s: got %v, want a progress error", tt.name, err)
+			}
+			if n := requests.Load(); n != 1 {
+				t.Errorf("%s: sent %d chunks, want 1", tt.name, n)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: upload still resending its chunk", tt.name)
+		}
+		ts.Close()
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// Sandbox limits applied to every WASM collector.
+const (
+	wasmMemoryLimitPages = 256 // 16 MiB
+	wasmMaxOutputBytes   = 1 << 20
+	wasmMaxModuleBytes   = 32 << 20
+)
+
+// WasmModule is a WASM collector distributed through the remote config. The module is
+// downloaded from URL (absolute, or relative to the server) and must match SHA256.
+type WasmModule struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+}
+
+// wasmRuntime holds the shared wazero runtime and the compiled modules, keyed by content hash.
+var wasmRuntime struct {
+	sync.Mutex
+	runtime  wazero.Runtime
+	compiled map[string]*compiledWasm
+}
+
+// compiledWasm is a compiled module with the number of runs using it. A stale module is no
+// longer installed and is closed when its last run ends.
+type compiledWasm struct {
+	module wazero.CompiledModule
+	users  int
+	stale  bool
+}
+
+// wasmInstalls records the last wasm.install result audited for each module, so a download
+// failing at every poll is audited once.
+var wasmInstalls struct {
+	sync.Mutex
+	results map[string]string
+}
+
+// auditWasmInstall writes entry to the audit log unless it repeats the last result for the
+// module name.
+func auditWasmInstall(name string, entry AuditEntry) {
+	wasmInstalls.Lock()
+	defer wasmInstalls.Unlock()
+	result := entry.Details + ": " + entry.Result
+	if wasmInstalls.results[name] == result {
+		return
+	}
+	if wasmInstalls.results == nil {
+		wasmInstalls.results = make(map[string]string)
+	}
+	wasmInstalls.results[name] = result
+	writeAudit(entry)
+}
+
+// remoteWasmDir returns the directory holding WASM collectors received from the server.
+func remoteWasmDir() string {
+	dir := filepath.Join(stateDir(), "wasm")
+	os.MkdirAll(dir, 0o700)
+	return dir
+}
+
+// wasmModulePaths returns the .wasm files in WASM_DIR and those received from the server.
+func wasmModulePaths() []string {
+	var paths []string
+	for _, dir := range []string{os.Getenv("WASM_DIR"), remoteWasmDir()} {
+		if dir == "" {
+			continue
+		}
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.wasm"))
+		paths = append(paths, matches...)
+	}
+	return paths
+}
+
+// compileWasm returns the compiled form of a module, compiling it on first use, and a
+// function to call once the run using it is over.
+func compileWasm(ctx context.Context, code []byte) (wazero.Runtime, wazero.CompiledModule, func(), error) {
+	key := sha256Hex(code)
+	wasmRuntime.Lock()
+	defer wasmRuntime.Unlock()
+	if wasmRuntime.runtime == nil {
+		config := wazero.NewRuntimeConfig().
+			WithCloseOnContextDone(true).
+			WithMemoryLimitPages(wasmMemoryLimitPages)
+		wasmRuntime.runtime = wazero.NewRuntimeWithConfig(context.Background(), config)
+		wasi_snapshot_preview1.MustInstantiate(context.Background(), wasmRuntime.runtime)
+		wasmRuntime.compiled = make(map[string]*compiledWasm)
+	}
+	c, ok := wasmRuntime.compiled[key]
+	if !ok {
+		m, err := wasmRuntime.runtime.CompileModule(ctx, code)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to compile module: %v", err)
+		}
+		c = &compiledWasm{module: m}
+		wasmRuntime.compiled[key] = c
+	}
+	c.users++
+	release := func() {
+		wasmRuntime.Lock()
+		defer wasmRuntime.Unlock()
+		c.users--
+		closeIfUnused(key, c)
+	}
+	return wasmRuntime.runtime, c.module, release, nil
+}
+
+// releaseWasmModules closes the compiled modules whose hash is not in installed, once no
+// run uses them any more.
+func releaseWasmModules(installed map[string]bool) {
+	wasmRuntime.Lock()
+	defer wasmRuntime.Unlock()
+	for key, c := range wasmRuntime.compiled {
+		if !installed[key] {
+			c.stale = true
+			closeIfUnused(key, c)
+		}
+	}
+}
+
+// closeIfUnused closes the compiled module c when it is stale and no run uses it. The
+// caller must hold wasmRuntime.
+func closeIfUnused(key string, c *compiledWasm) {
+	if !c.stale || c.users > 0 || wasmRuntime.compiled[key] != c {
+		return
+	}
+	c.module.Close(context.Background())
+	delete(wasmRuntime.compiled, key)
+}
+
+// limitedBuffer is a bytes.Buffer that fails writes beyond its limit.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+// Write implements io.Writer.
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, fmt.Errorf("output exceeds %d bytes", b.limit)
+	}
+	return b.Buffer.Write(p)
+}
+
+// runWasm runs the WASM collector code, read from the file name, as a WASI command in a
+// sandbox with no filesystem, network or environment access, and parses the JSON object it
+// writes to stdout.
+func runWasm(name string, code []byte) (map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout())
+	defer cancel()
+	r, compiled, release, err := compileWasm(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	stdout := &limitedBuffer{limit: wasmMaxOutputBytes}
+	config := wazero.NewModuleConfig().
+		WithName("").
+		WithStdout(stdout).
+		WithStderr(io.Discard).
+		WithArgs(name).
+		WithSysWalltime().
+		WithSysNanotime()
+	mod, err := r.InstantiateModule(ctx, compiled, config)
+	if mod != nil {
+		mod.Close(ctx)
+	}
+	// A WASI command that calls proc_exit(0) reports a successful exit as an error.
+	var exitErr *sys.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 0) {
+		return nil, fmt.Errorf("module failed: %v", err)
+	}
+	var metrics map[string]interface{}
+	if err := json.Unmarshal(stdout.Bytes(), &metrics); err != nil {
+		return nil, fmt.Errorf("invalid module output: %v", err)
+	}
+	return metrics, nil
+}
+
+// collectWasm runs every WASM collector and returns their metrics keyed by module name.
+// Compiled modules no longer installed are released afterwards.
+func collectWasm() map[string]PluginResult {
+	installed := make(map[string]bool)
+	defer releaseWasmModules(installed)
+	if !collectorEnabled("WASM_COLLECTORS", true) {
+		return nil
+	}
+	paths := wasmModulePaths()
+	if len(paths) == 0 {
+		recordPartDurations("wasm", nil)
+		return nil
+	}
+	results := make(map[string]PluginResult, len(paths))
+	durations := make(map[string]time.Duration, len(paths))
+	for _, path := range paths {
+		start := time.Now()
+		var result PluginResult
+		code, err := os.ReadFile(path)
+		if err == nil {
+			installed[sha256Hex(code)] = true
+			result.Metrics, err = runWasm(filepath.Base(path), code)
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".wasm")
+		results[name] = result
+		durations[name] = time.Since(start)
+	}
+	recordPartDurations("wasm", durations)
+	return results
+}
+
+// syncWasmModules makes the server-distributed WASM collectors match modules, downloading
+// new or changed ones and removing those no longer listed.
+func syncWasmModules(baseURL string, modules []WasmModule) {
+	dir := remoteWasmDir()
+	wanted := make(map[string]bool, len(modules))
+	for _, m := range modules {
+		if m.Name == "" || strings.ContainsAny(m.Name, `/\.`) {
+			fmt.Printf("Ignoring WASM module with invalid name %q\n", m.Name)
+			continue
+		}
+		path := filepath.Join(dir, m.Name+".wasm")
+		wanted[path] = true
+		if existing, err := os.ReadFile(path); err == nil && sha256Hex(existing) == strings.ToLower(m.SHA256) {
+			continue
+		}
+		entry := AuditEntry{Actor: "server:" + baseURL, Action: "wasm.install", Details: m.Name + " sha256 " + m.SHA256, Result: "installed"}
+		if err := downloadWasmModule(baseURL, m, path); err != nil {
+			fmt.Printf("Error downloading WASM module %s: %v\n", m.Name, err)
+			entry.Result = fmt.Sprintf("failed: %v", err)
+		}
+		auditWasmInstall(m.Name, entry)
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.wasm"))
+	for _, path := range paths {
+		if !wanted[path] {
+			os.Remove(path)
+			name := strings.TrimSuffix(filepath.Base(path), ".wasm")
+			writeAudit(AuditEntry{Actor: "server:" + baseURL, Action: "wasm.remove", Details: name, Result: "removed"})
+		}
+	}
+	wasmInstalls.Lock()
+	defer wasmInstalls.Unlock()
+	for name := range wasmInstalls.results {
+		if !wanted[filepath.Join(dir, name+".wasm")] {
+			delete(wasmInstalls.results, name)
+		}
+	}
+}
+
+// downloadWasmModule fetches a module, verifies its checksum and stores it at path.
+func downloadWasmModule(baseURL string, m WasmModule, path string) error {
+	url := m.URL
+	if !strings.Contains(url, "://") {
+		url = baseURL + "/" + strings.TrimPrefix(url, "/")
+	}
+	resp, err := httpClient().Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download failed with status: %s", resp.Status)
+	}
+	code, err := io.ReadAll(io.LimitReader(resp.Body, wasmMaxModuleBytes))
+	if err != nil {
+		return err
+	}
+	if sha256Hex(code) != strings.ToLower(m.SHA256) {
+		return fmt.Errorf("checksum mismatch")
+	}
+	return writeFileAtomic(path, code)
+}
+
+// sha256Hex returns the hex-encoded SHA-256 of data.
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+)
+
+// emptyWasmModule is the smallest valid WASM module: the magic number and version 1.
+var emptyWasmModule = []byte("\x00asm\x01\x00\x00\x00")
+
+func TestReleaseWasmModules(t *testing.T) {
+	_, _, release, err := compileWasm(context.Background(), emptyWasmModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := sha256Hex(emptyWasmModule)
+	compiled := func() bool {
+		wasmRuntime.Lock()
+		defer wasmRuntime.Unlock()
+		return wasmRuntime.compiled[key] != nil
+	}
+
+	releaseWasmModules(map[string]bool{key: true})
+	if !compiled() {
+		t.Fatal("installed module released")
+	}
+	releaseWasmModules(nil)
+	if !compiled() {
+		t.Fatal("module released while a run uses it")
+	}
+	release()
+	if compiled() {
+		t.Fatal("removed module kept after its last run")
+	}
+
+	// A module compiled again after being released is a new entry, not the stale one.
+	_, _, release, err = compileWasm(context.Background(), emptyWasmModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if !compiled() {
+		t.Fatal("module released without being removed")
+	}
+	releaseWasmModules(nil)
+}
+
+func TestAuditWasmInstallOnChange(t *testing.T) {
+	t.Setenv("STATE_DIR", t.TempDir())
+	t.Cleanup(func() { wasmInstalls.results = nil })
+	entry := func(result string) AuditEntry {
+		return AuditEntry{Actor: "server:test", Action: "wasm.install", Details: "queue sha256 00", Result: result}
+	}
+	for _, result := range []string{"failed: timeout", "failed: timeout", "installed", "failed: timeout"} {
+		auditWasmInstall("queue", entry(result))
+	}
+	data, err := os.ReadFile(auditLogPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines != 3 {
+		t.Errorf("got %d audit entries, want 3:\n%s", lines, data)
+	}
+}
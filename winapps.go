@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// IISStats are the IIS web server counters of a Windows host, read from the Windows
+// performance counters. Rates are per second over the last collection interval.
+type IISStats struct {
+	// State is the state of the World Wide Web Publishing Service (W3SVC).
+	State              string  `json:"state"`
+	RequestsPerSec     float64 `json:"requestsPerSec"`
+	CurrentConnections float64 `json:"currentConnections"`
+	// QueueLength is the number of requests waiting in the HTTP.sys queues of all the
+	// application pools.
+	QueueLength float64      `json:"queueLength"`
+	Sites       []IISSite    `json:"sites,omitempty"`
+	AppPools    []IISAppPool `json:"appPools,omitempty"`
+}
+
+// IISSite is the traffic of one IIS web site.
+type IISSite struct {
+	Name               string  `json:"name"`
+	RequestsPerSec     float64 `json:"requestsPerSec"`
+	CurrentConnections float64 `json:"currentConnections"`
+}
+
+// IISAppPool is the HTTP.sys request queue of one IIS application pool.
+type IISAppPool struct {
+	Name        string  `json:"name"`
+	QueueLength float64 `json:"queueLength"`
+	// MaxQueueItemAgeMs is how long the oldest queued request has been waiting.
+	MaxQueueItemAgeMs float64 `json:"maxQueueItemAgeMs"`
+	// RejectedRequests counts the requests rejected since the queue was created.
+	RejectedRequests float64 `json:"rejectedRequests"`
+}
+
+// MSSQLInstance is the basic health of one SQL Server instance, read from its service state
+// and performance counters. The counters are zero while the instance is not running.
+type MSSQLInstance struct {
+	// Name is the instance name, MSSQLSERVER for the default instance.
+	Name                  string  `json:"name"`
+	State                 string  `json:"state"`
+	UserConnections       float64 `json:"userConnections"`
+	BatchRequestsPerSec   float64 `json:"batchRequestsPerSec"`
+	BlockedProcesses      float64 `json:"blockedProcesses"`
+	DeadlocksPerSec       float64 `json:"deadlocksPerSec"`
+	PageLifeExpectancySec float64 `json:"pageLifeExpectancySec"`
+	BufferCacheHitPercent float64 `json:"bufferCacheHitPercent"`
+	MemoryGrantsPending   float64 `json:"memoryGrantsPending"`
+}
+
+// lastAppStates holds the last service state of each monitored application, by event type
+// prefix and instance, to report transitions.
+var lastAppStates struct {
+	sync.Mutex
+	states map[string]string
+}
+
+// trackAppState emits <kind>.down when the service of an application stops running and
+// <kind>.up when it runs again.
+func trackAppState(kind, name, state string) {
+	lastAppStates.Lock()
+	defer lastAppStates.Unlock()
+	if lastAppStates.states == nil {
+		lastAppStates.states = make(map[string]string)
+	}
+	key := kind + "/" + name
+	prev, known := lastAppStates.states[key]
+	lastAppStates.states[key] = state
+	switch {
+	case known && prev == "running" && state != "running":
+		emitEvent(kind+".down", "%s is no longer running: %s", name, state)
+	case known && prev != "running" && state == "running":
+		emitEvent(kind+".up", "%s is running again", name)
+	}
+}
+
+// collectIIS reads the IIS counters when IIS_STATS is "true". It reports nothing on hosts
+// without IIS.
+func collectIIS() (*IISStats, error) {
+	if !collectorEnabled("IIS_STATS", false) {
+		return nil, nil
+	}
+	stats, err := snapshotIIS()
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect IIS stats: %v", err)
+	}
+	if stats != nil {
+		trackAppState("iis", "IIS", stats.State)
+	}
+	return stats, nil
+}
+
+// collectMSSQL reads the health of the local SQL Server instances when MSSQL_STATS is
+// "true": every installed instance, or those listed in MSSQL_INSTANCES.
+func collectMSSQL() ([]MSSQLInstance, error) {
+	if !collectorEnabled("MSSQL_STATS", false) {
+		return nil, nil
+	}
+	instances, err := snapshotMSSQL(envList("MSSQL_INSTANCES", nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect SQL Server stats: %v", err)
+	}
+	for _, instance := range instances {
+		trackAppState("mssql", "SQL Server instance "+instance.Name, instance.State)
+	}
+	return instances, nil
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+// snapshotIIS is only implemented on Windows.
+func snapshotIIS() (*IISStats, error) {
+	return nil, nil
+}
+
+// snapshotMSSQL is only implemented on Windows.
+func snapshotMSSQL(names []string) ([]MSSQLInstance, error) {
+	return nil, nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Performance Data Helper (PDH) functions reading the Windows performance counters.
+var (
+	pdh                             = windows.NewLazySystemDLL("pdh.dll")
+	procPdhOpenQuery                = pdh.NewProc("PdhOpenQueryW")
+	procPdhAddEnglishCounter        = pdh.NewProc("PdhAddEnglishCounterW")
+	procPdhCollectQueryData         = pdh.NewProc("PdhCollectQueryData")
+	procPdhGetFormattedCounterArray = pdh.NewProc("PdhGetFormattedCounterArrayW")
+)
+
+// PDH constants, from pdh.h and pdhmsg.h.
+const (
+	pdhFmtDouble        = 0x00000200
+	pdhFmtNoCap100      = 0x00008000
+	pdhMoreData         = 0x800007D2
+	pdhCStatusValidData = 0x00000000
+	pdhCStatusNewData   = 0x00000001
+)
+
+// Services of the monitored applications.
+const (
+	iisServiceName          = "W3SVC"
+	mssqlDefaultInstance    = "MSSQLSERVER"
+	mssqlNamedServicePrefix = "MSSQL$"
+)
+
+// pdhCounterValueItem is a PDH_FMT_COUNTERVALUE_ITEM_W holding a double value. The padding
+// keeps the value at offset 8 on 32-bit Windows too, as the C union is 8-byte aligned.
+type pdhCounterValueItem struct {
+	name   *uint16
+	_      [8 - unsafe.Sizeof(uintptr(0))]byte
+	status uint32
+	_      uint32
+	value  float64
+}
+
+// perfQuery is a PDH query kept open across collections, so rate counters such as
+// requests/sec are computed over the collection interval. Counters are added on first use.
+type perfQuery struct {
+	handle   uintptr
+	counters map[string]uintptr
+}
+
+// perfQueries holds the open query of each collector.
+var perfQueries struct {
+	sync.Mutex
+	queries map[string]*perfQuery
+}
+
+// openPerfQuery returns the query of the collector name, opening it on first use. The caller
+// must hold perfQueries.
+func openPerfQuery(name string) (*perfQuery, error) {
+	if q := perfQueries.queries[name]; q != nil {
+		return q, nil
+	}
+	var handle uintptr
+	if r, _, _ := procPdhOpenQuery.Call(0, 0, uintptr(unsafe.Pointer(&handle))); r != 0 {
+		return nil, fmt.Errorf("PdhOpenQuery failed: 0x%08x", uint32(r))
+	}
+	if perfQueries.queries == nil {
+		perfQueries.queries = make(map[string]*perfQuery)
+	}
+	q := &perfQuery{handle: handle, counters: make(map[string]uintptr)}
+	perfQueries.queries[name] = q
+	return q, nil
+}
+
+// add adds the counter at path, such as `\Web Service(*)\Current Connections`, in its
+// English name whatever the system language. It fails when the counter's object is not
+// installed.
+func (q *perfQuery) add(path string) error {
+	if _, ok := q.counters[path]; ok {
+		return nil
+	}
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	var counter uintptr
+	if r, _, _ := procPdhAddEnglishCounter.Call(q.handle, uintptr(unsafe.Pointer(p)), 0, uintptr(unsafe.Pointer(&counter))); r != 0 {
+		return fmt.Errorf("counter %s not available: 0x%08x", path, uint32(r))
+	}
+	q.counters[path] = counter
+	return nil
+}
+
+// collect samples every counter of the query.
+func (q *perfQuery) collect() error {
+	if r, _, _ := procPdhCollectQueryData.Call(q.handle); r != 0 {
+		return fmt.Errorf("PdhCollectQueryData failed: 0x%08x", uint32(r))
+	}
+	return nil
+}
+
+// values returns the value of the counter at path by instance name, as of the last
+// collection. Instances without valid data, such as rate counters sampled only once so
+// far, are left out.
+func (q *perfQuery) values(path string) map[string]float64 {
+	counter, ok := q.counters[path]
+	if !ok {
+		return nil
+	}
+	var size, count uint32
+	r, _, _ := procPdhGetFormattedCounterArray.Call(counter, pdhFmtDouble|pdhFmtNoCap100, uintptr(unsafe.Pointer(&size)), uintptr(unsafe.Pointer(&count)), 0)
+	if uint32(r) != pdhMoreData || size == 0 {
+		return nil
+	}
+	// The buffer holds the items followed by their instance names.
+	itemSize := uint32(unsafe.Sizeof(pdhCounterValueItem{}))
+	buf := make([]pdhCounterValueItem, (size+itemSize-1)/itemSize)
+	r, _, _ = procPdhGetFormattedCounterArray.Call(counter, pdhFmtDouble|pdhFmtNoCap100, uintptr(unsafe.Pointer(&size)), uintptr(unsafe.Pointer(&count)), uintptr(unsafe.Pointer(&buf[0])))
+	if r != 0 {
+		return nil
+	}
+	values := make(map[string]float64, count)
+	for _, item := range buf[:min(int(count), len(buf))] {
+		if item.status == pdhCStatusValidData || item.status == pdhCStatusNewData {
+			values[windows.UTF16PtrToString(item.name)] = item.value
+		}
+	}
+	return values
+}
+
+// sample adds the counters at paths to the query of the collector name, collects them and
+// returns their values by path and instance. Counters whose object is not installed are
+// left out.
+func sample(name string, paths []string) (map[string]map[string]float64, error) {
+	perfQueries.Lock()
+	defer perfQueries.Unlock()
+	q, err := openPerfQuery(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		q.add(path)
+	}
+	if err := q.collect(); err != nil {
+		return nil, err
+	}
+	values := make(map[string]map[string]float64, len(paths))
+	for _, path := range paths {
+		values[path] = q.values(path)
+	}
+	return values, nil
+}
+
+// Counters read for IIS: per web site from the Web Service object, and per application pool
+// from the HTTP.sys request queues.
+const (
+	iisRequestsCounter    = `\Web Service(*)\Total Method Requests/sec`
+	iisConnectionsCounter = `\Web Service(*)\Current Connections`
+	iisQueueCounter       = `\HTTP Service Request Queues(*)\CurrentQueueSize`
+	iisQueueAgeCounter    = `\HTTP Service Request Queues(*)\MaxQueueItemAge`
+	iisRejectedCounter    = `\HTTP Service Request Queues(*)\RejectedRequests`
+)
+
+// snapshotIIS reads the IIS service state and counters. It returns nil when IIS is not
+// installed.
+func snapshotIIS() (*IISStats, error) {
+	states, err := serviceStates(func(name string) bool { return strings.EqualFold(name, iisServiceName) })
+	if err != nil {
+		return nil, err
+	}
+	if len(states) == 0 {
+		return nil, nil
+	}
+	stats := &IISStats{}
+	for _, state := range states {
+		stats.State = state
+	}
+	if stats.State != "running" {
+		return stats, nil
+	}
+	values, err := sample("iis", []string{iisRequestsCounter, iisConnectionsCounter, iisQueueCounter, iisQueueAgeCounter, iisRejectedCounter})
+	if err != nil {
+		return nil, err
+	}
+	connections := values[iisConnectionsCounter]
+	for site, requests := range values[iisRequestsCounter] {
+		if site == "_Total" {
+			stats.RequestsPerSec = requests
+			stats.CurrentConnections = connections[site]
+			continue
+		}
+		stats.Sites = append(stats.Sites, IISSite{Name: site, RequestsPerSec: requests, CurrentConnections: connections[site]})
+	}
+	for pool, queued := range values[iisQueueCounter] {
+		if pool == "_Total" {
+			continue
+		}
+		stats.QueueLength += queued
+		stats.AppPools = append(stats.AppPools, IISAppPool{
+			Name:              pool,
+			QueueLength:       queued,
+			MaxQueueItemAgeMs: values[iisQueueAgeCounter][pool],
+			RejectedRequests:  values[iisRejectedCounter][pool],
+		})
+	}
+	sort.Slice(stats.Sites, func(i, j int) bool { return stats.Sites[i].Name < stats.Sites[j].Name })
+	sort.Slice(stats.AppPools, func(i, j int) bool { return stats.AppPools[i].Name < stats.AppPools[j].Name })
+	return stats, nil
+}
+
+// mssqlInstanceName returns the SQL Server instance run by the service name, or "" if it is
+// not a SQL Server database engine service.
+func mssqlInstanceName(service string) string {
+	if strings.EqualFold(service, mssqlDefaultInstance) {
+		return mssqlDefaultInstance
+	}
+	if len(service) > len(mssqlNamedServicePrefix) && strings.EqualFold(service[:len(mssqlNamedServicePrefix)], mssqlNamedServicePrefix) {
+		return service[len(mssqlNamedServicePrefix):]
+	}
+	return ""
+}
+
+// mssqlCounterObject returns the prefix of the performance objects of an instance:
+// SQLServer for the default instance and MSSQL$<name> for named ones.
+func mssqlCounterObject(instance string) string {
+	if instance == mssqlDefaultInstance {
+		return "SQLServer"
+	}
+	return mssqlNamedServicePrefix + instance
+}
+
+// snapshotMSSQL reads the state and counters of the SQL Server instances installed as
+// services, or only those in names when set.
+func snapshotMSSQL(names []string) ([]MSSQLInstance, error) {
+	states, err := serviceStates(func(service string) bool {
+		instance := mssqlInstanceName(service)
+		return instance != "" && (len(names) == 0 || slices.ContainsFunc(names, func(n string) bool { return strings.EqualFold(n, instance) }))
+	})
+	if err != nil {
+		return nil, err
+	}
+	var instances []MSSQLInstance
+	for service, state := range states {
+		instances = append(instances, MSSQLInstance{Name: mssqlInstanceName(service), State: state})
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Name < instances[j].Name })
+
+	// The counters of every running instance are sampled together, so each rate covers one
+	// collection interval.
+	counters := make(map[string]*float64)
+	for i := range instances {
+		if instances[i].State == "running" {
+			addMSSQLCounters(counters, &instances[i])
+		}
+	}
+	if len(counters) == 0 {
+		return instances, nil
+	}
+	paths := make([]string, 0, len(counters))
+	for path := range counters {
+		paths = append(paths, path)
+	}
+	values, err := sample("mssql", paths)
+	if err != nil {
+		return nil, err
+	}
+	for path, field := range counters {
+		for _, v := range values[path] {
+			*field = v
+		}
+	}
+	return instances, nil
+}
+
+// addMSSQLCounters adds the counters of a running instance to counters, by counter path.
+func addMSSQLCounters(counters map[string]*float64, instance *MSSQLInstance) {
+	object := `\` + mssqlCounterObject(instance.Name)
+	counters[object+`:General Statistics\User Connections`] = &instance.UserConnections
+	counters[object+`:General Statistics\Processes blocked`] = &instance.BlockedProcesses
+	counters[object+`:SQL Statistics\Batch Requests/sec`] = &instance.BatchRequestsPerSec
+	counters[object+`:Locks(_Total)\Number of Deadlocks/sec`] = &instance.DeadlocksPerSec
+	counters[object+`:Buffer Manager\Page life expectancy`] = &instance.PageLifeExpectancySec
+	counters[object+`:Buffer Manager\Buffer cache hit ratio`] = &instance.BufferCacheHitPercent
+	counters[object+`:Memory Manager\Memory Grants Pending`] = &instance.MemoryGrantsPending
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// windowsInventoryInterval is the minimum time between two Windows inventory snapshots.
+const windowsInventoryInterval = 15 * time.Minute
+
+// WindowsService is an installed Windows service.
+type WindowsService struct {
+	Name             string `json:"name"`
+	DisplayName      string `json:"displayName,omitempty"`
+	StartType        string `json:"startType"`
+	DelayedAutoStart bool   `json:"delayedAutoStart,omitempty"`
+	State            string `json:"state"`
+}
+
+// InstalledSoftware is a program registered in the Windows uninstall registry keys.
+type InstalledSoftware struct {
+	Name         string `json:"name"`
+	Version      string `json:"version,omitempty"`
+	Publisher    string `json:"publisher,omitempty"`
+	InstallDate  string `json:"installDate,omitempty"`
+	Architecture string `json:"architecture"`
+}
+
+// WindowsInventory lists the services and installed software of a Windows host.
+type WindowsInventory struct {
+	Services []WindowsService    `json:"services"`
+	Software []InstalledSoftware `json:"software"`
+}
+
+// writeJSON encodes the inventory like encoding/json, one service and program at a time, so
+// the encoded inventory is never held in memory as a whole.
+func (inv *WindowsInventory) writeJSON(w io.Writer) error {
+	if _, err := io.WriteString(w, `{"services":`); err != nil {
+		return err
+	}
+	if err := writeJSONArray(w, inv.Services); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, `,"software":`); err != nil {
+		return err
+	}
+	if err := writeJSONArray(w, inv.Software); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "}")
+	return err
+}
+
+// lastWindowsInventory rate-limits inventory snapshots.
+var lastWindowsInventory struct {
+	sync.Mutex
+	taken time.Time
+}
+
+// collectWindowsInventory snapshots the installed services and software when
+// WINDOWS_INVENTORY is "true", at most every 15 minutes. The inventory is only reported in
+// the payload following each snapshot, since it rarely changes and is large. With chunked
+// uploads enabled it is sent as an upload of kind "windowsInventory" instead.
+func collectWindowsInventory() (*WindowsInventory, error) {
+	if !collectorEnabled("WINDOWS_INVENTORY", false) {
+		return nil, nil
+	}
+	lastWindowsInventory.Lock()
+	defer lastWindowsInventory.Unlock()
+	if time.Since(lastWindowsInventory.taken) < windowsInventoryInterval {
+		return nil, nil
+	}
+	lastWindowsInventory.taken = time.Now()
+	inventory, err := snapshotWindowsInventory()
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect Windows inventory: %v", err)
+	}
+	if chunkedUploads() {
+		_, err := stageUpload("windowsInventory", inventory.writeJSON)
+		if err == nil {
+			return nil, nil
+		}
+		fmt.Printf("Error uploading Windows inventory, sending it with the metrics: %v\n", err)
+	}
+	return inventory, nil
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+// snapshotWindowsInventory is only implemented on Windows.
+func snapshotWindowsInventory() (*WindowsInventory, error) {
+	return nil, nil
+}
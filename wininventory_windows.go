@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// uninstallKeys are the registry keys listing installed software, by architecture.
+var uninstallKeys = map[string]string{
+	`SOFTWARE\Microsoft\Windows\CurrentVersion\Uninstall`:             "x64",
+	`SOFTWARE\WOW6432Node\Microsoft\Windows\CurrentVersion\Uninstall`: "x86",
+}
+
+// serviceStartType names a service start type.
+func serviceStartType(t uint32) string {
+	switch t {
+	case windows.SERVICE_BOOT_START:
+		return "boot"
+	case windows.SERVICE_SYSTEM_START:
+		return "system"
+	case windows.SERVICE_AUTO_START:
+		return "automatic"
+	case windows.SERVICE_DEMAND_START:
+		return "manual"
+	case windows.SERVICE_DISABLED:
+		return "disabled"
+	}
+	return fmt.Sprintf("unknown(%d)", t)
+}
+
+// serviceState names a service state.
+func serviceState(s svc.State) string {
+	switch s {
+	case svc.Stopped:
+		return "stopped"
+	case svc.StartPending:
+		return "start_pending"
+	case svc.StopPending:
+		return "stop_pending"
+	case svc.Running:
+		return "running"
+	case svc.ContinuePending:
+		return "continue_pending"
+	case svc.PausePending:
+		return "pause_pending"
+	case svc.Paused:
+		return "paused"
+	}
+	return fmt.Sprintf("unknown(%d)", s)
+}
+
+// listServices reads the configuration and state of every Win32 service. The service
+// control manager and the services are opened with query rights only, so the inventory
+// also works when the agent does not run as an administrator.
+func listServices() ([]WindowsService, error) {
+	h, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_CONNECT|windows.SC_MANAGER_ENUMERATE_SERVICE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the service control manager: %v", err)
+	}
+	m := &mgr.Mgr{Handle: h}
+	defer m.Disconnect()
+	names, err := m.ListServices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services: %v", err)
+	}
+	var services []WindowsService
+	for _, name := range names {
+		sh, err := windows.OpenService(h, windows.StringToUTF16Ptr(name), windows.SERVICE_QUERY_CONFIG|windows.SERVICE_QUERY_STATUS)
+		if err != nil {
+			continue
+		}
+		s := &mgr.Service{Name: name, Handle: sh}
+		config, err := s.Config()
+		if err != nil {
+			s.Close()
+			continue
+		}
+		status, err := s.Query()
+		s.Close()
+		if err != nil {
+			continue
+		}
+		services = append(services, WindowsService{
+			Name:             name,
+			DisplayName:      config.DisplayName,
+			StartType:        serviceStartType(config.StartType),
+			DelayedAutoStart: config.DelayedAutoStart,
+			State:            serviceState(status.State),
+		})
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services, nil
+}
+
+// serviceStates returns the state of the installed services whose name satisfies match,
+// by service name, opening them with query rights only as listServices does.
+func serviceStates(match func(name string) bool) (map[string]string, error) {
+	h, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_CONNECT|windows.SC_MANAGER_ENUMERATE_SERVICE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the service control manager: %v", err)
+	}
+	m := &mgr.Mgr{Handle: h}
+	defer m.Disconnect()
+	names, err := m.ListServices()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services: %v", err)
+	}
+	states := make(map[string]string)
+	for _, name := range names {
+		if !match(name) {
+			continue
+		}
+		sh, err := windows.OpenService(h, windows.StringToUTF16Ptr(name), windows.SERVICE_QUERY_STATUS)
+		if err != nil {
+			continue
+		}
+		s := &mgr.Service{Name: name, Handle: sh}
+		status, err := s.Query()
+		s.Close()
+		if err == nil {
+			states[name] = serviceState(status.State)
+		}
+	}
+	return states, nil
+}
+
+// listSoftware reads the installed programs from the uninstall registry keys, skipping
+// system components and updates, which Windows does not show in its program list either.
+func listSoftware() []InstalledSoftware {
+	var software []InstalledSoftware
+	for path, arch := range uninstallKeys {
+		k, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.ENUMERATE_SUB_KEYS)
+		if err != nil {
+			continue
+		}
+		names, _ := k.ReadSubKeyNames(-1)
+		k.Close()
+		for _, name := range names {
+			sub, err := registry.OpenKey(registry.LOCAL_MACHINE, path+`\`+name, registry.QUERY_VALUE)
+			if err != nil {
+				continue
+			}
+			displayName, _, _ := sub.GetStringValue("DisplayName")
+			systemComponent, _, _ := sub.GetIntegerValue("SystemComponent")
+			parent, _, _ := sub.GetStringValue("ParentKeyName")
+			if displayName == "" || systemComponent == 1 || parent != "" {
+				sub.Close()
+				continue
+			}
+			entry := InstalledSoftware{Name: displayName, Architecture: arch}
+			entry.Version, _, _ = sub.GetStringValue("DisplayVersion")
+			entry.Publisher, _, _ = sub.GetStringValue("Publisher")
+			entry.InstallDate, _, _ = sub.GetStringValue("InstallDate")
+			sub.Close()
+			software = append(software, entry)
+		}
+	}
+	sort.Slice(software, func(i, j int) bool { return software[i].Name < software[j].Name })
+	return software
+}
+
+// snapshotWindowsInventory lists the installed services and software.
+func snapshotWindowsInventory() (*WindowsInventory, error) {
+	services, err := listServices()
+	if err != nil {
+		return nil, err
+	}
+	return &WindowsInventory{Services: services, Software: listSoftware()}, nil
+}